@@ -1,11 +1,111 @@
 package main
 
 import (
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+
 	"github.com/ananikitina/notes-rest/internal/app"
-	_ "github.com/golang-migrate/migrate/v4/source/file"
-	_ "github.com/lib/pq"
+	"github.com/ananikitina/notes-rest/internal/config"
+	"github.com/ananikitina/notes-rest/internal/logging"
 )
 
+const usage = `Usage: app [COMMAND] [FLAGS] [ARGS]
+
+Commands:
+  serve                 start the server (default)
+  migrate up            apply all pending migrations
+  migrate down N        roll back the last N migrations
+  migrate status        show the applied and pending migrations
+  migrate force V       set the version to V and clear the dirty flag, after fixing a failed migration by hand
+  seed [FILE]           create the users and notes of a YAML or JSON fixture that don't exist yet,
+                        by default the preconfigured users
+  create-admin          prompt for the email and password of a new admin and create it
+  config print          print the configuration with secrets redacted
+
+Flags:
+  --config FILE         YAML config file, also set by CONFIG_FILE
+  --<setting> VALUE     any setting, named like its environment variable in kebab case,
+                        e.g. --listen-addr :9090 for LISTEN_ADDR
+
+Flags take precedence over environment variables, which take precedence over the config file.
+`
+
 func main() {
-	app.Start()
+	command, args := "serve", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
+	}
+	switch command {
+	case "serve", "migrate", "seed", "create-admin", "config":
+	case "help":
+		fmt.Print(usage)
+		return
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", command, usage)
+		os.Exit(2)
+	}
+	if len(args) > 0 && (args[0] == "-h" || args[0] == "--help") {
+		fmt.Print(usage)
+		return
+	}
+
+	// The configuration is loaded once and passed to the command
+	cfg, args, err := config.Load(args)
+	if command == "config" && cfg != nil {
+		printConfig(cfg, args, err)
+		return
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid configuration:\n%v\n", err)
+		os.Exit(1)
+	}
+	slog.SetDefault(logging.New(os.Stderr, cfg))
+
+	switch command {
+	case "serve":
+		if len(args) != 0 {
+			fmt.Fprintf(os.Stderr, "serve takes no arguments, got %q\n", args)
+			os.Exit(2)
+		}
+		if err := app.Start(cfg); err != nil {
+			fatal("Server failed", err)
+		}
+	case "migrate":
+		if err := runMigrate(cfg, args); err != nil {
+			fatal("Migration failed", err)
+		}
+	case "seed":
+		if err := runSeed(cfg, args); err != nil {
+			fatal("Seeding failed", err)
+		}
+	case "create-admin":
+		if err := runCreateAdmin(cfg, args); err != nil {
+			fatal("Failed to create admin", err)
+		}
+	}
+}
+
+// printConfig prints the merged configuration, valid or not, and then the validation errors, so that
+// the values causing them can be found.
+func printConfig(cfg *config.Config, args []string, invalid error) {
+	if len(args) != 1 || args[0] != "print" {
+		fmt.Fprintf(os.Stderr, "unknown config command %q, only print is supported\n", args)
+		os.Exit(2)
+	}
+	if err := cfg.Print(os.Stdout); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to print configuration: %v\n", err)
+		os.Exit(1)
+	}
+	if invalid != nil {
+		fmt.Fprintf(os.Stderr, "Invalid configuration:\n%v\n", invalid)
+		os.Exit(1)
+	}
+}
+
+// fatal logs the error and exits.
+func fatal(message string, err error) {
+	slog.Error(message, "error", err)
+	os.Exit(1)
 }
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"strconv"
+
+	"github.com/ananikitina/notes-rest/internal/config"
+	"github.com/ananikitina/notes-rest/internal/database"
+	"github.com/golang-migrate/migrate/v4"
+)
+
+// runMigrate runs the migrate subcommand with its arguments.
+func runMigrate(cfg *config.Config, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("missing migrate command\n\n%s", usage)
+	}
+
+	// Check the arguments before connecting
+	var n int
+	switch args[0] {
+	case "up", "status":
+		if len(args) != 1 {
+			return fmt.Errorf("migrate %s takes no arguments", args[0])
+		}
+	case "down", "force":
+		if len(args) != 2 {
+			return fmt.Errorf("migrate %s needs one argument", args[0])
+		}
+		var err error
+		if n, err = strconv.Atoi(args[1]); err != nil {
+			return fmt.Errorf("invalid number %q", args[1])
+		}
+		if args[0] == "down" && n <= 0 {
+			return errors.New("migrate down needs a positive number of migrations")
+		}
+	default:
+		return fmt.Errorf("unknown migrate command %q\n\n%s", args[0], usage)
+	}
+
+	db, err := database.Open(cfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	m, err := database.NewMigrate(db, cfg.StorageDriver)
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "up":
+		err = m.Up()
+	case "down":
+		err = m.Steps(-n)
+	case "force":
+		err = m.Force(n)
+	case "status":
+		return printStatus(m, cfg.StorageDriver)
+	}
+	if errors.Is(err, migrate.ErrNoChange) {
+		slog.Info("Migrations are up to date, no changes applied")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	version, dirty, err := m.Version()
+	if errors.Is(err, migrate.ErrNilVersion) {
+		slog.Info("All migrations are rolled back")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	slog.Info("Database is at version", "version", version, "dirty", dirty)
+	return nil
+}
+
+// printStatus prints the version of the database and whether each migration is applied.
+func printStatus(m *migrate.Migrate, driver string) error {
+	current, dirty, err := m.Version()
+	if errors.Is(err, migrate.ErrNilVersion) {
+		fmt.Printf("%s database has no migrations applied\n", driver)
+	} else if err != nil {
+		return err
+	} else {
+		fmt.Printf("%s database is at version %d%s\n", driver, current, dirtyLabel(dirty))
+	}
+
+	migrations, err := database.Migrations(driver)
+	if err != nil {
+		return err
+	}
+	defer migrations.Close()
+
+	version, err := migrations.First()
+	for err == nil {
+		r, name, readErr := migrations.ReadUp(version)
+		if readErr != nil {
+			return readErr
+		}
+		r.Close()
+
+		state := "pending"
+		if version <= current {
+			state = "applied"
+		}
+		fmt.Printf("  %06d %-30s %s\n", version, name, state)
+		version, err = migrations.Next(version)
+	}
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+func dirtyLabel(dirty bool) string {
+	if dirty {
+		return " (dirty, fix the failed migration and run migrate force)"
+	}
+	return ""
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/ananikitina/notes-rest/fixtures"
+	"github.com/ananikitina/notes-rest/internal/app"
+	"github.com/ananikitina/notes-rest/internal/config"
+	"github.com/ananikitina/notes-rest/internal/usecases"
+	"golang.org/x/term"
+	"gopkg.in/yaml.v3"
+)
+
+// runSeed seeds the database with the fixture file given as the only argument, or with the
+// preconfigured users without one.
+func runSeed(cfg *config.Config, args []string) error {
+	if len(args) > 1 {
+		return errors.New("seed takes at most one fixture file")
+	}
+	data := fixtures.Seed
+	if len(args) == 1 {
+		var err error
+		if data, err = os.ReadFile(args[0]); err != nil {
+			return err
+		}
+	}
+	fixture, err := parseFixture(data)
+	if err != nil {
+		return err
+	}
+
+	seedUseCase, closeDB, err := app.NewSeedUseCase(cfg)
+	if err != nil {
+		return err
+	}
+	defer closeDB()
+
+	result, err := seedUseCase.Seed(context.Background(), fixture)
+	slog.Info("Seeded the database", "users_created", result.UsersCreated, "notes_created", result.NotesCreated,
+		"users_existing", result.UsersSkipped)
+	return err
+}
+
+// parseFixture parses a YAML or JSON fixture, rejecting unknown fields so typos don't go unnoticed.
+func parseFixture(data []byte) (*usecases.Fixture, error) {
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	var fixture usecases.Fixture
+	if err := decoder.Decode(&fixture); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("invalid fixture: %w", err)
+	}
+	return &fixture, nil
+}
+
+// runCreateAdmin prompts for the email and password of a new admin and creates it.
+func runCreateAdmin(cfg *config.Config, args []string) error {
+	if len(args) != 0 {
+		return errors.New("create-admin takes no arguments, it prompts for the credentials")
+	}
+	input := bufio.NewReader(os.Stdin)
+
+	email, err := prompt(input, "Email: ", false)
+	if err != nil {
+		return err
+	}
+	password, err := prompt(input, "Password: ", true)
+	if err != nil {
+		return err
+	}
+	confirmation, err := prompt(input, "Repeat password: ", true)
+	if err != nil {
+		return err
+	}
+	if password != confirmation {
+		return errors.New("passwords don't match")
+	}
+
+	seedUseCase, closeDB, err := app.NewSeedUseCase(cfg)
+	if err != nil {
+		return err
+	}
+	defer closeDB()
+
+	user, err := seedUseCase.CreateAdmin(context.Background(), email, password)
+	if err != nil {
+		return err
+	}
+	slog.Info("Admin created", "email", user.Email, "id", user.ID)
+	return nil
+}
+
+// prompt reads a line from the input. Secrets typed in a terminal are not echoed.
+func prompt(input *bufio.Reader, label string, secret bool) (string, error) {
+	fmt.Fprint(os.Stderr, label)
+	if secret && term.IsTerminal(int(os.Stdin.Fd())) {
+		value, err := term.ReadPassword(int(os.Stdin.Fd()))
+		fmt.Fprintln(os.Stderr)
+		return string(value), err
+	}
+
+	line, err := input.ReadString('\n')
+	if err != nil && (!errors.Is(err, io.EOF) || line == "") {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
@@ -0,0 +1,9 @@
+// Package fixtures embeds the default fixture of the seed command.
+package fixtures
+
+import _ "embed"
+
+// Seed is the fixture with the preconfigured users listed in the README.
+//
+//go:embed seed.yaml
+var Seed []byte
@@ -2,100 +2,237 @@ package app
 
 import (
 	"context"
-	"log"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
-	"time"
 
 	"github.com/ananikitina/notes-rest/internal/config"
 	"github.com/ananikitina/notes-rest/internal/database"
-	"github.com/ananikitina/notes-rest/internal/handlers"
-	"github.com/ananikitina/notes-rest/internal/middleware"
+	"github.com/ananikitina/notes-rest/internal/domain"
+	"github.com/ananikitina/notes-rest/internal/metrics"
 	"github.com/ananikitina/notes-rest/internal/repository"
+	"github.com/ananikitina/notes-rest/internal/repository/sqlite"
 	"github.com/ananikitina/notes-rest/internal/services"
+	"github.com/ananikitina/notes-rest/internal/tracing"
 	"github.com/ananikitina/notes-rest/internal/usecases"
-	"github.com/go-chi/chi"
 )
 
-func Start() {
-	log.Println("Starting application...")
+// Start runs the server with the configuration until it receives SIGINT or SIGTERM, and returns the
+// error if it can't be started or fails.
+func Start(cfg *config.Config) error {
+	slog.Info("Starting application...")
 
-	// Load configuration
-	cfg, err := config.LoadConfig()
+	//Initialize the tracing with the configured exporter
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg)
 	if err != nil {
-		log.Fatalf("Failed to load configuration:%v", err)
+		return fmt.Errorf("failed to initialize tracing: %w", err)
 	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+		defer cancel()
+		if err := shutdownTracing(ctx); err != nil {
+			slog.Error("Failed to export the remaining spans", "error", err)
+		}
+	}()
 
 	// Connect to database
-	_, err = database.ConnectDB()
+	db, err := database.ConnectDB(cfg)
 	if err != nil {
-		log.Fatalf("Could not connect to the database: %v", err)
+		return fmt.Errorf("could not connect to the database: %w", err)
 	}
-	defer database.DB.Close()
+	defer db.Close()
 
 	//Initialize the JWT service
-	jwtService := services.NewJWTService(cfg)
-
-	//Initialize the User repository, use case and handler
-	userRepo := repository.NewUserRepository(database.DB)
-	userUseCase := usecases.NewUserUseCase(userRepo)
-	userHandler := handlers.NewUserHandler(userUseCase, jwtService)
-
-	//Initialize the Note repository, use case and handler
-	noteRepo := repository.NewNoteRepository(database.DB)
-	noteUseCase := usecases.NewNoteUseCase(noteRepo)
-	noteHandler := handlers.NewNoteHandler(noteUseCase)
-
-	// Set up the router
-	r := chi.NewRouter()
-
-	//Public routes
-	r.Post("/register", userHandler.RegisterHandler())
-	r.Post("/login", userHandler.LoginHandler())
-
-	// Protected routes
-	r.Group(func(r chi.Router) {
-		r.Use(middleware.AuthMiddleware(jwtService))
-		r.Get("/notes", noteHandler.GetNotesHandler())
-		r.Post("/note", noteHandler.AddNoteHandler())
-
-		r.Group(func(r chi.Router) {
-			r.Use(middleware.AdminOnlyMiddleware)
-			r.Get("/allnotes", noteHandler.GetAllNotesHandler())
-		})
+	jwtService, err := services.NewJWTService(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to initialize JWT service: %w", err)
+	}
+
+	//Initialize the password hasher and policy
+	passwordHasher, err := services.NewPasswordHasher(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to initialize password hasher: %w", err)
+	}
+	passwordPolicy, err := services.NewPasswordPolicy(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to initialize password policy: %w", err)
+	}
+
+	//Initialize the metrics with the connection pool statistics
+	m := metrics.New()
+	m.RegisterDB(db, cfg.StorageDriver)
+
+	//Initialize the repositories and the event bus of the storage driver
+	repos := storageRepositories(db, cfg)
+	var eventBus domain.EventBus
+	switch cfg.StorageDriver {
+	case config.StorageDriverSQLite:
+		// A SQLite database is served by a single instance, so events don't leave the process
+		eventBus = services.NewHub()
+	default:
+		pgEventBus, err := services.NewPostgresEventBus(db, cfg.PostgresURL)
+		if err != nil {
+			return fmt.Errorf("failed to initialize event bus: %w", err)
+		}
+		defer pgEventBus.Close()
+		eventBus = pgEventBus
+	}
+
+	//Initialize the application with its services
+	application, err := New(cfg, repos, Services{
+		JWT:            jwtService,
+		Mailer:         services.NewMailer(cfg),
+		PasswordHasher: passwordHasher,
+		PasswordPolicy: passwordPolicy,
+		TOTP:           services.NewTOTP(),
+		OIDC:           services.NewOIDCService(cfg),
+		SpellChecker:   m.InstrumentSpellChecker(services.NewYandexSpellChecker(cfg), "yandex"),
+		WebhookSender:  services.NewWebhookSender(cfg),
+		Events:         eventBus,
+		Metrics:        m,
 	})
+	if err != nil {
+		return fmt.Errorf("failed to initialize application: %w", err)
+	}
 
-	// Create and configure the HTTP server
+	// Background workers run until shutdown
+	workersCtx, stopWorkers := context.WithCancel(context.Background())
+	defer stopWorkers()
+	application.RunWorkers(workersCtx)
+
+	// Create and configure the HTTP server. Request contexts are cancelled on shutdown,
+	// so the event streams don't keep it waiting
+	baseCtx, cancelRequests := context.WithCancel(context.Background())
 	server := &http.Server{
-		Addr:    ":8080",
-		Handler: r,
+		Addr:              cfg.ListenAddr,
+		Handler:           application.Router,
+		ReadHeaderTimeout: cfg.HTTPReadHeaderTimeout,
+		ReadTimeout:       cfg.HTTPReadTimeout,
+		WriteTimeout:      cfg.HTTPWriteTimeout,
+		IdleTimeout:       cfg.HTTPIdleTimeout,
+		BaseContext:       func(net.Listener) context.Context { return baseCtx },
 	}
+	server.RegisterOnShutdown(cancelRequests)
 
 	// Start the HTTP server in a separate goroutine
+	serverErrs := make(chan error, 2)
 	go func() {
-		if err := server.ListenAndServe(); err != nil {
-			log.Fatalf("Failed to start the server: %v", err)
+		if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+			serverErrs <- err
 		}
 	}()
 
-	log.Println("Server started on :8080")
+	slog.Info("Server started", "addr", cfg.ListenAddr)
+
+	// The metrics get their own listener if one is configured, so they can be kept off the public network
+	var metricsServer *http.Server
+	if cfg.MetricsEnabled && cfg.MetricsAddr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", application.Metrics.Handler())
+		metricsServer = &http.Server{
+			Addr:              cfg.MetricsAddr,
+			Handler:           mux,
+			ReadHeaderTimeout: cfg.HTTPReadHeaderTimeout,
+		}
+		go func() {
+			if err := metricsServer.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+				serverErrs <- fmt.Errorf("metrics listener: %w", err)
+			}
+		}()
+		slog.Info("Metrics server started", "addr", cfg.MetricsAddr)
+	}
 
 	// Set up channel to listen for OS signals
 	signals := make(chan os.Signal, 1)
 	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
-	<-signals
+	select {
+	case <-signals:
+	case err := <-serverErrs:
+		return fmt.Errorf("failed to start the server: %w", err)
+	}
 
-	log.Println("Shutdown signal received, shutting down server...")
+	slog.Info("Shutdown signal received, shutting down server...")
 
 	// Create a context with a timeout for graceful shutdown
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
 	defer cancel()
 
+	stopWorkers()
+	if metricsServer != nil {
+		if err := metricsServer.Shutdown(ctx); err != nil {
+			slog.Error("Failed to shut down the metrics server", "error", err)
+		}
+	}
 	if err := server.Shutdown(ctx); err != nil {
-		log.Fatalf("Server forced to shutdown: %v", err)
+		return fmt.Errorf("server forced to shutdown: %w", err)
+	}
+
+	slog.Info("Server exited gracefully.")
+	return nil
+}
+
+// NewSeedUseCase connects to the configured database and creates the use case of the seed and
+// create-admin commands. The returned function closes the database.
+func NewSeedUseCase(cfg *config.Config) (*usecases.SeedUseCase, func(), error) {
+	db, err := database.ConnectDB(cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	closeDB := func() { db.Close() }
+
+	passwordHasher, err := services.NewPasswordHasher(cfg)
+	if err != nil {
+		closeDB()
+		return nil, nil, err
+	}
+	passwordPolicy, err := services.NewPasswordPolicy(cfg)
+	if err != nil {
+		closeDB()
+		return nil, nil, err
+	}
+
+	repos := storageRepositories(db, cfg)
+	return usecases.NewSeedUseCase(repos.Users, repos.Notes, passwordHasher, passwordPolicy), closeDB, nil
+}
+
+// storageRepositories creates the repositories of the configured storage driver.
+func storageRepositories(db *sql.DB, cfg *config.Config) Repositories {
+	if cfg.StorageDriver == config.StorageDriverSQLite {
+		return sqliteRepositories(db, cfg)
 	}
+	return postgresRepositories(db, cfg)
+}
 
-	log.Println("Server exited gracefully.")
+// postgresRepositories creates the PostgreSQL repositories.
+func postgresRepositories(db *sql.DB, cfg *config.Config) Repositories {
+	return Repositories{
+		Users:         repository.NewUserRepository(db, cfg.DBQueryTimeout),
+		LoginAttempts: repository.NewLoginAttemptRepository(db, cfg.DBQueryTimeout),
+		Sessions:      repository.NewSessionRepository(db, cfg.DBQueryTimeout),
+		Notes:         repository.NewNoteRepository(db, cfg.DBQueryTimeout),
+		Workspaces:    repository.NewWorkspaceRepository(db, cfg.DBQueryTimeout),
+		PublicLinks:   repository.NewPublicLinkRepository(db, cfg.DBQueryTimeout),
+		Webhooks:      repository.NewWebhookRepository(db, cfg.DBQueryTimeout),
+		UnitOfWork:    repository.NewUnitOfWork(db, cfg.DBQueryTimeout),
+	}
+}
+
+// sqliteRepositories creates the SQLite repositories.
+func sqliteRepositories(db *sql.DB, cfg *config.Config) Repositories {
+	return Repositories{
+		Users:         sqlite.NewUserRepository(db, cfg.DBQueryTimeout),
+		LoginAttempts: sqlite.NewLoginAttemptRepository(db, cfg.DBQueryTimeout),
+		Sessions:      sqlite.NewSessionRepository(db, cfg.DBQueryTimeout),
+		Notes:         sqlite.NewNoteRepository(db, cfg.DBQueryTimeout),
+		Workspaces:    sqlite.NewWorkspaceRepository(db, cfg.DBQueryTimeout),
+		PublicLinks:   sqlite.NewPublicLinkRepository(db, cfg.DBQueryTimeout),
+		Webhooks:      sqlite.NewWebhookRepository(db, cfg.DBQueryTimeout),
+		UnitOfWork:    sqlite.NewUnitOfWork(db, cfg.DBQueryTimeout),
+	}
 }
@@ -0,0 +1,335 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/ananikitina/notes-rest/internal/config"
+	"github.com/ananikitina/notes-rest/internal/domain"
+	"github.com/ananikitina/notes-rest/internal/models"
+	"github.com/ananikitina/notes-rest/internal/services"
+)
+
+func TestRegistration(t *testing.T) {
+	env := newTestEnv(t)
+
+	body := map[string]string{"email": "new@example.com", "password": testPassword, "role": "admin"}
+	env.mustDo(t, "POST", "/register", "", body, http.StatusCreated)
+	token := env.login(t, "new@example.com")
+
+	var user models.User
+	env.mustDo(t, "GET", "/me", token, nil, http.StatusOK).decode(t, &user)
+	if user.Role != "user" || user.EmailVerified {
+		t.Errorf("registered user %+v, want an unverified user", user)
+	}
+	env.mustDo(t, "GET", "/allnotes", token, nil, http.StatusForbidden)
+	env.mustDo(t, "POST", "/note", token, map[string]string{"content": "New note"}, http.StatusForbidden)
+
+	verifyToken := env.mailer.token("new@example.com")
+	if verifyToken == "" {
+		t.Fatal("no verification email")
+	}
+	env.mustDo(t, "GET", "/verify-email?token="+url.QueryEscape(verifyToken), "", nil, http.StatusOK)
+	env.mustDo(t, "GET", "/me", token, nil, http.StatusOK).decode(t, &user)
+	if !user.EmailVerified {
+		t.Error("user is not verified after opening the link")
+	}
+	env.mustDo(t, "POST", "/note", token, map[string]string{"content": "New note"}, http.StatusCreated)
+}
+
+func TestVerificationPolicyLogin(t *testing.T) {
+	cfg := testConfig()
+	cfg.EmailVerificationPolicy = config.VerificationPolicyLogin
+	env := newTestEnvWith(t, cfg, nil)
+
+	env.mustDo(t, "POST", "/register", "", map[string]string{"email": "new@example.com", "password": testPassword}, http.StatusCreated)
+	credentials := map[string]string{"email": "new@example.com", "password": testPassword}
+	env.mustDo(t, "POST", "/login", "", credentials, http.StatusForbidden)
+
+	env.mustDo(t, "GET", "/verify-email?token="+url.QueryEscape(env.mailer.token("new@example.com")), "", nil, http.StatusOK)
+	env.mustDo(t, "POST", "/login", "", credentials, http.StatusOK)
+}
+
+func TestTwoFactorLogin(t *testing.T) {
+	env := newTestEnv(t)
+	env.createUser(t, "user@example.com", "user", true)
+	token := env.login(t, "user@example.com")
+	env.mustDo(t, "POST", "/2fa/setup", token, nil, http.StatusOK)
+	env.mustDo(t, "POST", "/2fa/enable", token, map[string]string{"code": validTOTPCode}, http.StatusOK)
+
+	var pending map[string]interface{}
+	credentials := map[string]string{"email": "user@example.com", "password": testPassword}
+	env.mustDo(t, "POST", "/login", "", credentials, http.StatusOK).decode(t, &pending)
+	if pending["mfaRequired"] != true || pending["token"] != nil {
+		t.Fatalf("login with two-factor authentication returned %v", pending)
+	}
+	mfaToken, _ := pending["mfaToken"].(string)
+
+	env.mustDo(t, "POST", "/login/2fa", "", map[string]string{"mfaToken": mfaToken, "code": "000000"}, http.StatusUnauthorized)
+	resp := env.mustDo(t, "POST", "/login/2fa", "", map[string]string{"mfaToken": mfaToken, "code": "000000"}, http.StatusTooManyRequests)
+	if resp.header.Get("Retry-After") == "" {
+		t.Error("no Retry-After header")
+	}
+	// The wrong code counts as a failed login of the account
+	env.mustDo(t, "POST", "/login", "", credentials, http.StatusTooManyRequests)
+}
+
+func TestEmailsAreCaseInsensitive(t *testing.T) {
+	env := newTestEnv(t)
+	env.mustDo(t, "POST", "/register", "", map[string]string{"email": "Mixed@Example.com", "password": testPassword}, http.StatusCreated)
+	env.mustDo(t, "POST", "/register", "", map[string]string{"email": "mixed@example.COM", "password": testPassword}, http.StatusConflict)
+
+	// Failures with any case count for the same account
+	for i := 0; i < 2; i++ {
+		env.do(t, "POST", "/login", "", map[string]string{"email": "MIXED@example.com", "password": "wrong password"}, nil)
+	}
+	env.mustDo(t, "POST", "/login", "", map[string]string{"email": "mixed@example.com", "password": testPassword}, http.StatusTooManyRequests)
+
+	if err := env.store.LoginAttempts().ClearFailures(context.Background(), "mixed@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	env.login(t, "mixed@EXAMPLE.com")
+}
+
+// oidcLogin goes through the test provider login with the code and returns the callback response.
+func (e *testEnv) oidcLogin(t *testing.T, code string) *response {
+	t.Helper()
+	resp := e.mustDo(t, "GET", "/auth/oidc/test/login", "", nil, http.StatusFound)
+	location, err := url.Parse(resp.header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cookie := strings.SplitN(resp.header.Get("Set-Cookie"), ";", 2)[0]
+	return e.do(t, "GET", "/auth/oidc/test/callback?state="+url.QueryEscape(location.Query().Get("state"))+"&code="+url.QueryEscape(code),
+		"", nil, http.Header{"Cookie": {cookie}})
+}
+
+func TestOIDCAccountLinking(t *testing.T) {
+	t.Run("verified account is linked", func(t *testing.T) {
+		env := newTestEnv(t)
+		userID := env.createUser(t, "user@example.com", "user", true)
+
+		var result map[string]string
+		env.oidcLogin(t, "email:user@example.com").decode(t, &result)
+		var user models.User
+		env.mustDo(t, "GET", "/me", result["token"], nil, http.StatusOK).decode(t, &user)
+		if user.ID != userID {
+			t.Errorf("logged in as user %d, want the existing user %d", user.ID, userID)
+		}
+	})
+
+	t.Run("unverified account is not linked", func(t *testing.T) {
+		env := newTestEnv(t)
+		// Anyone could have registered the address before its owner logs in with the provider
+		env.mustDo(t, "POST", "/register", "", map[string]string{"email": "victim@example.com", "password": testPassword}, http.StatusCreated)
+
+		if resp := env.oidcLogin(t, "email:victim@example.com"); resp.status != http.StatusConflict {
+			t.Errorf("got status %d, want %d: %s", resp.status, http.StatusConflict, resp.body)
+		}
+		if _, err := env.store.Users().GetUserByIdentity(context.Background(), "test", "victim@example.com"); err == nil {
+			t.Error("identity is linked to the unverified account")
+		}
+	})
+
+	t.Run("two-factor authentication is required", func(t *testing.T) {
+		env := newTestEnv(t)
+		env.createUser(t, "user@example.com", "user", true)
+		token := env.login(t, "user@example.com")
+		env.mustDo(t, "POST", "/2fa/setup", token, nil, http.StatusOK)
+		var enabled struct {
+			RecoveryCodes []string `json:"recoveryCodes"`
+		}
+		env.mustDo(t, "POST", "/2fa/enable", token, map[string]string{"code": validTOTPCode}, http.StatusOK).decode(t, &enabled)
+
+		resp := env.oidcLogin(t, "email:user@example.com")
+		var pending map[string]interface{}
+		resp.decode(t, &pending)
+		if resp.status != http.StatusOK || pending["mfaRequired"] != true || pending["token"] != nil {
+			t.Fatalf("callback for a user with two-factor authentication returned %d %v", resp.status, pending)
+		}
+		mfaToken, _ := pending["mfaToken"].(string)
+
+		// The TOTP step was used to enable two-factor authentication
+		var result map[string]string
+		env.mustDo(t, "POST", "/login/2fa", "", map[string]string{"mfaToken": mfaToken, "code": enabled.RecoveryCodes[0]}, http.StatusOK).decode(t, &result)
+		env.mustDo(t, "GET", "/me", result["token"], nil, http.StatusOK)
+	})
+}
+
+func TestSessions(t *testing.T) {
+	cfg := testConfig()
+	cfg.RequireAdminTwoFactor = false
+	env := newTestEnvWith(t, cfg, nil)
+	userID := env.createUser(t, "user@example.com", "user", true)
+	env.createUser(t, "admin@example.com", "admin", true)
+	admin := env.login(t, "admin@example.com")
+
+	var result map[string]string
+	credentials := map[string]string{"email": "user@example.com", "password": testPassword, "deviceLabel": "Laptop"}
+	env.mustDo(t, "POST", "/login", "", credentials, http.StatusOK).decode(t, &result)
+	laptop := result["token"]
+	phone := env.login(t, "user@example.com")
+	tablet := env.login(t, "user@example.com")
+
+	var sessions []models.Session
+	env.mustDo(t, "GET", "/sessions", phone, nil, http.StatusOK).decode(t, &sessions)
+	if len(sessions) != 3 {
+		t.Fatalf("got %d sessions, want 3", len(sessions))
+	}
+	var laptopSession, phoneSession models.Session
+	for _, session := range sessions {
+		switch {
+		case session.DeviceLabel == "Laptop":
+			laptopSession = session
+		case session.Current:
+			phoneSession = session
+		}
+	}
+	if laptopSession.ID == 0 || laptopSession.Current || laptopSession.UserAgent == "" || laptopSession.IP == "" || laptopSession.CreatedAt.IsZero() {
+		t.Errorf("laptop session %+v", laptopSession)
+	}
+	if phoneSession.ID == 0 {
+		t.Fatalf("no current session in %+v", sessions)
+	}
+
+	// A revoked token stops working right away
+	env.mustDo(t, "DELETE", fmt.Sprintf("/sessions/%d", laptopSession.ID), phone, nil, http.StatusNoContent)
+	env.mustDo(t, "GET", "/me", laptop, nil, http.StatusUnauthorized)
+	env.mustDo(t, "GET", "/me", phone, nil, http.StatusOK)
+
+	// Admins see and revoke the sessions of any user
+	env.mustDo(t, "GET", fmt.Sprintf("/users/%d/sessions", userID), admin, nil, http.StatusOK).decode(t, &sessions)
+	if len(sessions) != 2 {
+		t.Errorf("admin got %d sessions, want 2", len(sessions))
+	}
+	env.mustDo(t, "DELETE", fmt.Sprintf("/users/%d/sessions/%d", userID, phoneSession.ID), admin, nil, http.StatusNoContent)
+	env.mustDo(t, "GET", "/me", phone, nil, http.StatusUnauthorized)
+
+	// Changing the password keeps only the current session
+	other := env.login(t, "user@example.com")
+	env.mustDo(t, "POST", "/me/password", tablet, map[string]string{"currentPassword": testPassword, "newPassword": "another long password 2"}, http.StatusOK)
+	env.mustDo(t, "GET", "/me", other, nil, http.StatusUnauthorized)
+	env.mustDo(t, "GET", "/me", tablet, nil, http.StatusOK)
+
+	env.mustDo(t, "DELETE", fmt.Sprintf("/users/%d/sessions", userID), admin, nil, http.StatusNoContent)
+	env.mustDo(t, "GET", "/me", tablet, nil, http.StatusUnauthorized)
+}
+
+func TestDeleteAccount(t *testing.T) {
+	f := newFixture(t)
+	var workspaceNote models.Note
+	f.do(t, "POST", "/note", f.user, map[string]string{"content": "Team plan"},
+		http.Header{"X-Workspace-Id": {fmt.Sprint(f.workspaceID)}}).decode(t, &workspaceNote)
+	notes, err := f.store.Notes().GetOwnedByUser(context.Background(), f.userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f.mustDo(t, "DELETE", "/me", f.user, map[string]string{"password": "wrong password"}, http.StatusForbidden)
+	if err := f.store.LoginAttempts().ClearFailures(context.Background(), "user@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	f.mustDo(t, "DELETE", "/me", f.user, map[string]string{"password": testPassword}, http.StatusNoContent)
+
+	// The events are in the outbox with the deletion, so they are delivered even if the relay isn't running now
+	var events []models.DomainEvent
+	if err := f.store.Do(context.Background(), func(tx domain.Tx) error {
+		events, err = tx.Outbox().FetchPending(context.Background(), 1000)
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+	deleted := map[int][]int{}
+	for _, event := range events {
+		if event.Event.Type == models.EventNoteDeleted {
+			deleted[event.Event.NoteID] = event.Recipients
+		}
+	}
+	if len(deleted) != len(notes) {
+		t.Errorf("note.deleted events for %v, want one for each of the %d notes", deleted, len(notes))
+	}
+	for _, id := range []int{f.noteID, workspaceNote.ID} {
+		if !slices.Contains(deleted[id], f.otherID) {
+			t.Errorf("note.deleted of note %d is sent to %v, want the other user who could see it", id, deleted[id])
+		}
+	}
+}
+
+func TestAccountChangesAreThrottled(t *testing.T) {
+	f := newFixture(t)
+	wrong := map[string]string{"currentPassword": "wrong password", "newPassword": "another-long-password", "password": "wrong password",
+		"email": "renamed@example.com"}
+
+	f.mustDo(t, "POST", "/me/password", f.user, wrong, http.StatusForbidden)
+	for _, path := range []string{"/me/password", "/me/email"} {
+		resp := f.mustDo(t, "POST", path, f.user, wrong, http.StatusTooManyRequests)
+		if resp.header.Get("Retry-After") == "" {
+			t.Errorf("%s: no Retry-After header", path)
+		}
+	}
+	f.mustDo(t, "DELETE", "/me", f.user, map[string]string{"password": testPassword}, http.StatusTooManyRequests)
+	// The wrong password counts as a failed login of the account
+	f.mustDo(t, "POST", "/login", "", map[string]string{"email": "user@example.com", "password": testPassword}, http.StatusTooManyRequests)
+}
+
+func TestEmailChangeDoesntRevealAccounts(t *testing.T) {
+	f := newFixture(t)
+	sent := len(f.mailer.sent)
+	taken := f.mustDo(t, "POST", "/me/email", f.user, map[string]string{"email": "Other@example.com", "password": testPassword}, http.StatusAccepted)
+	free := f.mustDo(t, "POST", "/me/email", f.user, map[string]string{"email": "nobody@example.com", "password": testPassword}, http.StatusAccepted)
+	if string(taken.body) != string(free.body) {
+		t.Errorf("response for a taken email %s differs from %s", taken.body, free.body)
+	}
+
+	// The owner of the address is told about the request, and gets no link to confirm it
+	var told []sentEmail
+	for _, email := range f.mailer.sent[sent:] {
+		if email.to == "other@example.com" {
+			told = append(told, email)
+		}
+	}
+	if len(told) != 1 || !strings.Contains(told[0].body, "already belongs to your account") || strings.Contains(told[0].body, "token=") {
+		t.Errorf("owner of the taken address got %+v, want a notice without a link", told)
+	}
+	f.mustDo(t, "GET", "/verify-email?token="+url.QueryEscape(f.mailer.token("nobody@example.com")), "", nil, http.StatusOK)
+}
+
+func TestPasswordPolicyAndRehash(t *testing.T) {
+	cfg := testConfig()
+	cfg.PasswordHashAlgorithm = services.HashArgon2id
+	cfg.Argon2MemoryKiB, cfg.Argon2Iterations, cfg.Argon2Parallelism = 64, 1, 1
+	env := newTestEnvWith(t, cfg, nil)
+
+	for _, password := range []string{"", "short", "password123", "new@example.com"} {
+		env.mustDo(t, "POST", "/register", "", map[string]string{"email": "new@example.com", "password": password}, http.StatusBadRequest)
+	}
+
+	// A user whose password was hashed before the algorithm was changed
+	bcryptHasher, err := services.NewPasswordHasher(testConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash, err := bcryptHasher.Hash(testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := &models.User{Email: "old@example.com", Password: hash, Role: "user", EmailVerified: true}
+	if err := env.store.Users().CreateUser(context.Background(), user); err != nil {
+		t.Fatal(err)
+	}
+
+	env.login(t, "old@example.com")
+	stored, err := env.store.Users().GetUserByID(context.Background(), user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(stored.Password, "$argon2id$") {
+		t.Errorf("hash after logging in is %q, want an argon2id hash", stored.Password)
+	}
+	env.login(t, "old@example.com")
+}
@@ -0,0 +1,141 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ananikitina/notes-rest/internal/domain"
+	"github.com/ananikitina/notes-rest/internal/models"
+	"github.com/ananikitina/notes-rest/internal/services"
+)
+
+var errFakeToken = errors.New("invalid token")
+
+// fakeJWT issues readable unsigned tokens: "access|userID|role|sessionID" and "scope|userID|email".
+type fakeJWT struct{}
+
+func (fakeJWT) GenerateToken(userID int, userRole string, sessionID int) (string, error) {
+	return strings.Join([]string{"access", strconv.Itoa(userID), userRole, strconv.Itoa(sessionID)}, "|"), nil
+}
+
+func (fakeJWT) ValidateToken(tokenString string) (*domain.Claims, error) {
+	parts := strings.Split(tokenString, "|")
+	if len(parts) != 4 || parts[0] != "access" {
+		return nil, errFakeToken
+	}
+	userID, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return nil, errFakeToken
+	}
+	sessionID, err := strconv.Atoi(parts[3])
+	if err != nil {
+		return nil, errFakeToken
+	}
+	return &domain.Claims{UserID: userID, UserRole: parts[2], SessionID: sessionID}, nil
+}
+
+func (fakeJWT) GenerateScopedToken(userID int, scope, email string, ttl time.Duration) (string, error) {
+	return strings.Join([]string{scope, strconv.Itoa(userID), email}, "|"), nil
+}
+
+func (fakeJWT) ValidateScopedToken(tokenString, scope string) (*domain.Claims, error) {
+	parts := strings.Split(tokenString, "|")
+	if len(parts) != 3 || parts[0] != scope {
+		return nil, errFakeToken
+	}
+	userID, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return nil, errFakeToken
+	}
+	return &domain.Claims{UserID: userID, Scope: scope, Email: parts[2]}, nil
+}
+
+func (fakeJWT) PublicKeys() domain.JSONWebKeySet {
+	return domain.JSONWebKeySet{Keys: []domain.JSONWebKey{{Kty: "OKP", Kid: "test", Use: "sig", Alg: "EdDSA", Crv: "Ed25519", X: "AA"}}}
+}
+
+// fakeSpellChecker reports every "mistkae" in the text.
+type fakeSpellChecker struct{}
+
+func (fakeSpellChecker) Check(ctx context.Context, text, lang string) ([]services.SpellCheckError, error) {
+	var spellErrors []services.SpellCheckError
+	if pos := strings.Index(text, "mistkae"); pos >= 0 {
+		spellErrors = append(spellErrors, services.SpellCheckError{Pos: pos, Len: len("mistkae"), Word: "mistkae", S: []string{"mistake"}})
+	}
+	return spellErrors, nil
+}
+
+// fakeTOTP accepts the code "123456" once per time step.
+type fakeTOTP struct{}
+
+const validTOTPCode = "123456"
+
+func (fakeTOTP) GenerateSecret() (string, error) {
+	return "TESTSECRET", nil
+}
+
+func (fakeTOTP) URI(account, secret string) string {
+	return "otpauth://totp/notes-rest:" + account + "?secret=" + secret
+}
+
+func (fakeTOTP) Validate(secret, code string, at time.Time, lastStep int64) (int64, bool) {
+	step := at.Unix() / 30
+	return step, code == validTOTPCode && step > lastStep
+}
+
+// fakeOIDC is a provider that confirms the identity for the code "good", and
+// a verified email for the code "email:<address>".
+type fakeOIDC struct{}
+
+func (fakeOIDC) AuthCodeURL(ctx context.Context, provider, state, nonce, codeVerifier string) (string, error) {
+	return "https://idp.example.com/authorize?state=" + url.QueryEscape(state), nil
+}
+
+func (fakeOIDC) Exchange(ctx context.Context, provider, code, codeVerifier, nonce string) (*models.ExternalIdentity, error) {
+	if email, ok := strings.CutPrefix(code, "email:"); ok {
+		return &models.ExternalIdentity{Provider: provider, Subject: email, Email: email, EmailVerified: true}, nil
+	}
+	if code != "good" {
+		return nil, errors.New("invalid code")
+	}
+	return &models.ExternalIdentity{Provider: provider, Subject: "subject-1", Email: "sso@example.com", EmailVerified: true}, nil
+}
+
+// fakeMailer keeps the sent emails.
+type fakeMailer struct {
+	mu   sync.Mutex
+	sent []sentEmail
+}
+
+type sentEmail struct {
+	to, subject, body string
+}
+
+func (m *fakeMailer) Send(to, subject, body string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sent = append(m.sent, sentEmail{to: to, subject: subject, body: body})
+	return nil
+}
+
+// token returns the "token" parameter of the link in the last email sent to the address.
+func (m *fakeMailer) token(to string) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := len(m.sent) - 1; i >= 0; i-- {
+		if m.sent[i].to != to {
+			continue
+		}
+		for _, field := range strings.Fields(m.sent[i].body) {
+			if link, err := url.Parse(field); err == nil && link.Query().Get("token") != "" {
+				return link.Query().Get("token")
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,206 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/ananikitina/notes-rest/internal/domain"
+	"github.com/ananikitina/notes-rest/internal/models"
+)
+
+// pull returns the sync changes of the user since the token, and the token of the next pull.
+func (e *testEnv) pull(t *testing.T, token, since string) ([]models.NoteChange, string) {
+	t.Helper()
+	var page models.SyncPage
+	e.mustDo(t, "GET", "/sync?since="+url.QueryEscape(since), token, nil, http.StatusOK).decode(t, &page)
+	return page.Changes, page.Token
+}
+
+func TestRemovedMemberLosesTheirWorkspaceNotes(t *testing.T) {
+	f := newFixture(t)
+	f.mustDo(t, "POST", "/workspaces/invitations/accept?token="+url.QueryEscape(f.inviteToken), f.third, nil, http.StatusOK)
+
+	var note models.Note
+	resp := f.do(t, "POST", "/note", f.third, map[string]string{"content": "Draft"},
+		http.Header{"X-Workspace-Id": {fmt.Sprint(f.workspaceID)}})
+	if resp.status != http.StatusCreated {
+		t.Fatalf("got status %d, want %d: %s", resp.status, http.StatusCreated, resp.body)
+	}
+	resp.decode(t, &note)
+	_, since := f.pull(t, f.third, "")
+
+	f.mustDo(t, "DELETE", fmt.Sprintf("/workspaces/%d/members/%d", f.workspaceID, f.thirdID), f.user, nil, http.StatusNoContent)
+
+	notePath := fmt.Sprintf("/notes/%d", note.ID)
+	f.mustDo(t, "GET", notePath, f.third, nil, http.StatusNotFound)
+	f.mustDo(t, "PATCH", notePath, f.third, map[string]string{"content": "Changed"}, http.StatusNotFound)
+	f.mustDo(t, "POST", notePath+"/shares", f.third, map[string]string{"email": "third@example.com", "permission": "viewer"}, http.StatusNotFound)
+	f.mustDo(t, "POST", notePath+"/public-link", f.third, "{}", http.StatusNotFound)
+	f.mustDo(t, "DELETE", notePath, f.third, nil, http.StatusNotFound)
+	var result struct {
+		Results []models.SyncResult `json:"results"`
+	}
+	f.mustDo(t, "POST", "/sync", f.third, map[string]interface{}{"operations": []models.SyncOperation{
+		{ClientID: "1", Op: models.SyncUpdate, NoteID: note.ID, Content: "Changed"},
+	}}, http.StatusOK).decode(t, &result)
+	if len(result.Results) != 1 || result.Results[0].Status != models.SyncNotFound {
+		t.Errorf("sync update of the note = %+v, want not found", result.Results)
+	}
+
+	changes, _ := f.pull(t, f.third, since)
+	if len(changes) != 1 || changes[0].Type != models.ChangeDelete || changes[0].NoteID != note.ID {
+		t.Errorf("changes after the removal = %+v, want the note deleted", changes)
+	}
+
+	// The note stays in the workspace
+	f.mustDo(t, "GET", notePath, f.user, nil, http.StatusOK)
+}
+
+func TestSharingDoesntRevealAccounts(t *testing.T) {
+	f := newFixture(t)
+	path := fmt.Sprintf("/notes/%d/shares", f.noteID)
+
+	known := f.do(t, "POST", path, f.user, map[string]string{"email": "Third@example.com", "permission": "editor"}, nil)
+	unknown := f.do(t, "POST", path, f.user, map[string]string{"email": "Nobody@example.com", "permission": "editor"}, nil)
+	if known.status != http.StatusAccepted || unknown.status != http.StatusAccepted {
+		t.Fatalf("got statuses %d and %d, want %d", known.status, unknown.status, http.StatusAccepted)
+	}
+	if want := strings.Replace(string(known.body), "third@", "nobody@", 1); string(unknown.body) != want {
+		t.Errorf("response for an unknown email %s differs from %s", unknown.body, known.body)
+	}
+
+	f.mustDo(t, "PATCH", fmt.Sprintf("/notes/%d", f.noteID), f.third, map[string]string{"content": "Shared"}, http.StatusOK)
+	var shares []models.NoteShare
+	f.mustDo(t, "GET", path, f.user, nil, http.StatusOK).decode(t, &shares)
+	for _, share := range shares {
+		if share.Email == "nobody@example.com" {
+			t.Errorf("note is shared with an unknown email: %+v", share)
+		}
+	}
+}
+
+func TestPublicLinkPasswordThrottling(t *testing.T) {
+	f := newFixture(t)
+	path := "/p/" + f.passwordLinkToken
+
+	f.mustDo(t, "POST", path, "", url.Values{"password": {"wrong"}}, http.StatusUnauthorized)
+	resp := f.do(t, "GET", path, "", nil, http.Header{"X-Link-Password": {"link-password"}})
+	if resp.status != http.StatusTooManyRequests || resp.header.Get("Retry-After") != "1" {
+		t.Errorf("right password after a failure: got status %d, Retry-After %q, want %d, 1",
+			resp.status, resp.header.Get("Retry-After"), http.StatusTooManyRequests)
+	}
+	resp = f.do(t, "POST", path, "", url.Values{"password": {"link-password"}}, http.Header{"Accept": {"text/html"}})
+	if resp.status != http.StatusTooManyRequests || !strings.Contains(string(resp.body), "Too many attempts") {
+		t.Errorf("password form after a failure: got status %d: %s", resp.status, resp.body)
+	}
+
+	// Links without a password are not throttled
+	f.mustDo(t, "GET", "/p/"+f.linkToken, "", nil, http.StatusOK)
+}
+
+func TestSyncChecksSpelling(t *testing.T) {
+	f := newFixture(t)
+
+	var result struct {
+		Results []models.SyncResult `json:"results"`
+	}
+	f.mustDo(t, "POST", "/sync", f.user, map[string]interface{}{"operations": []models.SyncOperation{
+		{ClientID: "1", Op: models.SyncCreate, Content: "A mistkae"},
+		{ClientID: "2", Op: models.SyncCreate, Content: "Spelled right"},
+		{ClientID: "3", Op: models.SyncUpdate, NoteID: f.noteID, Content: "Another mistkae"},
+		{ClientID: "4", Op: models.SyncUpdate, NoteID: 999, Content: "A mistkae"},
+	}}, http.StatusOK).decode(t, &result)
+
+	want := []string{models.SyncRejected, models.SyncApplied, models.SyncRejected, models.SyncNotFound}
+	if len(result.Results) != len(want) {
+		t.Fatalf("got %d results, want %d", len(result.Results), len(want))
+	}
+	for i, got := range result.Results {
+		if got.Status != want[i] {
+			t.Errorf("result %s = %+v, want %s", got.ClientID, got, want[i])
+		}
+		if rejected := got.Status == models.SyncRejected; rejected != (got.SpellingErrors != nil) {
+			t.Errorf("result %s has spelling errors %v", got.ClientID, got.SpellingErrors)
+		}
+	}
+
+	var note models.Note
+	f.mustDo(t, "GET", fmt.Sprintf("/notes/%d", f.noteID), f.user, nil, http.StatusOK).decode(t, &note)
+	if strings.Contains(note.Content, "mistkae") {
+		t.Errorf("note with a spelling error was saved: %q", note.Content)
+	}
+}
+
+func TestNewNoteIgnoresServerFields(t *testing.T) {
+	f := newFixture(t)
+
+	var note models.Note
+	f.mustDo(t, "POST", "/note", f.user, map[string]interface{}{
+		"content": "New note", "id": 999, "userId": f.otherID, "workspaceId": f.workspaceID,
+		"createdAt": "2000-01-01T00:00:00Z", "updatedAt": "2000-01-01T00:00:00Z", "changeSeq": 999,
+	}, http.StatusCreated).decode(t, &note)
+	if note.ID == 999 || note.UserID != f.userID || note.WorkspaceID != nil || note.CreatedAt.Year() == 2000 ||
+		note.UpdatedAt != nil || note.ChangeSeq == 999 {
+		t.Errorf("created note = %+v, want the fields set by the server", note)
+	}
+
+	// The note.created event carries the stored note, not the request
+	var events []models.DomainEvent
+	if err := f.store.Do(context.Background(), func(tx domain.Tx) error {
+		var err error
+		events, err = tx.Outbox().FetchPending(context.Background(), 1000)
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+	var created *models.Note
+	for _, event := range events {
+		if event.Event.Type == models.EventNoteCreated && event.Event.NoteID == note.ID {
+			created = &models.Note{}
+			if err := json.Unmarshal(event.Data, created); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if created == nil || created.UpdatedAt != nil || created.CreatedAt.Year() == 2000 || created.UserID != f.userID {
+		t.Errorf("note.created event has note %+v", created)
+	}
+}
+
+func TestInvitationLinkOpensInBrowser(t *testing.T) {
+	f := newFixture(t)
+	var link string
+	for _, email := range f.mailer.sent {
+		for _, field := range strings.Fields(email.body) {
+			if email.to == "third@example.com" && strings.Contains(field, "/workspaces/invitations/accept?token=") {
+				link = field
+			}
+		}
+	}
+	u, err := url.Parse(link)
+	if err != nil || u.Query().Get("token") != f.inviteToken {
+		t.Fatalf("invitation email has link %q, want the link with the token", link)
+	}
+	path := u.RequestURI()
+
+	// Opening the link shows the invitation without logging in, and doesn't accept it
+	resp := f.do(t, "GET", path, "", nil, http.Header{"Accept": {"text/html"}})
+	if resp.status != http.StatusOK || !strings.Contains(string(resp.body), "as editor") || !strings.Contains(string(resp.body), "third@example.com") {
+		t.Errorf("GET of the link: got status %d: %s", resp.status, resp.body)
+	}
+	var invitation map[string]interface{}
+	f.mustDo(t, "GET", path, "", nil, http.StatusOK).decode(t, &invitation)
+	if invitation["role"] != models.WorkspaceEditor || invitation["workspaceId"] != float64(f.workspaceID) {
+		t.Errorf("invitation = %v", invitation)
+	}
+	f.mustDo(t, "GET", "/workspaces/invitations/accept?token=invalid", "", nil, http.StatusBadRequest)
+
+	f.mustDo(t, "POST", path, "", nil, http.StatusUnauthorized)
+	f.mustDo(t, "POST", path, f.third, nil, http.StatusOK)
+	f.mustDo(t, "GET", path, "", nil, http.StatusBadRequest)
+}
@@ -0,0 +1,218 @@
+package app
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/ananikitina/notes-rest/internal/config"
+	"github.com/ananikitina/notes-rest/internal/domain"
+	"github.com/ananikitina/notes-rest/internal/handlers"
+	"github.com/ananikitina/notes-rest/internal/metrics"
+	"github.com/ananikitina/notes-rest/internal/middleware"
+	"github.com/ananikitina/notes-rest/internal/services"
+	"github.com/ananikitina/notes-rest/internal/usecases"
+	"github.com/go-chi/chi"
+)
+
+// Repositories are the storage the application works with.
+type Repositories struct {
+	Users         domain.UserRepository
+	LoginAttempts domain.LoginAttemptRepository
+	Sessions      domain.SessionRepository
+	Notes         domain.NoteRepository
+	Workspaces    domain.WorkspaceRepository
+	PublicLinks   domain.PublicLinkRepository
+	Webhooks      domain.WebhookRepository
+	UnitOfWork    domain.UnitOfWork
+}
+
+// Services are the external services the application uses.
+type Services struct {
+	JWT            domain.JWTServiceInterface
+	Mailer         domain.Mailer
+	PasswordHasher domain.PasswordHasher
+	PasswordPolicy domain.PasswordPolicy
+	TOTP           domain.TOTPService
+	OIDC           domain.OIDCService
+	SpellChecker   services.SpellChecker
+	WebhookSender  domain.WebhookSender
+	Events         domain.EventBus
+	// Metrics of the application, created by New if nil
+	Metrics *metrics.Metrics
+}
+
+// Application is the HTTP router of the service with its background workers.
+type Application struct {
+	Router http.Handler
+	// Metrics are served by the router unless a separate metrics listener is configured
+	Metrics *metrics.Metrics
+
+	webhookUseCase *usecases.WebhookUseCase
+	outboxRelay    *services.OutboxRelay
+}
+
+// New builds the use cases, handlers and routes on top of the repositories and services.
+func New(cfg *config.Config, repos Repositories, svc Services) (*Application, error) {
+	//Initialize the metrics with the numbers of users and notes
+	m := svc.Metrics
+	if m == nil {
+		m = metrics.New()
+	}
+	m.RegisterStats(repos.Users, repos.Notes)
+
+	//Initialize the JWKS handler
+	jwksHandler := handlers.NewJWKSHandler(svc.JWT)
+
+	//Initialize the Session use case and handler
+	sessionUseCase := usecases.NewSessionUseCase(repos.Sessions, svc.JWT)
+	sessionHandler := handlers.NewSessionHandler(sessionUseCase)
+
+	//Initialize the Note use case, account deletion goes through it
+	noteUseCase := usecases.NewNoteUseCase(repos.Notes, repos.Workspaces, repos.Users, repos.UnitOfWork)
+
+	//Initialize the User use cases and handlers
+	userUseCase, err := usecases.NewUserUseCase(repos.Users, repos.LoginAttempts, repos.Sessions, noteUseCase, svc.PasswordHasher, svc.PasswordPolicy, svc.JWT, svc.Mailer, cfg)
+	if err != nil {
+		return nil, err
+	}
+	twoFactorUseCase := usecases.NewTwoFactorUseCase(repos.Users, repos.LoginAttempts, svc.TOTP, svc.JWT, cfg)
+	userHandler := handlers.NewUserHandler(userUseCase, twoFactorUseCase, sessionUseCase, m)
+	twoFactorHandler := handlers.NewTwoFactorHandler(twoFactorUseCase, sessionUseCase, m)
+
+	//Initialize the OpenID Connect use case and handler
+	oidcUseCase := usecases.NewOIDCUseCase(repos.Users, svc.OIDC, svc.PasswordHasher, cfg)
+	oidcHandler := handlers.NewOIDCHandler(oidcUseCase, twoFactorUseCase, sessionUseCase, m, cfg)
+
+	//Initialize the Workspace use case and handler
+	workspaceUseCase := usecases.NewWorkspaceUseCase(repos.Workspaces, repos.Users, svc.Mailer, cfg)
+	workspaceHandler := handlers.NewWorkspaceHandler(workspaceUseCase)
+
+	//Initialize the event handler
+	eventHandler := handlers.NewEventHandler(svc.Events, sessionUseCase, cfg)
+
+	//Initialize the Webhook use case and handler
+	webhookUseCase := usecases.NewWebhookUseCase(repos.Webhooks, svc.WebhookSender, cfg)
+	webhookHandler := handlers.NewWebhookHandler(webhookUseCase, twoFactorUseCase, cfg)
+
+	//Initialize the outbox relay with the configured sinks
+	var sinks []domain.EventSink
+	for _, sink := range cfg.OutboxSinks {
+		switch sink {
+		case config.OutboxSinkEvents:
+			sinks = append(sinks, services.NewEventBusSink(svc.Events))
+		case config.OutboxSinkWebhooks:
+			sinks = append(sinks, webhookUseCase)
+		case config.OutboxSinkLog:
+			sinks = append(sinks, services.LogSink{})
+		}
+	}
+	outboxRelay := services.NewOutboxRelay(repos.UnitOfWork, sinks, cfg.OutboxPollInterval)
+
+	//Initialize the Note handler
+	noteHandler := handlers.NewNoteHandler(noteUseCase, userUseCase, svc.SpellChecker)
+
+	//Initialize the Sync use case and handler
+	syncUseCase := usecases.NewSyncUseCase(repos.Notes, repos.Workspaces, noteUseCase)
+	syncHandler := handlers.NewSyncHandler(syncUseCase, noteUseCase, userUseCase, svc.SpellChecker)
+
+	//Initialize the Public link use case and handler
+	publicLinkUseCase := usecases.NewPublicLinkUseCase(repos.PublicLinks, repos.Notes, repos.LoginAttempts, noteUseCase, svc.PasswordHasher, cfg)
+	publicLinkHandler := handlers.NewPublicLinkHandler(publicLinkUseCase)
+
+	// Set up the router
+	r := chi.NewRouter()
+	r.Use(middleware.TracingMiddleware)
+	r.Use(middleware.RequestIDMiddleware)
+	r.Use(middleware.AccessLogMiddleware)
+	r.Use(middleware.MetricsMiddleware(m))
+
+	if cfg.MetricsEnabled && cfg.MetricsAddr == "" {
+		r.Handle("/metrics", m.Handler())
+	}
+
+	//Public routes
+	r.Get("/.well-known/jwks.json", jwksHandler.GetJWKSHandler())
+	r.Post("/register", userHandler.RegisterHandler())
+	r.Post("/login", userHandler.LoginHandler())
+	r.Post("/login/2fa", twoFactorHandler.LoginTwoFactorHandler())
+	r.Get("/auth/oidc/{provider}/login", oidcHandler.LoginHandler())
+	r.Get("/auth/oidc/{provider}/callback", oidcHandler.CallbackHandler())
+	r.Get("/verify-email", userHandler.VerifyEmailHandler())
+	r.Post("/verify-email/resend", userHandler.ResendVerificationHandler())
+	r.Get("/p/{token}", publicLinkHandler.ViewNoteHandler())
+	r.Post("/p/{token}", publicLinkHandler.ViewNoteHandler())
+	r.Get("/workspaces/invitations/accept", workspaceHandler.ViewInvitationHandler())
+
+	// Event streams, the token can also be passed in the query
+	r.Group(func(r chi.Router) {
+		r.Use(middleware.QueryTokenMiddleware)
+		r.Use(middleware.AuthMiddleware(svc.JWT, sessionUseCase, workspaceUseCase))
+		r.Get("/events", eventHandler.StreamEventsHandler())
+		r.Get("/ws", eventHandler.WebSocketHandler())
+	})
+
+	// Protected routes
+	r.Group(func(r chi.Router) {
+		r.Use(middleware.AuthMiddleware(svc.JWT, sessionUseCase, workspaceUseCase))
+		r.Get("/notes", noteHandler.GetNotesHandler())
+		r.Get("/notes/shared-with-me", noteHandler.GetSharedWithMeHandler())
+		r.Get("/sync", syncHandler.PullHandler())
+		r.Get("/notes/{id}", noteHandler.GetNoteHandler())
+		r.Delete("/notes/{id}", noteHandler.DeleteNoteHandler())
+		r.Get("/notes/{id}/shares", noteHandler.GetSharesHandler())
+		r.Post("/notes/{id}/shares", noteHandler.ShareNoteHandler())
+		r.Delete("/notes/{id}/shares/{userID}", noteHandler.DeleteShareHandler())
+		r.Post("/notes/{id}/public-link", publicLinkHandler.CreateLinkHandler())
+		r.Get("/notes/{id}/public-links", publicLinkHandler.GetLinksHandler())
+		r.Delete("/notes/{id}/public-links/{linkID}", publicLinkHandler.DeleteLinkHandler())
+		r.Get("/me", userHandler.GetMeHandler())
+		r.Patch("/me", userHandler.UpdateMeHandler())
+		r.Delete("/me", userHandler.DeleteMeHandler())
+		r.Post("/me/password", userHandler.ChangePasswordHandler())
+		r.Post("/me/email", userHandler.ChangeEmailHandler())
+		r.Get("/sessions", sessionHandler.GetSessionsHandler())
+		r.Delete("/sessions/{id}", sessionHandler.DeleteSessionHandler())
+		r.Post("/2fa/setup", twoFactorHandler.SetupHandler())
+		r.Post("/2fa/enable", twoFactorHandler.EnableHandler())
+		r.Post("/workspaces", workspaceHandler.CreateWorkspaceHandler())
+		r.Get("/workspaces", workspaceHandler.GetWorkspacesHandler())
+		r.Post("/workspaces/invitations/accept", workspaceHandler.AcceptInvitationHandler())
+		r.Get("/workspaces/{id}/members", workspaceHandler.GetMembersHandler())
+		r.Delete("/workspaces/{id}/members/{userID}", workspaceHandler.DeleteMemberHandler())
+		r.Post("/workspaces/{id}/invitations", workspaceHandler.InviteHandler())
+		r.Post("/webhooks", webhookHandler.CreateWebhookHandler())
+		r.Get("/webhooks", webhookHandler.GetWebhooksHandler())
+		r.Delete("/webhooks/{id}", webhookHandler.DeleteWebhookHandler())
+		r.Get("/webhooks/{id}/deliveries", webhookHandler.GetDeliveriesHandler())
+		r.Post("/webhooks/{id}/deliveries/{deliveryID}/redeliver", webhookHandler.RedeliverHandler())
+
+		r.Group(func(r chi.Router) {
+			if cfg.EmailVerificationPolicy != config.VerificationPolicyNone {
+				r.Use(middleware.VerifiedEmailMiddleware(userUseCase))
+			}
+			r.Post("/note", noteHandler.AddNoteHandler())
+			r.Patch("/notes/{id}", noteHandler.UpdateNoteHandler())
+			r.Post("/sync", syncHandler.PushHandler())
+		})
+
+		r.Group(func(r chi.Router) {
+			r.Use(middleware.AdminOnlyMiddleware)
+			if cfg.RequireAdminTwoFactor {
+				r.Use(middleware.TwoFactorRequiredMiddleware(twoFactorUseCase))
+			}
+			r.Get("/allnotes", noteHandler.GetAllNotesHandler())
+			r.Post("/users/{id}/unlock", userHandler.UnlockUserHandler())
+			r.Get("/users/{id}/sessions", sessionHandler.GetUserSessionsHandler())
+			r.Delete("/users/{id}/sessions", sessionHandler.DeleteUserSessionsHandler())
+			r.Delete("/users/{id}/sessions/{sessionID}", sessionHandler.DeleteUserSessionHandler())
+		})
+	})
+
+	return &Application{Router: r, Metrics: m, webhookUseCase: webhookUseCase, outboxRelay: outboxRelay}, nil
+}
+
+// RunWorkers starts the webhook delivery worker and the outbox relay, which stop when the context is cancelled.
+func (a *Application) RunWorkers(ctx context.Context) {
+	go a.webhookUseCase.RunWorker(ctx)
+	go a.outboxRelay.Run(ctx)
+}
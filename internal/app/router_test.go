@@ -0,0 +1,985 @@
+package app
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ananikitina/notes-rest/internal/config"
+	"github.com/ananikitina/notes-rest/internal/domain"
+	"github.com/ananikitina/notes-rest/internal/logging"
+	"github.com/ananikitina/notes-rest/internal/models"
+	"github.com/ananikitina/notes-rest/internal/repository/memory"
+	"github.com/ananikitina/notes-rest/internal/services"
+	"github.com/gorilla/websocket"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const testPassword = "correct-horse-battery"
+
+// testEnv is the application on in-memory repositories and fake services, served over HTTP.
+type testEnv struct {
+	app    *Application
+	server *httptest.Server
+	store  *memory.Store
+	mailer *fakeMailer
+	hasher domain.PasswordHasher
+}
+
+func testConfig() *config.Config {
+	return &config.Config{
+		AppBaseURL:                 "http://notes.test",
+		EmailVerificationPolicy:    config.VerificationPolicyNotes,
+		VerificationResendInterval: time.Minute,
+		RequireAdminTwoFactor:      true,
+		LoginMaxAttempts:           5,
+		LoginMaxAttemptsPerIP:      100,
+		LoginAttemptWindow:         15 * time.Minute,
+		LoginLockoutDuration:       15 * time.Minute,
+		OIDCProviders:              []config.OIDCProviderConfig{{Name: "test", DefaultRole: "user"}},
+		PasswordMinLength:          8,
+		PasswordHashAlgorithm:      services.HashBcrypt,
+		BcryptCost:                 4,
+		WebhookMaxAttempts:         3,
+		WebhookTimeout:             5 * time.Second,
+		OutboxSinks:                []string{config.OutboxSinkEvents, config.OutboxSinkWebhooks},
+		OutboxPollInterval:         10 * time.Millisecond,
+		StreamSessionCheckInterval: 50 * time.Millisecond,
+		MetricsEnabled:             true,
+	}
+}
+
+func newTestEnv(t *testing.T) *testEnv {
+	t.Helper()
+	return newTestEnvWith(t, testConfig(), nil)
+}
+
+// newTestEnvWith creates the test environment with the configuration, letting configure replace
+// the fake services.
+func newTestEnvWith(t *testing.T, cfg *config.Config, configure func(*Services)) *testEnv {
+	t.Helper()
+
+	hasher, err := services.NewPasswordHasher(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy, err := services.NewPasswordPolicy(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store := memory.NewStore()
+	mailer := &fakeMailer{}
+	svc := Services{
+		JWT:            fakeJWT{},
+		Mailer:         mailer,
+		PasswordHasher: hasher,
+		PasswordPolicy: policy,
+		TOTP:           fakeTOTP{},
+		OIDC:           fakeOIDC{},
+		SpellChecker:   fakeSpellChecker{},
+		WebhookSender:  services.NewWebhookSender(cfg),
+		Events:         services.NewHub(),
+	}
+	if configure != nil {
+		configure(&svc)
+	}
+	application, err := New(cfg, Repositories{
+		Users:         store.Users(),
+		LoginAttempts: store.LoginAttempts(),
+		Sessions:      store.Sessions(),
+		Notes:         store.Notes(),
+		Workspaces:    store.Workspaces(),
+		PublicLinks:   store.PublicLinks(),
+		Webhooks:      store.Webhooks(),
+		UnitOfWork:    store,
+	}, svc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(application.Router)
+	t.Cleanup(server.Close)
+	return &testEnv{app: application, server: server, store: store, mailer: mailer, hasher: hasher}
+}
+
+// response is a read HTTP response.
+type response struct {
+	status int
+	header http.Header
+	body   []byte
+}
+
+func (r *response) decode(t *testing.T, v interface{}) {
+	t.Helper()
+	if err := json.Unmarshal(r.body, v); err != nil {
+		t.Fatalf("decoding %s: %v", r.body, err)
+	}
+}
+
+// do sends the request with the JSON body, or form values for url.Values, and reads the response.
+// Redirects are not followed.
+func (e *testEnv) do(t *testing.T, method, path, token string, body interface{}, header http.Header) *response {
+	t.Helper()
+
+	var reader io.Reader
+	contentType := ""
+	switch body := body.(type) {
+	case nil:
+	case string:
+		reader, contentType = strings.NewReader(body), "application/json"
+	case url.Values:
+		reader, contentType = strings.NewReader(body.Encode()), "application/x-www-form-urlencoded"
+	default:
+		data, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader, contentType = strings.NewReader(string(data)), "application/json"
+	}
+
+	req, err := http.NewRequest(method, e.server.URL+path, reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &response{status: resp.StatusCode, header: resp.Header, body: data}
+}
+
+// mustDo sends the request and fails the test unless the response has the status.
+func (e *testEnv) mustDo(t *testing.T, method, path, token string, body interface{}, status int) *response {
+	t.Helper()
+	resp := e.do(t, method, path, token, body, nil)
+	if resp.status != status {
+		t.Fatalf("%s %s: got status %d, want %d: %s", method, path, resp.status, status, resp.body)
+	}
+	return resp
+}
+
+// createUser adds a user with the test password directly to the store.
+func (e *testEnv) createUser(t *testing.T, email, role string, verified bool) int {
+	t.Helper()
+	hash, err := e.hasher.Hash(testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := &models.User{Email: email, Password: hash, Role: role, EmailVerified: verified}
+	if err := e.store.Users().CreateUser(context.Background(), user); err != nil {
+		t.Fatal(err)
+	}
+	return user.ID
+}
+
+// login logs in with the test password and returns the access token.
+func (e *testEnv) login(t *testing.T, email string) string {
+	t.Helper()
+	var result map[string]interface{}
+	e.mustDo(t, "POST", "/login", "", map[string]string{"email": email, "password": testPassword}, http.StatusOK).decode(t, &result)
+	token, _ := result["token"].(string)
+	return token
+}
+
+// fixture is a populated application:
+//   - user owns a private note shared with other as a viewer, with a public link, and a workspace
+//     where other is a viewer and third has a pending invitation;
+//   - user has a webhook with a delivery and a second session;
+//   - admin has two-factor authentication enabled, plainAdmin doesn't;
+//   - unverified has registered but not verified the email.
+type fixture struct {
+	*testEnv
+
+	userID, otherID, thirdID, adminID, plainAdminID int
+	user, other, third, admin, plainAdmin           string
+
+	noteID, workspaceID, linkID, webhookID, sessionID int
+	deliveryID                                        int64
+	linkToken, passwordLinkToken                      string
+	inviteToken, verifyToken                          string
+	mfaToken, recoveryCode                            string
+}
+
+func newFixture(t *testing.T) *fixture {
+	t.Helper()
+	f := &fixture{testEnv: newTestEnv(t)}
+
+	f.userID = f.createUser(t, "user@example.com", "user", true)
+	f.otherID = f.createUser(t, "other@example.com", "user", true)
+	f.thirdID = f.createUser(t, "third@example.com", "user", true)
+	f.adminID = f.createUser(t, "admin@example.com", "admin", true)
+	f.plainAdminID = f.createUser(t, "plain-admin@example.com", "admin", true)
+	f.user = f.login(t, "user@example.com")
+	f.other = f.login(t, "other@example.com")
+	f.third = f.login(t, "third@example.com")
+	f.admin = f.login(t, "admin@example.com")
+	f.plainAdmin = f.login(t, "plain-admin@example.com")
+
+	// A second session of the user to revoke
+	f.login(t, "user@example.com")
+	sessions, err := f.store.Sessions().ListActiveByUser(context.Background(), f.userID)
+	if err != nil || len(sessions) != 2 {
+		t.Fatalf("sessions of the user: %v, %v", sessions, err)
+	}
+	f.sessionID = sessions[0].ID
+
+	var note models.Note
+	f.mustDo(t, "POST", "/note", f.user, map[string]string{"content": "Shopping list"}, http.StatusCreated).decode(t, &note)
+	f.noteID = note.ID
+	f.mustDo(t, "POST", fmt.Sprintf("/notes/%d/shares", f.noteID), f.user,
+		map[string]string{"email": "other@example.com", "permission": "viewer"}, http.StatusAccepted)
+
+	var link models.PublicLink
+	f.mustDo(t, "POST", fmt.Sprintf("/notes/%d/public-link", f.noteID), f.user, "{}", http.StatusCreated).decode(t, &link)
+	f.linkID, f.linkToken = link.ID, link.Token
+	f.mustDo(t, "POST", fmt.Sprintf("/notes/%d/public-link", f.noteID), f.user,
+		map[string]string{"password": "link-password"}, http.StatusCreated).decode(t, &link)
+	f.passwordLinkToken = link.Token
+
+	var workspace models.Workspace
+	f.mustDo(t, "POST", "/workspaces", f.user, map[string]string{"name": "Team"}, http.StatusCreated).decode(t, &workspace)
+	f.workspaceID = workspace.ID
+	invitePath := fmt.Sprintf("/workspaces/%d/invitations", f.workspaceID)
+	f.mustDo(t, "POST", invitePath, f.user, map[string]string{"email": "other@example.com", "role": "viewer"}, http.StatusCreated)
+	f.mustDo(t, "POST", "/workspaces/invitations/accept?token="+url.QueryEscape(f.mailer.token("other@example.com")), f.other, nil, http.StatusOK)
+	f.mustDo(t, "POST", invitePath, f.user, map[string]string{"email": "third@example.com", "role": "editor"}, http.StatusCreated)
+	f.inviteToken = f.mailer.token("third@example.com")
+
+	var webhook models.Webhook
+	f.mustDo(t, "POST", "/webhooks", f.user, map[string]interface{}{"url": "https://hooks.example.com/notes"}, http.StatusCreated).decode(t, &webhook)
+	f.webhookID = webhook.ID
+	if err := f.store.Webhooks().Enqueue(context.Background(), models.EventNoteCreated, []byte(`{}`), []int{f.userID}); err != nil {
+		t.Fatal(err)
+	}
+	deliveries, err := f.store.Webhooks().ListDeliveries(context.Background(), f.webhookID, 1)
+	if err != nil || len(deliveries) != 1 {
+		t.Fatalf("deliveries of the webhook: %v, %v", deliveries, err)
+	}
+	f.deliveryID = deliveries[0].ID
+
+	f.mustDo(t, "POST", "/2fa/setup", f.admin, nil, http.StatusOK)
+	var enabled struct {
+		RecoveryCodes []string `json:"recoveryCodes"`
+	}
+	f.mustDo(t, "POST", "/2fa/enable", f.admin, map[string]string{"code": validTOTPCode}, http.StatusOK).decode(t, &enabled)
+	f.recoveryCode = enabled.RecoveryCodes[0]
+	var pending map[string]interface{}
+	f.mustDo(t, "POST", "/login", "", map[string]string{"email": "admin@example.com", "password": testPassword}, http.StatusOK).decode(t, &pending)
+	f.mfaToken, _ = pending["mfaToken"].(string)
+
+	f.mustDo(t, "POST", "/register", "", map[string]string{"email": "unverified@example.com", "password": testPassword}, http.StatusCreated)
+	f.verifyToken = f.mailer.token("unverified@example.com")
+	return f
+}
+
+// expand replaces the {placeholders} of the fixture in the text.
+func (f *fixture) expand(text string) string {
+	return strings.NewReplacer(
+		"{note}", fmt.Sprint(f.noteID),
+		"{workspace}", fmt.Sprint(f.workspaceID),
+		"{link}", fmt.Sprint(f.linkID),
+		"{webhook}", fmt.Sprint(f.webhookID),
+		"{delivery}", fmt.Sprint(f.deliveryID),
+		"{session}", fmt.Sprint(f.sessionID),
+		"{userID}", fmt.Sprint(f.userID),
+		"{otherID}", fmt.Sprint(f.otherID),
+		"{linkToken}", f.linkToken,
+		"{passwordLinkToken}", f.passwordLinkToken,
+		"{inviteToken}", url.QueryEscape(f.inviteToken),
+		"{verifyToken}", url.QueryEscape(f.verifyToken),
+		"{mfaToken}", f.mfaToken,
+		"{recoveryCode}", f.recoveryCode,
+	).Replace(text)
+}
+
+// token returns the access token of the fixture user with the name; an empty name means no token.
+func (f *fixture) token(name string) string {
+	switch name {
+	case "user":
+		return f.user
+	case "other":
+		return f.other
+	case "third":
+		return f.third
+	case "admin":
+		return f.admin
+	case "plainAdmin":
+		return f.plainAdmin
+	case "invalid":
+		return "access|1|user|999"
+	}
+	return ""
+}
+
+func TestRoutes(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		as     string
+		body   interface{}
+		header http.Header
+		want   int
+	}{
+		// Public routes
+		{name: "jwks", method: "GET", path: "/.well-known/jwks.json", want: http.StatusOK},
+		{name: "register", method: "POST", path: "/register", body: `{"email":"new@example.com","password":"` + testPassword + `"}`, want: http.StatusCreated},
+		{name: "register taken email", method: "POST", path: "/register", body: `{"email":"user@example.com","password":"` + testPassword + `"}`, want: http.StatusConflict},
+		{name: "register invalid email", method: "POST", path: "/register", body: `{"email":"user","password":"` + testPassword + `"}`, want: http.StatusBadRequest},
+		{name: "register weak password", method: "POST", path: "/register", body: `{"email":"new@example.com","password":"short"}`, want: http.StatusBadRequest},
+		{name: "login", method: "POST", path: "/login", body: `{"email":"user@example.com","password":"` + testPassword + `"}`, want: http.StatusOK},
+		{name: "login wrong password", method: "POST", path: "/login", body: `{"email":"user@example.com","password":"wrong password"}`, want: http.StatusUnauthorized},
+		{name: "login unknown email", method: "POST", path: "/login", body: `{"email":"nobody@example.com","password":"` + testPassword + `"}`, want: http.StatusUnauthorized},
+		{name: "login 2fa recovery code", method: "POST", path: "/login/2fa", body: `{"mfaToken":"{mfaToken}","code":"{recoveryCode}"}`, want: http.StatusOK},
+		{name: "login 2fa totp code", method: "POST", path: "/login/2fa", body: `{"mfaToken":"{mfaToken}","code":"` + validTOTPCode + `"}`, want: http.StatusUnauthorized}, // the step was used to enable
+		{name: "login 2fa wrong code", method: "POST", path: "/login/2fa", body: `{"mfaToken":"{mfaToken}","code":"000000"}`, want: http.StatusUnauthorized},
+		{name: "login 2fa invalid token", method: "POST", path: "/login/2fa", body: `{"mfaToken":"invalid","code":"{recoveryCode}"}`, want: http.StatusUnauthorized},
+		{name: "oidc login", method: "GET", path: "/auth/oidc/test/login", want: http.StatusFound},
+		{name: "oidc login unknown provider", method: "GET", path: "/auth/oidc/unknown/login", want: http.StatusNotFound},
+		{name: "oidc callback without cookie", method: "GET", path: "/auth/oidc/test/callback?state=x&code=good", want: http.StatusBadRequest},
+		{name: "oidc callback provider error", method: "GET", path: "/auth/oidc/test/callback?error=access_denied", want: http.StatusUnauthorized},
+		{name: "verify email", method: "GET", path: "/verify-email?token={verifyToken}", want: http.StatusOK},
+		{name: "verify email invalid token", method: "GET", path: "/verify-email?token=invalid", want: http.StatusBadRequest},
+		{name: "verify email without token", method: "GET", path: "/verify-email", want: http.StatusBadRequest},
+		{name: "resend verification throttled", method: "POST", path: "/verify-email/resend", body: `{"email":"unverified@example.com"}`, want: http.StatusTooManyRequests},
+		{name: "resend verification unknown email", method: "POST", path: "/verify-email/resend", body: `{"email":"nobody@example.com"}`, want: http.StatusAccepted},
+		{name: "public link", method: "GET", path: "/p/{linkToken}", want: http.StatusOK},
+		{name: "public link unknown", method: "GET", path: "/p/unknown", want: http.StatusNotFound},
+		{name: "public link password required", method: "GET", path: "/p/{passwordLinkToken}", want: http.StatusUnauthorized},
+		{name: "public link password header", method: "GET", path: "/p/{passwordLinkToken}", header: http.Header{"X-Link-Password": {"link-password"}}, want: http.StatusOK},
+		{name: "public link password form", method: "POST", path: "/p/{passwordLinkToken}", body: url.Values{"password": {"link-password"}}, want: http.StatusOK},
+		{name: "public link wrong password form", method: "POST", path: "/p/{passwordLinkToken}", body: url.Values{"password": {"wrong"}}, want: http.StatusUnauthorized},
+
+		// Authentication
+		{name: "invalid token", method: "GET", path: "/notes", as: "invalid", want: http.StatusUnauthorized},
+		{name: "unknown workspace header", method: "GET", path: "/notes", as: "third", header: http.Header{"X-Workspace-Id": {"{workspace}"}}, want: http.StatusForbidden},
+		{name: "invalid workspace header", method: "GET", path: "/notes", as: "user", header: http.Header{"X-Workspace-Id": {"team"}}, want: http.StatusBadRequest},
+
+		// Notes
+		{name: "add note", method: "POST", path: "/note", as: "user", body: `{"content":"New note"}`, want: http.StatusCreated},
+		{name: "add note with spelling errors", method: "POST", path: "/note", as: "user", body: `{"content":"A mistkae"}`, want: http.StatusBadRequest},
+		{name: "add workspace note as viewer", method: "POST", path: "/note", as: "other", header: http.Header{"X-Workspace-Id": {"{workspace}"}}, body: `{"content":"New note"}`, want: http.StatusForbidden},
+		{name: "add workspace note with a spelling error as viewer", method: "POST", path: "/note", as: "other", header: http.Header{"X-Workspace-Id": {"{workspace}"}}, body: `{"content":"A mistkae"}`, want: http.StatusForbidden},
+		{name: "add workspace note", method: "POST", path: "/note", as: "user", header: http.Header{"X-Workspace-Id": {"{workspace}"}}, body: `{"content":"New note"}`, want: http.StatusCreated},
+		{name: "get notes", method: "GET", path: "/notes?q=shopping", as: "user", want: http.StatusOK},
+		{name: "get workspace notes", method: "GET", path: "/notes", as: "other", header: http.Header{"X-Workspace-Id": {"{workspace}"}}, want: http.StatusOK},
+		{name: "get notes shared with me", method: "GET", path: "/notes/shared-with-me", as: "other", want: http.StatusOK},
+		{name: "get note", method: "GET", path: "/notes/{note}", as: "user", want: http.StatusOK},
+		{name: "get shared note", method: "GET", path: "/notes/{note}", as: "other", want: http.StatusOK},
+		{name: "get note without access", method: "GET", path: "/notes/{note}", as: "third", want: http.StatusNotFound},
+		{name: "get note invalid id", method: "GET", path: "/notes/abc", as: "user", want: http.StatusBadRequest},
+		{name: "update note", method: "PATCH", path: "/notes/{note}", as: "user", body: `{"content":"Updated"}`, want: http.StatusOK},
+		{name: "update note with spelling errors", method: "PATCH", path: "/notes/{note}", as: "user", body: `{"content":"A mistkae"}`, want: http.StatusBadRequest},
+		{name: "update note as viewer", method: "PATCH", path: "/notes/{note}", as: "other", body: `{"content":"Updated"}`, want: http.StatusForbidden},
+		{name: "delete note", method: "DELETE", path: "/notes/{note}", as: "user", want: http.StatusNoContent},
+		{name: "delete note as viewer", method: "DELETE", path: "/notes/{note}", as: "other", want: http.StatusForbidden},
+		{name: "delete unknown note", method: "DELETE", path: "/notes/999", as: "user", want: http.StatusNotFound},
+		{name: "get shares", method: "GET", path: "/notes/{note}/shares", as: "user", want: http.StatusOK},
+		{name: "get shares as viewer", method: "GET", path: "/notes/{note}/shares", as: "other", want: http.StatusForbidden},
+		{name: "share note", method: "POST", path: "/notes/{note}/shares", as: "user", body: `{"email":"third@example.com","permission":"editor"}`, want: http.StatusAccepted},
+		{name: "share note with unknown user", method: "POST", path: "/notes/{note}/shares", as: "user", body: `{"email":"nobody@example.com","permission":"editor"}`, want: http.StatusAccepted},
+		{name: "share note invalid permission", method: "POST", path: "/notes/{note}/shares", as: "user", body: `{"email":"third@example.com","permission":"owner"}`, want: http.StatusBadRequest},
+		{name: "revoke share", method: "DELETE", path: "/notes/{note}/shares/{otherID}", as: "user", want: http.StatusNoContent},
+		{name: "remove share as recipient", method: "DELETE", path: "/notes/{note}/shares/{otherID}", as: "other", want: http.StatusNoContent},
+		{name: "revoke missing share", method: "DELETE", path: "/notes/{note}/shares/{userID}", as: "user", want: http.StatusNotFound},
+		{name: "create public link", method: "POST", path: "/notes/{note}/public-link", as: "user", body: `{}`, want: http.StatusCreated},
+		{name: "create public link as viewer", method: "POST", path: "/notes/{note}/public-link", as: "other", body: `{}`, want: http.StatusForbidden},
+		{name: "get public links", method: "GET", path: "/notes/{note}/public-links", as: "user", want: http.StatusOK},
+		{name: "delete public link", method: "DELETE", path: "/notes/{note}/public-links/{link}", as: "user", want: http.StatusNoContent},
+		{name: "delete unknown public link", method: "DELETE", path: "/notes/{note}/public-links/999", as: "user", want: http.StatusNotFound},
+
+		// Sync
+		{name: "pull", method: "GET", path: "/sync", as: "user", want: http.StatusOK},
+		{name: "pull invalid token", method: "GET", path: "/sync?since=invalid", as: "user", want: http.StatusBadRequest},
+		{name: "push", method: "POST", path: "/sync", as: "user", body: `{"operations":[{"clientId":"1","op":"create","content":"Offline note"}]}`, want: http.StatusOK},
+
+		// Account
+		{name: "get me", method: "GET", path: "/me", as: "user", want: http.StatusOK},
+		{name: "update me", method: "PATCH", path: "/me", as: "user", body: `{"displayName":"User","timezone":"Europe/Moscow"}`, want: http.StatusOK},
+		{name: "update me invalid timezone", method: "PATCH", path: "/me", as: "user", body: `{"timezone":"Nowhere/City"}`, want: http.StatusBadRequest},
+		{name: "delete me", method: "DELETE", path: "/me", as: "user", body: `{"password":"` + testPassword + `"}`, want: http.StatusNoContent},
+		{name: "delete me wrong password", method: "DELETE", path: "/me", as: "user", body: `{"password":"wrong password"}`, want: http.StatusForbidden},
+		{name: "change password", method: "POST", path: "/me/password", as: "user", body: `{"currentPassword":"` + testPassword + `","newPassword":"another-long-password"}`, want: http.StatusOK},
+		{name: "change password wrong password", method: "POST", path: "/me/password", as: "user", body: `{"currentPassword":"wrong password","newPassword":"another-long-password"}`, want: http.StatusForbidden},
+		{name: "change email", method: "POST", path: "/me/email", as: "user", body: `{"email":"renamed@example.com","password":"` + testPassword + `"}`, want: http.StatusAccepted},
+		{name: "change email taken", method: "POST", path: "/me/email", as: "user", body: `{"email":"other@example.com","password":"` + testPassword + `"}`, want: http.StatusAccepted},
+		{name: "get sessions", method: "GET", path: "/sessions", as: "user", want: http.StatusOK},
+		{name: "delete session", method: "DELETE", path: "/sessions/{session}", as: "user", want: http.StatusNoContent},
+		{name: "delete session of another user", method: "DELETE", path: "/sessions/{session}", as: "other", want: http.StatusNotFound},
+		{name: "2fa setup", method: "POST", path: "/2fa/setup", as: "user", want: http.StatusOK},
+		{name: "2fa setup when enabled", method: "POST", path: "/2fa/setup", as: "admin", want: http.StatusConflict},
+		{name: "2fa enable without setup", method: "POST", path: "/2fa/enable", as: "user", body: `{"code":"` + validTOTPCode + `"}`, want: http.StatusBadRequest},
+
+		// Workspaces
+		{name: "create workspace", method: "POST", path: "/workspaces", as: "user", body: `{"name":"Another team"}`, want: http.StatusCreated},
+		{name: "get workspaces", method: "GET", path: "/workspaces", as: "other", want: http.StatusOK},
+		{name: "accept invitation", method: "POST", path: "/workspaces/invitations/accept?token={inviteToken}", as: "third", want: http.StatusOK},
+		{name: "accept invitation of another email", method: "POST", path: "/workspaces/invitations/accept?token={inviteToken}", as: "admin", want: http.StatusForbidden},
+		{name: "accept invitation invalid token", method: "POST", path: "/workspaces/invitations/accept?token=invalid", as: "third", want: http.StatusBadRequest},
+		{name: "get members", method: "GET", path: "/workspaces/{workspace}/members", as: "other", want: http.StatusOK},
+		{name: "get members as non-member", method: "GET", path: "/workspaces/{workspace}/members", as: "third", want: http.StatusNotFound},
+		{name: "remove member", method: "DELETE", path: "/workspaces/{workspace}/members/{otherID}", as: "user", want: http.StatusNoContent},
+		{name: "remove member as viewer", method: "DELETE", path: "/workspaces/{workspace}/members/{userID}", as: "other", want: http.StatusForbidden},
+		{name: "invite", method: "POST", path: "/workspaces/{workspace}/invitations", as: "user", body: `{"email":"new@example.com","role":"viewer"}`, want: http.StatusCreated},
+		{name: "invite as viewer", method: "POST", path: "/workspaces/{workspace}/invitations", as: "other", body: `{"email":"new@example.com","role":"viewer"}`, want: http.StatusForbidden},
+
+		// Webhooks
+		{name: "create webhook", method: "POST", path: "/webhooks", as: "user", body: `{"url":"https://hooks.example.com/other","events":["note.created"]}`, want: http.StatusCreated},
+		{name: "create webhook invalid url", method: "POST", path: "/webhooks", as: "user", body: `{"url":"ftp://hooks.example.com"}`, want: http.StatusBadRequest},
+		{name: "create global webhook", method: "POST", path: "/webhooks", as: "admin", body: `{"url":"https://hooks.example.com/all","global":true}`, want: http.StatusCreated},
+		{name: "create global webhook as user", method: "POST", path: "/webhooks", as: "user", body: `{"url":"https://hooks.example.com/all","global":true}`, want: http.StatusForbidden},
+		{name: "create global webhook as admin without 2fa", method: "POST", path: "/webhooks", as: "plainAdmin", body: `{"url":"https://hooks.example.com/all","global":true}`, want: http.StatusForbidden},
+		{name: "create webhook as admin without 2fa", method: "POST", path: "/webhooks", as: "plainAdmin", body: `{"url":"https://hooks.example.com/own"}`, want: http.StatusCreated},
+		{name: "get webhooks", method: "GET", path: "/webhooks", as: "user", want: http.StatusOK},
+		{name: "delete webhook", method: "DELETE", path: "/webhooks/{webhook}", as: "user", want: http.StatusNoContent},
+		{name: "delete webhook of another user", method: "DELETE", path: "/webhooks/{webhook}", as: "other", want: http.StatusNotFound},
+		{name: "get deliveries", method: "GET", path: "/webhooks/{webhook}/deliveries", as: "user", want: http.StatusOK},
+		{name: "get deliveries of another user", method: "GET", path: "/webhooks/{webhook}/deliveries", as: "other", want: http.StatusNotFound},
+		{name: "redeliver", method: "POST", path: "/webhooks/{webhook}/deliveries/{delivery}/redeliver", as: "user", want: http.StatusAccepted},
+		{name: "redeliver unknown delivery", method: "POST", path: "/webhooks/{webhook}/deliveries/999/redeliver", as: "user", want: http.StatusNotFound},
+
+		// Admin routes
+		{name: "all notes", method: "GET", path: "/allnotes", as: "admin", want: http.StatusOK},
+		{name: "all notes as user", method: "GET", path: "/allnotes", as: "user", want: http.StatusForbidden},
+		{name: "all notes as admin without 2fa", method: "GET", path: "/allnotes", as: "plainAdmin", want: http.StatusForbidden},
+		{name: "unlock user", method: "POST", path: "/users/{userID}/unlock", as: "admin", want: http.StatusOK},
+		{name: "unlock unknown user", method: "POST", path: "/users/999/unlock", as: "admin", want: http.StatusNotFound},
+		{name: "get user sessions", method: "GET", path: "/users/{userID}/sessions", as: "admin", want: http.StatusOK},
+		{name: "delete user sessions", method: "DELETE", path: "/users/{userID}/sessions", as: "admin", want: http.StatusNoContent},
+		{name: "delete user session", method: "DELETE", path: "/users/{userID}/sessions/{session}", as: "admin", want: http.StatusNoContent},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFixture(t)
+
+			body := tt.body
+			if text, ok := body.(string); ok {
+				body = f.expand(text)
+			}
+			header := http.Header{}
+			for name, values := range tt.header {
+				for _, value := range values {
+					header.Add(name, f.expand(value))
+				}
+			}
+
+			resp := f.do(t, tt.method, f.expand(tt.path), f.token(tt.as), body, header)
+			if resp.status != tt.want {
+				t.Errorf("got status %d, want %d: %s", resp.status, tt.want, resp.body)
+			}
+		})
+	}
+}
+
+// protectedRoutes are the routes that require an access token.
+var protectedRoutes = []struct{ method, path string }{
+	{"GET", "/events"},
+	{"GET", "/ws"},
+	{"GET", "/notes"},
+	{"GET", "/notes/shared-with-me"},
+	{"GET", "/sync"},
+	{"POST", "/sync"},
+	{"POST", "/note"},
+	{"GET", "/notes/1"},
+	{"PATCH", "/notes/1"},
+	{"DELETE", "/notes/1"},
+	{"GET", "/notes/1/shares"},
+	{"POST", "/notes/1/shares"},
+	{"DELETE", "/notes/1/shares/2"},
+	{"POST", "/notes/1/public-link"},
+	{"GET", "/notes/1/public-links"},
+	{"DELETE", "/notes/1/public-links/1"},
+	{"GET", "/me"},
+	{"PATCH", "/me"},
+	{"DELETE", "/me"},
+	{"POST", "/me/password"},
+	{"POST", "/me/email"},
+	{"GET", "/sessions"},
+	{"DELETE", "/sessions/1"},
+	{"POST", "/2fa/setup"},
+	{"POST", "/2fa/enable"},
+	{"POST", "/workspaces"},
+	{"GET", "/workspaces"},
+	{"POST", "/workspaces/invitations/accept"},
+	{"GET", "/workspaces/1/members"},
+	{"DELETE", "/workspaces/1/members/2"},
+	{"POST", "/workspaces/1/invitations"},
+	{"POST", "/webhooks"},
+	{"GET", "/webhooks"},
+	{"DELETE", "/webhooks/1"},
+	{"GET", "/webhooks/1/deliveries"},
+	{"POST", "/webhooks/1/deliveries/1/redeliver"},
+	{"GET", "/allnotes"},
+	{"POST", "/users/1/unlock"},
+	{"GET", "/users/1/sessions"},
+	{"DELETE", "/users/1/sessions"},
+	{"DELETE", "/users/1/sessions/1"},
+}
+
+// adminRoutes are the protected routes only admins with two-factor authentication can use.
+var adminRoutes = protectedRoutes[len(protectedRoutes)-5:]
+
+func TestProtectedRoutesRequireToken(t *testing.T) {
+	f := newFixture(t)
+	for _, route := range protectedRoutes {
+		for _, as := range []string{"", "invalid"} {
+			resp := f.do(t, route.method, route.path, f.token(as), nil, nil)
+			if resp.status != http.StatusUnauthorized {
+				t.Errorf("%s %s with token %q: got status %d, want %d", route.method, route.path, as, resp.status, http.StatusUnauthorized)
+			}
+		}
+	}
+}
+
+func TestRevokedSessionIsRejected(t *testing.T) {
+	f := newFixture(t)
+	f.mustDo(t, "DELETE", "/users/"+fmt.Sprint(f.userID)+"/sessions", f.admin, nil, http.StatusNoContent)
+	f.mustDo(t, "GET", "/notes", f.user, nil, http.StatusUnauthorized)
+}
+
+func TestAdminRoutesRequireAdmin(t *testing.T) {
+	f := newFixture(t)
+	for _, route := range adminRoutes {
+		for _, as := range []string{"user", "plainAdmin"} {
+			resp := f.do(t, route.method, route.path, f.token(as), nil, nil)
+			if resp.status != http.StatusForbidden {
+				t.Errorf("%s %s as %s: got status %d, want %d", route.method, route.path, as, resp.status, http.StatusForbidden)
+			}
+		}
+	}
+}
+
+func TestUnverifiedUserCantChangeNotes(t *testing.T) {
+	f := newFixture(t)
+	f.createUser(t, "pending@example.com", "user", false)
+	token := f.login(t, "pending@example.com")
+
+	f.mustDo(t, "POST", "/note", token, map[string]string{"content": "New note"}, http.StatusForbidden)
+	f.mustDo(t, "POST", "/sync", token, map[string]interface{}{"operations": []interface{}{}}, http.StatusForbidden)
+	f.mustDo(t, "GET", "/notes", token, nil, http.StatusOK)
+}
+
+func TestOIDCLogin(t *testing.T) {
+	f := newFixture(t)
+
+	resp := f.mustDo(t, "GET", "/auth/oidc/test/login", "", nil, http.StatusFound)
+	location, err := url.Parse(resp.header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cookie := resp.header.Get("Set-Cookie")
+	if cookie == "" {
+		t.Fatal("no flow cookie")
+	}
+	state := location.Query().Get("state")
+
+	callback := func(state, code string) *response {
+		return f.do(t, "GET", "/auth/oidc/test/callback?state="+url.QueryEscape(state)+"&code="+code, "", nil,
+			http.Header{"Cookie": {strings.SplitN(cookie, ";", 2)[0]}})
+	}
+	if resp := callback("other state", "good"); resp.status != http.StatusBadRequest {
+		t.Errorf("wrong state: got status %d, want %d", resp.status, http.StatusBadRequest)
+	}
+	if resp := callback(state, "bad"); resp.status != http.StatusUnauthorized {
+		t.Errorf("wrong code: got status %d, want %d", resp.status, http.StatusUnauthorized)
+	}
+
+	resp = callback(state, "good")
+	if resp.status != http.StatusOK {
+		t.Fatalf("got status %d, want %d: %s", resp.status, http.StatusOK, resp.body)
+	}
+	var result map[string]string
+	resp.decode(t, &result)
+	var user models.User
+	f.mustDo(t, "GET", "/me", result["token"], nil, http.StatusOK).decode(t, &user)
+	if user.Email != "sso@example.com" || !user.EmailVerified {
+		t.Errorf("provisioned user %+v", user)
+	}
+}
+
+// runWorkers runs the outbox relay and the webhook worker until the test ends.
+func (e *testEnv) runWorkers(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	e.app.RunWorkers(ctx)
+}
+
+func TestEventStream(t *testing.T) {
+	env := newTestEnv(t)
+	env.createUser(t, "user@example.com", "user", true)
+	token := env.login(t, "user@example.com")
+	env.runWorkers(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", env.server.URL+"/events?access_token="+url.QueryEscape(token), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("got status %d and content type %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+
+	env.mustDo(t, "POST", "/note", token, map[string]string{"content": "New note"}, http.StatusCreated)
+
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		if scanner.Text() == "event: "+models.EventNoteCreated {
+			return
+		}
+	}
+	t.Fatalf("no %s event in the stream: %v", models.EventNoteCreated, scanner.Err())
+}
+
+func TestWebSocket(t *testing.T) {
+	env := newTestEnv(t)
+	env.createUser(t, "user@example.com", "user", true)
+	token := env.login(t, "user@example.com")
+	env.runWorkers(t)
+
+	wsURL := "ws" + strings.TrimPrefix(env.server.URL, "http") + "/ws"
+	if _, resp, err := websocket.DefaultDialer.Dial(wsURL, nil); err == nil || resp == nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("dial without a token: %v, %v", resp, err)
+	}
+
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL+"?access_token="+url.QueryEscape(token), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	env.mustDo(t, "POST", "/note", token, map[string]string{"content": "New note"}, http.StatusCreated)
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var event models.Event
+	if err := conn.ReadJSON(&event); err != nil {
+		t.Fatal(err)
+	}
+	if event.Type != models.EventNoteCreated {
+		t.Errorf("got event %q, want %q", event.Type, models.EventNoteCreated)
+	}
+}
+
+func TestStreamsCloseWhenSessionIsRevoked(t *testing.T) {
+	env := newTestEnv(t)
+	env.createUser(t, "user@example.com", "user", true)
+	token := env.login(t, "user@example.com")
+	other := env.login(t, "user@example.com")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", env.server.URL+"/events?access_token="+url.QueryEscape(token), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(env.server.URL, "http")+"/ws?access_token="+url.QueryEscape(token), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	var sessions []models.Session
+	env.mustDo(t, "GET", "/sessions", other, nil, http.StatusOK).decode(t, &sessions)
+	for _, session := range sessions {
+		if !session.Current {
+			env.mustDo(t, "DELETE", fmt.Sprintf("/sessions/%d", session.ID), other, nil, http.StatusNoContent)
+		}
+	}
+
+	// Both streams end on their own, before the context times out
+	if _, err := io.Copy(io.Discard, resp.Body); err != nil {
+		t.Errorf("event stream wasn't closed: %v", err)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, _, err = conn.ReadMessage()
+	if !websocket.IsCloseError(err, websocket.ClosePolicyViolation) {
+		t.Errorf("WebSocket read error = %v, want a policy violation close", err)
+	}
+}
+
+// syncBuffer is a buffer the server can log to while the test reads it.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+// records decodes the JSON log records.
+func (b *syncBuffer) records(t *testing.T) []map[string]interface{} {
+	t.Helper()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var records []map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(b.buf.Bytes()))
+	for decoder.More() {
+		var record map[string]interface{}
+		if err := decoder.Decode(&record); err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+func TestRequestIDAndAccessLog(t *testing.T) {
+	logs := &syncBuffer{}
+	defaultLogger := slog.Default()
+	slog.SetDefault(logging.New(logs, &config.Config{LogFormat: config.LogFormatJSON, LogLevel: slog.LevelInfo}))
+	t.Cleanup(func() { slog.SetDefault(defaultLogger) })
+
+	e := newTestEnv(t)
+	userID := e.createUser(t, "user@ex.com", "user", true)
+	token := e.login(t, "user@ex.com")
+
+	resp := e.do(t, "GET", "/notes", token, nil, http.Header{"X-Request-Id": {"client-request-1"}})
+	if got := resp.header.Get("X-Request-ID"); resp.status != http.StatusOK || got != "client-request-1" {
+		t.Fatalf("got status %d and request ID %q, want 200 and the client's ID", resp.status, got)
+	}
+	for _, header := range []http.Header{nil, {"X-Request-Id": {"has spaces"}}} {
+		if got := e.do(t, "GET", "/notes", token, nil, header).header.Get("X-Request-ID"); len(got) != 32 {
+			t.Errorf("request ID with header %v = %q, want a generated ID", header, got)
+		}
+	}
+
+	var access map[string]interface{}
+	for _, record := range logs.records(t) {
+		if record["msg"] == "Request" && record["request_id"] == "client-request-1" {
+			access = record
+		}
+	}
+	if access == nil {
+		t.Fatal("no access log record with the request ID")
+	}
+	want := map[string]interface{}{"level": "INFO", "method": "GET", "route": "/notes", "path": "/notes",
+		"status": float64(http.StatusOK), "user_id": float64(userID)}
+	for key, value := range want {
+		if access[key] != value {
+			t.Errorf("access log %s = %v, want %v", key, access[key], value)
+		}
+	}
+	if _, ok := access["latency"].(float64); !ok {
+		t.Errorf("access log has no latency: %v", access)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	e := newTestEnv(t)
+	e.createUser(t, "user@ex.com", "user", true)
+	token := e.login(t, "user@ex.com")
+	e.do(t, "GET", "/notes", token, nil, nil)
+	e.do(t, "POST", "/login", "", map[string]string{"email": "user@ex.com", "password": "wrong password"}, nil)
+	e.do(t, "GET", "/no-such-route", "", nil, nil)
+
+	resp := e.do(t, "GET", "/metrics", "", nil, nil)
+	if resp.status != http.StatusOK {
+		t.Fatalf("got status %d, want 200", resp.status)
+	}
+	for _, want := range []string{
+		`notes_http_requests_total{method="GET",route="/notes",status="200"} 1`,
+		`notes_http_requests_total{method="GET",route="unmatched",status="404"} 1`,
+		`notes_http_request_duration_seconds_bucket{method="GET",route="/notes",status="200",le="+Inf"} 1`,
+		`notes_logins_total{result="success"} 1`,
+		`notes_logins_total{result="failure"} 1`,
+		"notes_users 1",
+		"notes_notes 0",
+		"go_goroutines",
+	} {
+		if !strings.Contains(string(resp.body), want) {
+			t.Errorf("metrics lack %q", want)
+		}
+	}
+}
+
+func TestLoginMetricsCountIssuedSessions(t *testing.T) {
+	e := newTestEnv(t)
+	e.createUser(t, "user@ex.com", "user", true)
+	e.createUser(t, "oidc@ex.com", "user", true)
+	token := e.login(t, "user@ex.com")
+	e.mustDo(t, "POST", "/2fa/setup", token, nil, http.StatusOK)
+	var enabled struct {
+		RecoveryCodes []string `json:"recoveryCodes"`
+	}
+	e.mustDo(t, "POST", "/2fa/enable", token, map[string]string{"code": validTOTPCode}, http.StatusOK).decode(t, &enabled)
+
+	// The password alone doesn't log in a user with two-factor authentication
+	var pending map[string]interface{}
+	e.mustDo(t, "POST", "/login", "", map[string]string{"email": "user@ex.com", "password": testPassword}, http.StatusOK).decode(t, &pending)
+	mfaToken, _ := pending["mfaToken"].(string)
+	secondFactor := func(code string, status int) {
+		t.Helper()
+		e.mustDo(t, "POST", "/login/2fa", "", map[string]string{"mfaToken": mfaToken, "code": code}, status)
+	}
+	secondFactor("000000", http.StatusUnauthorized)
+	secondFactor(enabled.RecoveryCodes[0], http.StatusTooManyRequests)
+	if err := e.store.LoginAttempts().ClearFailures(context.Background(), "user@ex.com"); err != nil {
+		t.Fatal(err)
+	}
+	secondFactor(enabled.RecoveryCodes[0], http.StatusOK)
+	e.oidcLogin(t, "email:oidc@ex.com")
+
+	resp := e.mustDo(t, "GET", "/metrics", "", nil, http.StatusOK)
+	for _, want := range []string{
+		`notes_logins_total{result="success"} 3`,
+		`notes_logins_total{result="failure"} 1`,
+		`notes_logins_total{result="throttled"} 1`,
+	} {
+		if !strings.Contains(string(resp.body), want) {
+			t.Errorf("metrics lack %q", want)
+		}
+	}
+}
+
+func TestOIDCLoginMetrics(t *testing.T) {
+	e := newTestEnv(t)
+	e.createUser(t, "user@ex.com", "user", true)
+	// Anyone could have registered the address before its owner logs in with the provider
+	e.mustDo(t, "POST", "/register", "", map[string]string{"email": "unverified@ex.com", "password": testPassword}, http.StatusCreated)
+
+	e.mustDo(t, "GET", "/auth/oidc/test/callback?error=access_denied", "", nil, http.StatusUnauthorized)
+	e.mustDo(t, "GET", "/auth/oidc/test/callback?state=forged&code=good", "", nil, http.StatusBadRequest)
+	for code, status := range map[string]int{
+		"bad":                     http.StatusUnauthorized,
+		"email:unverified@ex.com": http.StatusConflict,
+		"email:user@ex.com":       http.StatusOK,
+	} {
+		if resp := e.oidcLogin(t, code); resp.status != status {
+			t.Fatalf("callback with code %q: got status %d, want %d: %s", code, resp.status, status, resp.body)
+		}
+	}
+
+	resp := e.mustDo(t, "GET", "/metrics", "", nil, http.StatusOK)
+	for _, want := range []string{
+		`notes_logins_total{result="success"} 1`,
+		`notes_logins_total{result="failure"} 4`,
+	} {
+		if !strings.Contains(string(resp.body), want) {
+			t.Errorf("metrics lack %q", want)
+		}
+	}
+}
+
+var (
+	recordSpansOnce sync.Once
+	spanRecorder    *tracetest.SpanRecorder
+)
+
+// recordSpans installs a tracer provider recording the spans in memory. Tracers taken before keep
+// the first provider installed, so it is installed once and shared by the tests, which tell their
+// spans apart by the trace ID.
+func recordSpans() *tracetest.SpanRecorder {
+	recordSpansOnce.Do(func() {
+		spanRecorder = tracetest.NewSpanRecorder()
+		otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spanRecorder)))
+		otel.SetTextMapPropagator(propagation.TraceContext{})
+	})
+	return spanRecorder
+}
+
+func TestTracing(t *testing.T) {
+	spans := recordSpans()
+
+	// The speller tells the trace context it was called with
+	spellerTraces := make(chan string, 1)
+	speller := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		spellerTraces <- r.Header.Get("traceparent")
+		w.Write([]byte("[]"))
+	}))
+	defer speller.Close()
+
+	cfg := testConfig()
+	cfg.SpellcheckAPIURL = speller.URL
+	cfg.SpellcheckTimeout = 5 * time.Second
+	e := newTestEnvWith(t, cfg, func(svc *Services) {
+		svc.SpellChecker = services.NewYandexSpellChecker(cfg)
+	})
+	e.createUser(t, "user@ex.com", "user", true)
+	token := e.login(t, "user@ex.com")
+
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	resp := e.do(t, "POST", "/note", token, map[string]string{"content": "Shopping list"},
+		http.Header{"Traceparent": {"00-" + traceID + "-00f067aa0ba902b7-01"}})
+	if resp.status != http.StatusCreated {
+		t.Fatalf("got status %d: %s", resp.status, resp.body)
+	}
+
+	byName := map[string]sdktrace.ReadOnlySpan{}
+	for _, span := range spans.Ended() {
+		if span.SpanContext().TraceID().String() == traceID {
+			byName[span.Name()] = span
+		}
+	}
+	server, ok := byName["POST /note"]
+	if !ok {
+		t.Fatalf("no server span named after the route in the caller's trace, got %v", byName)
+	}
+	if server.SpanKind() != trace.SpanKindServer || server.Parent().SpanID().String() != "00f067aa0ba902b7" {
+		t.Errorf("server span has kind %v and parent %v, want a server span continuing the caller's", server.SpanKind(), server.Parent().SpanID())
+	}
+	for _, name := range []string{"NoteUseCase.AddNote", "spellcheck POST"} {
+		span, ok := byName[name]
+		if !ok {
+			t.Errorf("no %s span in the trace", name)
+			continue
+		}
+		if span.Parent().SpanID() != server.SpanContext().SpanID() {
+			t.Errorf("%s span is not a child of the server span", name)
+		}
+	}
+
+	select {
+	case header := <-spellerTraces:
+		if !strings.Contains(header, traceID) || !strings.HasSuffix(header, byName["spellcheck POST"].SpanContext().SpanID().String()+"-01") {
+			t.Errorf("speller got traceparent %q, want the client span of the trace", header)
+		}
+	default:
+		t.Error("the speller was not called")
+	}
+}
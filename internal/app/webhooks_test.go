@@ -0,0 +1,159 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/ananikitina/notes-rest/internal/models"
+	"github.com/ananikitina/notes-rest/internal/services"
+)
+
+// receivedDelivery is a webhook delivery received by a webhookReceiver.
+type receivedDelivery struct {
+	path, event string
+	signature   string
+	timestamp   string
+	body        []byte
+}
+
+// webhookReceiver is a local webhook endpoint that records the deliveries it receives.
+type webhookReceiver struct {
+	*httptest.Server
+	mu         sync.Mutex
+	deliveries []receivedDelivery
+}
+
+func newWebhookReceiver(t *testing.T) *webhookReceiver {
+	t.Helper()
+	receiver := &webhookReceiver{}
+	receiver.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		receiver.mu.Lock()
+		receiver.deliveries = append(receiver.deliveries, receivedDelivery{
+			path:      r.URL.Path,
+			event:     r.Header.Get(services.WebhookEventHeader),
+			signature: r.Header.Get(services.WebhookSignatureHeader),
+			timestamp: r.Header.Get(services.WebhookTimestampHeader),
+			body:      body,
+		})
+		receiver.mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	t.Cleanup(receiver.Close)
+	return receiver
+}
+
+// received returns the deliveries to the path.
+func (r *webhookReceiver) received(path string) []receivedDelivery {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var deliveries []receivedDelivery
+	for _, delivery := range r.deliveries {
+		if delivery.path == path {
+			deliveries = append(deliveries, delivery)
+		}
+	}
+	return deliveries
+}
+
+// deliverWebhooks relays the outbox and sends the due webhook deliveries.
+func (e *testEnv) deliverWebhooks(t *testing.T) {
+	t.Helper()
+	ctx := context.Background()
+	if _, err := e.app.outboxRelay.RelayBatch(ctx); err != nil {
+		t.Fatal(err)
+	}
+	e.app.webhookUseCase.ProcessDue(ctx)
+}
+
+func TestWebhookDeliveries(t *testing.T) {
+	cfg := testConfig()
+	cfg.WebhookAllowPrivateNetworks = true
+	cfg.RequireAdminTwoFactor = false
+	env := newTestEnvWith(t, cfg, nil)
+	env.createUser(t, "user@example.com", "user", true)
+	env.createUser(t, "other@example.com", "user", true)
+	env.createUser(t, "admin@example.com", "admin", true)
+	user, other, admin := env.login(t, "user@example.com"), env.login(t, "other@example.com"), env.login(t, "admin@example.com")
+	receiver := newWebhookReceiver(t)
+
+	var own, global models.Webhook
+	env.mustDo(t, "POST", "/webhooks", user, map[string]interface{}{"url": receiver.URL + "/own", "events": []string{models.EventNoteCreated}},
+		http.StatusCreated).decode(t, &own)
+	env.mustDo(t, "POST", "/webhooks", admin, map[string]interface{}{"url": receiver.URL + "/global", "global": true},
+		http.StatusCreated).decode(t, &global)
+
+	var note models.Note
+	env.mustDo(t, "POST", "/note", user, map[string]string{"content": "Mine"}, http.StatusCreated).decode(t, &note)
+	env.mustDo(t, "PATCH", fmt.Sprintf("/notes/%d", note.ID), user, map[string]string{"content": "Mine, changed"}, http.StatusOK)
+	env.mustDo(t, "POST", "/note", other, map[string]string{"content": "Not mine"}, http.StatusCreated)
+	env.deliverWebhooks(t)
+
+	tests := []struct {
+		name    string
+		webhook models.Webhook
+		path    string
+		events  []string
+	}{
+		// Only the subscribed events of the notes the owner can see
+		{name: "own", webhook: own, path: "/own", events: []string{models.EventNoteCreated}},
+		{name: "global", webhook: global, path: "/global",
+			events: []string{models.EventNoteCreated, models.EventNoteUpdated, models.EventNoteCreated}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deliveries := receiver.received(tt.path)
+			if len(deliveries) != len(tt.events) {
+				t.Fatalf("got %d deliveries, want %d: %+v", len(deliveries), len(tt.events), deliveries)
+			}
+			for i, delivery := range deliveries {
+				if want := services.SignWebhook(tt.webhook.Secret, delivery.timestamp, delivery.body); delivery.signature != want {
+					t.Errorf("delivery %d has signature %q, want %q", i, delivery.signature, want)
+				}
+				var payload struct {
+					Type string      `json:"type"`
+					Data models.Note `json:"data"`
+				}
+				if err := json.Unmarshal(delivery.body, &payload); err != nil {
+					t.Fatal(err)
+				}
+				if delivery.event != tt.events[i] || payload.Type != tt.events[i] || payload.Data.ID == 0 {
+					t.Errorf("delivery %d = %s %s, want %s with the note", i, delivery.event, delivery.body, tt.events[i])
+				}
+			}
+		})
+	}
+
+	var history []models.WebhookDelivery
+	env.mustDo(t, "GET", fmt.Sprintf("/webhooks/%d/deliveries", own.ID), user, nil, http.StatusOK).decode(t, &history)
+	if len(history) != 1 || history[0].Status != models.DeliverySucceeded {
+		t.Errorf("delivery log of the webhook = %+v", history)
+	}
+}
+
+func TestWebhooksDontReachPrivateNetworks(t *testing.T) {
+	env := newTestEnv(t)
+	env.createUser(t, "user@example.com", "user", true)
+	user := env.login(t, "user@example.com")
+	receiver := newWebhookReceiver(t)
+
+	var webhook models.Webhook
+	env.mustDo(t, "POST", "/webhooks", user, map[string]interface{}{"url": receiver.URL}, http.StatusCreated).decode(t, &webhook)
+	env.mustDo(t, "POST", "/note", user, map[string]string{"content": "New note"}, http.StatusCreated)
+	env.deliverWebhooks(t)
+
+	if deliveries := receiver.received("/"); len(deliveries) != 0 {
+		t.Errorf("loopback receiver got %d deliveries", len(deliveries))
+	}
+	var history []models.WebhookDelivery
+	env.mustDo(t, "GET", fmt.Sprintf("/webhooks/%d/deliveries", webhook.ID), user, nil, http.StatusOK).decode(t, &history)
+	if len(history) != 1 || history[0].Status == models.DeliverySucceeded || history[0].Error == "" {
+		t.Errorf("delivery log of the webhook = %+v, want a refused delivery", history)
+	}
+}
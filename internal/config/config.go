@@ -1,28 +1,393 @@
 package config
 
 import (
-	"log"
-	"os"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 )
 
+// Email verification policies.
+const (
+	VerificationPolicyNone  = "none"  // unverified users have full access
+	VerificationPolicyNotes = "notes" // unverified users cannot create notes
+	VerificationPolicyLogin = "login" // unverified users cannot log in
+)
+
+// Storage drivers.
+const (
+	StorageDriverPostgres = "postgres"
+	StorageDriverSQLite   = "sqlite" // local development and single-user installs
+)
+
+// Outbox sinks.
+const (
+	OutboxSinkEvents   = "events"
+	OutboxSinkWebhooks = "webhooks"
+	OutboxSinkLog      = "log"
+)
+
+// Log formats.
+const (
+	LogFormatJSON = "json"
+	LogFormatText = "text"
+)
+
+// Trace exporters.
+const (
+	TracingExporterNone   = "none"
+	TracingExporterStdout = "stdout" // spans are written to stdout, for local debugging
+	TracingExporterOTLP   = "otlp"   // spans are sent to an OpenTelemetry collector over OTLP/HTTP
+)
+
+// OIDCProviderConfig describes an external OpenID Connect identity provider.
+type OIDCProviderConfig struct {
+	Name         string
+	IssuerURL    string
+	ClientID     string
+	ClientSecret string
+	Scopes       []string
+	// Claim with the user's groups and the groups that get the admin role
+	GroupsClaim string
+	AdminGroups []string
+	// Role of users provisioned on their first login
+	DefaultRole string
+}
+
 type Config struct {
-	PostgresURL    string
-	ExternalAPIURL string
-	JWTSecret      string
+	// Database backend: "postgres" or "sqlite", which stores everything in the SQLitePath file
+	StorageDriver string
+	PostgresURL   string
+	SQLitePath    string
+	// Apply pending migrations on startup; without it they are run with the migrate command
+	AutoMigrate bool
+
+	// Limit of every repository call and unit of work transaction; zero disables it
+	DBQueryTimeout time.Duration
+	// Connection pool of the database; zero lifetimes keep connections open
+	DBMaxOpenConns    int
+	DBMaxIdleConns    int
+	DBConnMaxLifetime time.Duration
+	DBConnMaxIdleTime time.Duration
+	// How long to retry connecting on startup while the database is not ready; zero tries once
+	DBConnectTimeout time.Duration
+
+	// Address the HTTP server listens on, e.g. ":8080"
+	ListenAddr string
+	// Server timeouts; zero disables them. A write timeout also ends event streams and WebSockets
+	HTTPReadHeaderTimeout time.Duration
+	HTTPReadTimeout       time.Duration
+	HTTPWriteTimeout      time.Duration
+	HTTPIdleTimeout       time.Duration
+	// How long requests in progress are waited for on shutdown
+	ShutdownTimeout time.Duration
+
+	// Format of the log, "json" or "text", and the lowest level logged
+	LogFormat string
+	LogLevel  slog.Level
+
+	// Serve Prometheus metrics at /metrics, on the separate MetricsAddr listener if it is set and
+	// on the main listener otherwise
+	MetricsEnabled bool
+	MetricsAddr    string
+
+	// Where OpenTelemetry traces are exported: "none", "stdout" or "otlp" to TracingOTLPEndpoint
+	TracingExporter     string
+	TracingOTLPEndpoint string
+
+	JWTSecret string
+
+	// Comma separated "kid=path[@notBefore]" list of RSA/Ed25519 PEM keys, see README
+	JWTKeys     string
+	JWTIssuer   string
+	JWTAudience string
+
+	// Public URL of the service, used to build links sent by email
+	AppBaseURL string
+
+	// Yandex.Speller endpoint notes are checked with
+	SpellcheckAPIURL  string
+	SpellcheckTimeout time.Duration
+
+	// SMTP settings; emails are written to the log when SMTPHost is empty
+	SMTPHost     string
+	SMTPPort     string
+	SMTPUsername string
+	SMTPPassword string
+	SMTPFrom     string
+
+	EmailVerificationPolicy    string
+	VerificationResendInterval time.Duration
+
+	// Admins without two-factor authentication can't access admin routes
+	RequireAdminTwoFactor bool
+
+	// Failed login limits; an account is locked after LoginMaxAttempts failures within LoginAttemptWindow
+	LoginMaxAttempts      int
+	LoginMaxAttemptsPerIP int
+	LoginAttemptWindow    time.Duration
+	LoginLockoutDuration  time.Duration
+
+	OIDCProviders []OIDCProviderConfig
+
+	// Password policy; the blocklist file adds to the built-in list of common passwords
+	PasswordMinLength     int
+	PasswordBlocklistFile string
+
+	// Hashing of new passwords: "bcrypt" or "argon2id". Older hashes are upgraded on login
+	PasswordHashAlgorithm string
+	BcryptCost            int
+	Argon2MemoryKiB       int
+	Argon2Iterations      int
+	Argon2Parallelism     int
+
+	// Webhook deliveries are retried with exponential backoff until WebhookMaxAttempts fail.
+	// Private network URLs are rejected unless allowed, so webhooks can't reach internal services
+	WebhookMaxAttempts          int
+	WebhookTimeout              time.Duration
+	WebhookAllowPrivateNetworks bool
+
+	// Sinks the outbox relay passes domain events to: "events" (streams), "webhooks" and "log"
+	OutboxSinks        []string
+	OutboxPollInterval time.Duration
+
+	// Open event streams check their session at this interval and are closed once it's revoked or expired
+	StreamSessionCheckInterval time.Duration
+
+	// settings are the resolved values, for Print
+	settings []setting
 }
 
-func LoadConfig() (*Config, error) {
+// minJWTSecretLength is the shortest accepted HS256 secret, the size of the hash.
+const minJWTSecretLength = 32
+
+// Load reads the configuration from the flags at the start of args, the environment (with variables
+// from a .env file) and the YAML config file, in this order of precedence, and validates it. It returns
+// the arguments after the flags. All invalid settings are reported in the error; the configuration
+// is still returned with it, so the resolved values can be printed, unless the flags or the config
+// file couldn't be read.
+func Load(args []string) (*Config, []string, error) {
 	if err := godotenv.Load(); err != nil {
-		log.Printf("No .env file found")
+		slog.Info("No .env file found")
+	}
+
+	l, args, err := newLoader(args)
+	if err != nil {
+		return nil, nil, err
 	}
 
 	config := &Config{
-		PostgresURL:    os.Getenv("POSTGRES_URL"),
-		ExternalAPIURL: os.Getenv("EXTERNAL_API_URL"),
-		JWTSecret:      os.Getenv("JWT_SECRET"),
+		StorageDriver: l.string("STORAGE_DRIVER", StorageDriverPostgres),
+		PostgresURL:   l.string("POSTGRES_URL", ""),
+		SQLitePath:    l.string("SQLITE_PATH", "notes.db"),
+		AutoMigrate:   l.bool("AUTO_MIGRATE", "true"),
+
+		DBQueryTimeout:    l.duration("DB_QUERY_TIMEOUT", "5s"),
+		DBMaxOpenConns:    l.int("DB_MAX_OPEN_CONNS", "25"),
+		DBMaxIdleConns:    l.int("DB_MAX_IDLE_CONNS", "25"),
+		DBConnMaxLifetime: l.duration("DB_CONN_MAX_LIFETIME", "30m"),
+		DBConnMaxIdleTime: l.duration("DB_CONN_MAX_IDLE_TIME", "5m"),
+		DBConnectTimeout:  l.duration("DB_CONNECT_TIMEOUT", "1m"),
+
+		ListenAddr:            l.string("LISTEN_ADDR", ":8080"),
+		HTTPReadHeaderTimeout: l.duration("HTTP_READ_HEADER_TIMEOUT", "10s"),
+		HTTPReadTimeout:       l.duration("HTTP_READ_TIMEOUT", "0s"),
+		HTTPWriteTimeout:      l.duration("HTTP_WRITE_TIMEOUT", "0s"),
+		HTTPIdleTimeout:       l.duration("HTTP_IDLE_TIMEOUT", "2m"),
+		ShutdownTimeout:       l.duration("SHUTDOWN_TIMEOUT", "5s"),
+
+		LogFormat: l.string("LOG_FORMAT", LogFormatJSON),
+		LogLevel:  l.level("LOG_LEVEL", "info"),
+
+		MetricsEnabled: l.bool("METRICS_ENABLED", "true"),
+		MetricsAddr:    l.string("METRICS_ADDR", ""),
+
+		TracingExporter:     l.string("TRACING_EXPORTER", TracingExporterNone),
+		TracingOTLPEndpoint: l.string("TRACING_OTLP_ENDPOINT", "http://localhost:4318/v1/traces"),
+
+		JWTSecret:   l.string("JWT_SECRET", ""),
+		JWTKeys:     l.string("JWT_KEYS", ""),
+		JWTIssuer:   l.string("JWT_ISSUER", "notes-rest"),
+		JWTAudience: l.string("JWT_AUDIENCE", "notes-rest"),
+
+		AppBaseURL: l.string("APP_BASE_URL", "http://localhost:8080"),
+
+		SpellcheckAPIURL:  l.string("SPELLCHECK_API_URL", "https://speller.yandex.net/services/spellservice.json/checkText"),
+		SpellcheckTimeout: l.duration("SPELLCHECK_TIMEOUT", "10s"),
+
+		SMTPHost:     l.string("SMTP_HOST", ""),
+		SMTPPort:     l.string("SMTP_PORT", "587"),
+		SMTPUsername: l.string("SMTP_USERNAME", ""),
+		SMTPPassword: l.string("SMTP_PASSWORD", ""),
+		SMTPFrom:     l.string("SMTP_FROM", "notes-rest@localhost"),
+
+		EmailVerificationPolicy:    l.string("EMAIL_VERIFICATION_POLICY", VerificationPolicyNotes),
+		VerificationResendInterval: l.duration("VERIFICATION_RESEND_INTERVAL", "1m"),
+		RequireAdminTwoFactor:      l.bool("REQUIRE_ADMIN_2FA", "true"),
+
+		LoginMaxAttempts:      l.int("LOGIN_MAX_ATTEMPTS", "5"),
+		LoginMaxAttemptsPerIP: l.int("LOGIN_MAX_ATTEMPTS_PER_IP", "20"),
+		LoginAttemptWindow:    l.duration("LOGIN_ATTEMPT_WINDOW", "15m"),
+		LoginLockoutDuration:  l.duration("LOGIN_LOCKOUT_DURATION", "15m"),
+
+		PasswordMinLength:     l.int("PASSWORD_MIN_LENGTH", "8"),
+		PasswordBlocklistFile: l.string("PASSWORD_BLOCKLIST_FILE", ""),
+		PasswordHashAlgorithm: l.string("PASSWORD_HASH_ALGORITHM", "bcrypt"),
+		BcryptCost:            l.int("BCRYPT_COST", "12"),
+		Argon2MemoryKiB:       l.int("ARGON2_MEMORY_KIB", "65536"),
+		Argon2Iterations:      l.int("ARGON2_ITERATIONS", "3"),
+		Argon2Parallelism:     l.int("ARGON2_PARALLELISM", "2"),
+
+		WebhookMaxAttempts:          l.int("WEBHOOK_MAX_ATTEMPTS", "8"),
+		WebhookTimeout:              l.duration("WEBHOOK_TIMEOUT", "10s"),
+		WebhookAllowPrivateNetworks: l.bool("WEBHOOK_ALLOW_PRIVATE_NETWORKS", "false"),
+
+		OutboxSinks:        l.list("OUTBOX_SINKS", "events,webhooks"),
+		OutboxPollInterval: l.duration("OUTBOX_POLL_INTERVAL", "500ms"),
+
+		StreamSessionCheckInterval: l.duration("STREAM_SESSION_CHECK_INTERVAL", "30s"),
+	}
+	for _, name := range l.list("OIDC_PROVIDERS", "") {
+		config.OIDCProviders = append(config.OIDCProviders, loadOIDCProvider(l, name))
+	}
+	config.settings = l.settings
+
+	return config, args, errors.Join(l.err(), config.Validate())
+}
+
+// loadOIDCProvider reads the OIDC_<NAME>_* settings of the provider.
+func loadOIDCProvider(l *loader, name string) OIDCProviderConfig {
+	prefix := "OIDC_" + strings.ToUpper(name) + "_"
+	return OIDCProviderConfig{
+		Name:         name,
+		IssuerURL:    l.string(prefix+"ISSUER_URL", ""),
+		ClientID:     l.string(prefix+"CLIENT_ID", ""),
+		ClientSecret: l.string(prefix+"CLIENT_SECRET", ""),
+		Scopes:       l.list(prefix+"SCOPES", "openid,email,profile"),
+		GroupsClaim:  l.string(prefix+"GROUPS_CLAIM", "groups"),
+		AdminGroups:  l.list(prefix+"ADMIN_GROUPS", ""),
+		DefaultRole:  l.string(prefix+"DEFAULT_ROLE", "user"),
+	}
+}
+
+// Validate checks the settings and reports all invalid ones.
+func (c *Config) Validate() error {
+	var errs []error
+	invalid := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	switch c.StorageDriver {
+	case StorageDriverPostgres:
+		if c.PostgresURL == "" {
+			invalid("POSTGRES_URL must be set for the postgres storage driver")
+		}
+	case StorageDriverSQLite:
+		if c.SQLitePath == "" {
+			invalid("SQLITE_PATH must be set for the sqlite storage driver")
+		}
+	default:
+		invalid("invalid STORAGE_DRIVER %q", c.StorageDriver)
+	}
+
+	if c.JWTKeys == "" && len(c.JWTSecret) < minJWTSecretLength {
+		if c.JWTSecret == "" {
+			invalid("either JWT_KEYS or JWT_SECRET must be set")
+		} else {
+			invalid("JWT_SECRET must be at least %d bytes long", minJWTSecretLength)
+		}
+	}
+
+	if c.ListenAddr == "" {
+		invalid("LISTEN_ADDR must be set")
+	}
+	if c.LogFormat != LogFormatJSON && c.LogFormat != LogFormatText {
+		invalid("invalid LOG_FORMAT %q", c.LogFormat)
+	}
+	if c.MetricsAddr != "" && c.MetricsAddr == c.ListenAddr {
+		invalid("METRICS_ADDR must differ from LISTEN_ADDR")
+	}
+	switch c.TracingExporter {
+	case TracingExporterNone, TracingExporterStdout:
+	case TracingExporterOTLP:
+		if u, err := url.Parse(c.TracingOTLPEndpoint); err != nil || u.Scheme == "" || u.Host == "" {
+			invalid("TRACING_OTLP_ENDPOINT must be an absolute URL")
+		}
+	default:
+		invalid("invalid TRACING_EXPORTER %q", c.TracingExporter)
+	}
+	for key, value := range map[string]string{"APP_BASE_URL": c.AppBaseURL, "SPELLCHECK_API_URL": c.SpellcheckAPIURL} {
+		if u, err := url.Parse(value); err != nil || u.Scheme == "" || u.Host == "" {
+			invalid("%s must be an absolute URL", key)
+		}
+	}
+
+	for key, value := range map[string]time.Duration{
+		"DB_QUERY_TIMEOUT": c.DBQueryTimeout, "DB_CONN_MAX_LIFETIME": c.DBConnMaxLifetime,
+		"DB_CONN_MAX_IDLE_TIME": c.DBConnMaxIdleTime, "DB_CONNECT_TIMEOUT": c.DBConnectTimeout,
+		"HTTP_READ_HEADER_TIMEOUT": c.HTTPReadHeaderTimeout, "HTTP_READ_TIMEOUT": c.HTTPReadTimeout,
+		"HTTP_WRITE_TIMEOUT": c.HTTPWriteTimeout, "HTTP_IDLE_TIMEOUT": c.HTTPIdleTimeout,
+		"SHUTDOWN_TIMEOUT": c.ShutdownTimeout, "SPELLCHECK_TIMEOUT": c.SpellcheckTimeout,
+		"VERIFICATION_RESEND_INTERVAL": c.VerificationResendInterval,
+	} {
+		if value < 0 {
+			invalid("%s must not be negative", key)
+		}
+	}
+	for key, value := range map[string]time.Duration{
+		"LOGIN_ATTEMPT_WINDOW": c.LoginAttemptWindow, "LOGIN_LOCKOUT_DURATION": c.LoginLockoutDuration,
+		"WEBHOOK_TIMEOUT": c.WebhookTimeout, "OUTBOX_POLL_INTERVAL": c.OutboxPollInterval,
+		"STREAM_SESSION_CHECK_INTERVAL": c.StreamSessionCheckInterval,
+	} {
+		if value <= 0 {
+			invalid("%s must be positive", key)
+		}
+	}
+	for key, value := range map[string]int{
+		"DB_MAX_OPEN_CONNS": c.DBMaxOpenConns, "DB_MAX_IDLE_CONNS": c.DBMaxIdleConns,
+	} {
+		if value < 0 {
+			invalid("%s must not be negative", key)
+		}
+	}
+	for key, value := range map[string]int{
+		"LOGIN_MAX_ATTEMPTS": c.LoginMaxAttempts, "LOGIN_MAX_ATTEMPTS_PER_IP": c.LoginMaxAttemptsPerIP,
+		"PASSWORD_MIN_LENGTH": c.PasswordMinLength, "WEBHOOK_MAX_ATTEMPTS": c.WebhookMaxAttempts,
+	} {
+		if value <= 0 {
+			invalid("%s must be positive", key)
+		}
+	}
+	if _, err := strconv.Atoi(c.SMTPPort); err != nil {
+		invalid("invalid SMTP_PORT %q", c.SMTPPort)
+	}
+
+	switch c.EmailVerificationPolicy {
+	case VerificationPolicyNone, VerificationPolicyNotes, VerificationPolicyLogin:
+	default:
+		invalid("invalid EMAIL_VERIFICATION_POLICY %q", c.EmailVerificationPolicy)
+	}
+	for _, sink := range c.OutboxSinks {
+		switch sink {
+		case OutboxSinkEvents, OutboxSinkWebhooks, OutboxSinkLog:
+		default:
+			invalid("invalid OUTBOX_SINKS item %q", sink)
+		}
+	}
+	for _, provider := range c.OIDCProviders {
+		if provider.IssuerURL == "" || provider.ClientID == "" {
+			prefix := "OIDC_" + strings.ToUpper(provider.Name) + "_"
+			invalid("%sISSUER_URL and %sCLIENT_ID must be set for OIDC provider %q", prefix, prefix, provider.Name)
+		}
 	}
 
-	return config, nil
+	// Map iteration is random, keep the report stable
+	sort.Slice(errs, func(i, j int) bool { return errs[i].Error() < errs[j].Error() })
+	return errors.Join(errs...)
 }
@@ -0,0 +1,166 @@
+package config
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testJWTSecret = "a_jwt_secret_that_is_long_enough_for_hs256"
+
+// writeConfigFile writes a YAML config file and points CONFIG_FILE at it.
+func writeConfigFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CONFIG_FILE", path)
+	return path
+}
+
+func setValidEnv(t *testing.T) {
+	t.Helper()
+	t.Setenv("STORAGE_DRIVER", StorageDriverSQLite)
+	t.Setenv("JWT_SECRET", testJWTSecret)
+	t.Setenv("JWT_KEYS", "")
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	setValidEnv(t)
+	writeConfigFile(t, `
+listen_addr: ":9000"
+bcrypt_cost: 10
+shutdown_timeout: 20s
+outbox_sinks: [events, log]
+`)
+	t.Setenv("LISTEN_ADDR", "")
+	t.Setenv("BCRYPT_COST", "11")
+	t.Setenv("SHUTDOWN_TIMEOUT", "30s")
+
+	cfg, args, err := Load([]string{"--shutdown-timeout=40s", "--http-idle-timeout", "1m", "serve", "--verbose"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.ListenAddr != ":9000" {
+		t.Errorf("ListenAddr = %q, want the file value", cfg.ListenAddr)
+	}
+	if cfg.BcryptCost != 11 {
+		t.Errorf("BcryptCost = %d, want the env value", cfg.BcryptCost)
+	}
+	if cfg.ShutdownTimeout != 40*time.Second || cfg.HTTPIdleTimeout != time.Minute {
+		t.Errorf("ShutdownTimeout, HTTPIdleTimeout = %v, %v, want the flag values", cfg.ShutdownTimeout, cfg.HTTPIdleTimeout)
+	}
+	if cfg.SMTPPort != "587" {
+		t.Errorf("SMTPPort = %q, want the default", cfg.SMTPPort)
+	}
+	if strings.Join(cfg.OutboxSinks, ",") != "events,log" {
+		t.Errorf("OutboxSinks = %v, want the file list", cfg.OutboxSinks)
+	}
+	if strings.Join(args, " ") != "serve --verbose" {
+		t.Errorf("args = %v, want the arguments after the flags", args)
+	}
+}
+
+func TestLoadReportsAllErrors(t *testing.T) {
+	setValidEnv(t)
+	path := writeConfigFile(t, "listen_adress: \":9000\"\n")
+	t.Setenv("JWT_SECRET", "too short")
+	t.Setenv("APP_BASE_URL", "localhost")
+	t.Setenv("LOG_LEVEL", "loud")
+	t.Setenv("LOG_FORMAT", "xml")
+	t.Setenv("LISTEN_ADDR", ":8080")
+	t.Setenv("METRICS_ADDR", ":8080")
+	t.Setenv("TRACING_EXPORTER", "jaeger")
+
+	_, _, err := Load([]string{"--bcrypt-cost=high", "--bogus=1"})
+	if err == nil {
+		t.Fatal("Load() accepted an invalid configuration")
+	}
+	for _, want := range []string{
+		"invalid BCRYPT_COST",
+		"unknown flag --bogus",
+		"unknown setting listen_adress in " + path,
+		"JWT_SECRET must be at least 32 bytes long",
+		"APP_BASE_URL",
+		"invalid LOG_LEVEL",
+		`invalid LOG_FORMAT "xml"`,
+		"METRICS_ADDR must differ from LISTEN_ADDR",
+		`invalid TRACING_EXPORTER "jaeger"`,
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Load() error = %q, want it to contain %q", err, want)
+		}
+	}
+}
+
+func TestLoadRejectsNestedFileSettings(t *testing.T) {
+	setValidEnv(t)
+	writeConfigFile(t, "smtp:\n  host: mail\n")
+	if _, _, err := Load(nil); err == nil {
+		t.Error("Load() accepted a nested setting")
+	}
+}
+
+func TestPrintRedactsSecrets(t *testing.T) {
+	setValidEnv(t)
+	writeConfigFile(t, "smtp_password: mail secret\n")
+	t.Setenv("POSTGRES_URL", "postgres://notes:db_password@db:5432/notes")
+
+	cfg, _, err := Load(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if err := cfg.Print(&out); err != nil {
+		t.Fatal(err)
+	}
+
+	printed := out.String()
+	for _, secret := range []string{testJWTSecret, "mail secret", "db_password"} {
+		if strings.Contains(printed, secret) {
+			t.Errorf("Print() revealed %q:\n%s", secret, printed)
+		}
+	}
+	for _, want := range []string{
+		"jwt_secret: '[redacted]' # env",
+		"smtp_password: '[redacted]' # file",
+		"postgres_url: postgres://notes:xxxxx@db:5432/notes # env",
+		"listen_addr: :8080 # default",
+		`jwt_keys: "" # default`,
+	} {
+		if !strings.Contains(printed, want) {
+			t.Errorf("Print() output lacks %q:\n%s", want, printed)
+		}
+	}
+}
+
+func TestInvalidConfigCanBePrinted(t *testing.T) {
+	setValidEnv(t)
+	t.Setenv("JWT_SECRET", "too short")
+	t.Setenv("LISTEN_ADDR", ":9000")
+
+	cfg, _, err := Load([]string{"--bcrypt-cost=high"})
+	if err == nil || cfg == nil {
+		t.Fatalf("Load() = %v, %v, want the configuration and an error", cfg, err)
+	}
+	var out bytes.Buffer
+	if err := cfg.Print(&out); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"listen_addr: :9000 # env", "bcrypt_cost: high # flag", "jwt_secret: '[redacted]' # env"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("Print() output lacks %q:\n%s", want, out.String())
+		}
+	}
+
+	// Without a readable config file there is nothing to print
+	t.Setenv("CONFIG_FILE", filepath.Join(t.TempDir(), "missing.yaml"))
+	if cfg, _, err := Load(nil); err == nil || cfg != nil {
+		t.Errorf("Load() with a missing config file = %v, %v, want an error only", cfg, err)
+	}
+}
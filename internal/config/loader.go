@@ -0,0 +1,238 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Origins of setting values, from the highest precedence to the lowest.
+const (
+	originFlag    = "flag"
+	originEnv     = "env"
+	originFile    = "file"
+	originDefault = "default"
+)
+
+// setting is a resolved configuration value with where it came from.
+type setting struct {
+	key    string
+	value  string
+	origin string
+}
+
+// loader resolves settings by their environment variable name. A setting can be given as the flag
+// --<name in kebab case>, the environment variable or the <name in snake case> key of the YAML config
+// file, in this order of precedence, and falls back to its default.
+type loader struct {
+	flags    map[string]string
+	file     map[string]string
+	filePath string
+	settings []setting
+	used     map[string]bool
+	errs     []error
+}
+
+// newLoader parses the flags at the start of args, reads the config file named by the --config flag or
+// CONFIG_FILE and returns the arguments after the flags.
+func newLoader(args []string) (*loader, []string, error) {
+	l := &loader{flags: map[string]string{}, file: map[string]string{}, used: map[string]bool{}}
+
+	for len(args) > 0 && strings.HasPrefix(args[0], "-") {
+		arg := args[0]
+		args = args[1:]
+		if arg == "--" {
+			break
+		}
+		name, value, ok := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		if !ok {
+			if len(args) == 0 {
+				return nil, nil, fmt.Errorf("flag %s needs a value", arg)
+			}
+			value, args = args[0], args[1:]
+		}
+		l.flags[strings.ToUpper(strings.ReplaceAll(name, "-", "_"))] = value
+	}
+
+	l.filePath = l.flags["CONFIG"]
+	delete(l.flags, "CONFIG")
+	if l.filePath == "" {
+		l.filePath = os.Getenv("CONFIG_FILE")
+	}
+	if l.filePath != "" {
+		if err := l.readFile(); err != nil {
+			return nil, nil, err
+		}
+	}
+	return l, args, nil
+}
+
+// readFile reads the YAML config file, a mapping of setting names to scalars or lists.
+func (l *loader) readFile() error {
+	data, err := os.ReadFile(l.filePath)
+	if err != nil {
+		return err
+	}
+	var values map[string]interface{}
+	if err := yaml.Unmarshal(data, &values); err != nil {
+		return fmt.Errorf("invalid config file %s: %w", l.filePath, err)
+	}
+
+	for name, value := range values {
+		key := strings.ToUpper(name)
+		switch value := value.(type) {
+		case nil:
+			l.file[key] = ""
+		case []interface{}:
+			items := make([]string, len(value))
+			for i, item := range value {
+				items[i] = fmt.Sprint(item)
+			}
+			l.file[key] = strings.Join(items, ",")
+		case map[string]interface{}:
+			return fmt.Errorf("invalid config file %s: %s must be a value or a list", l.filePath, name)
+		default:
+			l.file[key] = fmt.Sprint(value)
+		}
+	}
+	return nil
+}
+
+// get returns the value of the setting, or the fallback if it isn't given or is empty.
+func (l *loader) get(key, fallback string) string {
+	value, origin := fallback, originDefault
+	if flag, ok := l.flags[key]; ok && flag != "" {
+		value, origin = flag, originFlag
+	} else if env := os.Getenv(key); env != "" {
+		value, origin = env, originEnv
+	} else if file := l.file[key]; file != "" {
+		value, origin = file, originFile
+	}
+
+	if !l.used[key] {
+		l.used[key] = true
+		l.settings = append(l.settings, setting{key: key, value: value, origin: origin})
+	}
+	return value
+}
+
+func (l *loader) string(key, fallback string) string {
+	return l.get(key, fallback)
+}
+
+// list splits a comma separated value, dropping empty items.
+func (l *loader) list(key, fallback string) []string {
+	return splitList(l.get(key, fallback))
+}
+
+func (l *loader) duration(key, fallback string) time.Duration {
+	value, err := time.ParseDuration(l.get(key, fallback))
+	if err != nil {
+		l.errs = append(l.errs, fmt.Errorf("invalid %s: %w", key, err))
+	}
+	return value
+}
+
+func (l *loader) bool(key, fallback string) bool {
+	value, err := strconv.ParseBool(l.get(key, fallback))
+	if err != nil {
+		l.errs = append(l.errs, fmt.Errorf("invalid %s: %w", key, err))
+	}
+	return value
+}
+
+// level parses a log level: debug, info, warn or error.
+func (l *loader) level(key, fallback string) slog.Level {
+	var value slog.Level
+	if err := value.UnmarshalText([]byte(l.get(key, fallback))); err != nil {
+		l.errs = append(l.errs, fmt.Errorf("invalid %s: %w", key, err))
+	}
+	return value
+}
+
+func (l *loader) int(key, fallback string) int {
+	value, err := strconv.Atoi(l.get(key, fallback))
+	if err != nil {
+		l.errs = append(l.errs, fmt.Errorf("invalid %s: %w", key, err))
+	}
+	return value
+}
+
+// err returns the errors of parsing the values and of flags and file keys that aren't settings.
+func (l *loader) err() error {
+	errs := l.errs
+	for _, key := range unused(l.flags, l.used) {
+		errs = append(errs, fmt.Errorf("unknown flag --%s", strings.ToLower(strings.ReplaceAll(key, "_", "-"))))
+	}
+	for _, key := range unused(l.file, l.used) {
+		errs = append(errs, fmt.Errorf("unknown setting %s in %s", strings.ToLower(key), l.filePath))
+	}
+	return errors.Join(errs...)
+}
+
+func unused(values map[string]string, used map[string]bool) []string {
+	var keys []string
+	for key := range values {
+		if !used[key] {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Print writes the settings in the format of the config file, with secrets redacted and a comment
+// telling where each value came from.
+func (c *Config) Print(w io.Writer) error {
+	doc := &yaml.Node{Kind: yaml.MappingNode}
+	for _, s := range c.settings {
+		value := &yaml.Node{Kind: yaml.ScalarNode, Value: redact(s.key, s.value), LineComment: s.origin}
+		if value.Value == "" {
+			// Unquoted, an empty value would be null
+			value.Style = yaml.DoubleQuotedStyle
+		}
+		doc.Content = append(doc.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: strings.ToLower(s.key)}, value)
+	}
+	encoder := yaml.NewEncoder(w)
+	if err := encoder.Encode(doc); err != nil {
+		return err
+	}
+	return encoder.Close()
+}
+
+// redact hides the value of a secret setting and the password of a database URL.
+func redact(key, value string) string {
+	if value == "" {
+		return value
+	}
+	if strings.HasSuffix(key, "_SECRET") || strings.HasSuffix(key, "_PASSWORD") {
+		return "[redacted]"
+	}
+	if strings.HasSuffix(key, "_URL") {
+		if u, err := url.Parse(value); err == nil {
+			return u.Redacted()
+		}
+		return "[redacted]"
+	}
+	return value
+}
+
+// splitList splits a comma separated value, dropping empty items.
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -1,67 +1,202 @@
 package database
 
 import (
+	"context"
 	"database/sql"
-	"log"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"time"
 
+	"github.com/XSAM/otelsql"
 	"github.com/ananikitina/notes-rest/internal/config"
+	"github.com/ananikitina/notes-rest/migrations"
 	"github.com/golang-migrate/migrate/v4"
-	mg "github.com/golang-migrate/migrate/v4/database/postgres"
-	"github.com/lib/pq"
+	"github.com/golang-migrate/migrate/v4/database"
+	mpgx "github.com/golang-migrate/migrate/v4/database/pgx/v5"
+	msqlite "github.com/golang-migrate/migrate/v4/database/sqlite"
+	"github.com/golang-migrate/migrate/v4/source"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/stdlib"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	_ "modernc.org/sqlite"
 )
 
-var DB *sql.DB
+// Backoff of connection retries on startup, variables so tests can shorten them.
+var (
+	retryInitialDelay = 500 * time.Millisecond
+	retryMaxDelay     = 10 * time.Second
+)
+
+// sqlSpans traces the statements run as part of a traced operation, such as a request. Statements
+// without a trace, e.g. of the polling background workers, and the connection bookkeeping are left out.
+var sqlSpans = otelsql.WithSpanOptions(otelsql.SpanOptions{
+	OmitConnResetSession: true,
+	OmitRows:             true,
+	OmitConnectorConnect: true,
+	SpanFilter: func(ctx context.Context, _ otelsql.Method, _ string, _ []driver.NamedValue) bool {
+		return trace.SpanContextFromContext(ctx).IsValid()
+	},
+})
 
-func ConnectDB() (*sql.DB, error) {
-	// Load configuration
-	cfg, err := config.LoadConfig()
+// ConnectDB connects to the database of the configured storage driver and, unless AUTO_MIGRATE is
+// disabled, runs its migrations.
+func ConnectDB(cfg *config.Config) (*sql.DB, error) {
+	db, err := Open(cfg)
 	if err != nil {
-		log.Fatalf("Failed to load configuration: %v", err)
+		return nil, fmt.Errorf("failed to connect to the database: %w", err)
+	}
+
+	slog.Info("Database connected successfully", "driver", cfg.StorageDriver)
+
+	if !cfg.AutoMigrate {
+		slog.Info("Automatic migrations are disabled")
+		return db, nil
 	}
-	log.Println("Configuration loaded successfully")
 
-	// Parse PostgresURL into DSN
-	dsn, err := pq.ParseURL(cfg.PostgresURL)
+	// Run migrations
+	if err := Migrate(db, cfg.StorageDriver); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to run migrations: %w", err)
+	}
+
+	slog.Info("Migrations ran successfully")
+
+	return db, nil
+}
+
+// Open connects to the database of the configured storage driver with the configured pool settings.
+// PostgreSQL may still be starting, e.g. when it is started together with the app by Docker Compose,
+// so failed connections are retried with exponential backoff for up to DB_CONNECT_TIMEOUT.
+func Open(cfg *config.Config) (*sql.DB, error) {
+	var db *sql.DB
+	var err error
+	if cfg.StorageDriver == config.StorageDriverSQLite {
+		db, err = OpenSQLite(cfg.SQLitePath)
+	} else {
+		var connConfig *pgx.ConnConfig
+		if connConfig, err = pgx.ParseConfig(cfg.PostgresURL); err != nil {
+			return nil, err
+		}
+		err = retry(cfg.DBConnectTimeout, func() error {
+			db, err = connectPostgres(connConfig)
+			return err
+		})
+	}
 	if err != nil {
-		log.Fatalf("Failed to parse PostgresURL: %v", err)
+		return nil, err
+	}
+
+	db.SetMaxOpenConns(cfg.DBMaxOpenConns)
+	db.SetMaxIdleConns(cfg.DBMaxIdleConns)
+	db.SetConnMaxLifetime(cfg.DBConnMaxLifetime)
+	db.SetConnMaxIdleTime(cfg.DBConnMaxIdleTime)
+	return db, nil
+}
+
+// retry calls connect until it succeeds or the next attempt would start after the timeout, and returns
+// the last error.
+func retry(timeout time.Duration, connect func() error) error {
+	deadline := time.Now().Add(timeout)
+	delay := retryInitialDelay
+	for attempt := 1; ; attempt++ {
+		err := connect()
+		if err == nil || time.Now().Add(delay).After(deadline) {
+			return err
+		}
+		slog.Warn("Database is not ready, retrying", "attempt", attempt, "delay", delay, "error", err)
+		time.Sleep(delay)
+		delay = min(delay*2, retryMaxDelay)
 	}
+}
 
-	// Initialize the database connection
-	DB, err = sql.Open("postgres", dsn)
+// OpenPostgres connects to the PostgreSQL database at the URL with the pgx driver.
+func OpenPostgres(postgresURL string) (*sql.DB, error) {
+	connConfig, err := pgx.ParseConfig(postgresURL)
 	if err != nil {
-		log.Fatalf("Failed to initialize database connection: %v", err)
+		return nil, err
 	}
+	return connectPostgres(connConfig)
+}
+
+func connectPostgres(connConfig *pgx.ConnConfig) (*sql.DB, error) {
+	db := otelsql.OpenDB(stdlib.GetConnector(*connConfig), otelsql.WithAttributes(semconv.DBSystemPostgreSQL), sqlSpans)
 
 	// Test the connection
-	if err = DB.Ping(); err != nil {
-		log.Fatalf("Failed to connect to the database: %v", err)
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
 	}
+	return db, nil
+}
 
-	log.Println("Database connected successfully")
+// OpenSQLite opens the SQLite database file, creating it if it doesn't exist. Every connection enforces
+// foreign keys and writes times in the format the SQLite repositories compare; transactions take the
+// write lock when they begin, so concurrent writers wait for each other instead of failing.
+func OpenSQLite(path string) (*sql.DB, error) {
+	params := url.Values{}
+	params.Add("_pragma", "foreign_keys(1)")
+	params.Add("_pragma", "journal_mode(WAL)")
+	params.Add("_pragma", "busy_timeout(10000)")
+	params.Set("_time_format", "sqlite")
+	params.Set("_txlock", "immediate")
 
-	// Run migrations
-	driver, err := mg.WithInstance(DB, &mg.Config{})
+	db, err := otelsql.Open("sqlite", "file:"+path+"?"+params.Encode(), otelsql.WithAttributes(semconv.DBSystemSqlite), sqlSpans)
 	if err != nil {
-		log.Fatalf("Failed to create migration driver: %v", err)
+		return nil, err
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// Migrations returns the embedded migrations of the storage driver.
+func Migrations(driver string) (source.Driver, error) {
+	dir := "."
+	if driver == config.StorageDriverSQLite {
+		dir = "sqlite"
 	}
+	return iofs.New(migrations.FS, dir)
+}
 
-	m, err := migrate.NewWithDatabaseInstance(
-		"file:///root/migrations",
-		"postgres", driver)
+// NewMigrate prepares the embedded migrations of the storage driver to be run on the database.
+func NewMigrate(db *sql.DB, driver string) (*migrate.Migrate, error) {
+	var instance database.Driver
+	var err error
+	switch driver {
+	case config.StorageDriverSQLite:
+		instance, err = msqlite.WithInstance(db, &msqlite.Config{})
+	default:
+		instance, err = mpgx.WithInstance(db, &mpgx.Config{})
+	}
 	if err != nil {
-		log.Fatalf("Failed to initialize migration instance: %v", err)
+		return nil, err
 	}
 
-	err = m.Up()
+	migrations, err := Migrations(driver)
 	if err != nil {
-		if err == migrate.ErrNoChange {
-			log.Println("Migrations are up to date, no changes applied.")
-		} else {
-			log.Fatalf("Failed to run migration: %v", err)
-		}
+		return nil, err
 	}
+	return migrate.NewWithInstance("iofs", migrations, driver, instance)
+}
 
-	log.Println("Migrations ran successfully")
+// Migrate applies the migrations that the database doesn't have yet.
+func Migrate(db *sql.DB, driver string) error {
+	m, err := NewMigrate(db, driver)
+	if err != nil {
+		return err
+	}
 
-	return DB, nil
+	err = m.Up()
+	if errors.Is(err, migrate.ErrNoChange) {
+		slog.Info("Migrations are up to date, no changes applied")
+		return nil
+	}
+	return err
 }
@@ -0,0 +1,20 @@
+package domain
+
+import (
+	"context"
+
+	"github.com/ananikitina/notes-rest/internal/models"
+)
+
+// EventBus delivers note events to the subscribed users, possibly across app instances.
+type EventBus interface {
+	// Publish sends the event to the recipients.
+	Publish(ctx context.Context, event models.Event, recipients []int) error
+	// Subscribe returns a channel with the user's events and a function to cancel the subscription.
+	Subscribe(userID int) (<-chan models.Event, func())
+}
+
+// EventSink receives the domain events relayed from the outbox. Events are delivered at least once.
+type EventSink interface {
+	Handle(ctx context.Context, event *models.DomainEvent) error
+}
@@ -1,18 +1,56 @@
 package domain
 
 import (
+	"time"
+
 	"github.com/golang-jwt/jwt/v4"
 )
 
+// AccessTokenTTL is how long access tokens and their sessions are valid.
+const AccessTokenTTL = 72 * time.Hour
+
+// Token scopes for single-purpose tokens. Access tokens have an empty scope.
+const (
+	ScopeEmailVerification = "email_verification"
+	ScopeMFAPending        = "mfa_pending"
+	ScopeEmailChange       = "email_change"
+)
+
 // JWTService defines the contract for JWT operations.
 type JWTServiceInterface interface {
-	GenerateToken(userID int, userRole string) (string, error)
+	GenerateToken(userID int, userRole string, sessionID int) (string, error)
 	ValidateToken(tokenString string) (*Claims, error)
+	GenerateScopedToken(userID int, scope, email string, ttl time.Duration) (string, error)
+	ValidateScopedToken(tokenString, scope string) (*Claims, error)
+	// PublicKeys returns the keys that can be used to verify tokens.
+	PublicKeys() JSONWebKeySet
 }
 
 // Claims struct represents the JWT claims.
 type Claims struct {
-	UserID   int    `json:"user_id"`
-	UserRole string `json:"user_role"`
+	UserID    int    `json:"user_id"`
+	UserRole  string `json:"user_role,omitempty"`
+	SessionID int    `json:"sid,omitempty"`
+	Scope     string `json:"scope,omitempty"`
+	Email     string `json:"email,omitempty"`
 	jwt.RegisteredClaims
 }
+
+// JSONWebKey is a public key in JWK format (RFC 7517).
+type JSONWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	// RSA keys
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// Ed25519 keys
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+// JSONWebKeySet is the document served at /.well-known/jwks.json.
+type JSONWebKeySet struct {
+	Keys []JSONWebKey `json:"keys"`
+}
@@ -0,0 +1,6 @@
+package domain
+
+// Mailer defines the contract for sending emails.
+type Mailer interface {
+	Send(to, subject, body string) error
+}
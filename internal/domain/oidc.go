@@ -0,0 +1,18 @@
+package domain
+
+import (
+	"context"
+	"errors"
+
+	"github.com/ananikitina/notes-rest/internal/models"
+)
+
+var ErrUnknownProvider = errors.New("unknown identity provider")
+
+// OIDCService defines the contract for logging in with OpenID Connect providers.
+type OIDCService interface {
+	// AuthCodeURL returns the provider URL for the authorization code flow with PKCE.
+	AuthCodeURL(ctx context.Context, provider, state, nonce, codeVerifier string) (string, error)
+	// Exchange trades the authorization code for the identity from the verified ID token.
+	Exchange(ctx context.Context, provider, code, codeVerifier, nonce string) (*models.ExternalIdentity, error)
+}
@@ -0,0 +1,14 @@
+package domain
+
+// PasswordHasher defines the contract for hashing and verifying passwords.
+type PasswordHasher interface {
+	Hash(password string) (string, error)
+	Verify(hash, password string) (bool, error)
+	// NeedsRehash reports whether the hash was made with another algorithm or weaker parameters than configured.
+	NeedsRehash(hash string) bool
+}
+
+// PasswordPolicy defines the contract for checking new passwords.
+type PasswordPolicy interface {
+	Validate(password, email string) error
+}
@@ -1,10 +1,146 @@
 package domain
 
-import "github.com/ananikitina/notes-rest/internal/models"
+import (
+	"context"
+	"time"
+
+	"github.com/ananikitina/notes-rest/internal/models"
+)
 
 // NoteRepository defines the contract for note-related database operations.
+// A non-empty query limits the results to notes matching the full-text search.
 type NoteRepository interface {
-	Add(note models.Note) error
-	GetByUserID(userID int) ([]models.Note, error)
-	GetAllNotes() ([]models.Note, error)
+	Add(ctx context.Context, note *models.Note) error
+	GetByID(ctx context.Context, id int) (*models.Note, error)
+	// Update and Delete only apply if the note's change sequence still equals a non-zero baseSeq
+	Update(ctx context.Context, note *models.Note, baseSeq int64) (bool, error)
+	Delete(ctx context.Context, id int, baseSeq int64) (bool, error)
+	GetByUserID(ctx context.Context, userID int, query string) ([]models.Note, error)
+	GetByWorkspaceID(ctx context.Context, workspaceID int, query string) ([]models.Note, error)
+	// GetOwnedByUser returns the notes deleted with the user: theirs and all notes of the workspaces they own
+	GetOwnedByUser(ctx context.Context, userID int) ([]models.Note, error)
+	GetAllNotes(ctx context.Context) ([]models.Note, error)
+	CountNotes(ctx context.Context) (int, error)
+
+	// Sharing of notes with other users
+	SaveShare(ctx context.Context, share *models.NoteShare) error
+	GetShare(ctx context.Context, noteID, userID int) (*models.NoteShare, error)
+	ListShares(ctx context.Context, noteID int) ([]models.NoteShare, error)
+	RemoveShare(ctx context.Context, noteID, userID int) (bool, error)
+	GetSharedWith(ctx context.Context, userID int) ([]models.SharedNote, error)
+
+	// GetChanges returns changes of the notes the user can see after the since sequence, for sync
+	GetChanges(ctx context.Context, userID int, since int64, limit int) ([]models.NoteChange, error)
+}
+
+// UserRepository defines the contract for user-related database operations.
+type UserRepository interface {
+	// CreateUser sets the ID of the user; the password must already be hashed
+	CreateUser(ctx context.Context, user *models.User) error
+	GetUserByEmail(ctx context.Context, email string) (*models.User, error)
+	GetUserByID(ctx context.Context, id int) (*models.User, error)
+	GetUserByIdentity(ctx context.Context, provider, subject string) (*models.User, error)
+	CountUsers(ctx context.Context) (int, error)
+	LinkIdentity(ctx context.Context, id int, provider, subject string) error
+	UpdateRole(ctx context.Context, id int, role string) error
+	UpdateProfile(ctx context.Context, user *models.User) error
+	UpdatePassword(ctx context.Context, id int, passwordHash string) error
+	SetPendingEmail(ctx context.Context, id int, email string) error
+	ConfirmPendingEmail(ctx context.Context, id int) error
+	// DeleteUser deletes the user with their notes and login records
+	DeleteUser(ctx context.Context, id int) error
+	SetEmailVerified(ctx context.Context, id int) error
+	SetVerificationSentAt(ctx context.Context, id int, sentAt time.Time) error
+
+	// Two-factor authentication
+	SetTOTPSecret(ctx context.Context, id int, secret string) error
+	EnableTOTP(ctx context.Context, id int, lastStep int64, recoveryCodeHashes []string) error
+	// SetTOTPLastStep reports false if the step isn't later than the recorded one
+	SetTOTPLastStep(ctx context.Context, id int, step int64) (bool, error)
+	UseRecoveryCode(ctx context.Context, id int, codeHash string) (bool, error)
+}
+
+// OutboxRepository stores domain events until they are relayed to the event sinks.
+type OutboxRepository interface {
+	Add(ctx context.Context, event *models.DomainEvent) error
+	// FetchPending locks up to limit events in order; concurrent transactions skip the locked events
+	FetchPending(ctx context.Context, limit int) ([]models.DomainEvent, error)
+	Delete(ctx context.Context, ids []int64) error
+}
+
+// Tx gives access to repositories whose changes are part of one transaction.
+type Tx interface {
+	Users() UserRepository
+	Notes() NoteRepository
+	Outbox() OutboxRepository
+}
+
+// UnitOfWork runs changes across repositories atomically.
+type UnitOfWork interface {
+	// Do runs fn in a transaction that is committed if fn returns nil and rolled back otherwise.
+	Do(ctx context.Context, fn func(tx Tx) error) error
+}
+
+// SessionRepository defines the contract for login session records.
+type SessionRepository interface {
+	// Create sets the ID of the session
+	Create(ctx context.Context, session *models.Session) error
+	// GetActive and ListActiveByUser return sessions that are neither revoked nor expired
+	GetActive(ctx context.Context, id int) (*models.Session, error)
+	ListActiveByUser(ctx context.Context, userID int) ([]models.Session, error)
+	Touch(ctx context.Context, id int, lastSeenAt time.Time) error
+	Revoke(ctx context.Context, id, userID int) (bool, error)
+	RevokeOthersByUser(ctx context.Context, userID, keepID int) error
+	RevokeAllByUser(ctx context.Context, userID int) error
+}
+
+// LoginAttemptRepository defines the contract for login attempt records.
+type LoginAttemptRepository interface {
+	Add(ctx context.Context, attempt models.LoginAttempt) error
+	// CountFailuresByEmail returns the uncleared failures since the time and the time of the latest one
+	CountFailuresByEmail(ctx context.Context, email string, since time.Time) (int, time.Time, error)
+	// CountFailuresByIP returns the failures since the time and the time of the earliest one
+	CountFailuresByIP(ctx context.Context, ip string, since time.Time) (int, time.Time, error)
+	// CountFailuresByToken returns the failures with the token since the time, cleared or not, and the time of the latest one
+	CountFailuresByToken(ctx context.Context, tokenHash string, since time.Time) (int, time.Time, error)
+	ClearFailures(ctx context.Context, email string) error
+}
+
+// WorkspaceRepository defines the contract for workspaces, their members and invitations.
+type WorkspaceRepository interface {
+	// Create sets the ID of the workspace and adds its owner as a member
+	Create(ctx context.Context, workspace *models.Workspace) error
+	ListByUser(ctx context.Context, userID int) ([]models.Workspace, error)
+	GetMember(ctx context.Context, workspaceID, userID int) (*models.WorkspaceMember, error)
+	ListMembers(ctx context.Context, workspaceID int) ([]models.WorkspaceMember, error)
+	RemoveMember(ctx context.Context, workspaceID, userID int) (bool, error)
+	CreateInvitation(ctx context.Context, invitation *models.WorkspaceInvitation, tokenHash string) error
+	GetPendingInvitation(ctx context.Context, tokenHash string) (*models.WorkspaceInvitation, error)
+	AcceptInvitation(ctx context.Context, invitation *models.WorkspaceInvitation, userID int, at time.Time) error
+}
+
+// PublicLinkRepository defines the contract for public links to notes.
+type PublicLinkRepository interface {
+	// Create sets the ID of the link
+	Create(ctx context.Context, link *models.PublicLink, tokenHash string) error
+	GetActiveByToken(ctx context.Context, tokenHash string) (*models.PublicLink, error)
+	ListByNote(ctx context.Context, noteID int) ([]models.PublicLink, error)
+	Delete(ctx context.Context, id, noteID int) (bool, error)
+	RecordView(ctx context.Context, id int, at time.Time) error
+}
+
+// WebhookRepository defines the contract for webhooks and their delivery queue.
+type WebhookRepository interface {
+	// Create sets the ID of the webhook
+	Create(ctx context.Context, webhook *models.Webhook) error
+	ListByUser(ctx context.Context, userID int) ([]models.Webhook, error)
+	Delete(ctx context.Context, id, userID int) (bool, error)
+	Exists(ctx context.Context, id, userID int) (bool, error)
+	// Enqueue queues deliveries to the subscribed webhooks of the recipients and the global webhooks
+	Enqueue(ctx context.Context, eventType string, payload []byte, recipients []int) error
+	ListDeliveries(ctx context.Context, webhookID, limit int) ([]models.WebhookDelivery, error)
+	Redeliver(ctx context.Context, deliveryID int64, webhookID int, delivery *models.WebhookDelivery) error
+	// ClaimDue returns due pending deliveries with their webhooks and postpones them by the lease
+	ClaimDue(ctx context.Context, limit int, lease time.Duration) ([]models.WebhookDelivery, error)
+	RecordAttempt(ctx context.Context, delivery *models.WebhookDelivery) error
 }
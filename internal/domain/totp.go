@@ -0,0 +1,12 @@
+package domain
+
+import "time"
+
+// TOTPService defines the contract for time-based one-time passwords.
+type TOTPService interface {
+	GenerateSecret() (string, error)
+	URI(account, secret string) string
+	// Validate checks the code and returns the time step it belongs to.
+	// Codes from steps up to lastStep are rejected to prevent reuse.
+	Validate(secret, code string, at time.Time, lastStep int64) (int64, bool)
+}
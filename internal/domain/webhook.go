@@ -0,0 +1,13 @@
+package domain
+
+import (
+	"context"
+
+	"github.com/ananikitina/notes-rest/internal/models"
+)
+
+// WebhookSender delivers webhook events to their URLs.
+type WebhookSender interface {
+	// Send posts the delivery to its webhook and returns the response status code.
+	Send(ctx context.Context, delivery *models.WebhookDelivery) (int, error)
+}
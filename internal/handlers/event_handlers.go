@@ -0,0 +1,155 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/ananikitina/notes-rest/internal/config"
+	"github.com/ananikitina/notes-rest/internal/domain"
+	"github.com/ananikitina/notes-rest/internal/middleware"
+	"github.com/ananikitina/notes-rest/internal/usecases"
+	"github.com/gorilla/websocket"
+)
+
+// heartbeatInterval keeps idle streams from being closed by proxies.
+const heartbeatInterval = 30 * time.Second
+
+// wsWriteTimeout limits how long a write to a WebSocket client can block.
+const wsWriteTimeout = 10 * time.Second
+
+type EventHandler struct {
+	events         domain.EventBus
+	sessionUseCase *usecases.SessionUseCase
+	// sessionCheckInterval is how often open streams check that their session is still active
+	sessionCheckInterval time.Duration
+	upgrader             websocket.Upgrader
+}
+
+func NewEventHandler(events domain.EventBus, sessionUseCase *usecases.SessionUseCase, cfg *config.Config) *EventHandler {
+	return &EventHandler{
+		events:               events,
+		sessionUseCase:       sessionUseCase,
+		sessionCheckInterval: cfg.StreamSessionCheckInterval,
+		// Clients authenticate with a bearer token, not cookies, so cross-origin connections are safe
+		upgrader: websocket.Upgrader{CheckOrigin: func(r *http.Request) bool { return true }},
+	}
+}
+
+// sessionRevoked reports whether the session of the stream was revoked or has expired since it was opened.
+func (e *EventHandler) sessionRevoked(r *http.Request) bool {
+	userID := r.Context().Value(middleware.UserIDKey).(int)
+	sessionID := r.Context().Value(middleware.SessionIDKey).(int)
+	return e.sessionUseCase.ValidateSession(r.Context(), sessionID, userID) != nil
+}
+
+// StreamEventsHandler streams the user's note events as Server-Sent Events until the client
+// disconnects or the session is revoked.
+func (e *EventHandler) StreamEventsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value(middleware.UserIDKey).(int)
+
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "Streaming is not supported", http.StatusInternalServerError)
+			return
+		}
+
+		events, unsubscribe := e.events.Subscribe(userID)
+		defer unsubscribe()
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+		w.Header().Set("X-Accel-Buffering", "no")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+
+		heartbeat := time.NewTicker(heartbeatInterval)
+		defer heartbeat.Stop()
+		sessionCheck := time.NewTicker(e.sessionCheckInterval)
+		defer sessionCheck.Stop()
+
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-sessionCheck.C:
+				if e.sessionRevoked(r) {
+					return
+				}
+				continue
+			case <-heartbeat.C:
+				fmt.Fprint(w, ": ping\n\n")
+			case event := <-events:
+				data, err := json.Marshal(event)
+				if err != nil {
+					slog.ErrorContext(r.Context(), "Failed to encode event", "error", err)
+					continue
+				}
+				fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data)
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// WebSocketHandler streams the user's note events as JSON messages over a WebSocket until the
+// client disconnects or the session is revoked.
+func (e *EventHandler) WebSocketHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value(middleware.UserIDKey).(int)
+
+		conn, err := e.upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			// Upgrade has already written the error response
+			return
+		}
+		defer conn.Close()
+
+		events, unsubscribe := e.events.Subscribe(userID)
+		defer unsubscribe()
+
+		// The stream is one-way, reading only handles control frames and notices the client leaving
+		closed := make(chan struct{})
+		go func() {
+			defer close(closed)
+			for {
+				if _, _, err := conn.NextReader(); err != nil {
+					return
+				}
+			}
+		}()
+
+		heartbeat := time.NewTicker(heartbeatInterval)
+		defer heartbeat.Stop()
+		sessionCheck := time.NewTicker(e.sessionCheckInterval)
+		defer sessionCheck.Stop()
+
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-closed:
+				return
+			case <-sessionCheck.C:
+				if e.sessionRevoked(r) {
+					message := websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "session revoked")
+					conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(wsWriteTimeout))
+					return
+				}
+			case <-heartbeat.C:
+				if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteTimeout)); err != nil {
+					return
+				}
+			case event := <-events:
+				conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+				if err := conn.WriteJSON(event); err != nil {
+					return
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,25 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/ananikitina/notes-rest/internal/domain"
+)
+
+type JWKSHandler struct {
+	jwtService domain.JWTServiceInterface
+}
+
+func NewJWKSHandler(jwtService domain.JWTServiceInterface) *JWKSHandler {
+	return &JWKSHandler{jwtService: jwtService}
+}
+
+// GetJWKSHandler returns the public keys for verifying tokens issued by the service.
+func (j *JWKSHandler) GetJWKSHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "public, max-age=300")
+		json.NewEncoder(w).Encode(j.jwtService.PublicKeys())
+	}
+}
@@ -1,24 +1,28 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
-	"fmt"
+	"errors"
+	"log/slog"
 	"net/http"
-	"time"
+	"strconv"
 
 	"github.com/ananikitina/notes-rest/internal/middleware"
 	"github.com/ananikitina/notes-rest/internal/models"
 	"github.com/ananikitina/notes-rest/internal/services"
 	"github.com/ananikitina/notes-rest/internal/usecases"
-	_ "github.com/lib/pq"
+	"github.com/go-chi/chi"
 )
 
 type NoteHandler struct {
-	noteUseCase *usecases.NoteUseCase
+	noteUseCase  *usecases.NoteUseCase
+	userUseCase  *usecases.UserUseCase
+	spellChecker services.SpellChecker
 }
 
-func NewNoteHandler(noteUseCase *usecases.NoteUseCase) *NoteHandler {
-	return &NoteHandler{noteUseCase: noteUseCase}
+func NewNoteHandler(noteUseCase *usecases.NoteUseCase, userUseCase *usecases.UserUseCase, spellChecker services.SpellChecker) *NoteHandler {
+	return &NoteHandler{noteUseCase: noteUseCase, userUseCase: userUseCase, spellChecker: spellChecker}
 }
 
 // AddNoteHandler adds notes for the specified user.
@@ -27,37 +31,33 @@ func (n *NoteHandler) AddNoteHandler() http.HandlerFunc {
 
 		userID := r.Context().Value(middleware.UserIDKey).(int)
 
-		var note models.Note
-		if err := json.NewDecoder(r.Body).Decode(&note); err != nil {
+		// Only the content comes from the client, the other fields are set by the server
+		var req struct {
+			Content string `json:"content"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 			http.Error(w, "Invalid input", http.StatusBadRequest)
 			return
 		}
 
-		spellChecker := services.NewYandexSpellChecker()
-
-		// Spell check
-		spellErrors, err := spellChecker.Check(note.Content)
-		if err != nil {
-			fmt.Println(err)
-			http.Error(w, "Failed to check spelling", http.StatusInternalServerError)
+		// Check access before calling the spell checker
+		member, _ := r.Context().Value(middleware.WorkspaceMemberKey).(*models.WorkspaceMember)
+		if member != nil && !member.CanEdit() {
+			http.Error(w, "Forbidden: Viewers can't add notes to the workspace", http.StatusForbidden)
 			return
 		}
-
-		// Return spelling errors if found
-		if len(spellErrors) > 0 {
-			w.WriteHeader(http.StatusBadRequest)
-			json.NewEncoder(w).Encode(map[string]interface{}{
-				"message": "Spelling errors found",
-				"errors":  spellErrors,
-			})
+		if !n.checkSpelling(w, r, userID, req.Content) {
 			return
 		}
 
-		note.UserID = userID
-		note.CreatedAt = time.Now()
-
-		if err := n.noteUseCase.AddNote(note); err != nil {
-			fmt.Println(err)
+		note := models.Note{Content: req.Content, UserID: userID}
+		err := n.noteUseCase.AddNote(r.Context(), &note, member)
+		if errors.Is(err, usecases.ErrWorkspaceReadOnly) {
+			http.Error(w, "Forbidden: Viewers can't add notes to the workspace", http.StatusForbidden)
+			return
+		}
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Failed to add note", "error", err)
 			http.Error(w, "Failed to add note", http.StatusInternalServerError)
 			return
 		}
@@ -67,15 +67,17 @@ func (n *NoteHandler) AddNoteHandler() http.HandlerFunc {
 	}
 }
 
-// GetNotesHandler fetches notes from specified user.
+// GetNotesHandler fetches private notes from specified user, or notes of the selected workspace.
+// The "q" query parameter filters the notes by full-text search.
 func (n *NoteHandler) GetNotesHandler() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 
 		userID := r.Context().Value(middleware.UserIDKey).(int)
+		member, _ := r.Context().Value(middleware.WorkspaceMemberKey).(*models.WorkspaceMember)
 
-		notes, err := n.noteUseCase.GetNotesByUserID(userID)
+		notes, err := n.noteUseCase.GetNotes(r.Context(), userID, member, r.URL.Query().Get("q"))
 		if err != nil {
-			fmt.Println(err)
+			slog.ErrorContext(r.Context(), "Failed to fetch notes", "error", err)
 			http.Error(w, "Failed to fetch notes", http.StatusInternalServerError)
 			return
 		}
@@ -88,9 +90,9 @@ func (n *NoteHandler) GetNotesHandler() http.HandlerFunc {
 // GetAllNotesHandler fetches all notes (admin access)
 func (n *NoteHandler) GetAllNotesHandler() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		notes, err := n.noteUseCase.GetAllNotes()
+		notes, err := n.noteUseCase.GetAllNotes(r.Context())
 		if err != nil {
-			fmt.Println(err)
+			slog.ErrorContext(r.Context(), "Failed to fetch all notes", "error", err)
 			http.Error(w, "Failed to fetch all notes", http.StatusInternalServerError)
 			return
 		}
@@ -98,3 +100,242 @@ func (n *NoteHandler) GetAllNotesHandler() http.HandlerFunc {
 		json.NewEncoder(w).Encode(notes)
 	}
 }
+
+// GetNoteHandler fetches a note the user owns, can access through a workspace or that was shared with them.
+func (n *NoteHandler) GetNoteHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value(middleware.UserIDKey).(int)
+		noteID, err := strconv.Atoi(chi.URLParam(r, "id"))
+		if err != nil {
+			http.Error(w, "Invalid note ID", http.StatusBadRequest)
+			return
+		}
+
+		note, err := n.noteUseCase.GetNote(r.Context(), noteID, userID)
+		if writeNoteError(w, r, err, "Failed to fetch note") {
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(note)
+	}
+}
+
+// UpdateNoteHandler changes the content of a note the user can edit.
+func (n *NoteHandler) UpdateNoteHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value(middleware.UserIDKey).(int)
+		noteID, err := strconv.Atoi(chi.URLParam(r, "id"))
+		if err != nil {
+			http.Error(w, "Invalid note ID", http.StatusBadRequest)
+			return
+		}
+
+		var req struct {
+			Content string `json:"content"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid input", http.StatusBadRequest)
+			return
+		}
+
+		// Check access before calling the spell checker
+		if _, err := n.noteUseCase.GetNote(r.Context(), noteID, userID); writeNoteError(w, r, err, "Failed to update note") {
+			return
+		}
+		if !n.checkSpelling(w, r, userID, req.Content) {
+			return
+		}
+
+		note, err := n.noteUseCase.UpdateNote(r.Context(), noteID, userID, req.Content, 0)
+		if writeNoteError(w, r, err, "Failed to update note") {
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(note)
+	}
+}
+
+// DeleteNoteHandler deletes the user's note.
+func (n *NoteHandler) DeleteNoteHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value(middleware.UserIDKey).(int)
+		noteID, err := strconv.Atoi(chi.URLParam(r, "id"))
+		if err != nil {
+			http.Error(w, "Invalid note ID", http.StatusBadRequest)
+			return
+		}
+
+		err = n.noteUseCase.DeleteNote(r.Context(), noteID, userID, 0)
+		if writeNoteError(w, r, err, "Failed to delete note") {
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// ShareNoteHandler shares the user's note with another user by email.
+func (n *NoteHandler) ShareNoteHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value(middleware.UserIDKey).(int)
+		noteID, err := strconv.Atoi(chi.URLParam(r, "id"))
+		if err != nil {
+			http.Error(w, "Invalid note ID", http.StatusBadRequest)
+			return
+		}
+
+		var req struct {
+			Email      string `json:"email"`
+			Permission string `json:"permission"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid input", http.StatusBadRequest)
+			return
+		}
+		if !isValidEmail(req.Email) {
+			http.Error(w, "Invalid email address", http.StatusBadRequest)
+			return
+		}
+
+		share, err := n.noteUseCase.ShareNote(r.Context(), noteID, userID, req.Email, req.Permission)
+		if writeNoteError(w, r, err, "Failed to share note") {
+			return
+		}
+
+		// The response is the same whether the email has an account or not, so it can't be used to look for accounts
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(map[string]interface{}{"noteId": share.NoteID, "email": share.Email, "permission": share.Permission})
+	}
+}
+
+// GetSharesHandler lists the shares of the user's note.
+func (n *NoteHandler) GetSharesHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value(middleware.UserIDKey).(int)
+		noteID, err := strconv.Atoi(chi.URLParam(r, "id"))
+		if err != nil {
+			http.Error(w, "Invalid note ID", http.StatusBadRequest)
+			return
+		}
+
+		shares, err := n.noteUseCase.ListShares(r.Context(), noteID, userID)
+		if writeNoteError(w, r, err, "Failed to fetch shares") {
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(shares)
+	}
+}
+
+// DeleteShareHandler revokes a share of the user's note, or removes a note shared with the user.
+func (n *NoteHandler) DeleteShareHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value(middleware.UserIDKey).(int)
+		noteID, err := strconv.Atoi(chi.URLParam(r, "id"))
+		if err != nil {
+			http.Error(w, "Invalid note ID", http.StatusBadRequest)
+			return
+		}
+		targetUserID, err := strconv.Atoi(chi.URLParam(r, "userID"))
+		if err != nil {
+			http.Error(w, "Invalid user ID", http.StatusBadRequest)
+			return
+		}
+
+		err = n.noteUseCase.RevokeShare(r.Context(), noteID, userID, targetUserID)
+		if writeNoteError(w, r, err, "Failed to revoke share") {
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// GetSharedWithMeHandler fetches the notes other users shared with the user.
+func (n *NoteHandler) GetSharedWithMeHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value(middleware.UserIDKey).(int)
+
+		notes, err := n.noteUseCase.GetSharedWithMe(r.Context(), userID)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Failed to fetch shared notes", "error", err)
+			http.Error(w, "Failed to fetch shared notes", http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(notes)
+	}
+}
+
+// checkSpelling checks the content unless the user turned spell checking off. It writes the
+// response and returns false if the content can't be saved.
+func (n *NoteHandler) checkSpelling(w http.ResponseWriter, r *http.Request, userID int, content string) bool {
+	spellErrors, err := findSpellingErrors(r.Context(), n.userUseCase, n.noteUseCase, n.spellChecker, userID, content)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to check spelling", "error", err)
+		http.Error(w, "Failed to check spelling", http.StatusInternalServerError)
+		return false
+	}
+
+	// Return spelling errors if found
+	if len(spellErrors) > 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"message": "Spelling errors found",
+			"errors":  spellErrors,
+		})
+		return false
+	}
+	return true
+}
+
+// findSpellingErrors checks the content unless the user turned spell checking off, and reports
+// the errors found to the webhooks.
+func findSpellingErrors(ctx context.Context, userUseCase *usecases.UserUseCase, noteUseCase *usecases.NoteUseCase,
+	spellChecker services.SpellChecker, userID int, content string) ([]services.SpellCheckError, error) {
+	user, err := userUseCase.GetProfile(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !user.SpellcheckEnabled {
+		return nil, nil
+	}
+
+	spellErrors, err := spellChecker.Check(ctx, content, user.SpellcheckLanguages)
+	if err != nil {
+		return nil, err
+	}
+	if len(spellErrors) > 0 {
+		// The note is rejected either way, a failure to notify the webhooks is only logged
+		if err := noteUseCase.ReportSpellingErrors(ctx, userID, content, spellErrors); err != nil {
+			slog.ErrorContext(ctx, "Failed to report spelling errors", "error", err)
+		}
+	}
+	return spellErrors, nil
+}
+
+// writeNoteError writes the response for errors of note operations and reports whether there was an error.
+func writeNoteError(w http.ResponseWriter, r *http.Request, err error, message string) bool {
+	switch {
+	case err == nil:
+		return false
+	case errors.Is(err, usecases.ErrInvalidInput):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, usecases.ErrNoteReadOnly), errors.Is(err, usecases.ErrNotNoteOwner):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case errors.Is(err, usecases.ErrNoteNotFound):
+		http.Error(w, "Note not found", http.StatusNotFound)
+	case errors.Is(err, usecases.ErrUserNotFound):
+		http.Error(w, "User not found", http.StatusNotFound)
+	case errors.Is(err, usecases.ErrShareNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	default:
+		slog.ErrorContext(r.Context(), message, "error", err)
+		http.Error(w, message, http.StatusInternalServerError)
+	}
+	return true
+}
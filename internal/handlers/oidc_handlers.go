@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/ananikitina/notes-rest/internal/config"
+	"github.com/ananikitina/notes-rest/internal/domain"
+	"github.com/ananikitina/notes-rest/internal/metrics"
+	"github.com/ananikitina/notes-rest/internal/usecases"
+	"github.com/go-chi/chi"
+)
+
+// oidcCookie keeps the state, nonce and PKCE verifier between the login redirect and the callback.
+const oidcCookie = "oidc_flow"
+
+type OIDCHandler struct {
+	oidcUseCase      *usecases.OIDCUseCase
+	twoFactorUseCase *usecases.TwoFactorUseCase
+	sessionUseCase   *usecases.SessionUseCase
+	metrics          *metrics.Metrics
+	secureCookies    bool
+}
+
+func NewOIDCHandler(oidcUseCase *usecases.OIDCUseCase, twoFactorUseCase *usecases.TwoFactorUseCase, sessionUseCase *usecases.SessionUseCase,
+	metrics *metrics.Metrics, cfg *config.Config) *OIDCHandler {
+	return &OIDCHandler{
+		oidcUseCase:      oidcUseCase,
+		twoFactorUseCase: twoFactorUseCase,
+		sessionUseCase:   sessionUseCase,
+		metrics:          metrics,
+		secureCookies:    strings.HasPrefix(cfg.AppBaseURL, "https://"),
+	}
+}
+
+// LoginHandler redirects the user to the identity provider.
+func (o *OIDCHandler) LoginHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		provider := chi.URLParam(r, "provider")
+
+		req, err := o.oidcUseCase.StartLogin(r.Context(), provider)
+		if errors.Is(err, domain.ErrUnknownProvider) {
+			http.Error(w, "Unknown identity provider", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Failed to start login", "provider", provider, "error", err)
+			http.Error(w, "Failed to start login", http.StatusBadGateway)
+			return
+		}
+
+		http.SetCookie(w, &http.Cookie{
+			Name:     oidcCookie,
+			Value:    strings.Join([]string{provider, req.State, req.Nonce, req.CodeVerifier}, "|"),
+			Path:     "/auth/oidc/",
+			MaxAge:   600,
+			HttpOnly: true,
+			Secure:   o.secureCookies,
+			SameSite: http.SameSiteLaxMode,
+		})
+		http.Redirect(w, r, req.URL, http.StatusFound)
+	}
+}
+
+// CallbackHandler completes the login and returns a JWT.
+func (o *OIDCHandler) CallbackHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		provider := chi.URLParam(r, "provider")
+
+		if errParam := r.URL.Query().Get("error"); errParam != "" {
+			o.metrics.ObserveLogin(metrics.LoginFailure)
+			http.Error(w, "Login failed: "+errParam, http.StatusUnauthorized)
+			return
+		}
+
+		cookie, err := r.Cookie(oidcCookie)
+		if err != nil {
+			o.metrics.ObserveLogin(metrics.LoginFailure)
+			http.Error(w, "Login session expired", http.StatusBadRequest)
+			return
+		}
+		// The cookie is single use
+		http.SetCookie(w, &http.Cookie{Name: oidcCookie, Path: "/auth/oidc/", MaxAge: -1})
+
+		parts := strings.Split(cookie.Value, "|")
+		if len(parts) != 4 || parts[0] != provider || parts[1] != r.URL.Query().Get("state") {
+			o.metrics.ObserveLogin(metrics.LoginFailure)
+			http.Error(w, "Invalid state", http.StatusBadRequest)
+			return
+		}
+		nonce, codeVerifier := parts[2], parts[3]
+
+		user, err := o.oidcUseCase.CompleteLogin(r.Context(), provider, r.URL.Query().Get("code"), codeVerifier, nonce)
+		switch {
+		case errors.Is(err, domain.ErrUnknownProvider):
+			o.metrics.ObserveLogin(metrics.LoginFailure)
+			http.Error(w, "Unknown identity provider", http.StatusNotFound)
+			return
+		case errors.Is(err, usecases.ErrUnverifiedIdentity):
+			o.metrics.ObserveLogin(metrics.LoginFailure)
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		case errors.Is(err, usecases.ErrUnverifiedAccount):
+			o.metrics.ObserveLogin(metrics.LoginFailure)
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		case err != nil:
+			slog.WarnContext(r.Context(), "Failed to log in with identity provider", "provider", provider, "error", err)
+			o.metrics.ObserveLogin(metrics.LoginFailure)
+			http.Error(w, "Failed to log in with identity provider", http.StatusUnauthorized)
+			return
+		}
+
+		// The identity provider replaces the password, not the second factor
+		if user.TOTPEnabled {
+			mfaToken, err := o.twoFactorUseCase.GenerateMFAToken(user)
+			if err != nil {
+				http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"mfaRequired": true, "mfaToken": mfaToken})
+			return
+		}
+
+		token, err := o.sessionUseCase.StartSession(r.Context(), user, "", r.UserAgent(), clientIP(r))
+		if err != nil {
+			http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+			return
+		}
+		o.metrics.ObserveLogin(metrics.LoginSuccess)
+
+		json.NewEncoder(w).Encode(map[string]string{"token": token})
+	}
+}
@@ -0,0 +1,205 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"html/template"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ananikitina/notes-rest/internal/middleware"
+	"github.com/ananikitina/notes-rest/internal/models"
+	"github.com/ananikitina/notes-rest/internal/usecases"
+	"github.com/go-chi/chi"
+)
+
+// LinkPasswordHeader carries the password of a protected public link for JSON clients.
+const LinkPasswordHeader = "X-Link-Password"
+
+// publicNoteTemplate renders a note for browsers, or a password form if Password is set.
+var publicNoteTemplate = template.Must(template.New("note").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Note</title>
+</head>
+<body>
+{{if .PasswordRequired}}
+<form method="post">
+{{if .Error}}<p>{{.Error}}</p>{{end}}
+<label>Password <input type="password" name="password" autofocus></label>
+<button type="submit">Open</button>
+</form>
+{{else}}
+<pre style="white-space: pre-wrap">{{.Note.Content}}</pre>
+<p><small>Created {{.Note.CreatedAt.Format "2006-01-02 15:04"}}{{if .Note.UpdatedAt}}, updated {{.Note.UpdatedAt.Format "2006-01-02 15:04"}}{{end}}</small></p>
+{{end}}
+</body>
+</html>
+`))
+
+type PublicLinkHandler struct {
+	linkUseCase *usecases.PublicLinkUseCase
+}
+
+func NewPublicLinkHandler(linkUseCase *usecases.PublicLinkUseCase) *PublicLinkHandler {
+	return &PublicLinkHandler{linkUseCase: linkUseCase}
+}
+
+// CreateLinkHandler creates a public link to the user's note.
+func (p *PublicLinkHandler) CreateLinkHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value(middleware.UserIDKey).(int)
+		noteID, err := strconv.Atoi(chi.URLParam(r, "id"))
+		if err != nil {
+			http.Error(w, "Invalid note ID", http.StatusBadRequest)
+			return
+		}
+
+		var req struct {
+			ExpiresAt *time.Time `json:"expiresAt"`
+			Password  string     `json:"password"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid input", http.StatusBadRequest)
+			return
+		}
+
+		link, err := p.linkUseCase.CreateLink(r.Context(), noteID, userID, req.ExpiresAt, req.Password)
+		if writeNoteError(w, r, err, "Failed to create link") {
+			return
+		}
+
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(link)
+	}
+}
+
+// GetLinksHandler lists the public links of the user's note.
+func (p *PublicLinkHandler) GetLinksHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value(middleware.UserIDKey).(int)
+		noteID, err := strconv.Atoi(chi.URLParam(r, "id"))
+		if err != nil {
+			http.Error(w, "Invalid note ID", http.StatusBadRequest)
+			return
+		}
+
+		links, err := p.linkUseCase.ListLinks(r.Context(), noteID, userID)
+		if writeNoteError(w, r, err, "Failed to fetch links") {
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(links)
+	}
+}
+
+// DeleteLinkHandler revokes a public link of the user's note.
+func (p *PublicLinkHandler) DeleteLinkHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value(middleware.UserIDKey).(int)
+		noteID, err := strconv.Atoi(chi.URLParam(r, "id"))
+		if err != nil {
+			http.Error(w, "Invalid note ID", http.StatusBadRequest)
+			return
+		}
+		linkID, err := strconv.Atoi(chi.URLParam(r, "linkID"))
+		if err != nil {
+			http.Error(w, "Invalid link ID", http.StatusBadRequest)
+			return
+		}
+
+		err = p.linkUseCase.RevokeLink(r.Context(), noteID, userID, linkID)
+		if errors.Is(err, usecases.ErrLinkNotFound) {
+			http.Error(w, "Link not found", http.StatusNotFound)
+			return
+		}
+		if writeNoteError(w, r, err, "Failed to revoke link") {
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// ViewNoteHandler shows the note of a public link without authentication, as HTML to browsers
+// and as JSON otherwise. The password of a protected link is sent in the X-Link-Password header
+// or, from the HTML form, in the "password" form field.
+func (p *PublicLinkHandler) ViewNoteHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// The token is in the URL, keep it out of caches, search engines and referrers
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("X-Robots-Tag", "noindex")
+		w.Header().Set("Referrer-Policy", "no-referrer")
+
+		password := r.Header.Get(LinkPasswordHeader)
+		if r.Method == http.MethodPost {
+			password = r.PostFormValue("password")
+		}
+		html := strings.Contains(r.Header.Get("Accept"), "text/html")
+
+		note, err := p.linkUseCase.ViewNote(r.Context(), chi.URLParam(r, "token"), password, clientIP(r))
+		var throttled *usecases.LoginThrottledError
+		switch {
+		case errors.As(err, &throttled):
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(throttled.RetryAfter.Seconds()))))
+			if html {
+				renderPublicNote(w, r, http.StatusTooManyRequests, map[string]interface{}{"PasswordRequired": true, "Error": "Too many attempts, try again later"})
+				return
+			}
+			http.Error(w, "Too many password attempts, try again later", http.StatusTooManyRequests)
+			return
+		case errors.Is(err, usecases.ErrLinkNotFound):
+			http.Error(w, "Link not found or expired", http.StatusNotFound)
+			return
+		case errors.Is(err, usecases.ErrLinkPasswordRequired), errors.Is(err, usecases.ErrLinkWrongPassword):
+			if html {
+				message := ""
+				if errors.Is(err, usecases.ErrLinkWrongPassword) {
+					message = "Invalid password"
+				}
+				renderPublicNote(w, r, http.StatusUnauthorized, map[string]interface{}{"PasswordRequired": true, "Error": message})
+				return
+			}
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		case err != nil:
+			slog.ErrorContext(r.Context(), "Failed to fetch note", "error", err)
+			http.Error(w, "Failed to fetch note", http.StatusInternalServerError)
+			return
+		}
+
+		if html {
+			renderPublicNote(w, r, http.StatusOK, map[string]interface{}{"Note": note})
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(publicNote(note))
+	}
+}
+
+// publicNote returns the fields of the note that are shown through public links.
+func publicNote(note *models.Note) map[string]interface{} {
+	return map[string]interface{}{
+		"id":        note.ID,
+		"content":   note.Content,
+		"createdAt": note.CreatedAt,
+		"updatedAt": note.UpdatedAt,
+	}
+}
+
+// renderPublicNote writes publicNoteTemplate with the data.
+func renderPublicNote(w http.ResponseWriter, r *http.Request, status int, data map[string]interface{}) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	if err := publicNoteTemplate.Execute(w, data); err != nil {
+		slog.ErrorContext(r.Context(), "Failed to render public note", "error", err)
+	}
+}
@@ -0,0 +1,120 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/ananikitina/notes-rest/internal/middleware"
+	"github.com/ananikitina/notes-rest/internal/usecases"
+	"github.com/go-chi/chi"
+)
+
+type SessionHandler struct {
+	sessionUseCase *usecases.SessionUseCase
+}
+
+func NewSessionHandler(sessionUseCase *usecases.SessionUseCase) *SessionHandler {
+	return &SessionHandler{sessionUseCase: sessionUseCase}
+}
+
+// GetSessionsHandler lists the active sessions of the authenticated user.
+func (s *SessionHandler) GetSessionsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value(middleware.UserIDKey).(int)
+		sessionID := r.Context().Value(middleware.SessionIDKey).(int)
+
+		sessions, err := s.sessionUseCase.ListSessions(r.Context(), userID, sessionID)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Failed to fetch sessions", "error", err)
+			http.Error(w, "Failed to fetch sessions", http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(sessions)
+	}
+}
+
+// DeleteSessionHandler revokes a session of the authenticated user.
+func (s *SessionHandler) DeleteSessionHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value(middleware.UserIDKey).(int)
+		s.revokeSession(w, r, userID, chi.URLParam(r, "id"))
+	}
+}
+
+// GetUserSessionsHandler lists the active sessions of any user (admin access).
+func (s *SessionHandler) GetUserSessionsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, err := strconv.Atoi(chi.URLParam(r, "id"))
+		if err != nil {
+			http.Error(w, "Invalid user ID", http.StatusBadRequest)
+			return
+		}
+
+		sessions, err := s.sessionUseCase.ListSessions(r.Context(), userID, r.Context().Value(middleware.SessionIDKey).(int))
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Failed to fetch sessions", "error", err)
+			http.Error(w, "Failed to fetch sessions", http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(sessions)
+	}
+}
+
+// DeleteUserSessionHandler revokes a session of any user (admin access).
+func (s *SessionHandler) DeleteUserSessionHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, err := strconv.Atoi(chi.URLParam(r, "id"))
+		if err != nil {
+			http.Error(w, "Invalid user ID", http.StatusBadRequest)
+			return
+		}
+		s.revokeSession(w, r, userID, chi.URLParam(r, "sessionID"))
+	}
+}
+
+// DeleteUserSessionsHandler revokes all sessions of any user (admin access).
+func (s *SessionHandler) DeleteUserSessionsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, err := strconv.Atoi(chi.URLParam(r, "id"))
+		if err != nil {
+			http.Error(w, "Invalid user ID", http.StatusBadRequest)
+			return
+		}
+
+		if err := s.sessionUseCase.RevokeAllSessions(r.Context(), userID); err != nil {
+			slog.ErrorContext(r.Context(), "Failed to revoke sessions", "error", err)
+			http.Error(w, "Failed to revoke sessions", http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (s *SessionHandler) revokeSession(w http.ResponseWriter, r *http.Request, userID int, sessionParam string) {
+	sessionID, err := strconv.Atoi(sessionParam)
+	if err != nil {
+		http.Error(w, "Invalid session ID", http.StatusBadRequest)
+		return
+	}
+
+	err = s.sessionUseCase.RevokeSession(r.Context(), userID, sessionID)
+	if errors.Is(err, usecases.ErrSessionNotFound) {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to revoke session", "error", err)
+		http.Error(w, "Failed to revoke session", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/ananikitina/notes-rest/internal/middleware"
+	"github.com/ananikitina/notes-rest/internal/models"
+	"github.com/ananikitina/notes-rest/internal/services"
+	"github.com/ananikitina/notes-rest/internal/usecases"
+)
+
+type SyncHandler struct {
+	syncUseCase  *usecases.SyncUseCase
+	noteUseCase  *usecases.NoteUseCase
+	userUseCase  *usecases.UserUseCase
+	spellChecker services.SpellChecker
+}
+
+func NewSyncHandler(syncUseCase *usecases.SyncUseCase, noteUseCase *usecases.NoteUseCase, userUseCase *usecases.UserUseCase,
+	spellChecker services.SpellChecker) *SyncHandler {
+	return &SyncHandler{syncUseCase: syncUseCase, noteUseCase: noteUseCase, userUseCase: userUseCase, spellChecker: spellChecker}
+}
+
+// PullHandler returns the changes of the user's notes since the "since" token.
+func (s *SyncHandler) PullHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value(middleware.UserIDKey).(int)
+
+		limit := 0
+		if value := r.URL.Query().Get("limit"); value != "" {
+			var err error
+			if limit, err = strconv.Atoi(value); err != nil {
+				http.Error(w, "Invalid limit", http.StatusBadRequest)
+				return
+			}
+		}
+
+		page, err := s.syncUseCase.Pull(r.Context(), userID, r.URL.Query().Get("since"), limit)
+		if errors.Is(err, usecases.ErrInvalidInput) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Failed to sync notes", "error", err)
+			http.Error(w, "Failed to sync notes", http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(page)
+	}
+}
+
+// PushHandler applies a batch of the client's offline changes and returns a result for each of them.
+// Content with spelling errors is rejected per operation, like it is for single notes.
+func (s *SyncHandler) PushHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value(middleware.UserIDKey).(int)
+
+		var req struct {
+			Operations []models.SyncOperation `json:"operations"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid input", http.StatusBadRequest)
+			return
+		}
+
+		check := func(ctx context.Context, content string) error {
+			spellErrors, err := findSpellingErrors(ctx, s.userUseCase, s.noteUseCase, s.spellChecker, userID, content)
+			if err != nil {
+				return err
+			}
+			if len(spellErrors) > 0 {
+				return &usecases.SpellingError{Errors: spellErrors}
+			}
+			return nil
+		}
+
+		results, err := s.syncUseCase.Push(r.Context(), userID, req.Operations, check)
+		if errors.Is(err, usecases.ErrInvalidInput) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Failed to sync notes", "error", err)
+			http.Error(w, "Failed to sync notes", http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]interface{}{"results": results})
+	}
+}
@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/ananikitina/notes-rest/internal/metrics"
+	"github.com/ananikitina/notes-rest/internal/middleware"
+	"github.com/ananikitina/notes-rest/internal/usecases"
+)
+
+type TwoFactorHandler struct {
+	twoFactorUseCase *usecases.TwoFactorUseCase
+	sessionUseCase   *usecases.SessionUseCase
+	metrics          *metrics.Metrics
+}
+
+func NewTwoFactorHandler(twoFactorUseCase *usecases.TwoFactorUseCase, sessionUseCase *usecases.SessionUseCase, metrics *metrics.Metrics) *TwoFactorHandler {
+	return &TwoFactorHandler{twoFactorUseCase: twoFactorUseCase, sessionUseCase: sessionUseCase, metrics: metrics}
+}
+
+// SetupHandler generates a TOTP secret for the authenticated user.
+func (t *TwoFactorHandler) SetupHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value(middleware.UserIDKey).(int)
+
+		setup, err := t.twoFactorUseCase.Setup(r.Context(), userID)
+		if errors.Is(err, usecases.ErrTwoFactorAlreadyEnabled) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Failed to set up two-factor authentication", "error", err)
+			http.Error(w, "Failed to set up two-factor authentication", http.StatusInternalServerError)
+			return
+		}
+
+		json.NewEncoder(w).Encode(setup)
+	}
+}
+
+// EnableHandler enables two-factor authentication after checking a code from the authenticator app.
+func (t *TwoFactorHandler) EnableHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value(middleware.UserIDKey).(int)
+
+		var req struct {
+			Code string `json:"code"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid input", http.StatusBadRequest)
+			return
+		}
+
+		codes, err := t.twoFactorUseCase.Enable(r.Context(), userID, req.Code)
+		switch {
+		case errors.Is(err, usecases.ErrTwoFactorAlreadyEnabled):
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		case errors.Is(err, usecases.ErrTwoFactorNotSetUp), errors.Is(err, usecases.ErrInvalidCode):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		case err != nil:
+			slog.ErrorContext(r.Context(), "Failed to enable two-factor authentication", "error", err)
+			http.Error(w, "Failed to enable two-factor authentication", http.StatusInternalServerError)
+			return
+		}
+
+		// Recovery codes are only shown once
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"message":       "Two-factor authentication enabled",
+			"recoveryCodes": codes,
+		})
+	}
+}
+
+// LoginTwoFactorHandler exchanges the pending login token and a TOTP or recovery code for a JWT.
+func (t *TwoFactorHandler) LoginTwoFactorHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			MFAToken    string `json:"mfaToken"`
+			Code        string `json:"code"`
+			DeviceLabel string `json:"deviceLabel"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid input", http.StatusBadRequest)
+			return
+		}
+
+		user, err := t.twoFactorUseCase.CompleteLogin(r.Context(), req.MFAToken, req.Code, clientIP(r))
+		var throttled *usecases.LoginThrottledError
+		switch {
+		case errors.As(err, &throttled):
+			t.metrics.ObserveLogin(metrics.LoginThrottled)
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(throttled.RetryAfter.Seconds()))))
+			http.Error(w, throttled.Error(), http.StatusTooManyRequests)
+			return
+		case errors.Is(err, usecases.ErrInvalidToken), errors.Is(err, usecases.ErrTwoFactorNotSetUp):
+			t.metrics.ObserveLogin(metrics.LoginFailure)
+			http.Error(w, "Invalid or expired token", http.StatusUnauthorized)
+			return
+		case errors.Is(err, usecases.ErrInvalidCode):
+			t.metrics.ObserveLogin(metrics.LoginFailure)
+			http.Error(w, "Invalid code", http.StatusUnauthorized)
+			return
+		case err != nil:
+			slog.ErrorContext(r.Context(), "Failed to check code", "error", err)
+			http.Error(w, "Failed to check code", http.StatusInternalServerError)
+			return
+		}
+
+		token, err := t.sessionUseCase.StartSession(r.Context(), user, req.DeviceLabel, r.UserAgent(), clientIP(r))
+		if err != nil {
+			http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+			return
+		}
+		t.metrics.ObserveLogin(metrics.LoginSuccess)
+
+		json.NewEncoder(w).Encode(map[string]string{"token": token})
+	}
+}
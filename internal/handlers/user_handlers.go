@@ -2,41 +2,65 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
+	"log/slog"
+	"math"
+	"net"
 	"net/http"
+	"net/mail"
+	"strconv"
 
-	"github.com/ananikitina/notes-rest/internal/domain"
+	"github.com/ananikitina/notes-rest/internal/metrics"
+	"github.com/ananikitina/notes-rest/internal/middleware"
 	"github.com/ananikitina/notes-rest/internal/models"
 	"github.com/ananikitina/notes-rest/internal/usecases"
+	"github.com/go-chi/chi"
 )
 
 type UserHandler struct {
-	userUseCase *usecases.UserUseCase
-	jwtService  domain.JWTServiceInterface
+	userUseCase      *usecases.UserUseCase
+	twoFactorUseCase *usecases.TwoFactorUseCase
+	sessionUseCase   *usecases.SessionUseCase
+	metrics          *metrics.Metrics
 }
 
-func NewUserHandler(userUseCase *usecases.UserUseCase, jwtService domain.JWTServiceInterface) *UserHandler {
-	return &UserHandler{userUseCase: userUseCase, jwtService: jwtService}
+func NewUserHandler(userUseCase *usecases.UserUseCase, twoFactorUseCase *usecases.TwoFactorUseCase, sessionUseCase *usecases.SessionUseCase, metrics *metrics.Metrics) *UserHandler {
+	return &UserHandler{userUseCase: userUseCase, twoFactorUseCase: twoFactorUseCase, sessionUseCase: sessionUseCase, metrics: metrics}
 }
 
 // RegisterHandler creates new user.
 func (u *UserHandler) RegisterHandler() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		var user models.User
-		if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
+		var input struct {
+			Email    string `json:"email"`
+			Password string `json:"password"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
 			http.Error(w, "Invalid input", http.StatusBadRequest)
 			return
 		}
-		//default role "user" if empty
-		if user.Role == "" {
-			user.Role = "user"
+		if !isValidEmail(input.Email) {
+			http.Error(w, "Invalid email address", http.StatusBadRequest)
+			return
 		}
 
-		if err := u.userUseCase.Register(&user); err != nil {
+		//Self-registered accounts are always plain users, roles are granted by admins
+		user := models.User{Email: input.Email, Password: input.Password, Role: "user"}
+		err := u.userUseCase.Register(r.Context(), &user)
+		switch {
+		case errors.Is(err, usecases.ErrInvalidInput):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		case errors.Is(err, usecases.ErrUserExists):
 			http.Error(w, err.Error(), http.StatusConflict)
 			return
+		case err != nil:
+			slog.ErrorContext(r.Context(), "Failed to register user", "error", err)
+			http.Error(w, "Failed to register user", http.StatusInternalServerError)
+			return
 		}
 		w.WriteHeader(http.StatusCreated)
-		json.NewEncoder(w).Encode(map[string]string{"message": "User registered successfully"})
+		json.NewEncoder(w).Encode(map[string]string{"message": "User registered successfully, check your email to verify the address"})
 	}
 }
 
@@ -44,24 +68,291 @@ func (u *UserHandler) RegisterHandler() http.HandlerFunc {
 func (u *UserHandler) LoginHandler() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var cred struct {
-			Email    string `json:"email"`
-			Password string `json:"password"`
+			Email       string `json:"email"`
+			Password    string `json:"password"`
+			DeviceLabel string `json:"deviceLabel"`
 		}
 		if err := json.NewDecoder(r.Body).Decode(&cred); err != nil {
 			http.Error(w, "Invalid input", http.StatusBadRequest)
 			return
 		}
-		user, err := u.userUseCase.Authenticate(cred.Email, cred.Password)
-		if err != nil {
+		user, err := u.userUseCase.Authenticate(r.Context(), cred.Email, cred.Password, clientIP(r))
+		var throttled *usecases.LoginThrottledError
+		switch {
+		case errors.As(err, &throttled):
+			u.metrics.ObserveLogin(metrics.LoginThrottled)
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(throttled.RetryAfter.Seconds()))))
+			http.Error(w, throttled.Error(), http.StatusTooManyRequests)
+			return
+		case errors.Is(err, usecases.ErrEmailNotVerified):
+			u.metrics.ObserveLogin(metrics.LoginFailure)
+			http.Error(w, "Email address is not verified", http.StatusForbidden)
+			return
+		case errors.Is(err, usecases.ErrInvalidCredentials):
+			u.metrics.ObserveLogin(metrics.LoginFailure)
 			http.Error(w, "Invalid email or password", http.StatusUnauthorized)
 			return
+		case err != nil:
+			slog.ErrorContext(r.Context(), "Failed to log in", "error", err)
+			http.Error(w, "Failed to log in", http.StatusInternalServerError)
+			return
+		}
+
+		// The real token is issued by LoginTwoFactorHandler once the code is checked
+		if user.TOTPEnabled {
+			mfaToken, err := u.twoFactorUseCase.GenerateMFAToken(user)
+			if err != nil {
+				http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"mfaRequired": true, "mfaToken": mfaToken})
+			return
 		}
-		token, err := u.jwtService.GenerateToken(user.ID, user.Role)
+
+		token, err := u.sessionUseCase.StartSession(r.Context(), user, cred.DeviceLabel, r.UserAgent(), clientIP(r))
 		if err != nil {
 			http.Error(w, "Failed to generate token", http.StatusInternalServerError)
 			return
 		}
+		u.metrics.ObserveLogin(metrics.LoginSuccess)
 
 		json.NewEncoder(w).Encode(map[string]string{"token": token})
 	}
 }
+
+// VerifyEmailHandler confirms the user's email with the token from the verification link.
+func (u *UserHandler) VerifyEmailHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := r.URL.Query().Get("token")
+		if token == "" {
+			http.Error(w, "Token is required", http.StatusBadRequest)
+			return
+		}
+
+		err := u.userUseCase.VerifyEmail(r.Context(), token)
+		if errors.Is(err, usecases.ErrInvalidToken) {
+			http.Error(w, "Invalid or expired token", http.StatusBadRequest)
+			return
+		}
+		if errors.Is(err, usecases.ErrEmailTaken) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Failed to verify email", "error", err)
+			http.Error(w, "Failed to verify email", http.StatusInternalServerError)
+			return
+		}
+
+		json.NewEncoder(w).Encode(map[string]string{"message": "Email verified successfully"})
+	}
+}
+
+// ResendVerificationHandler sends the verification email again.
+func (u *UserHandler) ResendVerificationHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Email string `json:"email"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || !isValidEmail(req.Email) {
+			http.Error(w, "Invalid input", http.StatusBadRequest)
+			return
+		}
+
+		err := u.userUseCase.ResendVerification(r.Context(), req.Email)
+		if errors.Is(err, usecases.ErrVerificationThrottled) {
+			http.Error(w, err.Error(), http.StatusTooManyRequests)
+			return
+		}
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Failed to send verification email", "error", err)
+			http.Error(w, "Failed to send verification email", http.StatusInternalServerError)
+			return
+		}
+
+		// Same response whether or not the account exists
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(map[string]string{"message": "If the account exists and is not verified, a verification email has been sent"})
+	}
+}
+
+// GetMeHandler returns the profile of the authenticated user.
+func (u *UserHandler) GetMeHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value(middleware.UserIDKey).(int)
+
+		user, err := u.userUseCase.GetProfile(r.Context(), userID)
+		if err != nil {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(user)
+	}
+}
+
+// UpdateMeHandler updates the profile of the authenticated user.
+func (u *UserHandler) UpdateMeHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value(middleware.UserIDKey).(int)
+
+		var update models.ProfileUpdate
+		if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+			http.Error(w, "Invalid input", http.StatusBadRequest)
+			return
+		}
+
+		user, err := u.userUseCase.UpdateProfile(r.Context(), userID, update)
+		switch {
+		case errors.Is(err, usecases.ErrInvalidInput):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		case errors.Is(err, usecases.ErrUserNotFound):
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		case err != nil:
+			slog.ErrorContext(r.Context(), "Failed to update profile", "error", err)
+			http.Error(w, "Failed to update profile", http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(user)
+	}
+}
+
+// ChangePasswordHandler changes the password and logs out the other sessions.
+func (u *UserHandler) ChangePasswordHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value(middleware.UserIDKey).(int)
+		sessionID := r.Context().Value(middleware.SessionIDKey).(int)
+
+		var req struct {
+			CurrentPassword string `json:"currentPassword"`
+			NewPassword     string `json:"newPassword"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid input", http.StatusBadRequest)
+			return
+		}
+
+		err := u.userUseCase.ChangePassword(r.Context(), userID, sessionID, req.CurrentPassword, req.NewPassword, clientIP(r))
+		if writeAccountError(w, r, err, "Failed to change password") {
+			return
+		}
+
+		json.NewEncoder(w).Encode(map[string]string{"message": "Password changed successfully"})
+	}
+}
+
+// ChangeEmailHandler sends a confirmation link to the new email.
+func (u *UserHandler) ChangeEmailHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value(middleware.UserIDKey).(int)
+
+		var req struct {
+			Email    string `json:"email"`
+			Password string `json:"password"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid input", http.StatusBadRequest)
+			return
+		}
+		if !isValidEmail(req.Email) {
+			http.Error(w, "Invalid email address", http.StatusBadRequest)
+			return
+		}
+
+		err := u.userUseCase.RequestEmailChange(r.Context(), userID, req.Password, req.Email, clientIP(r))
+		if writeAccountError(w, r, err, "Failed to change email") {
+			return
+		}
+
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(map[string]string{"message": "Check your new email to confirm the change"})
+	}
+}
+
+// DeleteMeHandler deletes the account of the authenticated user and all their notes.
+func (u *UserHandler) DeleteMeHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value(middleware.UserIDKey).(int)
+
+		var req struct {
+			Password string `json:"password"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid input", http.StatusBadRequest)
+			return
+		}
+
+		err := u.userUseCase.DeleteAccount(r.Context(), userID, req.Password, clientIP(r))
+		if writeAccountError(w, r, err, "Failed to delete account") {
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// UnlockUserHandler clears failed login attempts for the user (admin access).
+func (u *UserHandler) UnlockUserHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, err := strconv.Atoi(chi.URLParam(r, "id"))
+		if err != nil {
+			http.Error(w, "Invalid user ID", http.StatusBadRequest)
+			return
+		}
+
+		err = u.userUseCase.UnlockUser(r.Context(), userID)
+		if errors.Is(err, usecases.ErrUserNotFound) {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Failed to unlock user", "error", err)
+			http.Error(w, "Failed to unlock user", http.StatusInternalServerError)
+			return
+		}
+
+		json.NewEncoder(w).Encode(map[string]string{"message": "User unlocked successfully"})
+	}
+}
+
+// writeAccountError writes the response for errors of account changes and reports whether there was an error.
+func writeAccountError(w http.ResponseWriter, r *http.Request, err error, message string) bool {
+	var throttled *usecases.LoginThrottledError
+	switch {
+	case err == nil:
+		return false
+	case errors.As(err, &throttled):
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(throttled.RetryAfter.Seconds()))))
+		http.Error(w, throttled.Error(), http.StatusTooManyRequests)
+	case errors.Is(err, usecases.ErrWrongPassword):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case errors.Is(err, usecases.ErrInvalidInput):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, usecases.ErrUserNotFound):
+		http.Error(w, "User not found", http.StatusNotFound)
+	default:
+		slog.ErrorContext(r.Context(), message, "error", err)
+		http.Error(w, message, http.StatusInternalServerError)
+	}
+	return true
+}
+
+// clientIP returns the IP address of the client without the port.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// isValidEmail checks that the value is a bare email address like "user@example.com".
+func isValidEmail(email string) bool {
+	addr, err := mail.ParseAddress(email)
+	return err == nil && addr.Address == email
+}
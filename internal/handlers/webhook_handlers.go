@@ -0,0 +1,162 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/ananikitina/notes-rest/internal/config"
+	"github.com/ananikitina/notes-rest/internal/middleware"
+	"github.com/ananikitina/notes-rest/internal/usecases"
+	"github.com/go-chi/chi"
+)
+
+type WebhookHandler struct {
+	webhookUseCase   *usecases.WebhookUseCase
+	twoFactorUseCase *usecases.TwoFactorUseCase
+	cfg              *config.Config
+}
+
+func NewWebhookHandler(webhookUseCase *usecases.WebhookUseCase, twoFactorUseCase *usecases.TwoFactorUseCase, cfg *config.Config) *WebhookHandler {
+	return &WebhookHandler{webhookUseCase: webhookUseCase, twoFactorUseCase: twoFactorUseCase, cfg: cfg}
+}
+
+// CreateWebhookHandler registers a webhook of the authenticated user. The response includes the
+// secret that signs the deliveries, which isn't shown again. Global webhooks see all notes, so like
+// the other admin routes they require two-factor authentication if REQUIRE_ADMIN_2FA is set.
+func (h *WebhookHandler) CreateWebhookHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value(middleware.UserIDKey).(int)
+		role, _ := r.Context().Value(middleware.UserRoleKey).(string)
+
+		var req struct {
+			URL    string   `json:"url"`
+			Events []string `json:"events"`
+			Global bool     `json:"global"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid input", http.StatusBadRequest)
+			return
+		}
+
+		if req.Global && role == "admin" && h.cfg.RequireAdminTwoFactor {
+			enabled, err := h.twoFactorUseCase.IsEnabled(r.Context(), userID)
+			if err != nil {
+				http.Error(w, "Failed to check two-factor authentication", http.StatusInternalServerError)
+				return
+			}
+			if !enabled {
+				http.Error(w, "Forbidden: Two-factor authentication must be enabled", http.StatusForbidden)
+				return
+			}
+		}
+
+		webhook, err := h.webhookUseCase.CreateWebhook(r.Context(), userID, role == "admin", req.URL, req.Events, req.Global)
+		if writeWebhookError(w, r, err, "Failed to create webhook") {
+			return
+		}
+
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(webhook)
+	}
+}
+
+// GetWebhooksHandler lists the webhooks of the authenticated user.
+func (h *WebhookHandler) GetWebhooksHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value(middleware.UserIDKey).(int)
+
+		webhooks, err := h.webhookUseCase.ListWebhooks(r.Context(), userID)
+		if writeWebhookError(w, r, err, "Failed to fetch webhooks") {
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(webhooks)
+	}
+}
+
+// DeleteWebhookHandler deletes a webhook of the authenticated user.
+func (h *WebhookHandler) DeleteWebhookHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value(middleware.UserIDKey).(int)
+		webhookID, err := strconv.Atoi(chi.URLParam(r, "id"))
+		if err != nil {
+			http.Error(w, "Invalid webhook ID", http.StatusBadRequest)
+			return
+		}
+
+		err = h.webhookUseCase.DeleteWebhook(r.Context(), webhookID, userID)
+		if writeWebhookError(w, r, err, "Failed to delete webhook") {
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// GetDeliveriesHandler lists the latest deliveries of a webhook of the authenticated user.
+func (h *WebhookHandler) GetDeliveriesHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value(middleware.UserIDKey).(int)
+		webhookID, err := strconv.Atoi(chi.URLParam(r, "id"))
+		if err != nil {
+			http.Error(w, "Invalid webhook ID", http.StatusBadRequest)
+			return
+		}
+
+		deliveries, err := h.webhookUseCase.ListDeliveries(r.Context(), webhookID, userID)
+		if writeWebhookError(w, r, err, "Failed to fetch deliveries") {
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(deliveries)
+	}
+}
+
+// RedeliverHandler queues a delivery of a webhook of the authenticated user again.
+func (h *WebhookHandler) RedeliverHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value(middleware.UserIDKey).(int)
+		webhookID, err := strconv.Atoi(chi.URLParam(r, "id"))
+		if err != nil {
+			http.Error(w, "Invalid webhook ID", http.StatusBadRequest)
+			return
+		}
+		deliveryID, err := strconv.ParseInt(chi.URLParam(r, "deliveryID"), 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid delivery ID", http.StatusBadRequest)
+			return
+		}
+
+		delivery, err := h.webhookUseCase.Redeliver(r.Context(), webhookID, userID, deliveryID)
+		if writeWebhookError(w, r, err, "Failed to redeliver") {
+			return
+		}
+
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(delivery)
+	}
+}
+
+// writeWebhookError writes the response for errors of webhook operations and reports whether there was an error.
+func writeWebhookError(w http.ResponseWriter, r *http.Request, err error, message string) bool {
+	switch {
+	case err == nil:
+		return false
+	case errors.Is(err, usecases.ErrInvalidInput):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, usecases.ErrGlobalWebhook):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case errors.Is(err, usecases.ErrWebhookNotFound),
+		errors.Is(err, usecases.ErrDeliveryNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	default:
+		slog.ErrorContext(r.Context(), message, "error", err)
+		http.Error(w, message, http.StatusInternalServerError)
+	}
+	return true
+}
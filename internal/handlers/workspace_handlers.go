@@ -0,0 +1,234 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/ananikitina/notes-rest/internal/middleware"
+	"github.com/ananikitina/notes-rest/internal/usecases"
+	"github.com/go-chi/chi"
+)
+
+// invitationTemplate shows a workspace invitation to browsers opening the link from the invitation email.
+var invitationTemplate = template.Must(template.New("invitation").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Workspace invitation</title>
+</head>
+<body>
+<p>You have been invited to a workspace as {{.Role}}.</p>
+<p>Log in as {{.Email}} in your notes app to accept the invitation. Apps accept it by sending
+a POST request to this link with your access token.</p>
+<p><small>The invitation expires {{.ExpiresAt.Format "2006-01-02 15:04"}}.</small></p>
+</body>
+</html>
+`))
+
+type WorkspaceHandler struct {
+	workspaceUseCase *usecases.WorkspaceUseCase
+}
+
+func NewWorkspaceHandler(workspaceUseCase *usecases.WorkspaceUseCase) *WorkspaceHandler {
+	return &WorkspaceHandler{workspaceUseCase: workspaceUseCase}
+}
+
+// CreateWorkspaceHandler creates a workspace owned by the authenticated user.
+func (h *WorkspaceHandler) CreateWorkspaceHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value(middleware.UserIDKey).(int)
+
+		var req struct {
+			Name string `json:"name"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid input", http.StatusBadRequest)
+			return
+		}
+
+		workspace, err := h.workspaceUseCase.CreateWorkspace(r.Context(), userID, req.Name)
+		if writeWorkspaceError(w, r, err, "Failed to create workspace") {
+			return
+		}
+
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(workspace)
+	}
+}
+
+// GetWorkspacesHandler lists the workspaces of the authenticated user.
+func (h *WorkspaceHandler) GetWorkspacesHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value(middleware.UserIDKey).(int)
+
+		workspaces, err := h.workspaceUseCase.ListWorkspaces(r.Context(), userID)
+		if writeWorkspaceError(w, r, err, "Failed to fetch workspaces") {
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(workspaces)
+	}
+}
+
+// GetMembersHandler lists the members of a workspace the authenticated user belongs to.
+func (h *WorkspaceHandler) GetMembersHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value(middleware.UserIDKey).(int)
+		workspaceID, err := strconv.Atoi(chi.URLParam(r, "id"))
+		if err != nil {
+			http.Error(w, "Invalid workspace ID", http.StatusBadRequest)
+			return
+		}
+
+		members, err := h.workspaceUseCase.ListMembers(r.Context(), workspaceID, userID)
+		if writeWorkspaceError(w, r, err, "Failed to fetch members") {
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(members)
+	}
+}
+
+// InviteHandler emails an invitation to join the workspace (owner only).
+func (h *WorkspaceHandler) InviteHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value(middleware.UserIDKey).(int)
+		workspaceID, err := strconv.Atoi(chi.URLParam(r, "id"))
+		if err != nil {
+			http.Error(w, "Invalid workspace ID", http.StatusBadRequest)
+			return
+		}
+
+		var req struct {
+			Email string `json:"email"`
+			Role  string `json:"role"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid input", http.StatusBadRequest)
+			return
+		}
+		if !isValidEmail(req.Email) {
+			http.Error(w, "Invalid email address", http.StatusBadRequest)
+			return
+		}
+
+		invitation, err := h.workspaceUseCase.Invite(r.Context(), workspaceID, userID, req.Email, req.Role)
+		if writeWorkspaceError(w, r, err, "Failed to send invitation") {
+			return
+		}
+
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(invitation)
+	}
+}
+
+// ViewInvitationHandler shows the invitation of the token without authentication, as HTML to browsers
+// opening the emailed link and as JSON otherwise. The invitation is accepted with a POST to the same URL.
+func (h *WorkspaceHandler) ViewInvitationHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// The token is in the URL, keep it out of caches and referrers
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("Referrer-Policy", "no-referrer")
+
+		token := r.URL.Query().Get("token")
+		if token == "" {
+			http.Error(w, "Token is required", http.StatusBadRequest)
+			return
+		}
+
+		invitation, err := h.workspaceUseCase.GetInvitation(r.Context(), token)
+		if writeWorkspaceError(w, r, err, "Failed to fetch invitation") {
+			return
+		}
+
+		if strings.Contains(r.Header.Get("Accept"), "text/html") {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			if err := invitationTemplate.Execute(w, invitation); err != nil {
+				slog.ErrorContext(r.Context(), "Failed to render invitation", "error", err)
+			}
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"workspaceId": invitation.WorkspaceID,
+			"email":       invitation.Email,
+			"role":        invitation.Role,
+			"expiresAt":   invitation.ExpiresAt,
+		})
+	}
+}
+
+// AcceptInvitationHandler adds the authenticated user to the workspace of the invitation token.
+func (h *WorkspaceHandler) AcceptInvitationHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value(middleware.UserIDKey).(int)
+		token := r.URL.Query().Get("token")
+		if token == "" {
+			http.Error(w, "Token is required", http.StatusBadRequest)
+			return
+		}
+
+		member, err := h.workspaceUseCase.AcceptInvitation(r.Context(), userID, token)
+		if writeWorkspaceError(w, r, err, "Failed to accept invitation") {
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(member)
+	}
+}
+
+// DeleteMemberHandler removes a member from the workspace; members can also remove themselves.
+func (h *WorkspaceHandler) DeleteMemberHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value(middleware.UserIDKey).(int)
+		workspaceID, err := strconv.Atoi(chi.URLParam(r, "id"))
+		if err != nil {
+			http.Error(w, "Invalid workspace ID", http.StatusBadRequest)
+			return
+		}
+		memberID, err := strconv.Atoi(chi.URLParam(r, "userID"))
+		if err != nil {
+			http.Error(w, "Invalid user ID", http.StatusBadRequest)
+			return
+		}
+
+		err = h.workspaceUseCase.RemoveMember(r.Context(), workspaceID, userID, memberID)
+		if writeWorkspaceError(w, r, err, "Failed to remove member") {
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// writeWorkspaceError writes the response for errors of workspace operations and reports whether there was an error.
+func writeWorkspaceError(w http.ResponseWriter, r *http.Request, err error, message string) bool {
+	switch {
+	case err == nil:
+		return false
+	case errors.Is(err, usecases.ErrInvalidInput):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, usecases.ErrInvalidToken):
+		http.Error(w, "Invalid or expired token", http.StatusBadRequest)
+	case errors.Is(err, usecases.ErrNotWorkspaceOwner),
+		errors.Is(err, usecases.ErrInvitationEmailMismatch),
+		errors.Is(err, usecases.ErrEmailNotVerified):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case errors.Is(err, usecases.ErrWorkspaceNotFound):
+		http.Error(w, "Workspace not found", http.StatusNotFound)
+	case errors.Is(err, usecases.ErrMemberNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	default:
+		slog.ErrorContext(r.Context(), message, "error", err)
+		http.Error(w, message, http.StatusInternalServerError)
+	}
+	return true
+}
@@ -0,0 +1,84 @@
+// Package logging sets up the structured logger. Records logged with the context of a request carry
+// its request ID and, once the request is authenticated, the user ID.
+package logging
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"sync/atomic"
+
+	"github.com/ananikitina/notes-rest/internal/config"
+)
+
+// New creates a logger writing records in the format and from the level of the configuration.
+func New(w io.Writer, cfg *config.Config) *slog.Logger {
+	options := &slog.HandlerOptions{Level: cfg.LogLevel}
+	var handler slog.Handler
+	if cfg.LogFormat == config.LogFormatText {
+		handler = slog.NewTextHandler(w, options)
+	} else {
+		handler = slog.NewJSONHandler(w, options)
+	}
+	return slog.New(contextHandler{handler})
+}
+
+type requestKey struct{}
+
+// request holds the attributes of a request. The user ID is set by the authentication, which runs
+// after the request was added to the context, so it is shared by pointer.
+type request struct {
+	id     string
+	userID atomic.Int64
+}
+
+// WithRequestID returns a context of a request with the ID.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestKey{}, &request{id: id})
+}
+
+// RequestID returns the ID of the request of the context, or "" outside of requests.
+func RequestID(ctx context.Context) string {
+	if req, ok := ctx.Value(requestKey{}).(*request); ok {
+		return req.id
+	}
+	return ""
+}
+
+// SetUserID records the authenticated user of the request of the context.
+func SetUserID(ctx context.Context, userID int) {
+	if req, ok := ctx.Value(requestKey{}).(*request); ok {
+		req.userID.Store(int64(userID))
+	}
+}
+
+// UserID returns the authenticated user of the request of the context, or 0.
+func UserID(ctx context.Context) int {
+	if req, ok := ctx.Value(requestKey{}).(*request); ok {
+		return int(req.userID.Load())
+	}
+	return 0
+}
+
+// contextHandler adds the attributes of the request of the context to the records.
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, record slog.Record) error {
+	if req, ok := ctx.Value(requestKey{}).(*request); ok {
+		record.AddAttrs(slog.String("request_id", req.id))
+		if userID := req.userID.Load(); userID != 0 {
+			record.AddAttrs(slog.Int64("user_id", userID))
+		}
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
@@ -0,0 +1,41 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/ananikitina/notes-rest/internal/config"
+)
+
+func TestRecordsCarryTheRequest(t *testing.T) {
+	var out bytes.Buffer
+	logger := New(&out, &config.Config{LogFormat: config.LogFormatText, LogLevel: slog.LevelInfo})
+
+	ctx := WithRequestID(context.Background(), "req-1")
+	logger.ErrorContext(ctx, "Before authentication")
+	SetUserID(ctx, 7)
+	logger.With("component", "test").ErrorContext(ctx, "After authentication")
+	logger.Error("Outside of requests")
+	logger.DebugContext(ctx, "Below the level")
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("got %d records, want 3:\n%s", len(lines), out.String())
+	}
+	for i, want := range []string{
+		`msg="Before authentication" request_id=req-1`,
+		`msg="After authentication" component=test request_id=req-1 user_id=7`,
+		`msg="Outside of requests"`,
+	} {
+		if !strings.HasSuffix(lines[i], want) {
+			t.Errorf("record %d = %q, want it to end with %q", i, lines[i], want)
+		}
+	}
+
+	if RequestID(ctx) != "req-1" || UserID(ctx) != 7 || RequestID(context.Background()) != "" {
+		t.Errorf("RequestID, UserID = %q, %d", RequestID(ctx), UserID(ctx))
+	}
+}
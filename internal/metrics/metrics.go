@@ -0,0 +1,170 @@
+// Package metrics collects the Prometheus metrics of the service.
+package metrics
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ananikitina/notes-rest/internal/domain"
+	"github.com/ananikitina/notes-rest/internal/services"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// namespace prefixes the names of the metrics of the service.
+const namespace = "notes"
+
+// Results of logins. A login succeeds once a session is issued, after the second factor if the user has one.
+const (
+	LoginSuccess   = "success"
+	LoginFailure   = "failure"
+	LoginThrottled = "throttled"
+)
+
+// statsTimeout limits the queries counting users and notes on every scrape.
+const statsTimeout = 5 * time.Second
+
+// Metrics is a registry with the metrics of the service.
+type Metrics struct {
+	registry *prometheus.Registry
+
+	httpRequests       *prometheus.CounterVec
+	httpDuration       *prometheus.HistogramVec
+	spellcheckDuration *prometheus.HistogramVec
+	spellcheckErrors   *prometheus.CounterVec
+	logins             *prometheus.CounterVec
+}
+
+// New creates the metrics with the Go runtime and process metrics.
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		httpRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "http_requests_total",
+			Help:      "HTTP requests by method, route pattern and status.",
+		}, []string{"method", "route", "status"}),
+		httpDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "Latency of HTTP requests by method, route pattern and status.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method", "route", "status"}),
+		spellcheckDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "spellcheck_duration_seconds",
+			Help:      "Latency of spell checker calls by provider.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"provider"}),
+		spellcheckErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "spellcheck_errors_total",
+			Help:      "Failed spell checker calls by provider.",
+		}, []string{"provider"}),
+		logins: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "logins_total",
+			Help:      "Logins by result: success when a session is issued, failure or throttled.",
+		}, []string{"result"}),
+	}
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.httpRequests, m.httpDuration, m.spellcheckDuration, m.spellcheckErrors, m.logins,
+	)
+	return m
+}
+
+// Handler serves the metrics in the Prometheus text format.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// ObserveRequest records a completed HTTP request. Requests that matched no route have the route
+// "unmatched", so unknown paths don't create new series.
+func (m *Metrics) ObserveRequest(method, route string, status int, duration time.Duration) {
+	if route == "" {
+		route = "unmatched"
+	}
+	labels := prometheus.Labels{"method": method, "route": route, "status": strconv.Itoa(status)}
+	m.httpRequests.With(labels).Inc()
+	m.httpDuration.With(labels).Observe(duration.Seconds())
+}
+
+// ObserveLogin records the result of a login step.
+func (m *Metrics) ObserveLogin(result string) {
+	m.logins.WithLabelValues(result).Inc()
+}
+
+// RegisterDB adds the connection pool statistics of the database.
+func (m *Metrics) RegisterDB(db *sql.DB, name string) {
+	m.registry.MustRegister(collectors.NewDBStatsCollector(db, name))
+}
+
+// RegisterStats adds the numbers of users and notes, counted on every scrape.
+func (m *Metrics) RegisterStats(users domain.UserRepository, notes domain.NoteRepository) {
+	m.registry.MustRegister(&statsCollector{users: users, notes: notes})
+}
+
+// InstrumentSpellChecker records the latency and errors of the checker's calls.
+func (m *Metrics) InstrumentSpellChecker(checker services.SpellChecker, provider string) services.SpellChecker {
+	return &instrumentedSpellChecker{
+		checker:  checker,
+		duration: m.spellcheckDuration.WithLabelValues(provider),
+		errors:   m.spellcheckErrors.WithLabelValues(provider),
+	}
+}
+
+type instrumentedSpellChecker struct {
+	checker  services.SpellChecker
+	duration prometheus.Observer
+	errors   prometheus.Counter
+}
+
+func (c *instrumentedSpellChecker) Check(ctx context.Context, text, lang string) ([]services.SpellCheckError, error) {
+	start := time.Now()
+	spellErrors, err := c.checker.Check(ctx, text, lang)
+	c.duration.Observe(time.Since(start).Seconds())
+	if err != nil {
+		c.errors.Inc()
+	}
+	return spellErrors, err
+}
+
+var (
+	usersDesc = prometheus.NewDesc(namespace+"_users", "Registered users.", nil, nil)
+	notesDesc = prometheus.NewDesc(namespace+"_notes", "Stored notes.", nil, nil)
+)
+
+// statsCollector counts users and notes in the repositories.
+type statsCollector struct {
+	users domain.UserRepository
+	notes domain.NoteRepository
+}
+
+func (c *statsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- usersDesc
+	ch <- notesDesc
+}
+
+// Collect sends the counts. A failed count is left out of the scrape and logged.
+func (c *statsCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), statsTimeout)
+	defer cancel()
+
+	if users, err := c.users.CountUsers(ctx); err != nil {
+		slog.Error("Failed to count users for metrics", "error", err)
+	} else {
+		ch <- prometheus.MustNewConstMetric(usersDesc, prometheus.GaugeValue, float64(users))
+	}
+	if notes, err := c.notes.CountNotes(ctx); err != nil {
+		slog.Error("Failed to count notes for metrics", "error", err)
+	} else {
+		ch <- prometheus.MustNewConstMetric(notesDesc, prometheus.GaugeValue, float64(notes))
+	}
+}
@@ -2,22 +2,33 @@ package middleware
 
 import (
 	"context"
+	"errors"
 	"net/http"
+	"strconv"
 	"strings"
 
 	"github.com/ananikitina/notes-rest/internal/domain"
+	"github.com/ananikitina/notes-rest/internal/logging"
+	"github.com/ananikitina/notes-rest/internal/usecases"
 )
 
 // A custom type for context keys
 type key int
 
 const (
-	UserIDKey   key = 0
-	UserRoleKey key = 1
+	UserIDKey    key = 0
+	UserRoleKey  key = 1
+	SessionIDKey key = 2
+	// WorkspaceMemberKey holds the *models.WorkspaceMember of the X-Workspace-ID workspace, if any
+	WorkspaceMemberKey key = 3
 )
 
-// AuthMiddleware ensures that the request is authenticated.
-func AuthMiddleware(jwtService domain.JWTServiceInterface) func(http.Handler) http.Handler {
+// WorkspaceHeader selects the workspace a request works in.
+const WorkspaceHeader = "X-Workspace-ID"
+
+// AuthMiddleware ensures that the request is authenticated and its session hasn't been revoked,
+// and resolves the user's membership in the workspace from the X-Workspace-ID header.
+func AuthMiddleware(jwtService domain.JWTServiceInterface, sessionUseCase *usecases.SessionUseCase, workspaceUseCase *usecases.WorkspaceUseCase) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			authHeader := r.Header.Get("Authorization")
@@ -33,15 +44,53 @@ func AuthMiddleware(jwtService domain.JWTServiceInterface) func(http.Handler) ht
 				return
 			}
 
+			if err := sessionUseCase.ValidateSession(r.Context(), claims.SessionID, claims.UserID); err != nil {
+				http.Error(w, "Invalid token", http.StatusUnauthorized)
+				return
+			}
+
 			userID := claims.UserID
 			role := claims.UserRole
 			ctx := context.WithValue(r.Context(), UserIDKey, userID)
 			ctx = context.WithValue(ctx, UserRoleKey, role)
+			ctx = context.WithValue(ctx, SessionIDKey, claims.SessionID)
+			// The access log and the error logs of the request record the user
+			logging.SetUserID(ctx, userID)
+
+			if header := r.Header.Get(WorkspaceHeader); header != "" {
+				workspaceID, err := strconv.Atoi(header)
+				if err != nil {
+					http.Error(w, "Invalid "+WorkspaceHeader+" header", http.StatusBadRequest)
+					return
+				}
+				member, err := workspaceUseCase.GetMembership(r.Context(), workspaceID, userID)
+				if errors.Is(err, usecases.ErrWorkspaceNotFound) {
+					http.Error(w, "Forbidden: Not a member of the workspace", http.StatusForbidden)
+					return
+				}
+				if err != nil {
+					http.Error(w, "Failed to check workspace membership", http.StatusInternalServerError)
+					return
+				}
+				ctx = context.WithValue(ctx, WorkspaceMemberKey, member)
+			}
 			next.ServeHTTP(w, r.WithContext(ctx))
 		})
 	}
 }
 
+// QueryTokenMiddleware accepts the token from the "access_token" query parameter when there is no
+// Authorization header. Browsers can't set headers for EventSource and WebSocket connections, so
+// it is only used for the streaming routes.
+func QueryTokenMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token := r.URL.Query().Get("access_token"); token != "" && r.Header.Get("Authorization") == "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
 func AdminOnlyMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		role := r.Context().Value(UserRoleKey).(string)
@@ -52,3 +101,41 @@ func AdminOnlyMiddleware(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+// VerifiedEmailMiddleware rejects requests from users who haven't verified their email yet.
+func VerifiedEmailMiddleware(userUseCase *usecases.UserUseCase) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			userID := r.Context().Value(UserIDKey).(int)
+			verified, err := userUseCase.IsEmailVerified(r.Context(), userID)
+			if err != nil {
+				http.Error(w, "Failed to check email verification", http.StatusInternalServerError)
+				return
+			}
+			if !verified {
+				http.Error(w, "Forbidden: Email address is not verified", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// TwoFactorRequiredMiddleware rejects requests from users without two-factor authentication enabled.
+func TwoFactorRequiredMiddleware(twoFactorUseCase *usecases.TwoFactorUseCase) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			userID := r.Context().Value(UserIDKey).(int)
+			enabled, err := twoFactorUseCase.IsEnabled(r.Context(), userID)
+			if err != nil {
+				http.Error(w, "Failed to check two-factor authentication", http.StatusInternalServerError)
+				return
+			}
+			if !enabled {
+				http.Error(w, "Forbidden: Two-factor authentication must be enabled", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/ananikitina/notes-rest/internal/logging"
+	"github.com/go-chi/chi"
+	chimiddleware "github.com/go-chi/chi/middleware"
+)
+
+// RequestIDHeader carries the ID that correlates the logs of a request.
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength limits request IDs taken from clients.
+const maxRequestIDLength = 128
+
+// RequestIDMiddleware takes the request ID from the X-Request-ID header, or generates one if it is
+// missing or invalid, returns it in the response header and adds it to the request's context so that
+// every record logged with the context carries it.
+func RequestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := r.Header.Get(RequestIDHeader)
+		if !validRequestID(requestID) {
+			requestID = newRequestID()
+		}
+		w.Header().Set(RequestIDHeader, requestID)
+		next.ServeHTTP(w, r.WithContext(logging.WithRequestID(r.Context(), requestID)))
+	})
+}
+
+// validRequestID reports whether the ID is short and only has characters that are safe in logs.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		if c < '!' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// AccessLogMiddleware logs every request when it is done with its route pattern, status and latency.
+// It must run inside RequestIDMiddleware; the user ID is added by the authentication.
+func AccessLogMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		// The wrapper keeps the Flusher and Hijacker of event streams and WebSockets
+		ww := chimiddleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
+
+		status := ww.Status()
+		if status == 0 {
+			// Nothing was written, or the connection was hijacked
+			status = http.StatusOK
+		}
+		slog.InfoContext(r.Context(), "Request",
+			"method", r.Method,
+			"route", routePattern(r),
+			"path", r.URL.Path,
+			"status", status,
+			"bytes", ww.BytesWritten(),
+			"latency", time.Since(start),
+		)
+	})
+}
+
+// routePattern returns the pattern of the route that handled the request, or "" if none matched.
+func routePattern(r *http.Request) string {
+	if routeCtx := chi.RouteContext(r.Context()); routeCtx != nil {
+		return routeCtx.RoutePattern()
+	}
+	return ""
+}
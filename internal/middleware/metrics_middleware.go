@@ -0,0 +1,26 @@
+package middleware
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/ananikitina/notes-rest/internal/metrics"
+	chimiddleware "github.com/go-chi/chi/middleware"
+)
+
+// MetricsMiddleware counts requests and measures their latency by route pattern and status.
+func MetricsMiddleware(m *metrics.Metrics) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			ww := chimiddleware.NewWrapResponseWriter(w, r.ProtoMajor)
+			next.ServeHTTP(ww, r)
+
+			status := ww.Status()
+			if status == 0 {
+				status = http.StatusOK
+			}
+			m.ObserveRequest(r.Method, routePattern(r), status, time.Since(start))
+		})
+	}
+}
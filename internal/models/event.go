@@ -0,0 +1,39 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Event types streamed to clients.
+const (
+	EventNoteCreated  = "note.created"
+	EventNoteUpdated  = "note.updated"
+	EventNoteDeleted  = "note.deleted"
+	EventShareCreated = "share.created"
+	EventShareRevoked = "share.revoked"
+
+	// Only sent to webhooks, when a note is rejected for spelling errors
+	EventSpellcheckFailed = "note.spellcheck_failed"
+)
+
+// Event notifies about a change of a note. It only identifies the note, clients fetch the note itself.
+type Event struct {
+	Type        string    `json:"type"`
+	NoteID      int       `json:"noteId"`
+	WorkspaceID *int      `json:"workspaceId,omitempty"`
+	ActorID     int       `json:"actorId"`
+	UserID      int       `json:"userId,omitempty"` // user a share was created or revoked for
+	At          time.Time `json:"at"`
+}
+
+// DomainEvent is an event stored in the outbox together with the change that caused it, and relayed
+// to the event sinks once the change is committed.
+type DomainEvent struct {
+	ID         int64 `json:"id"`
+	Event      Event `json:"event"`
+	Recipients []int `json:"recipients"`
+	// Data is the payload of webhook deliveries, e.g. the note
+	Data      json.RawMessage `json:"data,omitempty"`
+	CreatedAt time.Time       `json:"createdAt"`
+}
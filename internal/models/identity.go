@@ -0,0 +1,10 @@
+package models
+
+// ExternalIdentity is a user identity confirmed by an OpenID Connect provider.
+type ExternalIdentity struct {
+	Provider      string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Groups        []string
+}
@@ -0,0 +1,12 @@
+package models
+
+import "time"
+
+type LoginAttempt struct {
+	ID        int       `json:"id"`
+	Email     string    `json:"email"`
+	IP        string    `json:"ip"`
+	TokenHash string    `json:"-"` // hash of the token the attempt was made with, if any
+	Success   bool      `json:"success"`
+	CreatedAt time.Time `json:"createdAt"`
+}
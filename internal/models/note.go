@@ -2,9 +2,33 @@ package models
 
 import "time"
 
+// Permissions a note can be shared with.
+const (
+	NotePermissionViewer = "viewer"
+	NotePermissionEditor = "editor"
+)
+
 type Note struct {
-	ID        int       `json:"id"`
-	Content   string    `json:"content"`
-	UserID    int       `json:"userId"`
-	CreatedAt time.Time `json:"createdAt"`
+	ID          int        `json:"id"`
+	Content     string     `json:"content"`
+	UserID      int        `json:"userId"`
+	WorkspaceID *int       `json:"workspaceId,omitempty"` // nil for private notes
+	CreatedAt   time.Time  `json:"createdAt"`
+	UpdatedAt   *time.Time `json:"updatedAt,omitempty"`
+	ChangeSeq   int64      `json:"changeSeq"` // increases on every change
+}
+
+// NoteShare grants a user access to another user's note.
+type NoteShare struct {
+	NoteID     int       `json:"noteId"`
+	UserID     int       `json:"userId"`
+	Email      string    `json:"email,omitempty"`
+	Permission string    `json:"permission"`
+	CreatedAt  time.Time `json:"createdAt"`
+}
+
+// SharedNote is a note shared with the user, with the user's permission.
+type SharedNote struct {
+	Note
+	Permission string `json:"permission"`
 }
@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+// PublicLink gives read-only access to a note without an account.
+type PublicLink struct {
+	ID     int `json:"id"`
+	NoteID int `json:"noteId"`
+	// Token and URL are only known when the link is created, the database keeps a hash of the token
+	Token        string     `json:"token,omitempty"`
+	URL          string     `json:"url,omitempty"`
+	PasswordHash string     `json:"-"`
+	HasPassword  bool       `json:"hasPassword"`
+	ExpiresAt    *time.Time `json:"expiresAt,omitempty"`
+	CreatedAt    time.Time  `json:"createdAt"`
+	ViewCount    int        `json:"viewCount"`
+	LastViewedAt *time.Time `json:"lastViewedAt,omitempty"`
+}
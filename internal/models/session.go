@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+type Session struct {
+	ID          int       `json:"id"`
+	UserID      int       `json:"userId"`
+	DeviceLabel string    `json:"deviceLabel"`
+	UserAgent   string    `json:"userAgent"`
+	IP          string    `json:"ip"`
+	CreatedAt   time.Time `json:"createdAt"`
+	LastSeenAt  time.Time `json:"lastSeenAt"`
+	ExpiresAt   time.Time `json:"expiresAt"`
+	Current     bool      `json:"current"` // the session of the request
+}
@@ -0,0 +1,63 @@
+package models
+
+import "time"
+
+// Types of note changes returned by sync.
+const (
+	ChangeUpsert = "upsert"
+	ChangeDelete = "delete"
+)
+
+// Operations clients can push to sync.
+const (
+	SyncCreate = "create"
+	SyncUpdate = "update"
+	SyncDelete = "delete"
+)
+
+// Results of pushed operations.
+const (
+	SyncApplied  = "applied"
+	SyncConflict = "conflict"
+	SyncRejected = "rejected"
+	SyncNotFound = "not_found"
+	SyncFailed   = "error"
+)
+
+// SyncPage is a batch of changes with the token to request the next batch with.
+type SyncPage struct {
+	Changes []NoteChange `json:"changes"`
+	Token   string       `json:"token"`
+	HasMore bool         `json:"hasMore"`
+}
+
+// NoteChange is a created or updated note, or a tombstone of a note the user can no longer see.
+type NoteChange struct {
+	Type      string     `json:"type"`
+	NoteID    int        `json:"noteId"`
+	Seq       int64      `json:"seq"`
+	Note      *Note      `json:"note,omitempty"`
+	DeletedAt *time.Time `json:"deletedAt,omitempty"`
+}
+
+// SyncOperation is a change made on the client while offline.
+type SyncOperation struct {
+	// Client's identifier of the operation, echoed in the result
+	ClientID    string `json:"clientId"`
+	Op          string `json:"op"`
+	NoteID      int    `json:"noteId,omitempty"`
+	WorkspaceID *int   `json:"workspaceId,omitempty"`
+	Content     string `json:"content,omitempty"`
+	// changeSeq of the note the client changed; the change conflicts if the note changed since
+	BaseSeq int64 `json:"baseSeq,omitempty"`
+}
+
+// SyncResult is the outcome of a pushed operation. Note is the saved note, or the current note on conflict.
+type SyncResult struct {
+	ClientID string `json:"clientId"`
+	Status   string `json:"status"`
+	Note     *Note  `json:"note,omitempty"`
+	Error    string `json:"error,omitempty"`
+	// Spelling errors of rejected content
+	SpellingErrors interface{} `json:"spellingErrors,omitempty"`
+}
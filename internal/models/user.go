@@ -1,8 +1,30 @@
 package models
 
+import "time"
+
 type User struct {
-	ID       int    `json:"id"`
-	Email    string `json:"email"`
-	Password string `json:"password,omitempty"`
-	Role     string `json:"role"`
+	ID                  int        `json:"id"`
+	Email               string     `json:"email"`
+	Password            string     `json:"password,omitempty"`
+	Role                string     `json:"role"`
+	EmailVerified       bool       `json:"emailVerified"`
+	PendingEmail        string     `json:"pendingEmail,omitempty"` // new email waiting for confirmation
+	VerificationSentAt  *time.Time `json:"-"`
+	TOTPSecret          string     `json:"-"`
+	TOTPEnabled         bool       `json:"totpEnabled"`
+	TOTPLastStep        int64      `json:"-"`
+	DisplayName         string     `json:"displayName"`
+	Locale              string     `json:"locale"`
+	Timezone            string     `json:"timezone"`
+	SpellcheckEnabled   bool       `json:"spellcheckEnabled"`
+	SpellcheckLanguages string     `json:"spellcheckLanguages"` // comma separated, e.g. "ru,en"
+}
+
+// ProfileUpdate contains the profile fields to change; nil fields are left as is.
+type ProfileUpdate struct {
+	DisplayName         *string `json:"displayName"`
+	Locale              *string `json:"locale"`
+	Timezone            *string `json:"timezone"`
+	SpellcheckEnabled   *bool   `json:"spellcheckEnabled"`
+	SpellcheckLanguages *string `json:"spellcheckLanguages"`
 }
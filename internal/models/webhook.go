@@ -0,0 +1,43 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Webhook delivery statuses.
+const (
+	DeliveryPending   = "pending"
+	DeliverySucceeded = "succeeded"
+	DeliveryFailed    = "failed"
+)
+
+// Webhook posts note events to an external URL.
+type Webhook struct {
+	ID     int    `json:"id"`
+	UserID int    `json:"userId"`
+	URL    string `json:"url"`
+	// Secret signs the deliveries, it is only returned when the webhook is created
+	Secret    string    `json:"secret,omitempty"`
+	Events    []string  `json:"events"`
+	Global    bool      `json:"global"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// WebhookDelivery is a queued or sent event of a webhook.
+type WebhookDelivery struct {
+	ID            int64           `json:"id"`
+	WebhookID     int             `json:"webhookId"`
+	EventType     string          `json:"eventType"`
+	Payload       json.RawMessage `json:"payload"`
+	Status        string          `json:"status"`
+	Attempts      int             `json:"attempts"`
+	NextAttemptAt time.Time       `json:"nextAttemptAt"`
+	LastAttemptAt *time.Time      `json:"lastAttemptAt,omitempty"`
+	ResponseCode  *int            `json:"responseCode,omitempty"`
+	Error         string          `json:"error,omitempty"`
+	CreatedAt     time.Time       `json:"createdAt"`
+
+	// Webhook the delivery is sent to, set for deliveries claimed by the worker
+	Webhook *Webhook `json:"-"`
+}
@@ -0,0 +1,42 @@
+package models
+
+import "time"
+
+// Workspace member roles.
+const (
+	WorkspaceOwner  = "owner"
+	WorkspaceEditor = "editor"
+	WorkspaceViewer = "viewer"
+)
+
+type Workspace struct {
+	ID        int       `json:"id"`
+	Name      string    `json:"name"`
+	OwnerID   int       `json:"ownerId"`
+	Role      string    `json:"role,omitempty"` // role of the requesting user
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type WorkspaceMember struct {
+	WorkspaceID int       `json:"workspaceId"`
+	UserID      int       `json:"userId"`
+	Email       string    `json:"email,omitempty"`
+	Role        string    `json:"role"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+// CanEdit reports whether the member can create and change notes in the workspace.
+func (m *WorkspaceMember) CanEdit() bool {
+	return m.Role == WorkspaceOwner || m.Role == WorkspaceEditor
+}
+
+type WorkspaceInvitation struct {
+	ID          int        `json:"id"`
+	WorkspaceID int        `json:"workspaceId"`
+	Email       string     `json:"email"`
+	Role        string     `json:"role"`
+	InvitedBy   int        `json:"invitedBy"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	ExpiresAt   time.Time  `json:"expiresAt"`
+	AcceptedAt  *time.Time `json:"acceptedAt,omitempty"`
+}
@@ -0,0 +1,94 @@
+package memory
+
+import (
+	"context"
+	"time"
+
+	"github.com/ananikitina/notes-rest/internal/models"
+)
+
+// LoginAttemptRepository is an in-memory implementation of the LoginAttemptRepository interface.
+type LoginAttemptRepository struct {
+	store *Store
+}
+
+// Add records a login attempt.
+func (l *LoginAttemptRepository) Add(ctx context.Context, attempt models.LoginAttempt) error {
+	l.store.mu.Lock()
+	defer l.store.mu.Unlock()
+	d := &l.store.data
+
+	attempt.ID = int(d.nextID("login_attempts"))
+	d.loginAttempts = append(d.loginAttempts, loginAttemptRow{LoginAttempt: attempt})
+	return nil
+}
+
+// CountFailuresByEmail returns the number of failed attempts for the email since the given time
+// that haven't been cleared by a successful login or an unlock, and the time of the latest one.
+func (l *LoginAttemptRepository) CountFailuresByEmail(ctx context.Context, email string, since time.Time) (int, time.Time, error) {
+	l.store.mu.Lock()
+	defer l.store.mu.Unlock()
+
+	var count int
+	var last time.Time
+	for _, attempt := range l.store.data.loginAttempts {
+		if attempt.Email == email && !attempt.Success && !attempt.cleared && attempt.CreatedAt.After(since) {
+			count++
+			if attempt.CreatedAt.After(last) {
+				last = attempt.CreatedAt
+			}
+		}
+	}
+	return count, last, nil
+}
+
+// CountFailuresByIP returns the number of failed attempts from the IP since the given time
+// and the time of the earliest one.
+func (l *LoginAttemptRepository) CountFailuresByIP(ctx context.Context, ip string, since time.Time) (int, time.Time, error) {
+	l.store.mu.Lock()
+	defer l.store.mu.Unlock()
+
+	var count int
+	var first time.Time
+	for _, attempt := range l.store.data.loginAttempts {
+		if attempt.IP == ip && !attempt.Success && attempt.CreatedAt.After(since) {
+			count++
+			if first.IsZero() || attempt.CreatedAt.Before(first) {
+				first = attempt.CreatedAt
+			}
+		}
+	}
+	return count, first, nil
+}
+
+// CountFailuresByToken returns the number of failed attempts with the token since the given time,
+// including cleared ones, and the time of the latest one.
+func (l *LoginAttemptRepository) CountFailuresByToken(ctx context.Context, tokenHash string, since time.Time) (int, time.Time, error) {
+	l.store.mu.Lock()
+	defer l.store.mu.Unlock()
+
+	var count int
+	var last time.Time
+	for _, attempt := range l.store.data.loginAttempts {
+		if attempt.TokenHash == tokenHash && !attempt.Success && attempt.CreatedAt.After(since) {
+			count++
+			if attempt.CreatedAt.After(last) {
+				last = attempt.CreatedAt
+			}
+		}
+	}
+	return count, last, nil
+}
+
+// ClearFailures stops counting the failed attempts for the email. The records are kept.
+func (l *LoginAttemptRepository) ClearFailures(ctx context.Context, email string) error {
+	l.store.mu.Lock()
+	defer l.store.mu.Unlock()
+
+	for i, attempt := range l.store.data.loginAttempts {
+		if attempt.Email == email && !attempt.Success {
+			l.store.data.loginAttempts[i].cleared = true
+		}
+	}
+	return nil
+}
@@ -0,0 +1,24 @@
+package memory_test
+
+import (
+	"testing"
+
+	"github.com/ananikitina/notes-rest/internal/repository/memory"
+	"github.com/ananikitina/notes-rest/internal/repository/repotest"
+)
+
+func TestRepositories(t *testing.T) {
+	repotest.Run(t, func(t *testing.T) repotest.Repositories {
+		store := memory.NewStore()
+		return repotest.Repositories{
+			Users:         store.Users(),
+			LoginAttempts: store.LoginAttempts(),
+			Sessions:      store.Sessions(),
+			Notes:         store.Notes(),
+			Workspaces:    store.Workspaces(),
+			PublicLinks:   store.PublicLinks(),
+			Webhooks:      store.Webhooks(),
+			UnitOfWork:    store,
+		}
+	})
+}
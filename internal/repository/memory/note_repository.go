@@ -0,0 +1,371 @@
+package memory
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/ananikitina/notes-rest/internal/models"
+)
+
+// NoteRepository is an in-memory implementation of the NoteRepository interface.
+type NoteRepository struct {
+	store *Store
+}
+
+// Add stores a new note and sets its ID, creation time and change sequence.
+func (n *NoteRepository) Add(ctx context.Context, note *models.Note) error {
+	if err := n.store.lock(ctx); err != nil {
+		return err
+	}
+	defer n.store.mu.Unlock()
+	d := &n.store.data
+
+	note.ID = int(d.nextID("notes"))
+	note.CreatedAt = time.Now()
+	note.ChangeSeq = d.nextSeq()
+	d.notes[note.ID] = *note
+	return nil
+}
+
+// GetByID retrieves a note by its ID.
+func (n *NoteRepository) GetByID(ctx context.Context, id int) (*models.Note, error) {
+	if err := n.store.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer n.store.mu.Unlock()
+
+	note, ok := n.store.data.notes[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return &note, nil
+}
+
+// Update saves the content of the note and sets its new change sequence. With a non-zero baseSeq
+// the note is only saved if it hasn't changed since, otherwise false is returned.
+func (n *NoteRepository) Update(ctx context.Context, note *models.Note, baseSeq int64) (bool, error) {
+	if err := n.store.lock(ctx); err != nil {
+		return false, err
+	}
+	defer n.store.mu.Unlock()
+	d := &n.store.data
+
+	stored, ok := d.notes[note.ID]
+	if !ok || (baseSeq != 0 && stored.ChangeSeq != baseSeq) {
+		return false, nil
+	}
+	stored.Content = note.Content
+	stored.UpdatedAt = note.UpdatedAt
+	stored.ChangeSeq = d.nextSeq()
+	d.notes[note.ID] = stored
+	note.ChangeSeq = stored.ChangeSeq
+	return true, nil
+}
+
+// Delete removes the note with its shares and public links, leaving tombstones for sync. With a non-zero
+// baseSeq the note is only deleted if it hasn't changed since, otherwise false is returned.
+func (n *NoteRepository) Delete(ctx context.Context, id int, baseSeq int64) (bool, error) {
+	if err := n.store.lock(ctx); err != nil {
+		return false, err
+	}
+	defer n.store.mu.Unlock()
+	d := &n.store.data
+
+	note, ok := d.notes[id]
+	if !ok {
+		return false, sql.ErrNoRows
+	}
+	if baseSeq != 0 && note.ChangeSeq != baseSeq {
+		return false, nil
+	}
+	d.deleteNote(note)
+	return true, nil
+}
+
+// GetByUserID retrieves private notes of the user.
+func (n *NoteRepository) GetByUserID(ctx context.Context, userID int, query string) ([]models.Note, error) {
+	return n.findNotes(ctx, func(note *models.Note) bool {
+		return note.UserID == userID && note.WorkspaceID == nil && matchesSearch(note.Content, query)
+	})
+}
+
+// GetByWorkspaceID retrieves notes of the workspace.
+func (n *NoteRepository) GetByWorkspaceID(ctx context.Context, workspaceID int, query string) ([]models.Note, error) {
+	return n.findNotes(ctx, func(note *models.Note) bool {
+		return note.WorkspaceID != nil && *note.WorkspaceID == workspaceID && matchesSearch(note.Content, query)
+	})
+}
+
+// GetOwnedByUser retrieves the user's notes in any workspace and all notes of the workspaces the user owns.
+func (n *NoteRepository) GetOwnedByUser(ctx context.Context, userID int) ([]models.Note, error) {
+	return n.findNotes(ctx, func(note *models.Note) bool {
+		if note.UserID == userID {
+			return true
+		}
+		if note.WorkspaceID == nil {
+			return false
+		}
+		workspace, ok := n.store.data.workspaces[*note.WorkspaceID]
+		return ok && workspace.OwnerID == userID
+	})
+}
+
+// GetAllNotes retrieves all notes (admin access).
+func (n *NoteRepository) GetAllNotes(ctx context.Context) ([]models.Note, error) {
+	return n.findNotes(ctx, func(*models.Note) bool { return true })
+}
+
+// CountNotes returns the number of notes.
+func (n *NoteRepository) CountNotes(ctx context.Context) (int, error) {
+	if err := n.store.lock(ctx); err != nil {
+		return 0, err
+	}
+	defer n.store.mu.Unlock()
+	return len(n.store.data.notes), nil
+}
+
+// findNotes retrieves the notes matching the filter in creation order.
+func (n *NoteRepository) findNotes(ctx context.Context, filter func(note *models.Note) bool) ([]models.Note, error) {
+	if err := n.store.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer n.store.mu.Unlock()
+
+	var notes []models.Note
+	for _, note := range n.store.data.notes {
+		if filter(&note) {
+			notes = append(notes, note)
+		}
+	}
+	sortNotes(notes)
+	return notes, nil
+}
+
+// SaveShare shares the note with the user or changes the permission of an existing share.
+func (n *NoteRepository) SaveShare(ctx context.Context, share *models.NoteShare) error {
+	if err := n.store.lock(ctx); err != nil {
+		return err
+	}
+	defer n.store.mu.Unlock()
+	d := &n.store.data
+
+	key := userNoteKey{noteID: share.NoteID, userID: share.UserID}
+	if existing, ok := d.shares[key]; ok {
+		share.CreatedAt = existing.CreatedAt
+	}
+	d.shares[key] = models.NoteShare{NoteID: share.NoteID, UserID: share.UserID, Permission: share.Permission,
+		CreatedAt: share.CreatedAt}
+	d.revealNote(share.NoteID, share.UserID)
+	return nil
+}
+
+// GetShare retrieves the share of the note with the user.
+func (n *NoteRepository) GetShare(ctx context.Context, noteID, userID int) (*models.NoteShare, error) {
+	if err := n.store.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer n.store.mu.Unlock()
+
+	share, ok := n.store.data.shares[userNoteKey{noteID: noteID, userID: userID}]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return &share, nil
+}
+
+// ListShares retrieves the shares of the note with the emails of the users.
+func (n *NoteRepository) ListShares(ctx context.Context, noteID int) ([]models.NoteShare, error) {
+	if err := n.store.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer n.store.mu.Unlock()
+	d := &n.store.data
+
+	shares := []models.NoteShare{}
+	for key, share := range d.shares {
+		if key.noteID == noteID {
+			share.Email = d.users[share.UserID].Email
+			shares = append(shares, share)
+		}
+	}
+	sort.Slice(shares, func(i, j int) bool {
+		if !shares[i].CreatedAt.Equal(shares[j].CreatedAt) {
+			return shares[i].CreatedAt.Before(shares[j].CreatedAt)
+		}
+		return shares[i].UserID < shares[j].UserID
+	})
+	return shares, nil
+}
+
+// RemoveShare revokes the share of the note with the user and reports whether it existed.
+func (n *NoteRepository) RemoveShare(ctx context.Context, noteID, userID int) (bool, error) {
+	if err := n.store.lock(ctx); err != nil {
+		return false, err
+	}
+	defer n.store.mu.Unlock()
+	d := &n.store.data
+
+	key := userNoteKey{noteID: noteID, userID: userID}
+	if _, ok := d.shares[key]; !ok {
+		return false, nil
+	}
+	delete(d.shares, key)
+	d.hideNote(noteID, userID)
+	return true, nil
+}
+
+// GetSharedWith retrieves the notes shared with the user, with the user's permission.
+func (n *NoteRepository) GetSharedWith(ctx context.Context, userID int) ([]models.SharedNote, error) {
+	if err := n.store.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer n.store.mu.Unlock()
+	d := &n.store.data
+
+	var notes []models.Note
+	for key := range d.shares {
+		if note, ok := d.notes[key.noteID]; ok && key.userID == userID {
+			notes = append(notes, note)
+		}
+	}
+	sortNotes(notes)
+
+	shared := []models.SharedNote{}
+	for _, note := range notes {
+		permission := d.shares[userNoteKey{noteID: note.ID, userID: userID}].Permission
+		shared = append(shared, models.SharedNote{Note: note, Permission: permission})
+	}
+	return shared, nil
+}
+
+// GetChanges retrieves up to limit changes of the notes the user can see after the since sequence,
+// in sequence order: the notes created or changed since, and the tombstones of notes that were
+// deleted or became invisible to the user.
+func (n *NoteRepository) GetChanges(ctx context.Context, userID int, since int64, limit int) ([]models.NoteChange, error) {
+	if err := n.store.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer n.store.mu.Unlock()
+	d := &n.store.data
+
+	changes := []models.NoteChange{}
+	for _, note := range d.notes {
+		if note.ChangeSeq > since && d.canSee(&note, userID) {
+			note := note
+			changes = append(changes, models.NoteChange{Type: models.ChangeUpsert, NoteID: note.ID, Seq: note.ChangeSeq, Note: &note})
+		}
+	}
+	for key, tombstone := range d.tombstones {
+		if key.userID == userID && tombstone.seq > since {
+			deletedAt := tombstone.deletedAt
+			changes = append(changes, models.NoteChange{Type: models.ChangeDelete, NoteID: key.noteID, Seq: tombstone.seq,
+				DeletedAt: &deletedAt})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Seq < changes[j].Seq })
+	if len(changes) > limit {
+		changes = changes[:limit]
+	}
+	return changes, nil
+}
+
+// canSee reports whether the user owns the private note, is a member of its workspace or has it shared.
+// Authors only see their notes of a workspace while they are its members.
+func (d *data) canSee(note *models.Note, userID int) bool {
+	if note.WorkspaceID == nil {
+		if note.UserID == userID {
+			return true
+		}
+	} else if _, ok := d.members[userWorkspaceKey{workspaceID: *note.WorkspaceID, userID: userID}]; ok {
+		return true
+	}
+	_, ok := d.shares[userNoteKey{noteID: note.ID, userID: userID}]
+	return ok
+}
+
+// deleteNote removes the note with its shares and public links, leaving tombstones for everyone
+// who could see it.
+func (d *data) deleteNote(note models.Note) {
+	seen := map[int]bool{note.UserID: true}
+	if note.WorkspaceID != nil {
+		for key := range d.members {
+			if key.workspaceID == *note.WorkspaceID {
+				seen[key.userID] = true
+			}
+		}
+	}
+	for key := range d.shares {
+		if key.noteID == note.ID {
+			seen[key.userID] = true
+			delete(d.shares, key)
+		}
+	}
+	for userID := range seen {
+		d.tombstones[userNoteKey{noteID: note.ID, userID: userID}] = tombstone{seq: d.nextSeq(), deletedAt: time.Now()}
+	}
+	for id, link := range d.publicLinks {
+		if link.NoteID == note.ID {
+			delete(d.publicLinks, id)
+		}
+	}
+	delete(d.notes, note.ID)
+}
+
+// revealNote makes the note, which the user can now see, show up in the user's next sync.
+func (d *data) revealNote(noteID, userID int) {
+	delete(d.tombstones, userNoteKey{noteID: noteID, userID: userID})
+	if note, ok := d.notes[noteID]; ok {
+		note.ChangeSeq = d.nextSeq()
+		d.notes[noteID] = note
+	}
+}
+
+// hideNote records the user's tombstone for the note if the user can no longer see it.
+func (d *data) hideNote(noteID, userID int) {
+	if note, ok := d.notes[noteID]; ok && !d.canSee(&note, userID) {
+		d.tombstones[userNoteKey{noteID: noteID, userID: userID}] = tombstone{seq: d.nextSeq(), deletedAt: time.Now()}
+	}
+}
+
+// sortNotes sorts the notes in creation order.
+func sortNotes(notes []models.Note) {
+	sort.Slice(notes, func(i, j int) bool {
+		if !notes[i].CreatedAt.Equal(notes[j].CreatedAt) {
+			return notes[i].CreatedAt.Before(notes[j].CreatedAt)
+		}
+		return notes[i].ID < notes[j].ID
+	})
+}
+
+// matchesSearch reports whether the content contains all words of the query, like the full-text
+// search of the database with the simple configuration. An empty query matches all content, a query
+// without words matches nothing.
+func matchesSearch(content, query string) bool {
+	if query == "" {
+		return true
+	}
+	queryWords := splitWords(query)
+	if len(queryWords) == 0 {
+		return false
+	}
+	words := map[string]bool{}
+	for _, word := range splitWords(content) {
+		words[word] = true
+	}
+	for _, word := range queryWords {
+		if !words[word] {
+			return false
+		}
+	}
+	return true
+}
+
+func splitWords(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
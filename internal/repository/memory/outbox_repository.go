@@ -0,0 +1,60 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/ananikitina/notes-rest/internal/models"
+)
+
+// OutboxRepository is an in-memory implementation of the OutboxRepository interface.
+type OutboxRepository struct {
+	store *Store
+}
+
+// Add stores the event and sets its ID and creation time.
+func (o *OutboxRepository) Add(ctx context.Context, event *models.DomainEvent) error {
+	if err := o.store.lock(ctx); err != nil {
+		return err
+	}
+	defer o.store.mu.Unlock()
+	d := &o.store.data
+
+	event.ID = d.nextID("outbox")
+	event.CreatedAt = time.Now()
+	d.outbox[event.ID] = *event
+	return nil
+}
+
+// FetchPending retrieves up to limit events in the order they were added. Units of work of the store
+// don't run concurrently, so the events need no locking.
+func (o *OutboxRepository) FetchPending(ctx context.Context, limit int) ([]models.DomainEvent, error) {
+	if err := o.store.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer o.store.mu.Unlock()
+
+	var events []models.DomainEvent
+	for _, event := range o.store.data.outbox {
+		events = append(events, event)
+	}
+	sort.Slice(events, func(i, j int) bool { return events[i].ID < events[j].ID })
+	if len(events) > limit {
+		events = events[:limit]
+	}
+	return events, nil
+}
+
+// Delete removes the relayed events.
+func (o *OutboxRepository) Delete(ctx context.Context, ids []int64) error {
+	if err := o.store.lock(ctx); err != nil {
+		return err
+	}
+	defer o.store.mu.Unlock()
+
+	for _, id := range ids {
+		delete(o.store.data.outbox, id)
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package memory
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+	"time"
+
+	"github.com/ananikitina/notes-rest/internal/models"
+)
+
+// PublicLinkRepository is an in-memory implementation of the PublicLinkRepository interface.
+type PublicLinkRepository struct {
+	store *Store
+}
+
+// Create stores a link with the hash of its token and sets its ID.
+func (p *PublicLinkRepository) Create(ctx context.Context, link *models.PublicLink, tokenHash string) error {
+	p.store.mu.Lock()
+	defer p.store.mu.Unlock()
+	d := &p.store.data
+
+	for _, existing := range d.publicLinks {
+		if existing.tokenHash == tokenHash {
+			return ErrDuplicate
+		}
+	}
+	link.ID = int(d.nextID("public_links"))
+	stored := models.PublicLink{ID: link.ID, NoteID: link.NoteID, PasswordHash: link.PasswordHash,
+		HasPassword: link.PasswordHash != "", ExpiresAt: link.ExpiresAt, CreatedAt: link.CreatedAt}
+	d.publicLinks[link.ID] = publicLinkRow{PublicLink: stored, tokenHash: tokenHash}
+	return nil
+}
+
+// GetActiveByToken retrieves a link by token hash that hasn't expired.
+func (p *PublicLinkRepository) GetActiveByToken(ctx context.Context, tokenHash string) (*models.PublicLink, error) {
+	p.store.mu.Lock()
+	defer p.store.mu.Unlock()
+
+	for _, link := range p.store.data.publicLinks {
+		if link.tokenHash == tokenHash && (link.ExpiresAt == nil || link.ExpiresAt.After(time.Now())) {
+			return &link.PublicLink, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+// ListByNote retrieves the links of the note.
+func (p *PublicLinkRepository) ListByNote(ctx context.Context, noteID int) ([]models.PublicLink, error) {
+	p.store.mu.Lock()
+	defer p.store.mu.Unlock()
+
+	links := []models.PublicLink{}
+	for _, link := range p.store.data.publicLinks {
+		if link.NoteID == noteID {
+			links = append(links, link.PublicLink)
+		}
+	}
+	sort.Slice(links, func(i, j int) bool { return links[i].ID < links[j].ID })
+	return links, nil
+}
+
+// Delete removes the link of the note and reports whether it existed.
+func (p *PublicLinkRepository) Delete(ctx context.Context, id, noteID int) (bool, error) {
+	p.store.mu.Lock()
+	defer p.store.mu.Unlock()
+
+	link, ok := p.store.data.publicLinks[id]
+	if !ok || link.NoteID != noteID {
+		return false, nil
+	}
+	delete(p.store.data.publicLinks, id)
+	return true, nil
+}
+
+// RecordView increments the view count of the link.
+func (p *PublicLinkRepository) RecordView(ctx context.Context, id int, at time.Time) error {
+	p.store.mu.Lock()
+	defer p.store.mu.Unlock()
+
+	if link, ok := p.store.data.publicLinks[id]; ok {
+		link.ViewCount++
+		link.LastViewedAt = &at
+		p.store.data.publicLinks[id] = link
+	}
+	return nil
+}
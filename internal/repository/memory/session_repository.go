@@ -0,0 +1,110 @@
+package memory
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+	"time"
+
+	"github.com/ananikitina/notes-rest/internal/models"
+)
+
+// SessionRepository is an in-memory implementation of the SessionRepository interface.
+type SessionRepository struct {
+	store *Store
+}
+
+// Create adds a new session and sets its ID.
+func (s *SessionRepository) Create(ctx context.Context, session *models.Session) error {
+	s.store.mu.Lock()
+	defer s.store.mu.Unlock()
+	d := &s.store.data
+
+	session.ID = int(d.nextID("sessions"))
+	stored := *session
+	stored.LastSeenAt = session.CreatedAt
+	stored.Current = false
+	d.sessions[session.ID] = sessionRow{Session: stored}
+	return nil
+}
+
+// GetActive retrieves a session that is neither revoked nor expired.
+func (s *SessionRepository) GetActive(ctx context.Context, id int) (*models.Session, error) {
+	s.store.mu.Lock()
+	defer s.store.mu.Unlock()
+
+	stored, ok := s.store.data.sessions[id]
+	if !ok || !stored.active() {
+		return nil, sql.ErrNoRows
+	}
+	return &stored.Session, nil
+}
+
+// ListActiveByUser retrieves the user's sessions that are neither revoked nor expired.
+func (s *SessionRepository) ListActiveByUser(ctx context.Context, userID int) ([]models.Session, error) {
+	s.store.mu.Lock()
+	defer s.store.mu.Unlock()
+
+	sessions := []models.Session{}
+	for _, stored := range s.store.data.sessions {
+		if stored.UserID == userID && stored.active() {
+			sessions = append(sessions, stored.Session)
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].LastSeenAt.After(sessions[j].LastSeenAt) })
+	return sessions, nil
+}
+
+// Touch updates the time the session was last used.
+func (s *SessionRepository) Touch(ctx context.Context, id int, lastSeenAt time.Time) error {
+	s.store.mu.Lock()
+	defer s.store.mu.Unlock()
+
+	if stored, ok := s.store.data.sessions[id]; ok {
+		stored.LastSeenAt = lastSeenAt
+		s.store.data.sessions[id] = stored
+	}
+	return nil
+}
+
+// Revoke revokes the user's session and reports whether an active session was found.
+func (s *SessionRepository) Revoke(ctx context.Context, id, userID int) (bool, error) {
+	s.store.mu.Lock()
+	defer s.store.mu.Unlock()
+
+	stored, ok := s.store.data.sessions[id]
+	if !ok || stored.UserID != userID || stored.revoked {
+		return false, nil
+	}
+	stored.revoked = true
+	s.store.data.sessions[id] = stored
+	return true, nil
+}
+
+// RevokeOthersByUser revokes all sessions of the user except the given one.
+func (s *SessionRepository) RevokeOthersByUser(ctx context.Context, userID, keepID int) error {
+	s.revokeByUser(userID, keepID)
+	return nil
+}
+
+// RevokeAllByUser revokes all sessions of the user.
+func (s *SessionRepository) RevokeAllByUser(ctx context.Context, userID int) error {
+	s.revokeByUser(userID, 0)
+	return nil
+}
+
+func (s *SessionRepository) revokeByUser(userID, keepID int) {
+	s.store.mu.Lock()
+	defer s.store.mu.Unlock()
+
+	for id, stored := range s.store.data.sessions {
+		if stored.UserID == userID && id != keepID {
+			stored.revoked = true
+			s.store.data.sessions[id] = stored
+		}
+	}
+}
+
+func (s *sessionRow) active() bool {
+	return !s.revoked && s.ExpiresAt.After(time.Now())
+}
@@ -0,0 +1,250 @@
+// Package memory implements the repositories in memory, for tests and for running the handlers
+// without a database.
+package memory
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/ananikitina/notes-rest/internal/domain"
+	"github.com/ananikitina/notes-rest/internal/models"
+)
+
+// Store keeps the data of all repositories. Repositories of one store see each other's data,
+// like tables of one database.
+type Store struct {
+	mu sync.Mutex
+	// txMu serializes units of work, so a rolled back one doesn't undo the changes of another
+	txMu sync.Mutex
+	data data
+}
+
+// data holds the tables of the store.
+type data struct {
+	lastID  map[string]int64
+	lastSeq int64
+
+	users         map[int]models.User
+	identities    map[identityKey]int
+	recoveryCodes map[int][]recoveryCode
+	loginAttempts []loginAttemptRow
+	sessions      map[int]sessionRow
+
+	notes      map[int]models.Note
+	shares     map[userNoteKey]models.NoteShare
+	tombstones map[userNoteKey]tombstone
+
+	workspaces  map[int]models.Workspace
+	members     map[userWorkspaceKey]models.WorkspaceMember
+	invitations map[int]invitationRow
+	publicLinks map[int]publicLinkRow
+
+	webhooks   map[int]models.Webhook
+	deliveries map[int64]models.WebhookDelivery
+	outbox     map[int64]models.DomainEvent
+}
+
+type identityKey struct {
+	provider, subject string
+}
+
+type userNoteKey struct {
+	noteID, userID int
+}
+
+type userWorkspaceKey struct {
+	workspaceID, userID int
+}
+
+type recoveryCode struct {
+	hash string
+	used bool
+}
+
+type loginAttemptRow struct {
+	models.LoginAttempt
+	cleared bool
+}
+
+type sessionRow struct {
+	models.Session
+	revoked bool
+}
+
+type tombstone struct {
+	seq       int64
+	deletedAt time.Time
+}
+
+type invitationRow struct {
+	models.WorkspaceInvitation
+	tokenHash string
+}
+
+type publicLinkRow struct {
+	models.PublicLink
+	tokenHash string
+}
+
+// NewStore creates an empty store.
+func NewStore() *Store {
+	return &Store{data: data{
+		lastID:        map[string]int64{},
+		users:         map[int]models.User{},
+		identities:    map[identityKey]int{},
+		recoveryCodes: map[int][]recoveryCode{},
+		sessions:      map[int]sessionRow{},
+		notes:         map[int]models.Note{},
+		shares:        map[userNoteKey]models.NoteShare{},
+		tombstones:    map[userNoteKey]tombstone{},
+		workspaces:    map[int]models.Workspace{},
+		members:       map[userWorkspaceKey]models.WorkspaceMember{},
+		invitations:   map[int]invitationRow{},
+		publicLinks:   map[int]publicLinkRow{},
+		webhooks:      map[int]models.Webhook{},
+		deliveries:    map[int64]models.WebhookDelivery{},
+		outbox:        map[int64]models.DomainEvent{},
+	}}
+}
+
+// Users returns the user repository of the store.
+func (s *Store) Users() *UserRepository {
+	return &UserRepository{store: s}
+}
+
+// LoginAttempts returns the login attempt repository of the store.
+func (s *Store) LoginAttempts() *LoginAttemptRepository {
+	return &LoginAttemptRepository{store: s}
+}
+
+// Sessions returns the session repository of the store.
+func (s *Store) Sessions() *SessionRepository {
+	return &SessionRepository{store: s}
+}
+
+// Notes returns the note repository of the store.
+func (s *Store) Notes() *NoteRepository {
+	return &NoteRepository{store: s}
+}
+
+// Workspaces returns the workspace repository of the store.
+func (s *Store) Workspaces() *WorkspaceRepository {
+	return &WorkspaceRepository{store: s}
+}
+
+// PublicLinks returns the public link repository of the store.
+func (s *Store) PublicLinks() *PublicLinkRepository {
+	return &PublicLinkRepository{store: s}
+}
+
+// Webhooks returns the webhook repository of the store.
+func (s *Store) Webhooks() *WebhookRepository {
+	return &WebhookRepository{store: s}
+}
+
+// Outbox returns the outbox repository of the store.
+func (s *Store) Outbox() *OutboxRepository {
+	return &OutboxRepository{store: s}
+}
+
+// Do runs fn with the repositories of the store. If fn returns an error, the store is restored
+// to its state before fn.
+func (s *Store) Do(ctx context.Context, fn func(tx domain.Tx) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.txMu.Lock()
+	defer s.txMu.Unlock()
+
+	s.mu.Lock()
+	snapshot := s.data.clone()
+	s.mu.Unlock()
+
+	if err := fn(storeTx{store: s}); err != nil {
+		s.mu.Lock()
+		s.data = snapshot
+		s.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// storeTx is an implementation of the Tx interface over the store.
+type storeTx struct {
+	store *Store
+}
+
+func (t storeTx) Users() domain.UserRepository {
+	return t.store.Users()
+}
+
+func (t storeTx) Notes() domain.NoteRepository {
+	return t.store.Notes()
+}
+
+func (t storeTx) Outbox() domain.OutboxRepository {
+	return t.store.Outbox()
+}
+
+// lock locks the store unless the context is done.
+func (s *Store) lock(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	return nil
+}
+
+// nextID returns the next ID of the table, like a serial column. The store must be locked.
+func (d *data) nextID(table string) int64 {
+	d.lastID[table]++
+	return d.lastID[table]
+}
+
+// nextSeq returns the next note change sequence. The store must be locked.
+func (d *data) nextSeq() int64 {
+	d.lastSeq++
+	return d.lastSeq
+}
+
+// clone copies the tables. Rows are copied by value, their slices are never changed in place.
+func (d *data) clone() data {
+	recoveryCodes := make(map[int][]recoveryCode, len(d.recoveryCodes))
+	for id, codes := range d.recoveryCodes {
+		recoveryCodes[id] = slices.Clone(codes)
+	}
+	return data{
+		lastID:        maps.Clone(d.lastID),
+		lastSeq:       d.lastSeq,
+		users:         maps.Clone(d.users),
+		identities:    maps.Clone(d.identities),
+		recoveryCodes: recoveryCodes,
+		loginAttempts: slices.Clone(d.loginAttempts),
+		sessions:      maps.Clone(d.sessions),
+		notes:         maps.Clone(d.notes),
+		shares:        maps.Clone(d.shares),
+		tombstones:    maps.Clone(d.tombstones),
+		workspaces:    maps.Clone(d.workspaces),
+		members:       maps.Clone(d.members),
+		invitations:   maps.Clone(d.invitations),
+		publicLinks:   maps.Clone(d.publicLinks),
+		webhooks:      maps.Clone(d.webhooks),
+		deliveries:    maps.Clone(d.deliveries),
+		outbox:        maps.Clone(d.outbox),
+	}
+}
+
+var (
+	_ domain.UserRepository         = (*UserRepository)(nil)
+	_ domain.LoginAttemptRepository = (*LoginAttemptRepository)(nil)
+	_ domain.SessionRepository      = (*SessionRepository)(nil)
+	_ domain.NoteRepository         = (*NoteRepository)(nil)
+	_ domain.WorkspaceRepository    = (*WorkspaceRepository)(nil)
+	_ domain.PublicLinkRepository   = (*PublicLinkRepository)(nil)
+	_ domain.WebhookRepository      = (*WebhookRepository)(nil)
+	_ domain.OutboxRepository       = (*OutboxRepository)(nil)
+	_ domain.UnitOfWork             = (*Store)(nil)
+)
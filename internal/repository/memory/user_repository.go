@@ -0,0 +1,327 @@
+package memory
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/ananikitina/notes-rest/internal/models"
+)
+
+// ErrDuplicate is returned when a row violates a unique constraint, e.g. a taken email.
+var ErrDuplicate = errors.New("duplicate key value")
+
+// UserRepository is an in-memory implementation of the UserRepository interface.
+type UserRepository struct {
+	store *Store
+}
+
+// CreateUser adds a new user with the default profile and sets its ID. The password must already be hashed.
+func (u *UserRepository) CreateUser(ctx context.Context, user *models.User) error {
+	if err := u.store.lock(ctx); err != nil {
+		return err
+	}
+	defer u.store.mu.Unlock()
+	d := &u.store.data
+
+	for _, existing := range d.users {
+		if existing.Email == user.Email {
+			return ErrDuplicate
+		}
+	}
+	user.ID = int(d.nextID("users"))
+	d.users[user.ID] = models.User{
+		ID:                  user.ID,
+		Email:               user.Email,
+		Password:            user.Password,
+		Role:                user.Role,
+		EmailVerified:       user.EmailVerified,
+		Locale:              "en",
+		Timezone:            "UTC",
+		SpellcheckEnabled:   true,
+		SpellcheckLanguages: "ru,en",
+	}
+	return nil
+}
+
+// GetUserByEmail retrieves a user by their email.
+func (u *UserRepository) GetUserByEmail(ctx context.Context, email string) (*models.User, error) {
+	return u.findUser(ctx, func(user *models.User) bool { return user.Email == email })
+}
+
+// GetUserByID retrieves a user by their ID.
+func (u *UserRepository) GetUserByID(ctx context.Context, id int) (*models.User, error) {
+	return u.findUser(ctx, func(user *models.User) bool { return user.ID == id })
+}
+
+// CountUsers returns the number of users.
+func (u *UserRepository) CountUsers(ctx context.Context) (int, error) {
+	if err := u.store.lock(ctx); err != nil {
+		return 0, err
+	}
+	defer u.store.mu.Unlock()
+	return len(u.store.data.users), nil
+}
+
+// GetUserByIdentity retrieves the user linked to the external identity.
+func (u *UserRepository) GetUserByIdentity(ctx context.Context, provider, subject string) (*models.User, error) {
+	if err := u.store.lock(ctx); err != nil {
+		return nil, err
+	}
+	id, ok := u.store.data.identities[identityKey{provider: provider, subject: subject}]
+	u.store.mu.Unlock()
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return u.GetUserByID(ctx, id)
+}
+
+// LinkIdentity links an external identity to the user.
+func (u *UserRepository) LinkIdentity(ctx context.Context, id int, provider, subject string) error {
+	if err := u.store.lock(ctx); err != nil {
+		return err
+	}
+	defer u.store.mu.Unlock()
+
+	key := identityKey{provider: provider, subject: subject}
+	if _, ok := u.store.data.identities[key]; ok {
+		return ErrDuplicate
+	}
+	u.store.data.identities[key] = id
+	return nil
+}
+
+// UpdateRole changes the user's role.
+func (u *UserRepository) UpdateRole(ctx context.Context, id int, role string) error {
+	return u.updateUser(ctx, id, func(user *models.User) { user.Role = role })
+}
+
+// UpdateProfile saves the user's profile fields.
+func (u *UserRepository) UpdateProfile(ctx context.Context, user *models.User) error {
+	return u.updateUser(ctx, user.ID, func(stored *models.User) {
+		stored.DisplayName = user.DisplayName
+		stored.Locale = user.Locale
+		stored.Timezone = user.Timezone
+		stored.SpellcheckEnabled = user.SpellcheckEnabled
+		stored.SpellcheckLanguages = user.SpellcheckLanguages
+	})
+}
+
+// UpdatePassword saves the user's new password hash.
+func (u *UserRepository) UpdatePassword(ctx context.Context, id int, passwordHash string) error {
+	return u.updateUser(ctx, id, func(user *models.User) { user.Password = passwordHash })
+}
+
+// SetPendingEmail stores the new email until it is confirmed.
+func (u *UserRepository) SetPendingEmail(ctx context.Context, id int, email string) error {
+	return u.updateUser(ctx, id, func(user *models.User) { user.PendingEmail = email })
+}
+
+// ConfirmPendingEmail replaces the user's email with the confirmed pending one.
+func (u *UserRepository) ConfirmPendingEmail(ctx context.Context, id int) error {
+	return u.updateUser(ctx, id, func(user *models.User) {
+		if user.PendingEmail != "" {
+			user.Email = user.PendingEmail
+			user.PendingEmail = ""
+			user.EmailVerified = true
+		}
+	})
+}
+
+// DeleteUser deletes the user together with their notes, workspaces and login records.
+func (u *UserRepository) DeleteUser(ctx context.Context, id int) error {
+	if err := u.store.lock(ctx); err != nil {
+		return err
+	}
+	defer u.store.mu.Unlock()
+	d := &u.store.data
+
+	user, ok := d.users[id]
+	if !ok {
+		return nil
+	}
+
+	// The user's notes and the notes of the user's workspaces disappear for everyone who could see them
+	for workspaceID, workspace := range d.workspaces {
+		if workspace.OwnerID != id {
+			continue
+		}
+		for _, note := range d.notes {
+			if note.WorkspaceID != nil && *note.WorkspaceID == workspaceID {
+				d.deleteNote(note)
+			}
+		}
+		for key := range d.members {
+			if key.workspaceID == workspaceID {
+				delete(d.members, key)
+			}
+		}
+		for invitationID, invitation := range d.invitations {
+			if invitation.WorkspaceID == workspaceID {
+				delete(d.invitations, invitationID)
+			}
+		}
+		delete(d.workspaces, workspaceID)
+	}
+	for _, note := range d.notes {
+		if note.UserID == id {
+			d.deleteNote(note)
+		}
+	}
+
+	attempts := d.loginAttempts[:0]
+	for _, attempt := range d.loginAttempts {
+		if attempt.Email != strings.ToLower(user.Email) {
+			attempts = append(attempts, attempt)
+		}
+	}
+	d.loginAttempts = attempts
+
+	for key, userID := range d.identities {
+		if userID == id {
+			delete(d.identities, key)
+		}
+	}
+	delete(d.recoveryCodes, id)
+	for sessionID, session := range d.sessions {
+		if session.UserID == id {
+			delete(d.sessions, sessionID)
+		}
+	}
+	for key := range d.members {
+		if key.userID == id {
+			delete(d.members, key)
+		}
+	}
+	for invitationID, invitation := range d.invitations {
+		if invitation.InvitedBy == id {
+			invitation.InvitedBy = 0
+			d.invitations[invitationID] = invitation
+		}
+	}
+	for key := range d.shares {
+		if key.userID == id {
+			delete(d.shares, key)
+		}
+	}
+	for key := range d.tombstones {
+		if key.userID == id {
+			delete(d.tombstones, key)
+		}
+	}
+	for webhookID, webhook := range d.webhooks {
+		if webhook.UserID == id {
+			d.deleteWebhook(webhookID)
+		}
+	}
+	delete(d.users, id)
+	return nil
+}
+
+// SetEmailVerified marks the user's email as verified.
+func (u *UserRepository) SetEmailVerified(ctx context.Context, id int) error {
+	return u.updateUser(ctx, id, func(user *models.User) { user.EmailVerified = true })
+}
+
+// SetVerificationSentAt records when the last verification email was sent.
+func (u *UserRepository) SetVerificationSentAt(ctx context.Context, id int, sentAt time.Time) error {
+	return u.updateUser(ctx, id, func(user *models.User) { user.VerificationSentAt = &sentAt })
+}
+
+// SetTOTPSecret stores a new, not yet enabled TOTP secret for the user.
+func (u *UserRepository) SetTOTPSecret(ctx context.Context, id int, secret string) error {
+	return u.updateUser(ctx, id, func(user *models.User) {
+		user.TOTPSecret = secret
+		user.TOTPEnabled = false
+		user.TOTPLastStep = 0
+	})
+}
+
+// EnableTOTP turns on two-factor authentication and replaces the user's recovery codes.
+func (u *UserRepository) EnableTOTP(ctx context.Context, id int, lastStep int64, recoveryCodeHashes []string) error {
+	err := u.updateUser(ctx, id, func(user *models.User) {
+		user.TOTPEnabled = true
+		user.TOTPLastStep = lastStep
+	})
+	if err != nil {
+		return err
+	}
+
+	u.store.mu.Lock()
+	defer u.store.mu.Unlock()
+	codes := make([]recoveryCode, 0, len(recoveryCodeHashes))
+	for _, hash := range recoveryCodeHashes {
+		codes = append(codes, recoveryCode{hash: hash})
+	}
+	u.store.data.recoveryCodes[id] = codes
+	return nil
+}
+
+// SetTOTPLastStep records the time step of the last accepted TOTP code if it is later than the
+// recorded one, and reports whether it was.
+func (u *UserRepository) SetTOTPLastStep(ctx context.Context, id int, step int64) (bool, error) {
+	if err := u.store.lock(ctx); err != nil {
+		return false, err
+	}
+	defer u.store.mu.Unlock()
+
+	user, ok := u.store.data.users[id]
+	if !ok || user.TOTPLastStep >= step {
+		return false, nil
+	}
+	user.TOTPLastStep = step
+	u.store.data.users[id] = user
+	return true, nil
+}
+
+// UseRecoveryCode marks an unused recovery code as used and reports whether it was found.
+func (u *UserRepository) UseRecoveryCode(ctx context.Context, id int, codeHash string) (bool, error) {
+	if err := u.store.lock(ctx); err != nil {
+		return false, err
+	}
+	defer u.store.mu.Unlock()
+
+	codes := u.store.data.recoveryCodes[id]
+	for i, code := range codes {
+		if code.hash == codeHash && !code.used {
+			codes = append([]recoveryCode(nil), codes...)
+			codes[i].used = true
+			u.store.data.recoveryCodes[id] = codes
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// findUser retrieves the first user matching the filter.
+func (u *UserRepository) findUser(ctx context.Context, filter func(user *models.User) bool) (*models.User, error) {
+	if err := u.store.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer u.store.mu.Unlock()
+
+	for _, user := range u.store.data.users {
+		if filter(&user) {
+			return &user, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+// updateUser applies the change to the stored user, if the user exists.
+func (u *UserRepository) updateUser(ctx context.Context, id int, change func(user *models.User)) error {
+	if err := u.store.lock(ctx); err != nil {
+		return err
+	}
+	defer u.store.mu.Unlock()
+
+	user, ok := u.store.data.users[id]
+	if !ok {
+		return nil
+	}
+	change(&user)
+	u.store.data.users[id] = user
+	return nil
+}
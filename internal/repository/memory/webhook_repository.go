@@ -0,0 +1,195 @@
+package memory
+
+import (
+	"context"
+	"database/sql"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/ananikitina/notes-rest/internal/models"
+)
+
+// WebhookRepository is an in-memory implementation of the WebhookRepository interface.
+type WebhookRepository struct {
+	store *Store
+}
+
+// Create adds a new webhook and sets its ID.
+func (w *WebhookRepository) Create(ctx context.Context, webhook *models.Webhook) error {
+	w.store.mu.Lock()
+	defer w.store.mu.Unlock()
+	d := &w.store.data
+
+	webhook.ID = int(d.nextID("webhooks"))
+	stored := *webhook
+	stored.Events = slices.Clone(webhook.Events)
+	d.webhooks[webhook.ID] = stored
+	return nil
+}
+
+// ListByUser retrieves the user's webhooks without their secrets.
+func (w *WebhookRepository) ListByUser(ctx context.Context, userID int) ([]models.Webhook, error) {
+	w.store.mu.Lock()
+	defer w.store.mu.Unlock()
+
+	webhooks := []models.Webhook{}
+	for _, webhook := range w.store.data.webhooks {
+		if webhook.UserID == userID {
+			webhook.Secret = ""
+			webhooks = append(webhooks, webhook)
+		}
+	}
+	sort.Slice(webhooks, func(i, j int) bool { return webhooks[i].ID < webhooks[j].ID })
+	return webhooks, nil
+}
+
+// Delete removes the user's webhook with its deliveries and reports whether it existed.
+func (w *WebhookRepository) Delete(ctx context.Context, id, userID int) (bool, error) {
+	w.store.mu.Lock()
+	defer w.store.mu.Unlock()
+	d := &w.store.data
+
+	webhook, ok := d.webhooks[id]
+	if !ok || webhook.UserID != userID {
+		return false, nil
+	}
+	d.deleteWebhook(id)
+	return true, nil
+}
+
+// Exists reports whether the user has the webhook.
+func (w *WebhookRepository) Exists(ctx context.Context, id, userID int) (bool, error) {
+	w.store.mu.Lock()
+	defer w.store.mu.Unlock()
+
+	webhook, ok := w.store.data.webhooks[id]
+	return ok && webhook.UserID == userID, nil
+}
+
+// Enqueue queues a delivery of the event to every webhook subscribed to it that belongs to one of
+// the recipients or is global.
+func (w *WebhookRepository) Enqueue(ctx context.Context, eventType string, payload []byte, recipients []int) error {
+	w.store.mu.Lock()
+	defer w.store.mu.Unlock()
+	d := &w.store.data
+
+	ids := make([]int, 0, len(d.webhooks))
+	for id := range d.webhooks {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	for _, id := range ids {
+		webhook := d.webhooks[id]
+		if slices.Contains(webhook.Events, eventType) && (webhook.Global || slices.Contains(recipients, webhook.UserID)) {
+			d.addDelivery(id, eventType, slices.Clone(payload))
+		}
+	}
+	return nil
+}
+
+// ListDeliveries retrieves the latest deliveries of the webhook.
+func (w *WebhookRepository) ListDeliveries(ctx context.Context, webhookID, limit int) ([]models.WebhookDelivery, error) {
+	w.store.mu.Lock()
+	defer w.store.mu.Unlock()
+
+	deliveries := []models.WebhookDelivery{}
+	for _, delivery := range w.store.data.deliveries {
+		if delivery.WebhookID == webhookID {
+			deliveries = append(deliveries, delivery)
+		}
+	}
+	sort.Slice(deliveries, func(i, j int) bool { return deliveries[i].ID > deliveries[j].ID })
+	if len(deliveries) > limit {
+		deliveries = deliveries[:limit]
+	}
+	return deliveries, nil
+}
+
+// Redeliver queues a new delivery with the payload of an earlier delivery of the webhook and sets its fields.
+func (w *WebhookRepository) Redeliver(ctx context.Context, deliveryID int64, webhookID int, delivery *models.WebhookDelivery) error {
+	w.store.mu.Lock()
+	defer w.store.mu.Unlock()
+	d := &w.store.data
+
+	earlier, ok := d.deliveries[deliveryID]
+	if !ok || earlier.WebhookID != webhookID {
+		return sql.ErrNoRows
+	}
+	*delivery = d.addDelivery(webhookID, earlier.EventType, earlier.Payload)
+	return nil
+}
+
+// ClaimDue retrieves up to limit pending deliveries that are due, with their webhooks. The deliveries
+// are postponed by lease, so they are retried if the worker stops before recording the attempt.
+func (w *WebhookRepository) ClaimDue(ctx context.Context, limit int, lease time.Duration) ([]models.WebhookDelivery, error) {
+	w.store.mu.Lock()
+	defer w.store.mu.Unlock()
+	d := &w.store.data
+
+	now := time.Now()
+	var deliveries []models.WebhookDelivery
+	for _, delivery := range d.deliveries {
+		if delivery.Status == models.DeliveryPending && !delivery.NextAttemptAt.After(now) {
+			deliveries = append(deliveries, delivery)
+		}
+	}
+	sort.Slice(deliveries, func(i, j int) bool { return deliveries[i].NextAttemptAt.Before(deliveries[j].NextAttemptAt) })
+	if len(deliveries) > limit {
+		deliveries = deliveries[:limit]
+	}
+	for i := range deliveries {
+		deliveries[i].NextAttemptAt = now.Add(lease)
+		d.deliveries[deliveries[i].ID] = deliveries[i]
+
+		webhook := d.webhooks[deliveries[i].WebhookID]
+		deliveries[i].Webhook = &models.Webhook{ID: webhook.ID, UserID: webhook.UserID, URL: webhook.URL, Secret: webhook.Secret}
+	}
+	return deliveries, nil
+}
+
+// RecordAttempt saves the outcome of a delivery attempt: its status, attempt count, next attempt time,
+// response code and error.
+func (w *WebhookRepository) RecordAttempt(ctx context.Context, delivery *models.WebhookDelivery) error {
+	w.store.mu.Lock()
+	defer w.store.mu.Unlock()
+
+	stored, ok := w.store.data.deliveries[delivery.ID]
+	if !ok {
+		return nil
+	}
+	stored.Status = delivery.Status
+	stored.Attempts = delivery.Attempts
+	stored.NextAttemptAt = delivery.NextAttemptAt
+	stored.LastAttemptAt = delivery.LastAttemptAt
+	stored.ResponseCode = delivery.ResponseCode
+	stored.Error = delivery.Error
+	w.store.data.deliveries[delivery.ID] = stored
+	return nil
+}
+
+// addDelivery queues a pending delivery to the webhook.
+func (d *data) addDelivery(webhookID int, eventType string, payload []byte) models.WebhookDelivery {
+	now := time.Now()
+	delivery := models.WebhookDelivery{
+		ID:            d.nextID("webhook_deliveries"),
+		WebhookID:     webhookID,
+		EventType:     eventType,
+		Payload:       payload,
+		Status:        models.DeliveryPending,
+		NextAttemptAt: now,
+		CreatedAt:     now,
+	}
+	d.deliveries[delivery.ID] = delivery
+	return delivery
+}
+
+// deleteWebhook removes the webhook with its deliveries.
+func (d *data) deleteWebhook(id int) {
+	for deliveryID, delivery := range d.deliveries {
+		if delivery.WebhookID == id {
+			delete(d.deliveries, deliveryID)
+		}
+	}
+	delete(d.webhooks, id)
+}
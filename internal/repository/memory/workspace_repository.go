@@ -0,0 +1,181 @@
+package memory
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+	"time"
+
+	"github.com/ananikitina/notes-rest/internal/models"
+)
+
+// WorkspaceRepository is an in-memory implementation of the WorkspaceRepository interface.
+type WorkspaceRepository struct {
+	store *Store
+}
+
+// Create adds a new workspace with its owner as the first member and sets its ID.
+func (w *WorkspaceRepository) Create(ctx context.Context, workspace *models.Workspace) error {
+	if err := w.store.lock(ctx); err != nil {
+		return err
+	}
+	defer w.store.mu.Unlock()
+	d := &w.store.data
+
+	workspace.ID = int(d.nextID("workspaces"))
+	d.workspaces[workspace.ID] = models.Workspace{ID: workspace.ID, Name: workspace.Name, OwnerID: workspace.OwnerID,
+		CreatedAt: workspace.CreatedAt}
+	d.members[userWorkspaceKey{workspaceID: workspace.ID, userID: workspace.OwnerID}] = models.WorkspaceMember{
+		WorkspaceID: workspace.ID, UserID: workspace.OwnerID, Role: models.WorkspaceOwner, CreatedAt: workspace.CreatedAt}
+	return nil
+}
+
+// ListByUser retrieves the workspaces the user is a member of, with the user's role.
+func (w *WorkspaceRepository) ListByUser(ctx context.Context, userID int) ([]models.Workspace, error) {
+	if err := w.store.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer w.store.mu.Unlock()
+	d := &w.store.data
+
+	workspaces := []models.Workspace{}
+	for key, member := range d.members {
+		if key.userID == userID {
+			workspace := d.workspaces[key.workspaceID]
+			workspace.Role = member.Role
+			workspaces = append(workspaces, workspace)
+		}
+	}
+	sort.Slice(workspaces, func(i, j int) bool {
+		if workspaces[i].Name != workspaces[j].Name {
+			return workspaces[i].Name < workspaces[j].Name
+		}
+		return workspaces[i].ID < workspaces[j].ID
+	})
+	return workspaces, nil
+}
+
+// GetMember retrieves the user's membership in the workspace.
+func (w *WorkspaceRepository) GetMember(ctx context.Context, workspaceID, userID int) (*models.WorkspaceMember, error) {
+	if err := w.store.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer w.store.mu.Unlock()
+
+	member, ok := w.store.data.members[userWorkspaceKey{workspaceID: workspaceID, userID: userID}]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return &member, nil
+}
+
+// ListMembers retrieves the members of the workspace with their emails.
+func (w *WorkspaceRepository) ListMembers(ctx context.Context, workspaceID int) ([]models.WorkspaceMember, error) {
+	if err := w.store.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer w.store.mu.Unlock()
+	d := &w.store.data
+
+	members := []models.WorkspaceMember{}
+	for key, member := range d.members {
+		if key.workspaceID == workspaceID {
+			member.Email = d.users[member.UserID].Email
+			members = append(members, member)
+		}
+	}
+	sort.Slice(members, func(i, j int) bool {
+		if !members[i].CreatedAt.Equal(members[j].CreatedAt) {
+			return members[i].CreatedAt.Before(members[j].CreatedAt)
+		}
+		return members[i].UserID < members[j].UserID
+	})
+	return members, nil
+}
+
+// RemoveMember removes the user from the workspace and reports whether the user was a member.
+// The owner can't be removed.
+func (w *WorkspaceRepository) RemoveMember(ctx context.Context, workspaceID, userID int) (bool, error) {
+	if err := w.store.lock(ctx); err != nil {
+		return false, err
+	}
+	defer w.store.mu.Unlock()
+	d := &w.store.data
+
+	key := userWorkspaceKey{workspaceID: workspaceID, userID: userID}
+	member, ok := d.members[key]
+	if !ok || member.Role == models.WorkspaceOwner {
+		return false, nil
+	}
+	delete(d.members, key)
+	for _, note := range d.notes {
+		if note.WorkspaceID != nil && *note.WorkspaceID == workspaceID {
+			d.hideNote(note.ID, userID)
+		}
+	}
+	return true, nil
+}
+
+// CreateInvitation stores an invitation with the hash of its token and sets its ID.
+func (w *WorkspaceRepository) CreateInvitation(ctx context.Context, invitation *models.WorkspaceInvitation, tokenHash string) error {
+	if err := w.store.lock(ctx); err != nil {
+		return err
+	}
+	defer w.store.mu.Unlock()
+	d := &w.store.data
+
+	for _, existing := range d.invitations {
+		if existing.tokenHash == tokenHash {
+			return ErrDuplicate
+		}
+	}
+	invitation.ID = int(d.nextID("workspace_invitations"))
+	d.invitations[invitation.ID] = invitationRow{WorkspaceInvitation: *invitation, tokenHash: tokenHash}
+	return nil
+}
+
+// GetPendingInvitation retrieves an invitation by token hash that is neither accepted nor expired.
+func (w *WorkspaceRepository) GetPendingInvitation(ctx context.Context, tokenHash string) (*models.WorkspaceInvitation, error) {
+	if err := w.store.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer w.store.mu.Unlock()
+
+	for _, invitation := range w.store.data.invitations {
+		if invitation.tokenHash == tokenHash && invitation.AcceptedAt == nil && invitation.ExpiresAt.After(time.Now()) {
+			return &invitation.WorkspaceInvitation, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+// AcceptInvitation marks the invitation as accepted and adds the user to the workspace.
+// An existing membership keeps its role.
+func (w *WorkspaceRepository) AcceptInvitation(ctx context.Context, invitation *models.WorkspaceInvitation, userID int, at time.Time) error {
+	if err := w.store.lock(ctx); err != nil {
+		return err
+	}
+	defer w.store.mu.Unlock()
+	d := &w.store.data
+
+	stored, ok := d.invitations[invitation.ID]
+	if !ok || stored.AcceptedAt != nil {
+		// Accepted concurrently
+		return sql.ErrNoRows
+	}
+	stored.AcceptedAt = &at
+	d.invitations[invitation.ID] = stored
+
+	key := userWorkspaceKey{workspaceID: invitation.WorkspaceID, userID: userID}
+	if _, ok := d.members[key]; ok {
+		return nil
+	}
+	d.members[key] = models.WorkspaceMember{WorkspaceID: invitation.WorkspaceID, UserID: userID, Role: invitation.Role,
+		CreatedAt: at}
+	for _, note := range d.notes {
+		if note.WorkspaceID != nil && *note.WorkspaceID == invitation.WorkspaceID {
+			d.revealNote(note.ID, userID)
+		}
+	}
+	return nil
+}
@@ -1,37 +1,189 @@
 package repository
 
 import (
+	"context"
 	"database/sql"
+	"errors"
+	"fmt"
+	"time"
 
 	"github.com/ananikitina/notes-rest/internal/domain"
 	"github.com/ananikitina/notes-rest/internal/models"
 )
 
+const noteColumns = "id, content, user_id, workspace_id, created_at, updated_at, change_seq"
+
+// tombstoneSQL records tombstones for everyone who can see the notes n matching the condition,
+// so syncing clients learn that the notes are gone.
+const tombstoneSQL = `
+	INSERT INTO note_tombstones (note_id, user_id)
+	SELECT n.id, n.user_id FROM notes n WHERE %[1]s
+	UNION
+	SELECT n.id, m.user_id FROM notes n JOIN workspace_members m ON m.workspace_id = n.workspace_id WHERE %[1]s
+	UNION
+	SELECT n.id, s.user_id FROM notes n JOIN note_shares s ON s.note_id = n.id WHERE %[1]s
+	ON CONFLICT (note_id, user_id) DO UPDATE SET change_seq = nextval('note_change_seq'), deleted_at = NOW();
+`
+
+// lockChangesSQL serializes the transactions that assign change sequences of notes and tombstones.
+// The lock is held until the transaction ends, so sequences become visible in order and a client that
+// synced up to a sequence never misses a lower one committed later. Transactions take it before any
+// row lock, so they don't wait for it while holding rows another change needs.
+const lockChangesSQL = "SELECT pg_advisory_xact_lock(hashtext('note_change_seq'))"
+
+// lockChanges takes the change sequence lock in the transaction, see lockChangesSQL.
+func lockChanges(ctx context.Context, tx dbtx) error {
+	_, err := tx.ExecContext(ctx, lockChangesSQL)
+	return err
+}
+
+// inChangeTx runs fn like inTx, holding the change sequence lock.
+func inChangeTx(ctx context.Context, db dbtx, fn func(tx dbtx) error) error {
+	return inTx(ctx, db, func(tx dbtx) error {
+		if err := lockChanges(ctx, tx); err != nil {
+			return err
+		}
+		return fn(tx)
+	})
+}
+
+// visibleCondition matches the notes n the user in the parameter can see. Authors only see
+// their notes of a workspace while they are its members.
+const visibleCondition = `((n.user_id = $%d AND n.workspace_id IS NULL)
+	OR n.workspace_id IN (SELECT workspace_id FROM workspace_members WHERE user_id = $%[1]d)
+	OR n.id IN (SELECT note_id FROM note_shares WHERE user_id = $%[1]d))`
+
+// searchCondition matches notes by the full-text query in the parameter; an empty query matches all notes.
+const searchCondition = "($%d = '' OR to_tsvector('simple', content) @@ plainto_tsquery('simple', $%[1]d))"
+
 // noteRepository is an implementation of the NoteRepository interface.
 type noteRepository struct {
-	DB *sql.DB
+	DB      dbtx
+	Timeout time.Duration
 }
 
-// NewNoteRepository creates a new note repository with the given database connection.
-func NewNoteRepository(DB *sql.DB) domain.NoteRepository {
-	return &noteRepository{DB: DB}
+// NewNoteRepository creates a new note repository with the given database connection and query timeout.
+func NewNoteRepository(DB *sql.DB, timeout time.Duration) domain.NoteRepository {
+	return &noteRepository{DB: DB, Timeout: timeout}
 }
 
-// AddNote inserts a new note into the database.
-func (n *noteRepository) Add(note models.Note) error {
-	_, err := n.DB.Exec(`
-	INSERT INTO notes (content, user_id)  
-	VALUES ($1,$2)
-	RETURNING id;
-`, note.Content, note.UserID)
-	return err
+// AddNote inserts a new note into the database and sets its ID, creation time and change sequence.
+func (n *noteRepository) Add(ctx context.Context, note *models.Note) error {
+	ctx, cancel := withTimeout(ctx, n.Timeout)
+	defer cancel()
+
+	return inChangeTx(ctx, n.DB, func(tx dbtx) error {
+		return tx.QueryRowContext(ctx, `
+		INSERT INTO notes (content, user_id, workspace_id)  
+		VALUES ($1,$2,$3)
+		RETURNING id, created_at, change_seq;
+	`, note.Content, note.UserID, note.WorkspaceID).Scan(&note.ID, &note.CreatedAt, &note.ChangeSeq)
+	})
+}
+
+// GetByID retrieves a note by its ID.
+func (n *noteRepository) GetByID(ctx context.Context, id int) (*models.Note, error) {
+	notes, err := n.getNotes(ctx, "SELECT "+noteColumns+" FROM notes WHERE id = $1", id)
+	if err != nil {
+		return nil, err
+	}
+	if len(notes) == 0 {
+		return nil, sql.ErrNoRows
+	}
+	return &notes[0], nil
+}
+
+// Update saves the content of the note and sets its new change sequence. With a non-zero baseSeq
+// the note is only saved if it hasn't changed since, otherwise false is returned.
+func (n *noteRepository) Update(ctx context.Context, note *models.Note, baseSeq int64) (bool, error) {
+	ctx, cancel := withTimeout(ctx, n.Timeout)
+	defer cancel()
+
+	err := inChangeTx(ctx, n.DB, func(tx dbtx) error {
+		return tx.QueryRowContext(ctx, `
+		UPDATE notes SET content = $1, updated_at = $2, change_seq = nextval('note_change_seq')
+		WHERE id = $3 AND ($4 = 0 OR change_seq = $4)
+		RETURNING change_seq;
+	`, note.Content, note.UpdatedAt, note.ID, baseSeq).Scan(&note.ChangeSeq)
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Delete removes the note with its shares and public links, leaving tombstones for sync. With a non-zero
+// baseSeq the note is only deleted if it hasn't changed since, otherwise false is returned.
+func (n *noteRepository) Delete(ctx context.Context, id int, baseSeq int64) (bool, error) {
+	ctx, cancel := withTimeout(ctx, n.Timeout)
+	defer cancel()
+
+	deleted := false
+	err := inChangeTx(ctx, n.DB, func(tx dbtx) error {
+		var changeSeq int64
+		if err := tx.QueryRowContext(ctx, "SELECT change_seq FROM notes WHERE id = $1 FOR UPDATE", id).Scan(&changeSeq); err != nil {
+			return err
+		}
+		if baseSeq != 0 && changeSeq != baseSeq {
+			return nil
+		}
+
+		if _, err := tx.ExecContext(ctx, fmt.Sprintf(tombstoneSQL, "n.id = $1"), id); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, "DELETE FROM notes WHERE id = $1", id); err != nil {
+			return err
+		}
+		deleted = true
+		return nil
+	})
+	return deleted, err
+}
+
+// GetNotesByUserID retrieves private notes from specified user.
+func (n *noteRepository) GetByUserID(ctx context.Context, userID int, query string) ([]models.Note, error) {
+	return n.getNotes(ctx,
+		"SELECT "+noteColumns+" FROM notes WHERE user_id = $1 AND workspace_id IS NULL AND "+
+			fmt.Sprintf(searchCondition, 2)+" ORDER BY created_at;",
+		userID, query)
 }
 
-// GetNotesByUserID retrieves notes from specified user.
-func (n *noteRepository) GetByUserID(userID int) ([]models.Note, error) {
-	rows, err := n.DB.Query(
-		"SELECT id, content, user_id, created_at FROM notes WHERE user_id = $1;",
+// GetByWorkspaceID retrieves notes of the workspace.
+func (n *noteRepository) GetByWorkspaceID(ctx context.Context, workspaceID int, query string) ([]models.Note, error) {
+	return n.getNotes(ctx,
+		"SELECT "+noteColumns+" FROM notes WHERE workspace_id = $1 AND "+
+			fmt.Sprintf(searchCondition, 2)+" ORDER BY created_at;",
+		workspaceID, query)
+}
+
+// GetOwnedByUser retrieves the user's notes in any workspace and all notes of the workspaces the user owns.
+func (n *noteRepository) GetOwnedByUser(ctx context.Context, userID int) ([]models.Note, error) {
+	return n.getNotes(ctx,
+		"SELECT "+noteColumns+" FROM notes WHERE user_id = $1 OR workspace_id IN (SELECT id FROM workspaces WHERE owner_id = $1) ORDER BY created_at;",
 		userID)
+}
+
+// GetAllNotes retrieves all notes (admin access).
+func (n *noteRepository) GetAllNotes(ctx context.Context) ([]models.Note, error) {
+	return n.getNotes(ctx, "SELECT "+noteColumns+" FROM notes")
+}
+
+// CountNotes returns the number of notes.
+func (n *noteRepository) CountNotes(ctx context.Context) (int, error) {
+	ctx, cancel := withTimeout(ctx, n.Timeout)
+	defer cancel()
+
+	var count int
+	err := n.DB.QueryRowContext(ctx, "SELECT COUNT(*) FROM notes").Scan(&count)
+	return count, err
+}
+
+// getNotes runs a query selecting noteColumns and scans the rows.
+func (n *noteRepository) getNotes(ctx context.Context, query string, args ...interface{}) ([]models.Note, error) {
+	ctx, cancel := withTimeout(ctx, n.Timeout)
+	defer cancel()
+
+	rows, err := n.DB.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -40,7 +192,7 @@ func (n *noteRepository) GetByUserID(userID int) ([]models.Note, error) {
 	var notes []models.Note
 	for rows.Next() {
 		var note models.Note
-		if err := rows.Scan(&note.ID, &note.Content, &note.UserID, &note.CreatedAt); err != nil {
+		if err := scanNote(rows, &note); err != nil {
 			return nil, err
 		}
 		notes = append(notes, note)
@@ -48,21 +200,198 @@ func (n *noteRepository) GetByUserID(userID int) ([]models.Note, error) {
 	return notes, rows.Err()
 }
 
-// GetAllNotes retrieves all notes (admin access).
-func (n *noteRepository) GetAllNotes() ([]models.Note, error) {
-	rows, err := n.DB.Query("SELECT id, content, user_id, created_at FROM notes")
+// SaveShare shares the note with the user or changes the permission of an existing share.
+func (n *noteRepository) SaveShare(ctx context.Context, share *models.NoteShare) error {
+	ctx, cancel := withTimeout(ctx, n.Timeout)
+	defer cancel()
+
+	return inChangeTx(ctx, n.DB, func(tx dbtx) error {
+		err := tx.QueryRowContext(ctx, `
+		INSERT INTO note_shares (note_id, user_id, permission, created_at)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (note_id, user_id) DO UPDATE SET permission = EXCLUDED.permission
+		RETURNING created_at;
+	`, share.NoteID, share.UserID, share.Permission, share.CreatedAt).Scan(&share.CreatedAt)
+		if err != nil {
+			return err
+		}
+		return revealNotes(ctx, tx, "n.id = $1", share.UserID, share.NoteID)
+	})
+}
+
+// GetShare retrieves the share of the note with the user.
+func (n *noteRepository) GetShare(ctx context.Context, noteID, userID int) (*models.NoteShare, error) {
+	ctx, cancel := withTimeout(ctx, n.Timeout)
+	defer cancel()
+
+	var share models.NoteShare
+	err := n.DB.QueryRowContext(ctx,
+		"SELECT note_id, user_id, permission, created_at FROM note_shares WHERE note_id = $1 AND user_id = $2",
+		noteID, userID).Scan(&share.NoteID, &share.UserID, &share.Permission, &share.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &share, nil
+}
+
+// ListShares retrieves the shares of the note with the emails of the users.
+func (n *noteRepository) ListShares(ctx context.Context, noteID int) ([]models.NoteShare, error) {
+	ctx, cancel := withTimeout(ctx, n.Timeout)
+	defer cancel()
+
+	rows, err := n.DB.QueryContext(ctx, `
+	SELECT s.note_id, s.user_id, u.email, s.permission, s.created_at
+	FROM note_shares s JOIN users u ON u.id = s.user_id
+	WHERE s.note_id = $1
+	ORDER BY s.created_at;
+`, noteID)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var notes []models.Note
+	shares := []models.NoteShare{}
 	for rows.Next() {
-		var note models.Note
-		if err := rows.Scan(&note.ID, &note.Content, &note.UserID, &note.CreatedAt); err != nil {
+		var share models.NoteShare
+		if err := rows.Scan(&share.NoteID, &share.UserID, &share.Email, &share.Permission, &share.CreatedAt); err != nil {
+			return nil, err
+		}
+		shares = append(shares, share)
+	}
+	return shares, rows.Err()
+}
+
+// RemoveShare revokes the share of the note with the user and reports whether it existed.
+func (n *noteRepository) RemoveShare(ctx context.Context, noteID, userID int) (bool, error) {
+	ctx, cancel := withTimeout(ctx, n.Timeout)
+	defer cancel()
+
+	removed := false
+	err := inChangeTx(ctx, n.DB, func(tx dbtx) error {
+		res, err := tx.ExecContext(ctx, "DELETE FROM note_shares WHERE note_id = $1 AND user_id = $2", noteID, userID)
+		if err != nil {
+			return err
+		}
+		if count, err := res.RowsAffected(); err != nil || count == 0 {
+			return err
+		}
+		removed = true
+		return hideNotes(ctx, tx, "n.id = $1", userID, noteID)
+	})
+	return removed, err
+}
+
+// GetSharedWith retrieves the notes shared with the user, with the user's permission.
+func (n *noteRepository) GetSharedWith(ctx context.Context, userID int) ([]models.SharedNote, error) {
+	ctx, cancel := withTimeout(ctx, n.Timeout)
+	defer cancel()
+
+	rows, err := n.DB.QueryContext(ctx, `
+	SELECT n.id, n.content, n.user_id, n.workspace_id, n.created_at, n.updated_at, n.change_seq, s.permission
+	FROM notes n JOIN note_shares s ON s.note_id = n.id
+	WHERE s.user_id = $1
+	ORDER BY n.created_at;
+`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	notes := []models.SharedNote{}
+	for rows.Next() {
+		var note models.SharedNote
+		if err := scanNote(rows, &note.Note, &note.Permission); err != nil {
 			return nil, err
 		}
 		notes = append(notes, note)
 	}
-	return notes, nil
+	return notes, rows.Err()
+}
+
+// GetChanges retrieves up to limit changes of the notes the user can see after the since sequence,
+// in sequence order: the notes created or changed since, and the tombstones of notes that were
+// deleted or became invisible to the user.
+func (n *noteRepository) GetChanges(ctx context.Context, userID int, since int64, limit int) ([]models.NoteChange, error) {
+	ctx, cancel := withTimeout(ctx, n.Timeout)
+	defer cancel()
+
+	rows, err := n.DB.QueryContext(ctx, `
+	SELECT n.id, n.content, n.user_id, n.workspace_id, n.created_at, n.updated_at, n.change_seq, 'upsert'
+	FROM notes n
+	WHERE n.change_seq > $2 AND `+fmt.Sprintf(visibleCondition, 1)+`
+	UNION ALL
+	SELECT t.note_id, '', 0, NULL, t.deleted_at, NULL, t.change_seq, 'delete'
+	FROM note_tombstones t
+	WHERE t.user_id = $1 AND t.change_seq > $2
+	ORDER BY 7
+	LIMIT $3;
+`, userID, since, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	changes := []models.NoteChange{}
+	for rows.Next() {
+		var change models.NoteChange
+		var note models.Note
+		if err := scanNote(rows, &note, &change.Type); err != nil {
+			return nil, err
+		}
+		change.NoteID = note.ID
+		change.Seq = note.ChangeSeq
+		if change.Type == models.ChangeDelete {
+			change.DeletedAt = &note.CreatedAt
+		} else {
+			change.Note = &note
+		}
+		changes = append(changes, change)
+	}
+	return changes, rows.Err()
+}
+
+// revealNotes makes the notes n matching the condition, which the user can now see, show up in the user's
+// next sync: their change sequence is advanced and the user's tombstones for them are removed.
+func revealNotes(ctx context.Context, tx dbtx, condition string, userID int, args ...interface{}) error {
+	args = append(args, userID)
+	userParam := len(args)
+	_, err := tx.ExecContext(ctx, fmt.Sprintf(
+		"DELETE FROM note_tombstones WHERE user_id = $%d AND note_id IN (SELECT n.id FROM notes n WHERE %s)",
+		userParam, condition), args...)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, "UPDATE notes n SET change_seq = nextval('note_change_seq') WHERE "+condition, args[:userParam-1]...)
+	return err
+}
+
+// hideNotes records the user's tombstones for the notes n matching the condition that the user can no longer see.
+func hideNotes(ctx context.Context, tx dbtx, condition string, userID int, args ...interface{}) error {
+	args = append(args, userID)
+	userParam := len(args)
+	_, err := tx.ExecContext(ctx, fmt.Sprintf(`
+	INSERT INTO note_tombstones (note_id, user_id)
+	SELECT n.id, $%d FROM notes n
+	WHERE (%s) AND NOT %s
+	ON CONFLICT (note_id, user_id) DO UPDATE SET change_seq = nextval('note_change_seq'), deleted_at = NOW();
+`, userParam, condition, fmt.Sprintf(visibleCondition, userParam)), args...)
+	return err
+}
+
+// scanNote scans noteColumns followed by the extra destinations.
+func scanNote(rows *sql.Rows, note *models.Note, extra ...interface{}) error {
+	var workspaceID sql.NullInt64
+	var updatedAt sql.NullTime
+	dest := append([]interface{}{&note.ID, &note.Content, &note.UserID, &workspaceID, &note.CreatedAt, &updatedAt, &note.ChangeSeq}, extra...)
+	if err := rows.Scan(dest...); err != nil {
+		return err
+	}
+	if workspaceID.Valid {
+		id := int(workspaceID.Int64)
+		note.WorkspaceID = &id
+	}
+	if updatedAt.Valid {
+		note.UpdatedAt = &updatedAt.Time
+	}
+	return nil
 }
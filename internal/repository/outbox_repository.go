@@ -0,0 +1,61 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/ananikitina/notes-rest/internal/models"
+)
+
+// outboxRepository is an implementation of the OutboxRepository interface.
+type outboxRepository struct {
+	DB dbtx
+}
+
+// Add stores the event and sets its ID and creation time.
+func (o *outboxRepository) Add(ctx context.Context, event *models.DomainEvent) error {
+	payload, err := json.Marshal(event.Event)
+	if err != nil {
+		return err
+	}
+	var data interface{}
+	if event.Data != nil {
+		data = string(event.Data)
+	}
+	return o.DB.QueryRowContext(ctx,
+		"INSERT INTO outbox (event, recipients, data) VALUES ($1, $2, $3) RETURNING id, created_at",
+		string(payload), event.Recipients, data).Scan(&event.ID, &event.CreatedAt)
+}
+
+// FetchPending retrieves up to limit events in the order they were added, locking them until the
+// transaction ends. Events locked by other transactions are skipped.
+func (o *outboxRepository) FetchPending(ctx context.Context, limit int) ([]models.DomainEvent, error) {
+	rows, err := o.DB.QueryContext(ctx,
+		"SELECT id, event, recipients, data, created_at FROM outbox ORDER BY id LIMIT $1 FOR UPDATE SKIP LOCKED",
+		limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []models.DomainEvent
+	for rows.Next() {
+		var event models.DomainEvent
+		var payload, data []byte
+		if err := rows.Scan(&event.ID, &payload, arrayScanner(&event.Recipients), &data, &event.CreatedAt); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(payload, &event.Event); err != nil {
+			return nil, err
+		}
+		event.Data = data
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
+
+// Delete removes the relayed events.
+func (o *outboxRepository) Delete(ctx context.Context, ids []int64) error {
+	_, err := o.DB.ExecContext(ctx, "DELETE FROM outbox WHERE id = ANY($1)", ids)
+	return err
+}
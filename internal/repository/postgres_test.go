@@ -0,0 +1,45 @@
+package repository_test
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/ananikitina/notes-rest/internal/config"
+	"github.com/ananikitina/notes-rest/internal/database"
+	"github.com/ananikitina/notes-rest/internal/repository"
+	"github.com/ananikitina/notes-rest/internal/repository/repotest"
+)
+
+// TestRepositories runs against the database at TEST_POSTGRES_URL, which it wipes, and is skipped without it.
+func TestRepositories(t *testing.T) {
+	url := os.Getenv("TEST_POSTGRES_URL")
+	if url == "" {
+		t.Skip("TEST_POSTGRES_URL is not set")
+	}
+
+	repotest.Run(t, func(t *testing.T) repotest.Repositories {
+		db, err := database.OpenPostgres(url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { db.Close() })
+		if _, err := db.Exec("DROP SCHEMA public CASCADE; CREATE SCHEMA public"); err != nil {
+			t.Fatal(err)
+		}
+		if err := database.Migrate(db, config.StorageDriverPostgres); err != nil {
+			t.Fatal(err)
+		}
+
+		return repotest.Repositories{
+			Users:         repository.NewUserRepository(db, time.Minute),
+			LoginAttempts: repository.NewLoginAttemptRepository(db, time.Minute),
+			Sessions:      repository.NewSessionRepository(db, time.Minute),
+			Notes:         repository.NewNoteRepository(db, time.Minute),
+			Workspaces:    repository.NewWorkspaceRepository(db, time.Minute),
+			PublicLinks:   repository.NewPublicLinkRepository(db, time.Minute),
+			Webhooks:      repository.NewWebhookRepository(db, time.Minute),
+			UnitOfWork:    repository.NewUnitOfWork(db, time.Minute),
+		}
+	})
+}
@@ -0,0 +1,121 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/ananikitina/notes-rest/internal/models"
+)
+
+const publicLinkColumns = "id, note_id, password_hash, expires_at, created_at, view_count, last_viewed_at"
+
+// PublicLinkRepository handles public links to notes.
+type PublicLinkRepository struct {
+	DB      *sql.DB
+	Timeout time.Duration
+}
+
+// NewPublicLinkRepository creates a new public link repository with the given database connection and query timeout.
+func NewPublicLinkRepository(DB *sql.DB, timeout time.Duration) *PublicLinkRepository {
+	return &PublicLinkRepository{DB: DB, Timeout: timeout}
+}
+
+// Create stores a link with the hash of its token and sets its ID.
+func (p *PublicLinkRepository) Create(ctx context.Context, link *models.PublicLink, tokenHash string) error {
+	ctx, cancel := withTimeout(ctx, p.Timeout)
+	defer cancel()
+
+	var passwordHash sql.NullString
+	if link.PasswordHash != "" {
+		passwordHash = sql.NullString{String: link.PasswordHash, Valid: true}
+	}
+	return p.DB.QueryRowContext(ctx, `
+	INSERT INTO public_links (note_id, token_hash, password_hash, expires_at, created_at)
+	VALUES ($1, $2, $3, $4, $5)
+	RETURNING id;
+`, link.NoteID, tokenHash, passwordHash, link.ExpiresAt, link.CreatedAt).Scan(&link.ID)
+}
+
+// GetActiveByToken retrieves a link by token hash that hasn't expired.
+func (p *PublicLinkRepository) GetActiveByToken(ctx context.Context, tokenHash string) (*models.PublicLink, error) {
+	ctx, cancel := withTimeout(ctx, p.Timeout)
+	defer cancel()
+
+	rows, err := p.DB.QueryContext(ctx,
+		"SELECT "+publicLinkColumns+" FROM public_links WHERE token_hash = $1 AND (expires_at IS NULL OR expires_at > NOW())",
+		tokenHash)
+	if err != nil {
+		return nil, err
+	}
+	links, err := scanPublicLinks(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(links) == 0 {
+		return nil, sql.ErrNoRows
+	}
+	return &links[0], nil
+}
+
+// ListByNote retrieves the links of the note.
+func (p *PublicLinkRepository) ListByNote(ctx context.Context, noteID int) ([]models.PublicLink, error) {
+	ctx, cancel := withTimeout(ctx, p.Timeout)
+	defer cancel()
+
+	rows, err := p.DB.QueryContext(ctx,
+		"SELECT "+publicLinkColumns+" FROM public_links WHERE note_id = $1 ORDER BY created_at", noteID)
+	if err != nil {
+		return nil, err
+	}
+	return scanPublicLinks(rows)
+}
+
+// Delete removes the link of the note and reports whether it existed.
+func (p *PublicLinkRepository) Delete(ctx context.Context, id, noteID int) (bool, error) {
+	ctx, cancel := withTimeout(ctx, p.Timeout)
+	defer cancel()
+
+	res, err := p.DB.ExecContext(ctx, "DELETE FROM public_links WHERE id = $1 AND note_id = $2", id, noteID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// RecordView increments the view count of the link.
+func (p *PublicLinkRepository) RecordView(ctx context.Context, id int, at time.Time) error {
+	ctx, cancel := withTimeout(ctx, p.Timeout)
+	defer cancel()
+
+	_, err := p.DB.ExecContext(ctx,
+		"UPDATE public_links SET view_count = view_count + 1, last_viewed_at = $1 WHERE id = $2", at, id)
+	return err
+}
+
+// scanPublicLinks scans rows of publicLinkColumns and closes them.
+func scanPublicLinks(rows *sql.Rows) ([]models.PublicLink, error) {
+	defer rows.Close()
+
+	links := []models.PublicLink{}
+	for rows.Next() {
+		var link models.PublicLink
+		var passwordHash sql.NullString
+		var expiresAt, lastViewedAt sql.NullTime
+		if err := rows.Scan(&link.ID, &link.NoteID, &passwordHash, &expiresAt, &link.CreatedAt,
+			&link.ViewCount, &lastViewedAt); err != nil {
+			return nil, err
+		}
+		link.PasswordHash = passwordHash.String
+		link.HasPassword = passwordHash.Valid
+		if expiresAt.Valid {
+			link.ExpiresAt = &expiresAt.Time
+		}
+		if lastViewedAt.Valid {
+			link.LastViewedAt = &lastViewedAt.Time
+		}
+		links = append(links, link)
+	}
+	return links, rows.Err()
+}
@@ -0,0 +1,922 @@
+// Package repotest is a test suite for implementations of the repository interfaces. Every storage
+// backend runs it, so they all behave the same way behind the use cases.
+package repotest
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/ananikitina/notes-rest/internal/domain"
+	"github.com/ananikitina/notes-rest/internal/models"
+)
+
+// Repositories are the repositories of one backend, all over the same storage.
+type Repositories struct {
+	Users         domain.UserRepository
+	LoginAttempts domain.LoginAttemptRepository
+	Sessions      domain.SessionRepository
+	Notes         domain.NoteRepository
+	Workspaces    domain.WorkspaceRepository
+	PublicLinks   domain.PublicLinkRepository
+	Webhooks      domain.WebhookRepository
+	UnitOfWork    domain.UnitOfWork
+}
+
+// Run runs the suite. open must return repositories over new, empty storage on every call.
+func Run(t *testing.T, open func(t *testing.T) Repositories) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, r Repositories)
+	}{
+		{"Users", testUsers},
+		{"TwoFactor", testTwoFactor},
+		{"Notes", testNotes},
+		{"Search", testSearch},
+		{"SharesAndChanges", testSharesAndChanges},
+		{"ChangesCommitInOrder", testChangesCommitInOrder},
+		{"Workspaces", testWorkspaces},
+		{"DeleteUser", testDeleteUser},
+		{"Sessions", testSessions},
+		{"LoginAttempts", testLoginAttempts},
+		{"PublicLinks", testPublicLinks},
+		{"Webhooks", testWebhooks},
+		{"UnitOfWork", testUnitOfWork},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.fn(t, open(t))
+		})
+	}
+}
+
+func testUsers(t *testing.T, r Repositories) {
+	ctx := context.Background()
+	user := createUser(t, r, "alice@example.com")
+
+	got, err := r.Users.GetUserByEmail(ctx, "alice@example.com")
+	check(t, err)
+	if got.ID != user.ID || got.Password != "hash" || got.Role != "user" || got.EmailVerified {
+		t.Fatalf("GetUserByEmail() = %+v", got)
+	}
+	if got.Locale == "" || got.Timezone == "" || !got.SpellcheckEnabled {
+		t.Errorf("profile defaults aren't set: %+v", got)
+	}
+	if err := r.Users.CreateUser(ctx, &models.User{Email: "alice@example.com", Password: "hash", Role: "user"}); err == nil {
+		t.Error("CreateUser() with a taken email succeeded")
+	}
+	if _, err := r.Users.GetUserByEmail(ctx, "nobody@example.com"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("GetUserByEmail() of a missing user error = %v, want sql.ErrNoRows", err)
+	}
+	createUser(t, r, "bob@example.com")
+	if count, err := r.Users.CountUsers(ctx); err != nil || count != 2 {
+		t.Errorf("CountUsers() = %d, %v, want 2", count, err)
+	}
+
+	got.DisplayName, got.Locale, got.Timezone = "Alice", "ru", "Europe/Moscow"
+	got.SpellcheckEnabled, got.SpellcheckLanguages = false, "ru"
+	check(t, r.Users.UpdateProfile(ctx, got))
+	check(t, r.Users.UpdatePassword(ctx, user.ID, "new hash"))
+	check(t, r.Users.UpdateRole(ctx, user.ID, "admin"))
+	sentAt := time.Now()
+	check(t, r.Users.SetVerificationSentAt(ctx, user.ID, sentAt))
+	check(t, r.Users.SetPendingEmail(ctx, user.ID, "alice@example.org"))
+	check(t, r.Users.ConfirmPendingEmail(ctx, user.ID))
+
+	got, err = r.Users.GetUserByID(ctx, user.ID)
+	check(t, err)
+	if got.DisplayName != "Alice" || got.Locale != "ru" || got.Timezone != "Europe/Moscow" ||
+		got.SpellcheckEnabled || got.SpellcheckLanguages != "ru" {
+		t.Errorf("profile = %+v", got)
+	}
+	if got.Password != "new hash" || got.Role != "admin" {
+		t.Errorf("password, role = %q, %q", got.Password, got.Role)
+	}
+	if got.Email != "alice@example.org" || got.PendingEmail != "" || !got.EmailVerified {
+		t.Errorf("email, pending email, verified = %q, %q, %v", got.Email, got.PendingEmail, got.EmailVerified)
+	}
+	if got.VerificationSentAt == nil || !sameTime(*got.VerificationSentAt, sentAt) {
+		t.Errorf("VerificationSentAt = %v, want %v", got.VerificationSentAt, sentAt)
+	}
+
+	check(t, r.Users.LinkIdentity(ctx, user.ID, "google", "subject"))
+	got, err = r.Users.GetUserByIdentity(ctx, "google", "subject")
+	check(t, err)
+	if got.ID != user.ID {
+		t.Errorf("GetUserByIdentity() = user %d, want %d", got.ID, user.ID)
+	}
+	if _, err := r.Users.GetUserByIdentity(ctx, "github", "subject"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("GetUserByIdentity() of an unknown identity error = %v, want sql.ErrNoRows", err)
+	}
+}
+
+func testTwoFactor(t *testing.T, r Repositories) {
+	ctx := context.Background()
+	user := createUser(t, r, "alice@example.com")
+
+	check(t, r.Users.SetTOTPSecret(ctx, user.ID, "secret"))
+	check(t, r.Users.EnableTOTP(ctx, user.ID, 10, []string{"code 1", "code 2"}))
+	for _, tc := range []struct {
+		step int64
+		want bool
+	}{{10, false}, {11, true}, {11, false}} {
+		if ok, err := r.Users.SetTOTPLastStep(ctx, user.ID, tc.step); err != nil || ok != tc.want {
+			t.Errorf("SetTOTPLastStep(%d) = %v, %v, want %v", tc.step, ok, err, tc.want)
+		}
+	}
+	got, err := r.Users.GetUserByID(ctx, user.ID)
+	check(t, err)
+	if got.TOTPSecret != "secret" || !got.TOTPEnabled || got.TOTPLastStep != 11 {
+		t.Errorf("TOTP secret, enabled, last step = %q, %v, %d", got.TOTPSecret, got.TOTPEnabled, got.TOTPLastStep)
+	}
+
+	for _, tc := range []struct {
+		code string
+		want bool
+	}{{"code 1", true}, {"code 1", false}, {"code 3", false}, {"code 2", true}} {
+		used, err := r.Users.UseRecoveryCode(ctx, user.ID, tc.code)
+		check(t, err)
+		if used != tc.want {
+			t.Errorf("UseRecoveryCode(%q) = %v, want %v", tc.code, used, tc.want)
+		}
+	}
+
+	// Enabling again replaces the codes
+	check(t, r.Users.EnableTOTP(ctx, user.ID, 12, []string{"code 4"}))
+	if used, err := r.Users.UseRecoveryCode(ctx, user.ID, "code 4"); err != nil || !used {
+		t.Errorf("UseRecoveryCode() of a new code = %v, %v", used, err)
+	}
+}
+
+func testNotes(t *testing.T, r Repositories) {
+	ctx := context.Background()
+	user := createUser(t, r, "alice@example.com")
+	note := addNote(t, r, user.ID, nil, "first")
+	if note.ID == 0 || note.ChangeSeq == 0 || note.CreatedAt.IsZero() {
+		t.Fatalf("Add() = %+v", note)
+	}
+
+	got, err := r.Notes.GetByID(ctx, note.ID)
+	check(t, err)
+	if got.Content != "first" || got.UserID != user.ID || got.WorkspaceID != nil || got.ChangeSeq != note.ChangeSeq {
+		t.Errorf("GetByID() = %+v", got)
+	}
+
+	baseSeq := note.ChangeSeq
+	updatedAt := time.Now()
+	note.Content, note.UpdatedAt = "second", &updatedAt
+	if ok, err := r.Notes.Update(ctx, note, baseSeq+100); err != nil || ok {
+		t.Errorf("Update() with a stale base = %v, %v, want false", ok, err)
+	}
+	if ok, err := r.Notes.Update(ctx, note, baseSeq); err != nil || !ok {
+		t.Fatalf("Update() = %v, %v, want true", ok, err)
+	}
+	if note.ChangeSeq <= baseSeq {
+		t.Errorf("ChangeSeq after Update() = %d, want more than %d", note.ChangeSeq, baseSeq)
+	}
+	got, err = r.Notes.GetByID(ctx, note.ID)
+	check(t, err)
+	if got.Content != "second" || got.UpdatedAt == nil || !sameTime(*got.UpdatedAt, updatedAt) {
+		t.Errorf("GetByID() after Update() = %+v", got)
+	}
+
+	other := addNote(t, r, user.ID, nil, "other")
+	all, err := r.Notes.GetAllNotes(ctx)
+	check(t, err)
+	if ids := noteIDs(all); !reflect.DeepEqual(ids, []int{note.ID, other.ID}) {
+		t.Errorf("GetAllNotes() = %v, want %v", ids, []int{note.ID, other.ID})
+	}
+
+	if ok, err := r.Notes.Delete(ctx, note.ID, baseSeq); err != nil || ok {
+		t.Errorf("Delete() with a stale base = %v, %v, want false", ok, err)
+	}
+	if ok, err := r.Notes.Delete(ctx, note.ID, note.ChangeSeq); err != nil || !ok {
+		t.Errorf("Delete() = %v, %v, want true", ok, err)
+	}
+	if _, err := r.Notes.GetByID(ctx, note.ID); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("GetByID() of a deleted note error = %v, want sql.ErrNoRows", err)
+	}
+	if count, err := r.Notes.CountNotes(ctx); err != nil || count != 1 {
+		t.Errorf("CountNotes() after Delete() = %d, %v, want 1", count, err)
+	}
+	if _, err := r.Notes.Delete(ctx, note.ID, 0); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("Delete() of a deleted note error = %v, want sql.ErrNoRows", err)
+	}
+}
+
+func testSearch(t *testing.T, r Repositories) {
+	ctx := context.Background()
+	user := createUser(t, r, "alice@example.com")
+	shopping := addNote(t, r, user.ID, nil, "Shopping list for Monday: milk, bread")
+	meeting := addNote(t, r, user.ID, nil, "Meeting notes")
+	russian := addNote(t, r, user.ID, nil, "купить молоко")
+
+	for _, tc := range []struct {
+		query string
+		want  []int
+	}{
+		{"", []int{shopping.ID, meeting.ID, russian.ID}},
+		{"shopping", []int{shopping.ID}},
+		{"MONDAY milk", []int{shopping.ID}},
+		{"shopping meeting", nil},
+		{"молоко", []int{russian.ID}},
+		{"!!!", nil},
+	} {
+		notes, err := r.Notes.GetByUserID(ctx, user.ID, tc.query)
+		check(t, err)
+		if ids := noteIDs(notes); !reflect.DeepEqual(ids, tc.want) {
+			t.Errorf("GetByUserID(%q) = %v, want %v", tc.query, ids, tc.want)
+		}
+	}
+}
+
+func testSharesAndChanges(t *testing.T, r Repositories) {
+	ctx := context.Background()
+	alice := createUser(t, r, "alice@example.com")
+	bob := createUser(t, r, "bob@example.com")
+	note := addNote(t, r, alice.ID, nil, "shared")
+
+	if changes := getChanges(t, r, bob.ID, 0); len(changes) != 0 {
+		t.Fatalf("GetChanges() before sharing = %+v", changes)
+	}
+
+	sharedAt := time.Now()
+	share := &models.NoteShare{NoteID: note.ID, UserID: bob.ID, Permission: models.NotePermissionViewer, CreatedAt: sharedAt}
+	check(t, r.Notes.SaveShare(ctx, share))
+	share.Permission, share.CreatedAt = models.NotePermissionEditor, sharedAt.Add(time.Minute)
+	check(t, r.Notes.SaveShare(ctx, share))
+	if !sameTime(share.CreatedAt, sharedAt) {
+		t.Errorf("CreatedAt after changing the permission = %v, want %v", share.CreatedAt, sharedAt)
+	}
+
+	got, err := r.Notes.GetShare(ctx, note.ID, bob.ID)
+	check(t, err)
+	if got.Permission != models.NotePermissionEditor {
+		t.Errorf("GetShare() permission = %q, want %q", got.Permission, models.NotePermissionEditor)
+	}
+	shares, err := r.Notes.ListShares(ctx, note.ID)
+	check(t, err)
+	if len(shares) != 1 || shares[0].Email != "bob@example.com" {
+		t.Errorf("ListShares() = %+v", shares)
+	}
+	shared, err := r.Notes.GetSharedWith(ctx, bob.ID)
+	check(t, err)
+	if len(shared) != 1 || shared[0].ID != note.ID || shared[0].Permission != models.NotePermissionEditor {
+		t.Errorf("GetSharedWith() = %+v", shared)
+	}
+
+	changes := getChanges(t, r, bob.ID, 0)
+	if len(changes) != 1 || changes[0].Type != models.ChangeUpsert || changes[0].Note == nil ||
+		changes[0].Note.Content != "shared" {
+		t.Fatalf("GetChanges() after sharing = %+v", changes)
+	}
+	since := changes[0].Seq
+
+	if ok, err := r.Notes.RemoveShare(ctx, note.ID, bob.ID); err != nil || !ok {
+		t.Errorf("RemoveShare() = %v, %v, want true", ok, err)
+	}
+	if ok, err := r.Notes.RemoveShare(ctx, note.ID, bob.ID); err != nil || ok {
+		t.Errorf("RemoveShare() of a removed share = %v, %v, want false", ok, err)
+	}
+	changes = getChanges(t, r, bob.ID, since)
+	if len(changes) != 1 || changes[0].Type != models.ChangeDelete || changes[0].NoteID != note.ID ||
+		changes[0].DeletedAt == nil {
+		t.Fatalf("GetChanges() after unsharing = %+v", changes)
+	}
+
+	// Sharing again brings the note back
+	check(t, r.Notes.SaveShare(ctx, &models.NoteShare{NoteID: note.ID, UserID: bob.ID, Permission: models.NotePermissionViewer,
+		CreatedAt: time.Now()}))
+	changes = getChanges(t, r, bob.ID, 0)
+	if len(changes) != 1 || changes[0].Type != models.ChangeUpsert {
+		t.Errorf("GetChanges() after sharing again = %+v", changes)
+	}
+
+	other := addNote(t, r, alice.ID, nil, "other")
+	_, err = r.Notes.Delete(ctx, note.ID, 0)
+	check(t, err)
+	changes = getChanges(t, r, alice.ID, 0)
+	if len(changes) != 2 || changes[0].Type != models.ChangeUpsert || changes[0].NoteID != other.ID ||
+		changes[1].Type != models.ChangeDelete || changes[1].NoteID != note.ID || changes[0].Seq >= changes[1].Seq {
+		t.Errorf("GetChanges() after deleting = %+v", changes)
+	}
+	page, err := r.Notes.GetChanges(ctx, alice.ID, 0, 1)
+	check(t, err)
+	if len(page) != 1 || page[0].NoteID != other.ID {
+		t.Errorf("GetChanges() with limit 1 = %+v", page)
+	}
+	if changes := getChanges(t, r, bob.ID, 0); len(changes) != 1 || changes[0].Type != models.ChangeDelete {
+		t.Errorf("GetChanges() of the share recipient after deleting = %+v", changes)
+	}
+}
+
+// testChangesCommitInOrder checks that a change committed after another doesn't get a lower sequence,
+// so a client that synced in between doesn't skip it.
+func testChangesCommitInOrder(t *testing.T, r Repositories) {
+	ctx := context.Background()
+	alice := createUser(t, r, "alice@example.com")
+	first := addNote(t, r, alice.ID, nil, "first")
+	second := addNote(t, r, alice.ID, nil, "second")
+	since := maxSeq(getChanges(t, r, alice.ID, 0))
+
+	// The first note is changed in a transaction that stays open until the client synced
+	updated, commit := make(chan struct{}), make(chan struct{})
+	txDone := make(chan error, 1)
+	go func() {
+		txDone <- r.UnitOfWork.Do(ctx, func(tx domain.Tx) error {
+			now := time.Now()
+			if _, err := tx.Notes().Update(ctx, &models.Note{ID: first.ID, Content: "first changed", UpdatedAt: &now}, 0); err != nil {
+				return err
+			}
+			close(updated)
+			<-commit
+			return nil
+		})
+	}()
+	select {
+	case <-updated:
+	case err := <-txDone:
+		t.Fatalf("UnitOfWork.Do() = %v", err)
+	}
+
+	// The second note is changed meanwhile, the change either waits for the transaction or commits first
+	otherDone := make(chan error, 1)
+	go func() {
+		now := time.Now()
+		_, err := r.Notes.Update(ctx, &models.Note{ID: second.ID, Content: "second changed", UpdatedAt: &now}, 0)
+		otherDone <- err
+	}()
+	select {
+	case err := <-otherDone:
+		otherDone <- err
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	synced := getChanges(t, r, alice.ID, since)
+	close(commit)
+	check(t, <-txDone)
+	check(t, <-otherDone)
+
+	seen := map[int]bool{}
+	for _, change := range append(synced, getChanges(t, r, alice.ID, max(since, maxSeq(synced)))...) {
+		seen[change.NoteID] = true
+	}
+	if !seen[first.ID] || !seen[second.ID] {
+		t.Errorf("changes synced while a change was being committed = %+v, the client missed a change", synced)
+	}
+}
+
+func testWorkspaces(t *testing.T, r Repositories) {
+	ctx := context.Background()
+	alice := createUser(t, r, "alice@example.com")
+	bob := createUser(t, r, "bob@example.com")
+
+	workspace := &models.Workspace{Name: "Team", OwnerID: alice.ID, CreatedAt: time.Now()}
+	check(t, r.Workspaces.Create(ctx, workspace))
+	list, err := r.Workspaces.ListByUser(ctx, alice.ID)
+	check(t, err)
+	if len(list) != 1 || list[0].ID != workspace.ID || list[0].Role != models.WorkspaceOwner {
+		t.Fatalf("ListByUser() = %+v", list)
+	}
+	note := addNote(t, r, alice.ID, &workspace.ID, "team note")
+
+	now := time.Now()
+	expired := &models.WorkspaceInvitation{WorkspaceID: workspace.ID, Email: "bob@example.com",
+		Role: models.WorkspaceViewer, InvitedBy: alice.ID, CreatedAt: now.Add(-2 * time.Hour), ExpiresAt: now.Add(-time.Hour)}
+	check(t, r.Workspaces.CreateInvitation(ctx, expired, "expired token"))
+	if _, err := r.Workspaces.GetPendingInvitation(ctx, "expired token"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("GetPendingInvitation() of an expired invitation error = %v, want sql.ErrNoRows", err)
+	}
+	invitation := &models.WorkspaceInvitation{WorkspaceID: workspace.ID, Email: "bob@example.com",
+		Role: models.WorkspaceViewer, InvitedBy: alice.ID, CreatedAt: now, ExpiresAt: now.Add(time.Hour)}
+	check(t, r.Workspaces.CreateInvitation(ctx, invitation, "token"))
+	if invitation.ID == 0 {
+		t.Error("CreateInvitation() didn't set ID")
+	}
+
+	pending, err := r.Workspaces.GetPendingInvitation(ctx, "token")
+	check(t, err)
+	if pending.ID != invitation.ID || pending.Role != models.WorkspaceViewer || !sameTime(pending.ExpiresAt, invitation.ExpiresAt) {
+		t.Errorf("GetPendingInvitation() = %+v", pending)
+	}
+	check(t, r.Workspaces.AcceptInvitation(ctx, pending, bob.ID, time.Now()))
+	if err := r.Workspaces.AcceptInvitation(ctx, pending, bob.ID, time.Now()); err == nil {
+		t.Error("AcceptInvitation() of an accepted invitation succeeded")
+	}
+	if _, err := r.Workspaces.GetPendingInvitation(ctx, "token"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("GetPendingInvitation() of an accepted invitation error = %v, want sql.ErrNoRows", err)
+	}
+
+	member, err := r.Workspaces.GetMember(ctx, workspace.ID, bob.ID)
+	check(t, err)
+	if member.Role != models.WorkspaceViewer {
+		t.Errorf("GetMember() role = %q, want %q", member.Role, models.WorkspaceViewer)
+	}
+	members, err := r.Workspaces.ListMembers(ctx, workspace.ID)
+	check(t, err)
+	if len(members) != 2 {
+		t.Errorf("ListMembers() = %+v", members)
+	}
+	notes, err := r.Notes.GetByWorkspaceID(ctx, workspace.ID, "")
+	check(t, err)
+	if ids := noteIDs(notes); !reflect.DeepEqual(ids, []int{note.ID}) {
+		t.Errorf("GetByWorkspaceID() = %v, want %v", ids, []int{note.ID})
+	}
+	changes := getChanges(t, r, bob.ID, 0)
+	if len(changes) != 1 || changes[0].Type != models.ChangeUpsert || changes[0].NoteID != note.ID {
+		t.Fatalf("GetChanges() of a member = %+v", changes)
+	}
+	bobsNote := addNote(t, r, bob.ID, &workspace.ID, "bob's team note")
+	since := changes[0].Seq
+
+	if ok, err := r.Workspaces.RemoveMember(ctx, workspace.ID, alice.ID); err != nil || ok {
+		t.Errorf("RemoveMember() of the owner = %v, %v, want false", ok, err)
+	}
+	if ok, err := r.Workspaces.RemoveMember(ctx, workspace.ID, bob.ID); err != nil || !ok {
+		t.Errorf("RemoveMember() = %v, %v, want true", ok, err)
+	}
+	if _, err := r.Workspaces.GetMember(ctx, workspace.ID, bob.ID); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("GetMember() of a removed member error = %v, want sql.ErrNoRows", err)
+	}
+	// The notes bob wrote stay in the workspace and are no longer his
+	deleted := map[int]bool{}
+	for _, change := range getChanges(t, r, bob.ID, since) {
+		if change.Type != models.ChangeDelete {
+			t.Errorf("GetChanges() of a removed member has %+v", change)
+		}
+		deleted[change.NoteID] = true
+	}
+	if !reflect.DeepEqual(deleted, map[int]bool{note.ID: true, bobsNote.ID: true}) {
+		t.Errorf("GetChanges() of a removed member has deletes of %v, want %d and %d", deleted, note.ID, bobsNote.ID)
+	}
+	if notes, err := r.Notes.GetByWorkspaceID(ctx, workspace.ID, ""); err != nil || len(notes) != 2 {
+		t.Errorf("GetByWorkspaceID() after removing a member = %v, %v, want both notes", noteIDs(notes), err)
+	}
+}
+
+func testDeleteUser(t *testing.T, r Repositories) {
+	ctx := context.Background()
+	alice := createUser(t, r, "alice@example.com")
+	bob := createUser(t, r, "bob@example.com")
+
+	private := addNote(t, r, alice.ID, nil, "private")
+	check(t, r.Notes.SaveShare(ctx, &models.NoteShare{NoteID: private.ID, UserID: bob.ID, Permission: models.NotePermissionViewer,
+		CreatedAt: time.Now()}))
+	workspace := &models.Workspace{Name: "Team", OwnerID: alice.ID, CreatedAt: time.Now()}
+	check(t, r.Workspaces.Create(ctx, workspace))
+	invitation := &models.WorkspaceInvitation{WorkspaceID: workspace.ID, Email: "bob@example.com",
+		Role: models.WorkspaceEditor, InvitedBy: alice.ID, CreatedAt: time.Now(), ExpiresAt: time.Now().Add(time.Hour)}
+	check(t, r.Workspaces.CreateInvitation(ctx, invitation, "token"))
+	check(t, r.Workspaces.AcceptInvitation(ctx, invitation, bob.ID, time.Now()))
+	// A note bob wrote in alice's workspace goes away with the workspace
+	team := addNote(t, r, bob.ID, &workspace.ID, "team")
+	createSession(t, r, alice.ID, time.Now().Add(time.Hour))
+	check(t, r.Webhooks.Create(ctx, &models.Webhook{UserID: alice.ID, URL: "https://example.com", Secret: "secret",
+		Events: []string{models.EventNoteCreated}, CreatedAt: time.Now()}))
+	bobsNote := addNote(t, r, bob.ID, nil, "bob's")
+	since := maxSeq(getChanges(t, r, bob.ID, 0))
+
+	owned, err := r.Notes.GetOwnedByUser(ctx, alice.ID)
+	check(t, err)
+	if ids := noteIDs(owned); !reflect.DeepEqual(ids, []int{private.ID, team.ID}) {
+		t.Errorf("GetOwnedByUser() = %v, want %v", ids, []int{private.ID, team.ID})
+	}
+
+	check(t, r.Users.DeleteUser(ctx, alice.ID))
+	if _, err := r.Notes.GetByID(ctx, bobsNote.ID); err != nil {
+		t.Errorf("GetByID() of another user's note error = %v", err)
+	}
+	if _, err := r.Users.GetUserByID(ctx, alice.ID); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("GetUserByID() of a deleted user error = %v, want sql.ErrNoRows", err)
+	}
+	for _, id := range []int{private.ID, team.ID} {
+		if _, err := r.Notes.GetByID(ctx, id); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("GetByID(%d) after deleting the user error = %v, want sql.ErrNoRows", id, err)
+		}
+	}
+	if list, err := r.Workspaces.ListByUser(ctx, bob.ID); err != nil || len(list) != 0 {
+		t.Errorf("ListByUser() of a member = %+v, %v, want none", list, err)
+	}
+	deleted := map[int]bool{}
+	for _, change := range getChanges(t, r, bob.ID, since) {
+		if change.Type == models.ChangeDelete {
+			deleted[change.NoteID] = true
+		}
+	}
+	if !deleted[private.ID] || !deleted[team.ID] {
+		t.Errorf("GetChanges() after deleting the user has deletes of %v, want %d and %d", deleted, private.ID, team.ID)
+	}
+}
+
+func testSessions(t *testing.T, r Repositories) {
+	ctx := context.Background()
+	user := createUser(t, r, "alice@example.com")
+	first := createSession(t, r, user.ID, time.Now().Add(time.Hour))
+	second := createSession(t, r, user.ID, time.Now().Add(time.Hour))
+	third := createSession(t, r, user.ID, time.Now().Add(time.Hour))
+	expired := createSession(t, r, user.ID, time.Now().Add(-time.Hour))
+
+	got, err := r.Sessions.GetActive(ctx, first.ID)
+	check(t, err)
+	if got.UserID != user.ID || got.DeviceLabel != "laptop" || got.IP != "127.0.0.1" || !sameTime(got.ExpiresAt, first.ExpiresAt) {
+		t.Errorf("GetActive() = %+v", got)
+	}
+	if _, err := r.Sessions.GetActive(ctx, expired.ID); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("GetActive() of an expired session error = %v, want sql.ErrNoRows", err)
+	}
+	assertSessions(t, r, user.ID, first.ID, second.ID, third.ID)
+
+	lastSeenAt := time.Now().Add(time.Minute)
+	check(t, r.Sessions.Touch(ctx, first.ID, lastSeenAt))
+	got, err = r.Sessions.GetActive(ctx, first.ID)
+	check(t, err)
+	if !sameTime(got.LastSeenAt, lastSeenAt) {
+		t.Errorf("LastSeenAt after Touch() = %v, want %v", got.LastSeenAt, lastSeenAt)
+	}
+
+	if ok, err := r.Sessions.Revoke(ctx, third.ID, user.ID+1); err != nil || ok {
+		t.Errorf("Revoke() of another user's session = %v, %v, want false", ok, err)
+	}
+	if ok, err := r.Sessions.Revoke(ctx, third.ID, user.ID); err != nil || !ok {
+		t.Errorf("Revoke() = %v, %v, want true", ok, err)
+	}
+	if ok, err := r.Sessions.Revoke(ctx, third.ID, user.ID); err != nil || ok {
+		t.Errorf("Revoke() of a revoked session = %v, %v, want false", ok, err)
+	}
+	assertSessions(t, r, user.ID, first.ID, second.ID)
+
+	check(t, r.Sessions.RevokeOthersByUser(ctx, user.ID, second.ID))
+	assertSessions(t, r, user.ID, second.ID)
+	check(t, r.Sessions.RevokeAllByUser(ctx, user.ID))
+	assertSessions(t, r, user.ID)
+}
+
+func testLoginAttempts(t *testing.T, r Repositories) {
+	ctx := context.Background()
+	start := time.Now().Add(-time.Hour)
+	last := start.Add(30 * time.Minute)
+	for _, attempt := range []models.LoginAttempt{
+		{Email: "alice@example.com", IP: "10.0.0.1", CreatedAt: start},
+		{Email: "alice@example.com", IP: "10.0.0.2", CreatedAt: last},
+		{Email: "alice@example.com", IP: "10.0.0.1", Success: true, CreatedAt: last},
+		{Email: "bob@example.com", IP: "10.0.0.1", CreatedAt: start.Add(10 * time.Minute)},
+		{Email: "bob@example.com", IP: "10.0.0.3", TokenHash: "token", CreatedAt: start},
+		{Email: "bob@example.com", IP: "10.0.0.3", TokenHash: "token", CreatedAt: last},
+	} {
+		check(t, r.LoginAttempts.Add(ctx, attempt))
+	}
+
+	for _, tc := range []struct {
+		name  string
+		count func(since time.Time) (int, time.Time, error)
+		since time.Time
+		want  int
+		at    time.Time // the latest failure by email or token, the earliest by IP
+	}{
+		{"by email", func(since time.Time) (int, time.Time, error) {
+			return r.LoginAttempts.CountFailuresByEmail(ctx, "alice@example.com", since)
+		}, start.Add(-time.Minute), 2, last},
+		{"by email since", func(since time.Time) (int, time.Time, error) {
+			return r.LoginAttempts.CountFailuresByEmail(ctx, "alice@example.com", since)
+		}, start.Add(time.Minute), 1, last},
+		{"by IP", func(since time.Time) (int, time.Time, error) {
+			return r.LoginAttempts.CountFailuresByIP(ctx, "10.0.0.1", since)
+		}, start.Add(-time.Minute), 2, start},
+		{"by token", func(since time.Time) (int, time.Time, error) {
+			return r.LoginAttempts.CountFailuresByToken(ctx, "token", since)
+		}, start.Add(-time.Minute), 2, last},
+		{"unknown", func(since time.Time) (int, time.Time, error) {
+			return r.LoginAttempts.CountFailuresByEmail(ctx, "nobody@example.com", since)
+		}, start.Add(-time.Minute), 0, time.Time{}},
+	} {
+		count, at, err := tc.count(tc.since)
+		check(t, err)
+		if count != tc.want || !sameTime(at, tc.at) {
+			t.Errorf("%s: count, time = %d, %v, want %d, %v", tc.name, count, at, tc.want, tc.at)
+		}
+	}
+
+	check(t, r.LoginAttempts.ClearFailures(ctx, "alice@example.com"))
+	if count, _, err := r.LoginAttempts.CountFailuresByEmail(ctx, "alice@example.com", start.Add(-time.Minute)); err != nil || count != 0 {
+		t.Errorf("CountFailuresByEmail() after ClearFailures() = %d, %v, want 0", count, err)
+	}
+	check(t, r.LoginAttempts.ClearFailures(ctx, "bob@example.com"))
+	if count, _, err := r.LoginAttempts.CountFailuresByToken(ctx, "token", start.Add(-time.Minute)); err != nil || count != 2 {
+		t.Errorf("CountFailuresByToken() after ClearFailures() = %d, %v, want 2", count, err)
+	}
+}
+
+func testPublicLinks(t *testing.T, r Repositories) {
+	ctx := context.Background()
+	user := createUser(t, r, "alice@example.com")
+	note := addNote(t, r, user.ID, nil, "public")
+
+	expiresAt := time.Now().Add(time.Hour)
+	link := &models.PublicLink{NoteID: note.ID, PasswordHash: "hash", ExpiresAt: &expiresAt, CreatedAt: time.Now()}
+	check(t, r.PublicLinks.Create(ctx, link, "token"))
+	past := time.Now().Add(-time.Hour)
+	expired := &models.PublicLink{NoteID: note.ID, ExpiresAt: &past, CreatedAt: time.Now()}
+	check(t, r.PublicLinks.Create(ctx, expired, "expired token"))
+
+	got, err := r.PublicLinks.GetActiveByToken(ctx, "token")
+	check(t, err)
+	if got.ID != link.ID || got.NoteID != note.ID || !got.HasPassword || got.PasswordHash != "hash" ||
+		got.ExpiresAt == nil || !sameTime(*got.ExpiresAt, expiresAt) {
+		t.Errorf("GetActiveByToken() = %+v", got)
+	}
+	if _, err := r.PublicLinks.GetActiveByToken(ctx, "expired token"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("GetActiveByToken() of an expired link error = %v, want sql.ErrNoRows", err)
+	}
+
+	viewedAt := time.Now()
+	check(t, r.PublicLinks.RecordView(ctx, link.ID, viewedAt))
+	links, err := r.PublicLinks.ListByNote(ctx, note.ID)
+	check(t, err)
+	if len(links) != 2 {
+		t.Fatalf("ListByNote() = %+v", links)
+	}
+	for _, l := range links {
+		if l.ID == link.ID && (l.ViewCount != 1 || l.LastViewedAt == nil || !sameTime(*l.LastViewedAt, viewedAt)) {
+			t.Errorf("link after RecordView() = %+v", l)
+		}
+	}
+
+	if ok, err := r.PublicLinks.Delete(ctx, expired.ID, note.ID); err != nil || !ok {
+		t.Errorf("Delete() = %v, %v, want true", ok, err)
+	}
+	if ok, err := r.PublicLinks.Delete(ctx, expired.ID, note.ID); err != nil || ok {
+		t.Errorf("Delete() of a deleted link = %v, %v, want false", ok, err)
+	}
+	_, err = r.Notes.Delete(ctx, note.ID, 0)
+	check(t, err)
+	if _, err := r.PublicLinks.GetActiveByToken(ctx, "token"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("GetActiveByToken() after deleting the note error = %v, want sql.ErrNoRows", err)
+	}
+}
+
+func testWebhooks(t *testing.T, r Repositories) {
+	ctx := context.Background()
+	alice := createUser(t, r, "alice@example.com")
+	admin := createUser(t, r, "admin@example.com")
+	own := &models.Webhook{UserID: alice.ID, URL: "https://example.com/own", Secret: "own secret",
+		Events: []string{models.EventNoteCreated}, CreatedAt: time.Now()}
+	check(t, r.Webhooks.Create(ctx, own))
+	global := &models.Webhook{UserID: admin.ID, URL: "https://example.com/global", Secret: "global secret",
+		Events: []string{models.EventNoteCreated, models.EventNoteDeleted}, Global: true, CreatedAt: time.Now()}
+	check(t, r.Webhooks.Create(ctx, global))
+
+	list, err := r.Webhooks.ListByUser(ctx, alice.ID)
+	check(t, err)
+	if len(list) != 1 || list[0].ID != own.ID || list[0].Secret != "" ||
+		!reflect.DeepEqual(list[0].Events, own.Events) {
+		t.Errorf("ListByUser() = %+v", list)
+	}
+	if ok, err := r.Webhooks.Exists(ctx, own.ID, alice.ID); err != nil || !ok {
+		t.Errorf("Exists() = %v, %v, want true", ok, err)
+	}
+	if ok, err := r.Webhooks.Exists(ctx, own.ID, admin.ID); err != nil || ok {
+		t.Errorf("Exists() for another user = %v, %v, want false", ok, err)
+	}
+
+	check(t, r.Webhooks.Enqueue(ctx, models.EventNoteCreated, []byte(`{"noteId":1}`), []int{alice.ID}))
+	check(t, r.Webhooks.Enqueue(ctx, models.EventNoteDeleted, []byte(`{"noteId":1}`), []int{alice.ID}))
+	check(t, r.Webhooks.Enqueue(ctx, models.EventNoteCreated, []byte(`{"noteId":2}`), nil))
+	assertDeliveries(t, r, own.ID, 1)
+	assertDeliveries(t, r, global.ID, 3)
+
+	claimed, err := r.Webhooks.ClaimDue(ctx, 10, time.Minute)
+	check(t, err)
+	if len(claimed) != 4 {
+		t.Fatalf("ClaimDue() = %d deliveries, want 4", len(claimed))
+	}
+	for _, delivery := range claimed {
+		if delivery.Webhook == nil || delivery.Webhook.ID != delivery.WebhookID || delivery.Webhook.Secret == "" {
+			t.Errorf("claimed delivery webhook = %+v", delivery.Webhook)
+		}
+		if delivery.Status != models.DeliveryPending || !delivery.NextAttemptAt.After(time.Now()) {
+			t.Errorf("claimed delivery status, next attempt = %q, %v", delivery.Status, delivery.NextAttemptAt)
+		}
+	}
+	if again, err := r.Webhooks.ClaimDue(ctx, 10, time.Minute); err != nil || len(again) != 0 {
+		t.Errorf("ClaimDue() of claimed deliveries = %d, %v, want none", len(again), err)
+	}
+
+	delivery := claimed[0]
+	code := 500
+	lastAttemptAt := time.Now()
+	delivery.Attempts, delivery.NextAttemptAt, delivery.LastAttemptAt = 1, lastAttemptAt.Add(-time.Second), &lastAttemptAt
+	delivery.ResponseCode, delivery.Error = &code, "status 500"
+	check(t, r.Webhooks.RecordAttempt(ctx, &delivery))
+	retried, err := r.Webhooks.ClaimDue(ctx, 10, time.Minute)
+	check(t, err)
+	if len(retried) != 1 || retried[0].ID != delivery.ID || retried[0].Attempts != 1 ||
+		retried[0].ResponseCode == nil || *retried[0].ResponseCode != 500 || retried[0].Error != "status 500" {
+		t.Fatalf("ClaimDue() after a failed attempt = %+v", retried)
+	}
+	delivery.Status = models.DeliverySucceeded
+	check(t, r.Webhooks.RecordAttempt(ctx, &delivery))
+
+	var redelivered models.WebhookDelivery
+	check(t, r.Webhooks.Redeliver(ctx, delivery.ID, delivery.WebhookID, &redelivered))
+	if redelivered.ID == delivery.ID || redelivered.Status != models.DeliveryPending || redelivered.Attempts != 0 ||
+		redelivered.EventType != delivery.EventType || !jsonEqual(redelivered.Payload, delivery.Payload) {
+		t.Errorf("Redeliver() = %+v, from %+v", redelivered, delivery)
+	}
+	if err := r.Webhooks.Redeliver(ctx, delivery.ID, delivery.WebhookID+100, &redelivered); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("Redeliver() of another webhook's delivery error = %v, want sql.ErrNoRows", err)
+	}
+
+	if ok, err := r.Webhooks.Delete(ctx, own.ID, alice.ID); err != nil || !ok {
+		t.Errorf("Delete() = %v, %v, want true", ok, err)
+	}
+	if ok, err := r.Webhooks.Delete(ctx, own.ID, alice.ID); err != nil || ok {
+		t.Errorf("Delete() of a deleted webhook = %v, %v, want false", ok, err)
+	}
+	assertDeliveries(t, r, own.ID, 0)
+}
+
+func testUnitOfWork(t *testing.T, r Repositories) {
+	ctx := context.Background()
+	user := createUser(t, r, "alice@example.com")
+
+	var note models.Note
+	err := r.UnitOfWork.Do(ctx, func(tx domain.Tx) error {
+		note = models.Note{Content: "committed", UserID: user.ID}
+		if err := tx.Notes().Add(ctx, &note); err != nil {
+			return err
+		}
+		return tx.Outbox().Add(ctx, &models.DomainEvent{
+			Event:      models.Event{Type: models.EventNoteCreated, NoteID: note.ID, ActorID: user.ID, At: time.Now()},
+			Recipients: []int{user.ID},
+			Data:       json.RawMessage(`{"content":"committed"}`),
+		})
+	})
+	check(t, err)
+
+	errRollback := errors.New("rollback")
+	err = r.UnitOfWork.Do(ctx, func(tx domain.Tx) error {
+		rolledBack := models.Note{Content: "rolled back", UserID: user.ID}
+		if err := tx.Notes().Add(ctx, &rolledBack); err != nil {
+			return err
+		}
+		if err := tx.Outbox().Add(ctx, &models.DomainEvent{Event: models.Event{Type: models.EventNoteCreated,
+			NoteID: rolledBack.ID}}); err != nil {
+			return err
+		}
+		return errRollback
+	})
+	if !errors.Is(err, errRollback) {
+		t.Fatalf("Do() error = %v, want %v", err, errRollback)
+	}
+	err = r.UnitOfWork.Do(ctx, func(tx domain.Tx) error {
+		if err := tx.Users().DeleteUser(ctx, user.ID); err != nil {
+			return err
+		}
+		return errRollback
+	})
+	if !errors.Is(err, errRollback) {
+		t.Fatalf("Do() error = %v, want %v", err, errRollback)
+	}
+	if _, err := r.Users.GetUserByID(ctx, user.ID); err != nil {
+		t.Errorf("GetUserByID() after a rolled back deletion error = %v", err)
+	}
+
+	notes, err := r.Notes.GetByUserID(ctx, user.ID, "")
+	check(t, err)
+	if ids := noteIDs(notes); !reflect.DeepEqual(ids, []int{note.ID}) {
+		t.Errorf("notes after the units of work = %v, want %v", ids, []int{note.ID})
+	}
+
+	var events []models.DomainEvent
+	check(t, r.UnitOfWork.Do(ctx, func(tx domain.Tx) error {
+		var err error
+		events, err = tx.Outbox().FetchPending(ctx, 10)
+		return err
+	}))
+	if len(events) != 1 {
+		t.Fatalf("FetchPending() = %+v, want 1 event", events)
+	}
+	event := events[0]
+	if event.ID == 0 || event.Event.Type != models.EventNoteCreated || event.Event.NoteID != note.ID ||
+		!reflect.DeepEqual(event.Recipients, []int{user.ID}) || !jsonEqual(event.Data, []byte(`{"content":"committed"}`)) {
+		t.Errorf("FetchPending() = %+v", event)
+	}
+
+	check(t, r.UnitOfWork.Do(ctx, func(tx domain.Tx) error {
+		if err := tx.Outbox().Delete(ctx, []int64{event.ID}); err != nil {
+			return err
+		}
+		events, err = tx.Outbox().FetchPending(ctx, 10)
+		return err
+	}))
+	if len(events) != 0 {
+		t.Errorf("FetchPending() after Delete() = %+v, want none", events)
+	}
+}
+
+func createUser(t *testing.T, r Repositories, email string) *models.User {
+	t.Helper()
+	user := &models.User{Email: email, Password: "hash", Role: "user"}
+	check(t, r.Users.CreateUser(context.Background(), user))
+	if user.ID == 0 {
+		t.Fatal("CreateUser() didn't set ID")
+	}
+	return user
+}
+
+func addNote(t *testing.T, r Repositories, userID int, workspaceID *int, content string) *models.Note {
+	t.Helper()
+	note := &models.Note{Content: content, UserID: userID, WorkspaceID: workspaceID}
+	check(t, r.Notes.Add(context.Background(), note))
+	return note
+}
+
+func createSession(t *testing.T, r Repositories, userID int, expiresAt time.Time) *models.Session {
+	ctx := context.Background()
+	t.Helper()
+	session := &models.Session{UserID: userID, DeviceLabel: "laptop", UserAgent: "test", IP: "127.0.0.1",
+		CreatedAt: time.Now(), ExpiresAt: expiresAt}
+	check(t, r.Sessions.Create(ctx, session))
+	return session
+}
+
+func getChanges(t *testing.T, r Repositories, userID int, since int64) []models.NoteChange {
+	t.Helper()
+	changes, err := r.Notes.GetChanges(context.Background(), userID, since, 100)
+	check(t, err)
+	return changes
+}
+
+func maxSeq(changes []models.NoteChange) int64 {
+	var seq int64
+	for _, change := range changes {
+		if change.Seq > seq {
+			seq = change.Seq
+		}
+	}
+	return seq
+}
+
+func assertSessions(t *testing.T, r Repositories, userID int, want ...int) {
+	ctx := context.Background()
+	t.Helper()
+	sessions, err := r.Sessions.ListActiveByUser(ctx, userID)
+	check(t, err)
+	ids := []int{}
+	for _, session := range sessions {
+		ids = append(ids, session.ID)
+	}
+	sort.Ints(ids)
+	if want == nil {
+		want = []int{}
+	}
+	if !reflect.DeepEqual(ids, want) {
+		t.Errorf("ListActiveByUser() = %v, want %v", ids, want)
+	}
+}
+
+func assertDeliveries(t *testing.T, r Repositories, webhookID, want int) {
+	ctx := context.Background()
+	t.Helper()
+	deliveries, err := r.Webhooks.ListDeliveries(ctx, webhookID, 10)
+	check(t, err)
+	if len(deliveries) != want {
+		t.Errorf("ListDeliveries(%d) = %d deliveries, want %d", webhookID, len(deliveries), want)
+	}
+}
+
+// noteIDs returns the IDs of the notes in ascending order, or nil for no notes.
+func noteIDs(notes []models.Note) []int {
+	var ids []int
+	for _, note := range notes {
+		ids = append(ids, note.ID)
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+// sameTime reports whether the times are equal to the microsecond, the precision of PostgreSQL,
+// which rounds the times it stores.
+func sameTime(a, b time.Time) bool {
+	return a.Round(time.Microsecond).Equal(b.Round(time.Microsecond))
+}
+
+func jsonEqual(a, b []byte) bool {
+	var x, y interface{}
+	if json.Unmarshal(a, &x) != nil || json.Unmarshal(b, &y) != nil {
+		return false
+	}
+	return reflect.DeepEqual(x, y)
+}
+
+func check(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,123 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/ananikitina/notes-rest/internal/models"
+)
+
+// SessionRepository handles login session records.
+type SessionRepository struct {
+	DB      *sql.DB
+	Timeout time.Duration
+}
+
+// NewSessionRepository creates a new session repository with the given database connection and query timeout.
+func NewSessionRepository(DB *sql.DB, timeout time.Duration) *SessionRepository {
+	return &SessionRepository{DB: DB, Timeout: timeout}
+}
+
+// Create adds a new session and sets its ID.
+func (s *SessionRepository) Create(ctx context.Context, session *models.Session) error {
+	ctx, cancel := withTimeout(ctx, s.Timeout)
+	defer cancel()
+
+	return s.DB.QueryRowContext(ctx, `
+	INSERT INTO sessions (user_id, device_label, user_agent, ip, created_at, last_seen_at, expires_at)
+	VALUES ($1, $2, $3, $4, $5, $5, $6)
+	RETURNING id;
+`, session.UserID, session.DeviceLabel, session.UserAgent, session.IP, session.CreatedAt, session.ExpiresAt).
+		Scan(&session.ID)
+}
+
+// GetActive retrieves a session that is neither revoked nor expired.
+func (s *SessionRepository) GetActive(ctx context.Context, id int) (*models.Session, error) {
+	ctx, cancel := withTimeout(ctx, s.Timeout)
+	defer cancel()
+
+	var session models.Session
+	err := s.DB.QueryRowContext(ctx, `
+	SELECT id, user_id, device_label, user_agent, ip, created_at, last_seen_at, expires_at
+	FROM sessions WHERE id = $1 AND revoked_at IS NULL AND expires_at > NOW();
+`, id).Scan(&session.ID, &session.UserID, &session.DeviceLabel, &session.UserAgent, &session.IP,
+		&session.CreatedAt, &session.LastSeenAt, &session.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// ListActiveByUser retrieves the user's sessions that are neither revoked nor expired.
+func (s *SessionRepository) ListActiveByUser(ctx context.Context, userID int) ([]models.Session, error) {
+	ctx, cancel := withTimeout(ctx, s.Timeout)
+	defer cancel()
+
+	rows, err := s.DB.QueryContext(ctx, `
+	SELECT id, user_id, device_label, user_agent, ip, created_at, last_seen_at, expires_at
+	FROM sessions WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > NOW()
+	ORDER BY last_seen_at DESC;
+`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []models.Session{}
+	for rows.Next() {
+		var session models.Session
+		if err := rows.Scan(&session.ID, &session.UserID, &session.DeviceLabel, &session.UserAgent, &session.IP,
+			&session.CreatedAt, &session.LastSeenAt, &session.ExpiresAt); err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, rows.Err()
+}
+
+// Touch updates the time the session was last used.
+func (s *SessionRepository) Touch(ctx context.Context, id int, lastSeenAt time.Time) error {
+	ctx, cancel := withTimeout(ctx, s.Timeout)
+	defer cancel()
+
+	_, err := s.DB.ExecContext(ctx, "UPDATE sessions SET last_seen_at = $1 WHERE id = $2", lastSeenAt, id)
+	return err
+}
+
+// Revoke revokes the user's session and reports whether an active session was found.
+func (s *SessionRepository) Revoke(ctx context.Context, id, userID int) (bool, error) {
+	ctx, cancel := withTimeout(ctx, s.Timeout)
+	defer cancel()
+
+	res, err := s.DB.ExecContext(ctx,
+		"UPDATE sessions SET revoked_at = NOW() WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL",
+		id, userID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// RevokeOthersByUser revokes all sessions of the user except the given one.
+func (s *SessionRepository) RevokeOthersByUser(ctx context.Context, userID, keepID int) error {
+	ctx, cancel := withTimeout(ctx, s.Timeout)
+	defer cancel()
+
+	_, err := s.DB.ExecContext(ctx,
+		"UPDATE sessions SET revoked_at = NOW() WHERE user_id = $1 AND id <> $2 AND revoked_at IS NULL",
+		userID, keepID)
+	return err
+}
+
+// RevokeAllByUser revokes all sessions of the user.
+func (s *SessionRepository) RevokeAllByUser(ctx context.Context, userID int) error {
+	ctx, cancel := withTimeout(ctx, s.Timeout)
+	defer cancel()
+
+	_, err := s.DB.ExecContext(ctx,
+		"UPDATE sessions SET revoked_at = NOW() WHERE user_id = $1 AND revoked_at IS NULL",
+		userID)
+	return err
+}
@@ -0,0 +1,99 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/ananikitina/notes-rest/internal/models"
+)
+
+// LoginAttemptRepository handles login attempt records.
+type LoginAttemptRepository struct {
+	DB      conn
+	Timeout time.Duration
+}
+
+// NewLoginAttemptRepository creates a new login attempt repository with the given database connection and query timeout.
+func NewLoginAttemptRepository(DB *sql.DB, timeout time.Duration) *LoginAttemptRepository {
+	return &LoginAttemptRepository{DB: conn{DB}, Timeout: timeout}
+}
+
+// Add records a login attempt.
+func (l *LoginAttemptRepository) Add(ctx context.Context, attempt models.LoginAttempt) error {
+	ctx, cancel := withTimeout(ctx, l.Timeout)
+	defer cancel()
+
+	_, err := l.DB.ExecContext(ctx,
+		"INSERT INTO login_attempts (email, ip, token_hash, success, created_at) VALUES (?1, ?2, ?3, ?4, ?5)",
+		attempt.Email, attempt.IP, attempt.TokenHash, attempt.Success, attempt.CreatedAt)
+	return err
+}
+
+// CountFailuresByEmail returns the number of failed attempts for the email since the given time
+// that haven't been cleared by a successful login or an unlock, and the time of the latest one.
+func (l *LoginAttemptRepository) CountFailuresByEmail(ctx context.Context, email string, since time.Time) (int, time.Time, error) {
+	ctx, cancel := withTimeout(ctx, l.Timeout)
+	defer cancel()
+
+	var count int
+	var last sql.NullString
+	err := l.DB.QueryRowContext(ctx, `
+	SELECT COUNT(*), MAX(created_at) FROM login_attempts
+	WHERE email = ?1 AND NOT success AND NOT cleared AND created_at > ?2;
+`, email, since).Scan(&count, &last)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	lastAt, err := parseTime(last)
+	return count, lastAt, err
+}
+
+// CountFailuresByIP returns the number of failed attempts from the IP since the given time
+// and the time of the earliest one.
+func (l *LoginAttemptRepository) CountFailuresByIP(ctx context.Context, ip string, since time.Time) (int, time.Time, error) {
+	ctx, cancel := withTimeout(ctx, l.Timeout)
+	defer cancel()
+
+	var count int
+	var first sql.NullString
+	err := l.DB.QueryRowContext(ctx, `
+	SELECT COUNT(*), MIN(created_at) FROM login_attempts
+	WHERE ip = ?1 AND NOT success AND created_at > ?2;
+`, ip, since).Scan(&count, &first)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	firstAt, err := parseTime(first)
+	return count, firstAt, err
+}
+
+// CountFailuresByToken returns the number of failed attempts with the token since the given time,
+// including cleared ones, and the time of the latest one.
+func (l *LoginAttemptRepository) CountFailuresByToken(ctx context.Context, tokenHash string, since time.Time) (int, time.Time, error) {
+	ctx, cancel := withTimeout(ctx, l.Timeout)
+	defer cancel()
+
+	var count int
+	var last sql.NullString
+	err := l.DB.QueryRowContext(ctx, `
+	SELECT COUNT(*), MAX(created_at) FROM login_attempts
+	WHERE token_hash = ?1 AND NOT success AND created_at > ?2;
+`, tokenHash, since).Scan(&count, &last)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	lastAt, err := parseTime(last)
+	return count, lastAt, err
+}
+
+// ClearFailures stops counting the failed attempts for the email. The records are kept.
+func (l *LoginAttemptRepository) ClearFailures(ctx context.Context, email string) error {
+	ctx, cancel := withTimeout(ctx, l.Timeout)
+	defer cancel()
+
+	_, err := l.DB.ExecContext(ctx,
+		"UPDATE login_attempts SET cleared = TRUE WHERE email = ?1 AND NOT success AND NOT cleared",
+		email)
+	return err
+}
@@ -0,0 +1,495 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/ananikitina/notes-rest/internal/domain"
+	"github.com/ananikitina/notes-rest/internal/models"
+)
+
+const noteColumns = "n.id, n.content, n.user_id, n.workspace_id, n.created_at, n.updated_at, n.change_seq"
+
+// visibilitySQL selects the notes n matching the condition with everyone who can see them.
+const visibilitySQL = `
+	SELECT n.id, n.user_id FROM notes n WHERE %[1]s
+	UNION
+	SELECT n.id, m.user_id FROM notes n JOIN workspace_members m ON m.workspace_id = n.workspace_id WHERE %[1]s
+	UNION
+	SELECT n.id, s.user_id FROM notes n JOIN note_shares s ON s.note_id = n.id WHERE %[1]s
+`
+
+// visibleCondition matches the notes n the user in the parameter can see. Authors only see
+// their notes of a workspace while they are its members.
+const visibleCondition = `((n.user_id = ?%d AND n.workspace_id IS NULL)
+	OR n.workspace_id IN (SELECT workspace_id FROM workspace_members WHERE user_id = ?%[1]d)
+	OR n.id IN (SELECT note_id FROM note_shares WHERE user_id = ?%[1]d))`
+
+// searchCondition matches notes by the FTS5 query in the parameter.
+const searchCondition = "n.id IN (SELECT rowid FROM notes_fts WHERE notes_fts MATCH ?%d)"
+
+// noteRepository is an implementation of the NoteRepository interface.
+type noteRepository struct {
+	DB      conn
+	Timeout time.Duration
+}
+
+// NewNoteRepository creates a new note repository with the given database connection and query timeout.
+func NewNoteRepository(DB *sql.DB, timeout time.Duration) domain.NoteRepository {
+	return &noteRepository{DB: conn{DB}, Timeout: timeout}
+}
+
+// AddNote inserts a new note into the database and sets its ID, creation time and change sequence.
+func (n *noteRepository) Add(ctx context.Context, note *models.Note) error {
+	ctx, cancel := withTimeout(ctx, n.Timeout)
+	defer cancel()
+
+	return inTx(ctx, n.DB, func(tx conn) error {
+		seq, err := nextSeq(ctx, tx)
+		if err != nil {
+			return err
+		}
+		createdAt := time.Now()
+		err = tx.QueryRowContext(ctx, `
+		INSERT INTO notes (content, user_id, workspace_id, created_at, change_seq)
+		VALUES (?1, ?2, ?3, ?4, ?5)
+		RETURNING id;
+	`, note.Content, note.UserID, note.WorkspaceID, createdAt, seq).Scan(&note.ID)
+		if err != nil {
+			return err
+		}
+		note.CreatedAt = createdAt
+		note.ChangeSeq = seq
+		return nil
+	})
+}
+
+// GetByID retrieves a note by its ID.
+func (n *noteRepository) GetByID(ctx context.Context, id int) (*models.Note, error) {
+	notes, err := n.getNotes(ctx, "SELECT "+noteColumns+" FROM notes n WHERE n.id = ?1", id)
+	if err != nil {
+		return nil, err
+	}
+	if len(notes) == 0 {
+		return nil, sql.ErrNoRows
+	}
+	return &notes[0], nil
+}
+
+// Update saves the content of the note and sets its new change sequence. With a non-zero baseSeq
+// the note is only saved if it hasn't changed since, otherwise false is returned.
+func (n *noteRepository) Update(ctx context.Context, note *models.Note, baseSeq int64) (bool, error) {
+	ctx, cancel := withTimeout(ctx, n.Timeout)
+	defer cancel()
+
+	updated := false
+	err := inTx(ctx, n.DB, func(tx conn) error {
+		seq, err := nextSeq(ctx, tx)
+		if err != nil {
+			return err
+		}
+		res, err := tx.ExecContext(ctx, `
+		UPDATE notes SET content = ?1, updated_at = ?2, change_seq = ?3
+		WHERE id = ?4 AND (?5 = 0 OR change_seq = ?5);
+	`, note.Content, note.UpdatedAt, seq, note.ID, baseSeq)
+		if err != nil {
+			return err
+		}
+		if count, err := res.RowsAffected(); err != nil || count == 0 {
+			return err
+		}
+		note.ChangeSeq = seq
+		updated = true
+		return nil
+	})
+	return updated, err
+}
+
+// Delete removes the note with its shares and public links, leaving tombstones for sync. With a non-zero
+// baseSeq the note is only deleted if it hasn't changed since, otherwise false is returned.
+func (n *noteRepository) Delete(ctx context.Context, id int, baseSeq int64) (bool, error) {
+	ctx, cancel := withTimeout(ctx, n.Timeout)
+	defer cancel()
+
+	deleted := false
+	err := inTx(ctx, n.DB, func(tx conn) error {
+		var changeSeq int64
+		if err := tx.QueryRowContext(ctx, "SELECT change_seq FROM notes WHERE id = ?1", id).Scan(&changeSeq); err != nil {
+			return err
+		}
+		if baseSeq != 0 && changeSeq != baseSeq {
+			return nil
+		}
+
+		if err := addTombstones(ctx, tx, "n.id = ?1", id); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, "DELETE FROM notes WHERE id = ?1", id); err != nil {
+			return err
+		}
+		deleted = true
+		return nil
+	})
+	return deleted, err
+}
+
+// GetNotesByUserID retrieves private notes from specified user.
+func (n *noteRepository) GetByUserID(ctx context.Context, userID int, query string) ([]models.Note, error) {
+	return n.search(ctx, "n.user_id = ?1 AND n.workspace_id IS NULL", userID, query)
+}
+
+// GetByWorkspaceID retrieves notes of the workspace.
+func (n *noteRepository) GetByWorkspaceID(ctx context.Context, workspaceID int, query string) ([]models.Note, error) {
+	return n.search(ctx, "n.workspace_id = ?1", workspaceID, query)
+}
+
+// GetOwnedByUser retrieves the user's notes in any workspace and all notes of the workspaces the user owns.
+func (n *noteRepository) GetOwnedByUser(ctx context.Context, userID int) ([]models.Note, error) {
+	return n.getNotes(ctx,
+		"SELECT "+noteColumns+" FROM notes n WHERE n.user_id = ?1 OR n.workspace_id IN (SELECT id FROM workspaces WHERE owner_id = ?1) ORDER BY n.created_at;",
+		userID)
+}
+
+// GetAllNotes retrieves all notes (admin access).
+func (n *noteRepository) GetAllNotes(ctx context.Context) ([]models.Note, error) {
+	return n.getNotes(ctx, "SELECT "+noteColumns+" FROM notes n")
+}
+
+// CountNotes returns the number of notes.
+func (n *noteRepository) CountNotes(ctx context.Context) (int, error) {
+	ctx, cancel := withTimeout(ctx, n.Timeout)
+	defer cancel()
+
+	var count int
+	err := n.DB.QueryRowContext(ctx, "SELECT COUNT(*) FROM notes").Scan(&count)
+	return count, err
+}
+
+// search retrieves the notes n matching the condition on the ID parameter and, unless it is empty,
+// the full-text query.
+func (n *noteRepository) search(ctx context.Context, condition string, id int, query string) ([]models.Note, error) {
+	if query == "" {
+		return n.getNotes(ctx, "SELECT "+noteColumns+" FROM notes n WHERE "+condition+" ORDER BY n.created_at;", id)
+	}
+	match := ftsQuery(query)
+	if match == "" {
+		// Like an empty tsquery, a query without words matches no notes
+		return nil, nil
+	}
+	return n.getNotes(ctx,
+		"SELECT "+noteColumns+" FROM notes n WHERE "+condition+" AND "+fmt.Sprintf(searchCondition, 2)+
+			" ORDER BY n.created_at;",
+		id, match)
+}
+
+// ftsQuery turns a search query into an FTS5 query matching the notes with all of its words, like
+// plainto_tsquery does. The words are quoted, so the FTS5 query syntax in the search query is ignored.
+func ftsQuery(query string) string {
+	words := strings.FieldsFunc(query, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for i, word := range words {
+		words[i] = `"` + word + `"`
+	}
+	return strings.Join(words, " ")
+}
+
+// getNotes runs a query selecting noteColumns and scans the rows.
+func (n *noteRepository) getNotes(ctx context.Context, query string, args ...interface{}) ([]models.Note, error) {
+	ctx, cancel := withTimeout(ctx, n.Timeout)
+	defer cancel()
+
+	rows, err := n.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var notes []models.Note
+	for rows.Next() {
+		var note models.Note
+		if err := scanNote(rows, &note); err != nil {
+			return nil, err
+		}
+		notes = append(notes, note)
+	}
+	return notes, rows.Err()
+}
+
+// SaveShare shares the note with the user or changes the permission of an existing share.
+func (n *noteRepository) SaveShare(ctx context.Context, share *models.NoteShare) error {
+	ctx, cancel := withTimeout(ctx, n.Timeout)
+	defer cancel()
+
+	return inTx(ctx, n.DB, func(tx conn) error {
+		_, err := tx.ExecContext(ctx, `
+		INSERT INTO note_shares (note_id, user_id, permission, created_at)
+		VALUES (?1, ?2, ?3, ?4)
+		ON CONFLICT (note_id, user_id) DO UPDATE SET permission = excluded.permission;
+	`, share.NoteID, share.UserID, share.Permission, share.CreatedAt)
+		if err != nil {
+			return err
+		}
+		err = tx.QueryRowContext(ctx, "SELECT created_at FROM note_shares WHERE note_id = ?1 AND user_id = ?2",
+			share.NoteID, share.UserID).Scan(&share.CreatedAt)
+		if err != nil {
+			return err
+		}
+		return revealNotes(ctx, tx, "n.id = ?1", share.UserID, share.NoteID)
+	})
+}
+
+// GetShare retrieves the share of the note with the user.
+func (n *noteRepository) GetShare(ctx context.Context, noteID, userID int) (*models.NoteShare, error) {
+	ctx, cancel := withTimeout(ctx, n.Timeout)
+	defer cancel()
+
+	var share models.NoteShare
+	err := n.DB.QueryRowContext(ctx,
+		"SELECT note_id, user_id, permission, created_at FROM note_shares WHERE note_id = ?1 AND user_id = ?2",
+		noteID, userID).Scan(&share.NoteID, &share.UserID, &share.Permission, &share.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &share, nil
+}
+
+// ListShares retrieves the shares of the note with the emails of the users.
+func (n *noteRepository) ListShares(ctx context.Context, noteID int) ([]models.NoteShare, error) {
+	ctx, cancel := withTimeout(ctx, n.Timeout)
+	defer cancel()
+
+	rows, err := n.DB.QueryContext(ctx, `
+	SELECT s.note_id, s.user_id, u.email, s.permission, s.created_at
+	FROM note_shares s JOIN users u ON u.id = s.user_id
+	WHERE s.note_id = ?1
+	ORDER BY s.created_at;
+`, noteID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	shares := []models.NoteShare{}
+	for rows.Next() {
+		var share models.NoteShare
+		if err := rows.Scan(&share.NoteID, &share.UserID, &share.Email, &share.Permission, &share.CreatedAt); err != nil {
+			return nil, err
+		}
+		shares = append(shares, share)
+	}
+	return shares, rows.Err()
+}
+
+// RemoveShare revokes the share of the note with the user and reports whether it existed.
+func (n *noteRepository) RemoveShare(ctx context.Context, noteID, userID int) (bool, error) {
+	ctx, cancel := withTimeout(ctx, n.Timeout)
+	defer cancel()
+
+	removed := false
+	err := inTx(ctx, n.DB, func(tx conn) error {
+		res, err := tx.ExecContext(ctx, "DELETE FROM note_shares WHERE note_id = ?1 AND user_id = ?2", noteID, userID)
+		if err != nil {
+			return err
+		}
+		if count, err := res.RowsAffected(); err != nil || count == 0 {
+			return err
+		}
+		removed = true
+		return hideNotes(ctx, tx, "n.id = ?1", userID, noteID)
+	})
+	return removed, err
+}
+
+// GetSharedWith retrieves the notes shared with the user, with the user's permission.
+func (n *noteRepository) GetSharedWith(ctx context.Context, userID int) ([]models.SharedNote, error) {
+	ctx, cancel := withTimeout(ctx, n.Timeout)
+	defer cancel()
+
+	rows, err := n.DB.QueryContext(ctx, `
+	SELECT `+noteColumns+`, s.permission
+	FROM notes n JOIN note_shares s ON s.note_id = n.id
+	WHERE s.user_id = ?1
+	ORDER BY n.created_at;
+`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	notes := []models.SharedNote{}
+	for rows.Next() {
+		var note models.SharedNote
+		if err := scanNote(rows, &note.Note, &note.Permission); err != nil {
+			return nil, err
+		}
+		notes = append(notes, note)
+	}
+	return notes, rows.Err()
+}
+
+// GetChanges retrieves up to limit changes of the notes the user can see after the since sequence,
+// in sequence order: the notes created or changed since, and the tombstones of notes that were
+// deleted or became invisible to the user.
+func (n *noteRepository) GetChanges(ctx context.Context, userID int, since int64, limit int) ([]models.NoteChange, error) {
+	ctx, cancel := withTimeout(ctx, n.Timeout)
+	defer cancel()
+
+	rows, err := n.DB.QueryContext(ctx, `
+	SELECT `+noteColumns+`, 'upsert'
+	FROM notes n
+	WHERE n.change_seq > ?2 AND `+fmt.Sprintf(visibleCondition, 1)+`
+	UNION ALL
+	SELECT t.note_id, '', 0, NULL, t.deleted_at, NULL, t.change_seq, 'delete'
+	FROM note_tombstones t
+	WHERE t.user_id = ?1 AND t.change_seq > ?2
+	ORDER BY 7
+	LIMIT ?3;
+`, userID, since, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	changes := []models.NoteChange{}
+	for rows.Next() {
+		var change models.NoteChange
+		var note models.Note
+		if err := scanNote(rows, &note, &change.Type); err != nil {
+			return nil, err
+		}
+		change.NoteID = note.ID
+		change.Seq = note.ChangeSeq
+		if change.Type == models.ChangeDelete {
+			change.DeletedAt = &note.CreatedAt
+		} else {
+			change.Note = &note
+		}
+		changes = append(changes, change)
+	}
+	return changes, rows.Err()
+}
+
+// addTombstones records tombstones for everyone who can see the notes n matching the condition,
+// so syncing clients learn that the notes are gone.
+func addTombstones(ctx context.Context, tx conn, condition string, args ...interface{}) error {
+	return saveTombstones(ctx, tx, fmt.Sprintf(visibilitySQL, condition), args...)
+}
+
+// revealNotes makes the notes n matching the condition, which the user can now see, show up in the user's
+// next sync: their change sequence is advanced and the user's tombstones for them are removed.
+func revealNotes(ctx context.Context, tx conn, condition string, userID int, args ...interface{}) error {
+	args = append(args, userID)
+	userParam := len(args)
+	_, err := tx.ExecContext(ctx, fmt.Sprintf(
+		"DELETE FROM note_tombstones WHERE user_id = ?%d AND note_id IN (SELECT n.id FROM notes n WHERE %s)",
+		userParam, condition), args...)
+	if err != nil {
+		return err
+	}
+
+	ids, err := queryIDs(ctx, tx, "SELECT n.id FROM notes n WHERE "+condition, args[:userParam-1]...)
+	if err != nil {
+		return err
+	}
+	// Every note takes its own sequence value, so a sync page never ends between notes of one value
+	for _, id := range ids {
+		seq, err := nextSeq(ctx, tx)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, "UPDATE notes SET change_seq = ?1 WHERE id = ?2", seq, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// hideNotes records the user's tombstones for the notes n matching the condition that the user can no longer see.
+func hideNotes(ctx context.Context, tx conn, condition string, userID int, args ...interface{}) error {
+	args = append(args, userID)
+	userParam := len(args)
+	return saveTombstones(ctx, tx, fmt.Sprintf("SELECT n.id, ?%d FROM notes n WHERE (%s) AND NOT %s",
+		userParam, condition, fmt.Sprintf(visibleCondition, userParam)), args...)
+}
+
+// saveTombstones records a tombstone for every note and user pair selected by the query, each with
+// its own sequence value.
+func saveTombstones(ctx context.Context, tx conn, query string, args ...interface{}) error {
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	type tombstone struct{ noteID, userID int }
+	var tombstones []tombstone
+	for rows.Next() {
+		var t tombstone
+		if err := rows.Scan(&t.noteID, &t.userID); err != nil {
+			rows.Close()
+			return err
+		}
+		tombstones = append(tombstones, t)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	deletedAt := time.Now()
+	for _, t := range tombstones {
+		seq, err := nextSeq(ctx, tx)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, `
+		INSERT INTO note_tombstones (note_id, user_id, change_seq, deleted_at)
+		VALUES (?1, ?2, ?3, ?4)
+		ON CONFLICT (note_id, user_id) DO UPDATE SET change_seq = excluded.change_seq, deleted_at = excluded.deleted_at;
+	`, t.noteID, t.userID, seq, deletedAt)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// queryIDs runs a query selecting one integer column and returns the values.
+func queryIDs(ctx context.Context, tx conn, query string, args ...interface{}) ([]int, error) {
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// scanNote scans noteColumns followed by the extra destinations.
+func scanNote(rows *sql.Rows, note *models.Note, extra ...interface{}) error {
+	var workspaceID sql.NullInt64
+	var updatedAt sql.NullTime
+	dest := append([]interface{}{&note.ID, &note.Content, &note.UserID, &workspaceID, &note.CreatedAt, &updatedAt, &note.ChangeSeq}, extra...)
+	if err := rows.Scan(dest...); err != nil {
+		return err
+	}
+	if workspaceID.Valid {
+		id := int(workspaceID.Int64)
+		note.WorkspaceID = &id
+	}
+	if updatedAt.Valid {
+		note.UpdatedAt = &updatedAt.Time
+	}
+	return nil
+}
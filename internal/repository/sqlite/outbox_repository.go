@@ -0,0 +1,89 @@
+package sqlite
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/ananikitina/notes-rest/internal/models"
+)
+
+// outboxRepository is an implementation of the OutboxRepository interface.
+type outboxRepository struct {
+	DB conn
+}
+
+// Add stores the event and sets its ID and creation time.
+func (o *outboxRepository) Add(ctx context.Context, event *models.DomainEvent) error {
+	payload, err := json.Marshal(event.Event)
+	if err != nil {
+		return err
+	}
+	recipients, err := jsonArray(event.Recipients)
+	if err != nil {
+		return err
+	}
+	var data interface{}
+	if event.Data != nil {
+		data = string(event.Data)
+	}
+	createdAt := time.Now()
+	err = o.DB.QueryRowContext(ctx,
+		"INSERT INTO outbox (event, recipients, data, created_at) VALUES (?1, ?2, ?3, ?4) RETURNING id",
+		string(payload), recipients, data, createdAt).Scan(&event.ID)
+	if err != nil {
+		return err
+	}
+	event.CreatedAt = createdAt
+	return nil
+}
+
+// FetchPending retrieves up to limit events in the order they were added. Transactions hold the
+// database write lock from their start, so no other transaction relays the events concurrently.
+func (o *outboxRepository) FetchPending(ctx context.Context, limit int) ([]models.DomainEvent, error) {
+	rows, err := o.DB.QueryContext(ctx,
+		"SELECT id, event, recipients, data, created_at FROM outbox ORDER BY id LIMIT ?1",
+		limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []models.DomainEvent
+	for rows.Next() {
+		var event models.DomainEvent
+		var payload, recipients, data []byte
+		if err := rows.Scan(&event.ID, &payload, &recipients, &data, &event.CreatedAt); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(payload, &event.Event); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(recipients, &event.Recipients); err != nil {
+			return nil, err
+		}
+		event.Data = data
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
+
+// Delete removes the relayed events.
+func (o *outboxRepository) Delete(ctx context.Context, ids []int64) error {
+	list, err := jsonArray(ids)
+	if err != nil {
+		return err
+	}
+	_, err = o.DB.ExecContext(ctx, "DELETE FROM outbox WHERE id IN (SELECT value FROM json_each(?1))", list)
+	return err
+}
+
+// jsonArray encodes the slice as a JSON array, the form lists are stored and passed to queries in.
+// A nil slice is encoded as an empty array.
+func jsonArray(list interface{}) (string, error) {
+	data, err := json.Marshal(list)
+	if string(data) == "null" {
+		return "[]", err
+	}
+	return string(data), err
+}
@@ -0,0 +1,80 @@
+package sqlite_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ananikitina/notes-rest/internal/config"
+	"github.com/ananikitina/notes-rest/internal/database"
+	"github.com/ananikitina/notes-rest/internal/repository/repotest"
+	"github.com/ananikitina/notes-rest/internal/repository/sqlite"
+)
+
+func TestRepositories(t *testing.T) {
+	repotest.Run(t, func(t *testing.T) repotest.Repositories {
+		db := openDB(t)
+		return repotest.Repositories{
+			Users:         sqlite.NewUserRepository(db, time.Minute),
+			LoginAttempts: sqlite.NewLoginAttemptRepository(db, time.Minute),
+			Sessions:      sqlite.NewSessionRepository(db, time.Minute),
+			Notes:         sqlite.NewNoteRepository(db, time.Minute),
+			Workspaces:    sqlite.NewWorkspaceRepository(db, time.Minute),
+			PublicLinks:   sqlite.NewPublicLinkRepository(db, time.Minute),
+			Webhooks:      sqlite.NewWebhookRepository(db, time.Minute),
+			UnitOfWork:    sqlite.NewUnitOfWork(db, time.Minute),
+		}
+	})
+}
+
+func TestQueriesAreCancelledWithTheContext(t *testing.T) {
+	db := openDB(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	queries := map[string]func() error{
+		"sessions": func() error {
+			_, err := sqlite.NewSessionRepository(db, time.Minute).ListActiveByUser(ctx, 1)
+			return err
+		},
+		"login attempts": func() error {
+			_, _, err := sqlite.NewLoginAttemptRepository(db, time.Minute).CountFailuresByIP(ctx, "127.0.0.1", time.Time{})
+			return err
+		},
+		"public links": func() error {
+			_, err := sqlite.NewPublicLinkRepository(db, time.Minute).ListByNote(ctx, 1)
+			return err
+		},
+		"webhooks": func() error {
+			_, err := sqlite.NewWebhookRepository(db, time.Minute).ListByUser(ctx, 1)
+			return err
+		},
+	}
+	for name, query := range queries {
+		if err := query(); !errors.Is(err, context.Canceled) {
+			t.Errorf("%s query with a cancelled context error = %v, want context.Canceled", name, err)
+		}
+	}
+
+	_, err := sqlite.NewSessionRepository(db, time.Nanosecond).ListActiveByUser(context.Background(), 1)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("query over the timeout error = %v, want context.DeadlineExceeded", err)
+	}
+}
+
+func openDB(t *testing.T) *sql.DB {
+	t.Helper()
+	// Every connection to an in-memory database gets a database of its own, so a file is used
+	db, err := database.OpenSQLite(filepath.Join(t.TempDir(), "notes.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := database.Migrate(db, config.StorageDriverSQLite); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
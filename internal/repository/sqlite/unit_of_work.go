@@ -0,0 +1,157 @@
+// Package sqlite implements the repositories on SQLite, for local development and single-user installs.
+// The database must be opened with database.OpenSQLite and migrated with the SQLite migrations.
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/ananikitina/notes-rest/internal/domain"
+)
+
+// timeFormat is the format times are written in by connections opened with database.OpenSQLite.
+const timeFormat = "2006-01-02 15:04:05.999999999-07:00"
+
+// dbtx is implemented by *sql.DB and *sql.Tx, so repositories can run inside a unit of work.
+type dbtx interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// conn runs queries on a database or transaction with time arguments converted to UTC. SQLite stores
+// times as text, which only sorts and compares in time order when all times are in the same zone.
+type conn struct {
+	db dbtx
+}
+
+func (c conn) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return c.db.ExecContext(ctx, query, utc(args)...)
+}
+
+func (c conn) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	return c.db.QueryContext(ctx, query, utc(args)...)
+}
+
+func (c conn) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	return c.db.QueryRowContext(ctx, query, utc(args)...)
+}
+
+func (c conn) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return c.ExecContext(context.Background(), query, args...)
+}
+
+func (c conn) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return c.QueryContext(context.Background(), query, args...)
+}
+
+func (c conn) QueryRow(query string, args ...interface{}) *sql.Row {
+	return c.QueryRowContext(context.Background(), query, args...)
+}
+
+// utc returns the arguments with times converted to UTC.
+func utc(args []interface{}) []interface{} {
+	converted := make([]interface{}, len(args))
+	for i, arg := range args {
+		switch arg := arg.(type) {
+		case time.Time:
+			converted[i] = arg.UTC()
+		case *time.Time:
+			if arg != nil {
+				converted[i] = arg.UTC()
+			}
+		default:
+			converted[i] = arg
+		}
+	}
+	return converted
+}
+
+// parseTime parses a time computed by a query, such as an aggregate, which the driver returns as text.
+func parseTime(value sql.NullString) (time.Time, error) {
+	if !value.Valid {
+		return time.Time{}, nil
+	}
+	return time.Parse(timeFormat, value.String)
+}
+
+// UnitOfWork is an implementation of the UnitOfWork interface over database transactions.
+type UnitOfWork struct {
+	DB *sql.DB
+	// Timeout limits the whole transaction
+	Timeout time.Duration
+}
+
+// NewUnitOfWork creates a new unit of work with the given database connection and transaction timeout.
+func NewUnitOfWork(DB *sql.DB, timeout time.Duration) *UnitOfWork {
+	return &UnitOfWork{DB: DB, Timeout: timeout}
+}
+
+// Do runs fn with repositories bound to a new transaction, committing it if fn returns nil.
+func (u *UnitOfWork) Do(ctx context.Context, fn func(tx domain.Tx) error) error {
+	ctx, cancel := withTimeout(ctx, u.Timeout)
+	defer cancel()
+
+	tx, err := u.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := fn(&txRepositories{tx: conn{tx}}); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// withTimeout limits the context to the timeout; a zero timeout leaves it unchanged.
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// txRepositories is an implementation of the Tx interface.
+type txRepositories struct {
+	tx conn
+}
+
+func (t *txRepositories) Users() domain.UserRepository {
+	return &UserRepository{DB: t.tx}
+}
+
+func (t *txRepositories) Notes() domain.NoteRepository {
+	return &noteRepository{DB: t.tx}
+}
+
+func (t *txRepositories) Outbox() domain.OutboxRepository {
+	return &outboxRepository{DB: t.tx}
+}
+
+// inTx runs fn in the transaction db already is, or in a new transaction committed if fn returns nil.
+func inTx(ctx context.Context, db conn, fn func(tx conn) error) error {
+	if _, ok := db.db.(*sql.Tx); ok {
+		return fn(db)
+	}
+
+	tx, err := db.db.(*sql.DB).BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := fn(conn{tx}); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// nextSeq takes the next value of the note change sequence. SQLite has no sequences, so the value
+// is kept in a table; tx must be a transaction, which holds the write lock until it ends.
+func nextSeq(ctx context.Context, tx conn) (int64, error) {
+	var seq int64
+	err := tx.QueryRowContext(ctx, "UPDATE note_change_seq SET value = value + 1 RETURNING value").Scan(&seq)
+	return seq, err
+}
@@ -0,0 +1,253 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/ananikitina/notes-rest/internal/models"
+)
+
+const deliveryColumns = `d.id, d.webhook_id, d.event_type, d.payload, d.status, d.attempts, d.next_attempt_at,
+	d.last_attempt_at, d.response_code, d.error, d.created_at`
+
+// WebhookRepository handles webhooks and their delivery queue.
+type WebhookRepository struct {
+	DB      conn
+	Timeout time.Duration
+}
+
+// NewWebhookRepository creates a new webhook repository with the given database connection and query timeout.
+func NewWebhookRepository(DB *sql.DB, timeout time.Duration) *WebhookRepository {
+	return &WebhookRepository{DB: conn{DB}, Timeout: timeout}
+}
+
+// Create adds a new webhook and sets its ID.
+func (w *WebhookRepository) Create(ctx context.Context, webhook *models.Webhook) error {
+	ctx, cancel := withTimeout(ctx, w.Timeout)
+	defer cancel()
+
+	events, err := jsonArray(webhook.Events)
+	if err != nil {
+		return err
+	}
+	return w.DB.QueryRowContext(ctx, `
+	INSERT INTO webhooks (user_id, url, secret, events, global, created_at)
+	VALUES (?1, ?2, ?3, ?4, ?5, ?6)
+	RETURNING id;
+`, webhook.UserID, webhook.URL, webhook.Secret, events, webhook.Global, webhook.CreatedAt).
+		Scan(&webhook.ID)
+}
+
+// ListByUser retrieves the user's webhooks without their secrets.
+func (w *WebhookRepository) ListByUser(ctx context.Context, userID int) ([]models.Webhook, error) {
+	ctx, cancel := withTimeout(ctx, w.Timeout)
+	defer cancel()
+
+	rows, err := w.DB.QueryContext(ctx,
+		"SELECT id, user_id, url, events, global, created_at FROM webhooks WHERE user_id = ?1 ORDER BY id", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	webhooks := []models.Webhook{}
+	for rows.Next() {
+		var webhook models.Webhook
+		var events []byte
+		if err := rows.Scan(&webhook.ID, &webhook.UserID, &webhook.URL, &events,
+			&webhook.Global, &webhook.CreatedAt); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(events, &webhook.Events); err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, webhook)
+	}
+	return webhooks, rows.Err()
+}
+
+// Delete removes the user's webhook with its deliveries and reports whether it existed.
+func (w *WebhookRepository) Delete(ctx context.Context, id, userID int) (bool, error) {
+	ctx, cancel := withTimeout(ctx, w.Timeout)
+	defer cancel()
+
+	res, err := w.DB.ExecContext(ctx, "DELETE FROM webhooks WHERE id = ?1 AND user_id = ?2", id, userID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// Exists reports whether the user has the webhook.
+func (w *WebhookRepository) Exists(ctx context.Context, id, userID int) (bool, error) {
+	ctx, cancel := withTimeout(ctx, w.Timeout)
+	defer cancel()
+
+	var exists bool
+	err := w.DB.QueryRowContext(ctx,
+		"SELECT EXISTS (SELECT 1 FROM webhooks WHERE id = ?1 AND user_id = ?2)", id, userID).Scan(&exists)
+	return exists, err
+}
+
+// Enqueue queues a delivery of the event to every webhook subscribed to it that belongs to one of
+// the recipients or is global.
+func (w *WebhookRepository) Enqueue(ctx context.Context, eventType string, payload []byte, recipients []int) error {
+	ctx, cancel := withTimeout(ctx, w.Timeout)
+	defer cancel()
+
+	users, err := jsonArray(recipients)
+	if err != nil {
+		return err
+	}
+	_, err = w.DB.ExecContext(ctx, `
+	INSERT INTO webhook_deliveries (webhook_id, event_type, payload, next_attempt_at, created_at)
+	SELECT id, ?1, ?2, ?4, ?4 FROM webhooks
+	WHERE EXISTS (SELECT 1 FROM json_each(webhooks.events) WHERE value = ?1)
+		AND (global OR user_id IN (SELECT value FROM json_each(?3)));
+`, eventType, string(payload), users, time.Now())
+	return err
+}
+
+// ListDeliveries retrieves the latest deliveries of the webhook.
+func (w *WebhookRepository) ListDeliveries(ctx context.Context, webhookID, limit int) ([]models.WebhookDelivery, error) {
+	ctx, cancel := withTimeout(ctx, w.Timeout)
+	defer cancel()
+
+	rows, err := w.DB.QueryContext(ctx,
+		"SELECT "+deliveryColumns+" FROM webhook_deliveries d WHERE d.webhook_id = ?1 ORDER BY d.id DESC LIMIT ?2",
+		webhookID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := []models.WebhookDelivery{}
+	for rows.Next() {
+		var delivery models.WebhookDelivery
+		if err := scanDelivery(rows, &delivery); err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries, rows.Err()
+}
+
+// Redeliver queues a new delivery with the payload of an earlier delivery of the webhook and sets its fields.
+func (w *WebhookRepository) Redeliver(ctx context.Context, deliveryID int64, webhookID int, delivery *models.WebhookDelivery) error {
+	ctx, cancel := withTimeout(ctx, w.Timeout)
+	defer cancel()
+
+	var id int64
+	err := w.DB.QueryRowContext(ctx, `
+	INSERT INTO webhook_deliveries (webhook_id, event_type, payload, next_attempt_at, created_at)
+	SELECT webhook_id, event_type, payload, ?3, ?3 FROM webhook_deliveries WHERE id = ?1 AND webhook_id = ?2
+	RETURNING id;
+`, deliveryID, webhookID, time.Now()).Scan(&id)
+	if err != nil {
+		return err
+	}
+	row := w.DB.QueryRowContext(ctx, "SELECT "+deliveryColumns+" FROM webhook_deliveries d WHERE d.id = ?1", id)
+	return scanDelivery(row, delivery)
+}
+
+// ClaimDue retrieves up to limit pending deliveries that are due, with their webhooks. The deliveries
+// are postponed by lease, so the next claim skips them while they are sent and they are retried if the
+// worker stops before recording the attempt.
+func (w *WebhookRepository) ClaimDue(ctx context.Context, limit int, lease time.Duration) ([]models.WebhookDelivery, error) {
+	ctx, cancel := withTimeout(ctx, w.Timeout)
+	defer cancel()
+
+	now := time.Now()
+	rows, err := w.DB.QueryContext(ctx, `
+	UPDATE webhook_deliveries SET next_attempt_at = ?3
+	WHERE id IN (
+		SELECT id FROM webhook_deliveries
+		WHERE status = 'pending' AND next_attempt_at <= ?2
+		ORDER BY next_attempt_at
+		LIMIT ?1
+	)
+	RETURNING id;
+`, limit, now, now.Add(lease))
+	if err != nil {
+		return nil, err
+	}
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil || len(ids) == 0 {
+		return nil, err
+	}
+
+	claimed, err := jsonArray(ids)
+	if err != nil {
+		return nil, err
+	}
+	rows, err = w.DB.QueryContext(ctx, `
+	SELECT `+deliveryColumns+`, wh.id, wh.user_id, wh.url, wh.secret
+	FROM webhook_deliveries d JOIN webhooks wh ON wh.id = d.webhook_id
+	WHERE d.id IN (SELECT value FROM json_each(?1))
+	ORDER BY d.id;
+`, claimed)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deliveries []models.WebhookDelivery
+	for rows.Next() {
+		var delivery models.WebhookDelivery
+		webhook := &models.Webhook{}
+		if err := scanDelivery(rows, &delivery, &webhook.ID, &webhook.UserID, &webhook.URL, &webhook.Secret); err != nil {
+			return nil, err
+		}
+		delivery.Webhook = webhook
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries, rows.Err()
+}
+
+// RecordAttempt saves the outcome of a delivery attempt: its status, attempt count, next attempt time,
+// response code and error.
+func (w *WebhookRepository) RecordAttempt(ctx context.Context, delivery *models.WebhookDelivery) error {
+	ctx, cancel := withTimeout(ctx, w.Timeout)
+	defer cancel()
+
+	_, err := w.DB.ExecContext(ctx, `
+	UPDATE webhook_deliveries
+	SET status = ?1, attempts = ?2, next_attempt_at = ?3, last_attempt_at = ?4, response_code = ?5, error = ?6
+	WHERE id = ?7;
+`, delivery.Status, delivery.Attempts, delivery.NextAttemptAt, delivery.LastAttemptAt, delivery.ResponseCode,
+		delivery.Error, delivery.ID)
+	return err
+}
+
+// scanDelivery scans deliveryColumns followed by the extra destinations.
+func scanDelivery(row interface{ Scan(...interface{}) error }, delivery *models.WebhookDelivery, extra ...interface{}) error {
+	var payload []byte
+	var lastAttemptAt sql.NullTime
+	var responseCode sql.NullInt64
+	dest := append([]interface{}{&delivery.ID, &delivery.WebhookID, &delivery.EventType, &payload, &delivery.Status,
+		&delivery.Attempts, &delivery.NextAttemptAt, &lastAttemptAt, &responseCode, &delivery.Error, &delivery.CreatedAt}, extra...)
+	if err := row.Scan(dest...); err != nil {
+		return err
+	}
+	delivery.Payload = payload
+	if lastAttemptAt.Valid {
+		delivery.LastAttemptAt = &lastAttemptAt.Time
+	}
+	if responseCode.Valid {
+		code := int(responseCode.Int64)
+		delivery.ResponseCode = &code
+	}
+	return nil
+}
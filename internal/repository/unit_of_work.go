@@ -0,0 +1,95 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/ananikitina/notes-rest/internal/domain"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// dbtx is implemented by *sql.DB and *sql.Tx, so repositories can run inside a unit of work.
+type dbtx interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// arrayScanner scans a PostgreSQL array into the slice v points to. Slices are passed to queries as
+// they are, the pgx driver encodes them as arrays.
+func arrayScanner(v interface{}) sql.Scanner {
+	return pgtype.NewMap().SQLScanner(v)
+}
+
+// UnitOfWork is an implementation of the UnitOfWork interface over database transactions.
+type UnitOfWork struct {
+	DB *sql.DB
+	// Timeout limits the whole transaction
+	Timeout time.Duration
+}
+
+// NewUnitOfWork creates a new unit of work with the given database connection and transaction timeout.
+func NewUnitOfWork(DB *sql.DB, timeout time.Duration) *UnitOfWork {
+	return &UnitOfWork{DB: DB, Timeout: timeout}
+}
+
+// Do runs fn with repositories bound to a new transaction, committing it if fn returns nil.
+func (u *UnitOfWork) Do(ctx context.Context, fn func(tx domain.Tx) error) error {
+	ctx, cancel := withTimeout(ctx, u.Timeout)
+	defer cancel()
+
+	tx, err := u.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := fn(&txRepositories{tx: tx}); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// withTimeout limits the context to the timeout; a zero timeout leaves it unchanged.
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// txRepositories is an implementation of the Tx interface.
+type txRepositories struct {
+	tx *sql.Tx
+}
+
+func (t *txRepositories) Users() domain.UserRepository {
+	return &UserRepository{DB: t.tx}
+}
+
+func (t *txRepositories) Notes() domain.NoteRepository {
+	return &noteRepository{DB: t.tx}
+}
+
+func (t *txRepositories) Outbox() domain.OutboxRepository {
+	return &outboxRepository{DB: t.tx}
+}
+
+// inTx runs fn in the transaction db already is, or in a new transaction committed if fn returns nil.
+func inTx(ctx context.Context, db dbtx, fn func(tx dbtx) error) error {
+	if tx, ok := db.(*sql.Tx); ok {
+		return fn(tx)
+	}
+
+	tx, err := db.(*sql.DB).BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -1,45 +1,255 @@
 package repository
 
 import (
+	"context"
 	"database/sql"
+	"fmt"
+	"time"
 
 	"github.com/ananikitina/notes-rest/internal/models"
-	"golang.org/x/crypto/bcrypt"
 )
 
-// userRepository handles user-related database operations.
+// userColumns are the columns scanned by getUser.
+const userColumns = `id, email, password, role, email_verified, pending_email, verification_sent_at,
+	totp_secret, totp_enabled, totp_last_step,
+	display_name, locale, timezone, spellcheck_enabled, spellcheck_languages`
+
+// UserRepository is an implementation of the UserRepository interface.
 type UserRepository struct {
-	DB *sql.DB
+	DB      dbtx
+	Timeout time.Duration
 }
 
-// NewUserRepository creates a new user repository with the given database connection.
-func NewUserRepository(DB *sql.DB) *UserRepository {
-	return &UserRepository{DB: DB}
+// NewUserRepository creates a new user repository with the given database connection and query timeout.
+func NewUserRepository(DB *sql.DB, timeout time.Duration) *UserRepository {
+	return &UserRepository{DB: DB, Timeout: timeout}
 }
 
-// CreateUser adds a new user to the database.
-func (u *UserRepository) CreateUser(user *models.User) error {
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(user.Password), bcrypt.DefaultCost)
-	if err != nil {
+// CreateUser adds a new user to the database and sets its ID. The password must already be hashed.
+func (u *UserRepository) CreateUser(ctx context.Context, user *models.User) error {
+	ctx, cancel := withTimeout(ctx, u.Timeout)
+	defer cancel()
+
+	return u.DB.QueryRowContext(ctx,
+		"INSERT INTO users (email,password,role,email_verified) VALUES ($1, $2,$3,$4) RETURNING id",
+		user.Email, user.Password, user.Role, user.EmailVerified).
+		Scan(&user.ID)
+}
+
+// GetUserByEmail retrieves a user by their email.
+func (u *UserRepository) GetUserByEmail(ctx context.Context, email string) (*models.User, error) {
+	return u.getUser(ctx, "SELECT "+userColumns+" FROM users WHERE email = $1", email)
+}
+
+// GetUserByID retrieves a user by their ID.
+func (u *UserRepository) GetUserByID(ctx context.Context, id int) (*models.User, error) {
+	return u.getUser(ctx, "SELECT "+userColumns+" FROM users WHERE id = $1", id)
+}
+
+// GetUserByIdentity retrieves the user linked to the external identity.
+func (u *UserRepository) GetUserByIdentity(ctx context.Context, provider, subject string) (*models.User, error) {
+	return u.getUser(ctx, `
+	SELECT `+userColumns+` FROM users
+	WHERE id = (SELECT user_id FROM user_identities WHERE provider = $1 AND subject = $2);
+`, provider, subject)
+}
+
+// CountUsers returns the number of users.
+func (u *UserRepository) CountUsers(ctx context.Context) (int, error) {
+	ctx, cancel := withTimeout(ctx, u.Timeout)
+	defer cancel()
+
+	var count int
+	err := u.DB.QueryRowContext(ctx, "SELECT COUNT(*) FROM users").Scan(&count)
+	return count, err
+}
+
+// LinkIdentity links an external identity to the user.
+func (u *UserRepository) LinkIdentity(ctx context.Context, id int, provider, subject string) error {
+	ctx, cancel := withTimeout(ctx, u.Timeout)
+	defer cancel()
+
+	_, err := u.DB.ExecContext(ctx,
+		"INSERT INTO user_identities (user_id, provider, subject) VALUES ($1, $2, $3)",
+		id, provider, subject)
+	return err
+}
+
+// UpdateRole changes the user's role.
+func (u *UserRepository) UpdateRole(ctx context.Context, id int, role string) error {
+	ctx, cancel := withTimeout(ctx, u.Timeout)
+	defer cancel()
+
+	_, err := u.DB.ExecContext(ctx, "UPDATE users SET role = $1 WHERE id = $2", role, id)
+	return err
+}
+
+// UpdateProfile saves the user's profile fields.
+func (u *UserRepository) UpdateProfile(ctx context.Context, user *models.User) error {
+	ctx, cancel := withTimeout(ctx, u.Timeout)
+	defer cancel()
+
+	_, err := u.DB.ExecContext(ctx, `
+	UPDATE users SET display_name = $1, locale = $2, timezone = $3, spellcheck_enabled = $4, spellcheck_languages = $5
+	WHERE id = $6;
+`, user.DisplayName, user.Locale, user.Timezone, user.SpellcheckEnabled, user.SpellcheckLanguages, user.ID)
+	return err
+}
+
+// UpdatePassword saves the user's new password hash.
+func (u *UserRepository) UpdatePassword(ctx context.Context, id int, passwordHash string) error {
+	ctx, cancel := withTimeout(ctx, u.Timeout)
+	defer cancel()
+
+	_, err := u.DB.ExecContext(ctx, "UPDATE users SET password = $1 WHERE id = $2", passwordHash, id)
+	return err
+}
+
+// SetPendingEmail stores the new email until it is confirmed.
+func (u *UserRepository) SetPendingEmail(ctx context.Context, id int, email string) error {
+	ctx, cancel := withTimeout(ctx, u.Timeout)
+	defer cancel()
+
+	_, err := u.DB.ExecContext(ctx, "UPDATE users SET pending_email = $1 WHERE id = $2", email, id)
+	return err
+}
+
+// ConfirmPendingEmail replaces the user's email with the confirmed pending one.
+func (u *UserRepository) ConfirmPendingEmail(ctx context.Context, id int) error {
+	ctx, cancel := withTimeout(ctx, u.Timeout)
+	defer cancel()
+
+	_, err := u.DB.ExecContext(ctx, `
+	UPDATE users SET email = pending_email, pending_email = '', email_verified = TRUE
+	WHERE id = $1 AND pending_email <> '';
+`, id)
+	return err
+}
+
+// DeleteUser deletes the user together with their notes and login records.
+func (u *UserRepository) DeleteUser(ctx context.Context, id int) error {
+	ctx, cancel := withTimeout(ctx, u.Timeout)
+	defer cancel()
+
+	return inChangeTx(ctx, u.DB, func(tx dbtx) error {
+		// The user's notes and the notes of the user's workspaces disappear for everyone who could see them
+		if _, err := tx.ExecContext(ctx, fmt.Sprintf(tombstoneSQL,
+			"(n.user_id = $1 OR n.workspace_id IN (SELECT id FROM workspaces WHERE owner_id = $1))"), id); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, "DELETE FROM notes WHERE user_id = $1", id); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx,
+			"DELETE FROM login_attempts WHERE email = (SELECT LOWER(email) FROM users WHERE id = $1)", id); err != nil {
+			return err
+		}
+		// Sessions, identities and recovery codes are removed by ON DELETE CASCADE
+		_, err := tx.ExecContext(ctx, "DELETE FROM users WHERE id = $1", id)
 		return err
-	}
-	user.Password = string(hashedPassword)
+	})
+}
+
+// SetEmailVerified marks the user's email as verified.
+func (u *UserRepository) SetEmailVerified(ctx context.Context, id int) error {
+	ctx, cancel := withTimeout(ctx, u.Timeout)
+	defer cancel()
 
-	_, err = u.DB.Exec(
-		"INSERT INTO users (email,password,role) VALUES ($1, $2,$3)",
-		user.Email, user.Password, user.Role)
+	_, err := u.DB.ExecContext(ctx, "UPDATE users SET email_verified = TRUE WHERE id = $1", id)
 	return err
 }
 
-// GetUserByEmail retrieves a user by their email.
-func (u *UserRepository) GetUserByEmail(email string) (*models.User, error) {
+// SetVerificationSentAt records when the last verification email was sent.
+func (u *UserRepository) SetVerificationSentAt(ctx context.Context, id int, sentAt time.Time) error {
+	ctx, cancel := withTimeout(ctx, u.Timeout)
+	defer cancel()
+
+	_, err := u.DB.ExecContext(ctx, "UPDATE users SET verification_sent_at = $1 WHERE id = $2", sentAt, id)
+	return err
+}
+
+// SetTOTPSecret stores a new, not yet enabled TOTP secret for the user.
+func (u *UserRepository) SetTOTPSecret(ctx context.Context, id int, secret string) error {
+	ctx, cancel := withTimeout(ctx, u.Timeout)
+	defer cancel()
+
+	_, err := u.DB.ExecContext(ctx,
+		"UPDATE users SET totp_secret = $1, totp_enabled = FALSE, totp_last_step = 0 WHERE id = $2",
+		secret, id)
+	return err
+}
+
+// EnableTOTP turns on two-factor authentication and replaces the user's recovery codes.
+func (u *UserRepository) EnableTOTP(ctx context.Context, id int, lastStep int64, recoveryCodeHashes []string) error {
+	ctx, cancel := withTimeout(ctx, u.Timeout)
+	defer cancel()
+
+	return inTx(ctx, u.DB, func(tx dbtx) error {
+		if _, err := tx.ExecContext(ctx,
+			"UPDATE users SET totp_enabled = TRUE, totp_last_step = $1 WHERE id = $2",
+			lastStep, id); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, "DELETE FROM recovery_codes WHERE user_id = $1", id); err != nil {
+			return err
+		}
+		for _, hash := range recoveryCodeHashes {
+			if _, err := tx.ExecContext(ctx,
+				"INSERT INTO recovery_codes (user_id, code_hash) VALUES ($1, $2)",
+				id, hash); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// SetTOTPLastStep records the time step of the last accepted TOTP code if it is later than the
+// recorded one, and reports whether it was. Concurrent requests can't both use the same step.
+func (u *UserRepository) SetTOTPLastStep(ctx context.Context, id int, step int64) (bool, error) {
+	ctx, cancel := withTimeout(ctx, u.Timeout)
+	defer cancel()
+
+	res, err := u.DB.ExecContext(ctx,
+		"UPDATE users SET totp_last_step = $1 WHERE id = $2 AND totp_last_step < $1", step, id)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// UseRecoveryCode marks an unused recovery code as used and reports whether it was found.
+func (u *UserRepository) UseRecoveryCode(ctx context.Context, id int, codeHash string) (bool, error) {
+	ctx, cancel := withTimeout(ctx, u.Timeout)
+	defer cancel()
+
+	res, err := u.DB.ExecContext(ctx,
+		"UPDATE recovery_codes SET used_at = NOW() WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL",
+		id, codeHash)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+func (u *UserRepository) getUser(ctx context.Context, query string, args ...interface{}) (*models.User, error) {
+	ctx, cancel := withTimeout(ctx, u.Timeout)
+	defer cancel()
+
 	var user models.User
+	var sentAt sql.NullTime
 
-	err := u.DB.QueryRow(
-		"SELECT id, email, password, role FROM users WHERE email = $1", email).
-		Scan(&user.ID, &user.Email, &user.Password, &user.Role)
+	err := u.DB.QueryRowContext(ctx, query, args...).
+		Scan(&user.ID, &user.Email, &user.Password, &user.Role, &user.EmailVerified, &user.PendingEmail, &sentAt,
+			&user.TOTPSecret, &user.TOTPEnabled, &user.TOTPLastStep,
+			&user.DisplayName, &user.Locale, &user.Timezone, &user.SpellcheckEnabled, &user.SpellcheckLanguages)
 	if err != nil {
 		return nil, err
 	}
+	if sentAt.Valid {
+		user.VerificationSentAt = &sentAt.Time
+	}
 	return &user, nil
 }
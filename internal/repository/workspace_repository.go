@@ -0,0 +1,227 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/ananikitina/notes-rest/internal/models"
+)
+
+// WorkspaceRepository handles workspaces, their members and invitations.
+type WorkspaceRepository struct {
+	DB      *sql.DB
+	Timeout time.Duration
+}
+
+// NewWorkspaceRepository creates a new workspace repository with the given database connection and query timeout.
+func NewWorkspaceRepository(DB *sql.DB, timeout time.Duration) *WorkspaceRepository {
+	return &WorkspaceRepository{DB: DB, Timeout: timeout}
+}
+
+// Create adds a new workspace with its owner as the first member and sets its ID.
+func (w *WorkspaceRepository) Create(ctx context.Context, workspace *models.Workspace) error {
+	ctx, cancel := withTimeout(ctx, w.Timeout)
+	defer cancel()
+
+	tx, err := w.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx,
+		"INSERT INTO workspaces (name, owner_id, created_at) VALUES ($1, $2, $3) RETURNING id",
+		workspace.Name, workspace.OwnerID, workspace.CreatedAt).Scan(&workspace.ID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx,
+		"INSERT INTO workspace_members (workspace_id, user_id, role, created_at) VALUES ($1, $2, $3, $4)",
+		workspace.ID, workspace.OwnerID, models.WorkspaceOwner, workspace.CreatedAt)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ListByUser retrieves the workspaces the user is a member of, with the user's role.
+func (w *WorkspaceRepository) ListByUser(ctx context.Context, userID int) ([]models.Workspace, error) {
+	ctx, cancel := withTimeout(ctx, w.Timeout)
+	defer cancel()
+
+	rows, err := w.DB.QueryContext(ctx, `
+	SELECT ws.id, ws.name, ws.owner_id, m.role, ws.created_at
+	FROM workspaces ws JOIN workspace_members m ON m.workspace_id = ws.id
+	WHERE m.user_id = $1
+	ORDER BY ws.name;
+`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	workspaces := []models.Workspace{}
+	for rows.Next() {
+		var workspace models.Workspace
+		if err := rows.Scan(&workspace.ID, &workspace.Name, &workspace.OwnerID, &workspace.Role, &workspace.CreatedAt); err != nil {
+			return nil, err
+		}
+		workspaces = append(workspaces, workspace)
+	}
+	return workspaces, rows.Err()
+}
+
+// GetMember retrieves the user's membership in the workspace.
+func (w *WorkspaceRepository) GetMember(ctx context.Context, workspaceID, userID int) (*models.WorkspaceMember, error) {
+	ctx, cancel := withTimeout(ctx, w.Timeout)
+	defer cancel()
+
+	var member models.WorkspaceMember
+	err := w.DB.QueryRowContext(ctx,
+		"SELECT workspace_id, user_id, role, created_at FROM workspace_members WHERE workspace_id = $1 AND user_id = $2",
+		workspaceID, userID).Scan(&member.WorkspaceID, &member.UserID, &member.Role, &member.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &member, nil
+}
+
+// ListMembers retrieves the members of the workspace with their emails.
+func (w *WorkspaceRepository) ListMembers(ctx context.Context, workspaceID int) ([]models.WorkspaceMember, error) {
+	ctx, cancel := withTimeout(ctx, w.Timeout)
+	defer cancel()
+
+	rows, err := w.DB.QueryContext(ctx, `
+	SELECT m.workspace_id, m.user_id, u.email, m.role, m.created_at
+	FROM workspace_members m JOIN users u ON u.id = m.user_id
+	WHERE m.workspace_id = $1
+	ORDER BY m.created_at;
+`, workspaceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	members := []models.WorkspaceMember{}
+	for rows.Next() {
+		var member models.WorkspaceMember
+		if err := rows.Scan(&member.WorkspaceID, &member.UserID, &member.Email, &member.Role, &member.CreatedAt); err != nil {
+			return nil, err
+		}
+		members = append(members, member)
+	}
+	return members, rows.Err()
+}
+
+// RemoveMember removes the user from the workspace and reports whether the user was a member.
+// The owner can't be removed.
+func (w *WorkspaceRepository) RemoveMember(ctx context.Context, workspaceID, userID int) (bool, error) {
+	ctx, cancel := withTimeout(ctx, w.Timeout)
+	defer cancel()
+
+	tx, err := w.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+	if err := lockChanges(ctx, tx); err != nil {
+		return false, err
+	}
+
+	res, err := tx.ExecContext(ctx,
+		"DELETE FROM workspace_members WHERE workspace_id = $1 AND user_id = $2 AND role <> $3",
+		workspaceID, userID, models.WorkspaceOwner)
+	if err != nil {
+		return false, err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return false, err
+	}
+	if err := hideNotes(ctx, tx, "n.workspace_id = $1", userID, workspaceID); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// CreateInvitation stores an invitation with the hash of its token and sets its ID.
+func (w *WorkspaceRepository) CreateInvitation(ctx context.Context, invitation *models.WorkspaceInvitation, tokenHash string) error {
+	ctx, cancel := withTimeout(ctx, w.Timeout)
+	defer cancel()
+
+	return w.DB.QueryRowContext(ctx, `
+	INSERT INTO workspace_invitations (workspace_id, email, role, token_hash, invited_by, created_at, expires_at)
+	VALUES ($1, $2, $3, $4, $5, $6, $7)
+	RETURNING id;
+`, invitation.WorkspaceID, invitation.Email, invitation.Role, tokenHash, invitation.InvitedBy,
+		invitation.CreatedAt, invitation.ExpiresAt).Scan(&invitation.ID)
+}
+
+// GetPendingInvitation retrieves an invitation by token hash that is neither accepted nor expired.
+func (w *WorkspaceRepository) GetPendingInvitation(ctx context.Context, tokenHash string) (*models.WorkspaceInvitation, error) {
+	ctx, cancel := withTimeout(ctx, w.Timeout)
+	defer cancel()
+
+	var invitation models.WorkspaceInvitation
+	var invitedBy sql.NullInt64
+	err := w.DB.QueryRowContext(ctx, `
+	SELECT id, workspace_id, email, role, invited_by, created_at, expires_at
+	FROM workspace_invitations
+	WHERE token_hash = $1 AND accepted_at IS NULL AND expires_at > NOW();
+`, tokenHash).Scan(&invitation.ID, &invitation.WorkspaceID, &invitation.Email, &invitation.Role, &invitedBy,
+		&invitation.CreatedAt, &invitation.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+	invitation.InvitedBy = int(invitedBy.Int64)
+	return &invitation, nil
+}
+
+// AcceptInvitation marks the invitation as accepted and adds the user to the workspace.
+// An existing membership keeps its role.
+func (w *WorkspaceRepository) AcceptInvitation(ctx context.Context, invitation *models.WorkspaceInvitation, userID int, at time.Time) error {
+	ctx, cancel := withTimeout(ctx, w.Timeout)
+	defer cancel()
+
+	tx, err := w.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := lockChanges(ctx, tx); err != nil {
+		return err
+	}
+
+	res, err := tx.ExecContext(ctx,
+		"UPDATE workspace_invitations SET accepted_at = $1 WHERE id = $2 AND accepted_at IS NULL",
+		at, invitation.ID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		// Accepted concurrently
+		if err == nil {
+			err = sql.ErrNoRows
+		}
+		return err
+	}
+
+	res, err = tx.ExecContext(ctx, `
+	INSERT INTO workspace_members (workspace_id, user_id, role, created_at)
+	VALUES ($1, $2, $3, $4)
+	ON CONFLICT (workspace_id, user_id) DO NOTHING;
+`, invitation.WorkspaceID, userID, invitation.Role, at)
+	if err != nil {
+		return err
+	}
+	added, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if added > 0 {
+		if err := revealNotes(ctx, tx, "n.workspace_id = $1", userID, invitation.WorkspaceID); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
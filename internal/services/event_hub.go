@@ -0,0 +1,65 @@
+package services
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+
+	"github.com/ananikitina/notes-rest/internal/models"
+)
+
+// subscriberBuffer is how many events a subscriber can fall behind before events are dropped.
+const subscriberBuffer = 32
+
+// Hub is an in-process event bus. On its own it only reaches subscribers of this instance.
+type Hub struct {
+	mu          sync.RWMutex
+	subscribers map[int]map[chan models.Event]struct{}
+}
+
+// NewHub creates an empty hub.
+func NewHub() *Hub {
+	return &Hub{subscribers: make(map[int]map[chan models.Event]struct{})}
+}
+
+// Publish delivers the event to the recipients subscribed to this hub.
+func (h *Hub) Publish(ctx context.Context, event models.Event, recipients []int) error {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for _, userID := range recipients {
+		for ch := range h.subscribers[userID] {
+			select {
+			case ch <- event:
+			default:
+				// Don't let a slow client block the others
+				slog.WarnContext(ctx, "Dropped event, subscriber is too slow", "type", event.Type, "user", userID)
+			}
+		}
+	}
+	return nil
+}
+
+// Subscribe registers a subscriber for the user's events.
+func (h *Hub) Subscribe(userID int) (<-chan models.Event, func()) {
+	ch := make(chan models.Event, subscriberBuffer)
+
+	h.mu.Lock()
+	if h.subscribers[userID] == nil {
+		h.subscribers[userID] = make(map[chan models.Event]struct{})
+	}
+	h.subscribers[userID][ch] = struct{}{}
+	h.mu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			h.mu.Lock()
+			delete(h.subscribers[userID], ch)
+			if len(h.subscribers[userID]) == 0 {
+				delete(h.subscribers, userID)
+			}
+			h.mu.Unlock()
+		})
+	}
+}
@@ -1,7 +1,11 @@
 package services
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
+	"fmt"
+	"sort"
 	"time"
 
 	"github.com/ananikitina/notes-rest/internal/config"
@@ -11,36 +15,134 @@ import (
 
 // JWTService struct for generating and validating JWT tokens.
 type JWTService struct {
-	secret string
-	issuer string
+	keys     []*signingKey // sorted by activation time
+	issuer   string
+	audience string
 }
 
 // NewJWTService creates a new JWT service with given configuration.
-func NewJWTService(cfg *config.Config) domain.JWTServiceInterface {
-	return &JWTService{secret: cfg.JWTSecret, issuer: "notes-rest"}
+// Tokens are signed with the RSA/Ed25519 keys from JWTKeys, or with JWTSecret (HS256) if no keys are configured.
+func NewJWTService(cfg *config.Config) (domain.JWTServiceInterface, error) {
+	keys, err := parseKeySpecs(cfg.JWTKeys)
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		if cfg.JWTSecret == "" {
+			return nil, errors.New("either JWT_KEYS or JWT_SECRET must be set")
+		}
+		secret := []byte(cfg.JWTSecret)
+		keys = []*signingKey{{kid: "hs256", method: jwt.SigningMethodHS256, private: secret, public: secret}}
+	}
+	sort.SliceStable(keys, func(i, j int) bool { return keys[i].notBefore.Before(keys[j].notBefore) })
+
+	return &JWTService{keys: keys, issuer: cfg.JWTIssuer, audience: cfg.JWTAudience}, nil
 }
 
 // GenerateToken creates a JWT token for a user.
-func (j *JWTService) GenerateToken(userID int, userRole string) (string, error) {
+func (j *JWTService) GenerateToken(userID int, userRole string, sessionID int) (string, error) {
 	claims := &domain.Claims{
-		UserID:   userID,
-		UserRole: userRole,
+		UserID:    userID,
+		UserRole:  userRole,
+		SessionID: sessionID,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour * 72)),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(domain.AccessTokenTTL)),
 			Issuer:    j.issuer,
+			Audience:  jwt.ClaimStrings{j.audience},
 		},
 	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(j.secret))
+	return j.sign(claims)
 }
 
 // ValidateToken validates the JWT token and returns the claims.
 func (j *JWTService) ValidateToken(tokenString string) (*domain.Claims, error) {
+	claims, err := j.parse(tokenString)
+	if err != nil {
+		return nil, err
+	}
+	// Scoped tokens must never be accepted as access tokens
+	if claims.Scope != "" {
+		return nil, errors.New("invalid token")
+	}
+	return claims, nil
+}
+
+// GenerateScopedToken creates a short-lived token that is only valid for the given scope.
+// Every token gets a random ID, so tokens issued at the same time are still distinct.
+func (j *JWTService) GenerateScopedToken(userID int, scope, email string, ttl time.Duration) (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", fmt.Errorf("failed to generate token ID: %w", err)
+	}
+	claims := &domain.Claims{
+		UserID: userID,
+		Scope:  scope,
+		Email:  email,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        hex.EncodeToString(id),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(ttl)),
+			Issuer:    j.issuer,
+			Audience:  jwt.ClaimStrings{j.audience},
+		},
+	}
+	return j.sign(claims)
+}
+
+// ValidateScopedToken validates a scoped token and returns the claims.
+func (j *JWTService) ValidateScopedToken(tokenString, scope string) (*domain.Claims, error) {
+	claims, err := j.parse(tokenString)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Scope != scope {
+		return nil, errors.New("invalid token scope")
+	}
+	return claims, nil
+}
+
+// PublicKeys returns all configured asymmetric keys, including the ones that are
+// not active yet or were rotated out, so verifiers can cache them in advance.
+func (j *JWTService) PublicKeys() domain.JSONWebKeySet {
+	set := domain.JSONWebKeySet{Keys: []domain.JSONWebKey{}}
+	for _, key := range j.keys {
+		if jwk, ok := key.jwk(); ok {
+			set.Keys = append(set.Keys, jwk)
+		}
+	}
+	return set
+}
+
+// currentKey returns the most recently activated key. Before the first
+// activation time the earliest key is used.
+func (j *JWTService) currentKey(now time.Time) *signingKey {
+	current := j.keys[0]
+	for _, key := range j.keys[1:] {
+		if key.notBefore.After(now) {
+			break
+		}
+		current = key
+	}
+	return current
+}
+
+func (j *JWTService) sign(claims *domain.Claims) (string, error) {
+	key := j.currentKey(time.Now())
+	token := jwt.NewWithClaims(key.method, claims)
+	token.Header["kid"] = key.kid
+	return token.SignedString(key.private)
+}
+
+func (j *JWTService) parse(tokenString string) (*domain.Claims, error) {
 	token, err := jwt.ParseWithClaims(tokenString, &domain.Claims{}, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+		key, err := j.keyFor(token)
+		if err != nil {
+			return nil, err
+		}
+		// The algorithm must match the key, otherwise a public key could be used as an HMAC secret
+		if token.Method.Alg() != key.method.Alg() {
 			return nil, errors.New("unexpected signing method")
 		}
-		return []byte(j.secret), nil
+		return key.public, nil
 	})
 	if err != nil {
 		return nil, err
@@ -49,5 +151,22 @@ func (j *JWTService) ValidateToken(tokenString string) (*domain.Claims, error) {
 	if !ok || !token.Valid {
 		return nil, errors.New("invalid token")
 	}
+	if !claims.VerifyIssuer(j.issuer, true) {
+		return nil, errors.New("invalid token issuer")
+	}
+	if !claims.VerifyAudience(j.audience, true) {
+		return nil, errors.New("invalid token audience")
+	}
 	return claims, nil
 }
+
+// keyFor selects the verification key by the kid header.
+func (j *JWTService) keyFor(token *jwt.Token) (*signingKey, error) {
+	kid, _ := token.Header["kid"].(string)
+	for _, key := range j.keys {
+		if key.kid == kid {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown key id %q", kid)
+}
@@ -0,0 +1,124 @@
+package services
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ananikitina/notes-rest/internal/domain"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// signingKey is a key used to sign and verify tokens.
+type signingKey struct {
+	kid       string
+	method    jwt.SigningMethod
+	private   interface{} // *rsa.PrivateKey, ed25519.PrivateKey or []byte for HMAC
+	public    interface{} // *rsa.PublicKey, ed25519.PublicKey or []byte for HMAC
+	notBefore time.Time   // the key is used for signing from this time
+}
+
+// parseKeySpecs parses a comma separated list of "kid=path[@notBefore]" entries,
+// where notBefore is an RFC 3339 time, and loads the private keys from the PEM files.
+func parseKeySpecs(specs string) ([]*signingKey, error) {
+	var keys []*signingKey
+	seen := map[string]bool{}
+
+	for _, spec := range strings.Split(specs, ",") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+
+		kid, rest, ok := strings.Cut(spec, "=")
+		if !ok || kid == "" || rest == "" {
+			return nil, fmt.Errorf("invalid key spec %q, expected kid=path[@notBefore]", spec)
+		}
+		if seen[kid] {
+			return nil, fmt.Errorf("duplicate key id %q", kid)
+		}
+		seen[kid] = true
+
+		path, notBeforeStr, hasNotBefore := strings.Cut(rest, "@")
+		var notBefore time.Time
+		if hasNotBefore {
+			var err error
+			if notBefore, err = time.Parse(time.RFC3339, notBeforeStr); err != nil {
+				return nil, fmt.Errorf("invalid activation time for key %q: %w", kid, err)
+			}
+		}
+
+		key, err := loadPrivateKey(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load key %q: %w", kid, err)
+		}
+		key.kid = kid
+		key.notBefore = notBefore
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// loadPrivateKey reads an RSA or Ed25519 private key from a PEM file.
+func loadPrivateKey(path string) (*signingKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found in %s", path)
+	}
+
+	var parsed interface{}
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block type %q", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch key := parsed.(type) {
+	case *rsa.PrivateKey:
+		if key.N.BitLen() < 2048 {
+			return nil, fmt.Errorf("RSA key must be at least 2048 bits")
+		}
+		return &signingKey{method: jwt.SigningMethodRS256, private: key, public: &key.PublicKey}, nil
+	case ed25519.PrivateKey:
+		return &signingKey{method: jwt.SigningMethodEdDSA, private: key, public: key.Public()}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %T, use RSA or Ed25519", parsed)
+	}
+}
+
+// jwk converts the public part of the key to JWK format.
+func (k *signingKey) jwk() (domain.JSONWebKey, bool) {
+	jwk := domain.JSONWebKey{Kid: k.kid, Use: "sig", Alg: k.method.Alg()}
+
+	switch pub := k.public.(type) {
+	case *rsa.PublicKey:
+		jwk.Kty = "RSA"
+		jwk.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+		jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+	case ed25519.PublicKey:
+		jwk.Kty = "OKP"
+		jwk.Crv = "Ed25519"
+		jwk.X = base64.RawURLEncoding.EncodeToString(pub)
+	default:
+		// Symmetric keys are never published
+		return jwk, false
+	}
+	return jwk, true
+}
@@ -0,0 +1,261 @@
+package services
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ananikitina/notes-rest/internal/config"
+	"github.com/ananikitina/notes-rest/internal/domain"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+const testJWTSecret = "a secret that is long enough for HS256"
+
+// writeKey writes a private key in PKCS #8 PEM format and returns its path.
+func writeKey(t *testing.T, key interface{}) string {
+	t.Helper()
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "key.pem")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func rsaKey(t *testing.T, bits int) *rsa.PrivateKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, bits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func newJWTService(t *testing.T, keys string) *JWTService {
+	t.Helper()
+	service, err := NewJWTService(&config.Config{JWTSecret: testJWTSecret, JWTKeys: keys, JWTIssuer: "notes-rest", JWTAudience: "notes-rest"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return service.(*JWTService)
+}
+
+// tokenKid returns the kid header of a token without verifying it.
+func tokenKid(t *testing.T, token string) string {
+	t.Helper()
+	parsed, _, err := jwt.NewParser().ParseUnverified(token, &domain.Claims{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	kid, _ := parsed.Header["kid"].(string)
+	return kid
+}
+
+func TestJWTKeys(t *testing.T) {
+	rsaPath := writeKey(t, rsaKey(t, 2048))
+	_, edPrivate, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edPath := writeKey(t, edPrivate)
+
+	tests := []struct {
+		name string
+		keys string
+		kid  string
+		alg  string
+	}{
+		{name: "secret", keys: "", kid: "hs256", alg: "HS256"},
+		{name: "rsa", keys: "rsa=" + rsaPath, kid: "rsa", alg: "RS256"},
+		{name: "ed25519", keys: "ed=" + edPath, kid: "ed", alg: "EdDSA"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := newJWTService(t, tt.keys)
+			token, err := service.GenerateToken(1, "user", 2)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if kid := tokenKid(t, token); kid != tt.kid {
+				t.Errorf("kid = %q, want %q", kid, tt.kid)
+			}
+			claims, err := service.ValidateToken(token)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if claims.UserID != 1 || claims.UserRole != "user" || claims.SessionID != 2 {
+				t.Errorf("claims = %+v", claims)
+			}
+
+			published := service.PublicKeys().Keys
+			if tt.alg == "HS256" {
+				if len(published) != 0 {
+					t.Errorf("the secret is published: %+v", published)
+				}
+				return
+			}
+			if len(published) != 1 || published[0].Kid != tt.kid || published[0].Alg != tt.alg {
+				t.Fatalf("published keys = %+v", published)
+			}
+			// Other services verify tokens with the published key only
+			if _, err := jwt.Parse(token, func(*jwt.Token) (interface{}, error) { return publicKey(t, published[0]), nil }); err != nil {
+				t.Errorf("token can't be verified with the published key: %v", err)
+			}
+		})
+	}
+}
+
+// publicKey converts a published JWK back to a public key.
+func publicKey(t *testing.T, jwk domain.JSONWebKey) interface{} {
+	t.Helper()
+	decode := func(s string) []byte {
+		b, err := base64.RawURLEncoding.DecodeString(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+	switch jwk.Kty {
+	case "RSA":
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(decode(jwk.N)), E: int(new(big.Int).SetBytes(decode(jwk.E)).Int64())}
+	case "OKP":
+		return ed25519.PublicKey(decode(jwk.X))
+	}
+	t.Fatalf("unexpected key type %q", jwk.Kty)
+	return nil
+}
+
+func TestJWTKeyRotation(t *testing.T) {
+	oldPath, newPath := writeKey(t, rsaKey(t, 2048)), writeKey(t, rsaKey(t, 2048))
+	past := time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)
+	future := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+
+	// Before the new key is activated tokens are still signed with the old one
+	beforeRotation := newJWTService(t, fmt.Sprintf("old=%s,new=%s@%s", oldPath, newPath, future))
+	oldToken, err := beforeRotation.GenerateToken(1, "user", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kid := tokenKid(t, oldToken); kid != "old" {
+		t.Errorf("kid before the rotation = %q, want old", kid)
+	}
+	if keys := beforeRotation.PublicKeys().Keys; len(keys) != 2 {
+		t.Errorf("%d keys are published, want the upcoming key too", len(keys))
+	}
+
+	afterRotation := newJWTService(t, fmt.Sprintf("old=%s,new=%s@%s", oldPath, newPath, past))
+	newToken, err := afterRotation.GenerateToken(1, "user", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kid := tokenKid(t, newToken); kid != "new" {
+		t.Errorf("kid after the rotation = %q, want new", kid)
+	}
+	if _, err := afterRotation.ValidateToken(oldToken); err != nil {
+		t.Errorf("token signed with the old key is rejected after the rotation: %v", err)
+	}
+
+	// Once the old key is removed its tokens are no longer accepted
+	withoutOldKey := newJWTService(t, "new="+newPath)
+	if _, err := withoutOldKey.ValidateToken(oldToken); err == nil {
+		t.Error("token signed with a removed key is accepted")
+	}
+}
+
+func TestJWTRejectsInvalidTokens(t *testing.T) {
+	rsaPrivate := rsaKey(t, 2048)
+	service := newJWTService(t, "rsa="+writeKey(t, rsaPrivate))
+	claims := func() *domain.Claims {
+		return &domain.Claims{UserID: 1, UserRole: "admin", RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+			Issuer:    "notes-rest",
+			Audience:  jwt.ClaimStrings{"notes-rest"},
+		}}
+	}
+	sign := func(method jwt.SigningMethod, kid string, claims *domain.Claims, key interface{}) string {
+		token := jwt.NewWithClaims(method, claims)
+		token.Header["kid"] = kid
+		signed, err := token.SignedString(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signed
+	}
+	publicPEM, err := x509.MarshalPKIXPublicKey(&rsaPrivate.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wrongIssuer := claims()
+	wrongIssuer.Issuer = "someone-else"
+	wrongAudience := claims()
+	wrongAudience.Audience = jwt.ClaimStrings{"another-service"}
+	expired := claims()
+	expired.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Minute))
+	scoped, err := service.GenerateScopedToken(1, "mfa", "alice@example.com", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{name: "wrong issuer", token: sign(jwt.SigningMethodRS256, "rsa", wrongIssuer, rsaPrivate)},
+		{name: "wrong audience", token: sign(jwt.SigningMethodRS256, "rsa", wrongAudience, rsaPrivate)},
+		{name: "expired", token: sign(jwt.SigningMethodRS256, "rsa", expired, rsaPrivate)},
+		{name: "unknown kid", token: sign(jwt.SigningMethodRS256, "other", claims(), rsaPrivate)},
+		{name: "other key", token: sign(jwt.SigningMethodRS256, "rsa", claims(), rsaKey(t, 2048))},
+		// The public key is known to everyone and must not work as an HMAC secret
+		{name: "public key as secret", token: sign(jwt.SigningMethodHS256, "rsa", claims(), publicPEM)},
+		{name: "scoped token", token: scoped},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := service.ValidateToken(tt.token); err == nil {
+				t.Error("ValidateToken() accepted the token")
+			}
+		})
+	}
+
+	if _, err := service.ValidateScopedToken(scoped, "password_reset"); err == nil {
+		t.Error("ValidateScopedToken() accepted a token of another scope")
+	}
+}
+
+func TestParseKeySpecs(t *testing.T) {
+	rsaPath := writeKey(t, rsaKey(t, 2048))
+	tests := []struct {
+		name  string
+		specs string
+		err   string
+	}{
+		{name: "missing path", specs: "kid=", err: "invalid key spec"},
+		{name: "missing kid", specs: "=" + rsaPath, err: "invalid key spec"},
+		{name: "duplicate kid", specs: "a=" + rsaPath + ",a=" + rsaPath, err: "duplicate key id"},
+		{name: "invalid activation time", specs: "a=" + rsaPath + "@tomorrow", err: "invalid activation time"},
+		{name: "missing file", specs: "a=" + filepath.Join(t.TempDir(), "missing.pem"), err: "failed to load key"},
+		{name: "short rsa key", specs: "a=" + writeKey(t, rsaKey(t, 1024)), err: "at least 2048 bits"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := parseKeySpecs(tt.specs); err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("parseKeySpecs() error = %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,61 @@
+package services
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"net/smtp"
+	"strings"
+
+	"github.com/ananikitina/notes-rest/internal/config"
+	"github.com/ananikitina/notes-rest/internal/domain"
+)
+
+// SMTPMailer sends emails through an SMTP server.
+type SMTPMailer struct {
+	addr string
+	from string
+	auth smtp.Auth
+}
+
+// LogMailer writes emails to the application log instead of sending them (development).
+type LogMailer struct{}
+
+// NewMailer creates an SMTP mailer, or a log mailer if no SMTP host is configured.
+func NewMailer(cfg *config.Config) domain.Mailer {
+	if cfg.SMTPHost == "" {
+		return &LogMailer{}
+	}
+
+	var auth smtp.Auth
+	if cfg.SMTPUsername != "" {
+		auth = smtp.PlainAuth("", cfg.SMTPUsername, cfg.SMTPPassword, cfg.SMTPHost)
+	}
+	return &SMTPMailer{
+		addr: net.JoinHostPort(cfg.SMTPHost, cfg.SMTPPort),
+		from: cfg.SMTPFrom,
+		auth: auth,
+	}
+}
+
+// Send delivers a plain text email to the recipient.
+func (m *SMTPMailer) Send(to, subject, body string) error {
+	var msg strings.Builder
+	fmt.Fprintf(&msg, "From: %s\r\n", m.from)
+	fmt.Fprintf(&msg, "To: %s\r\n", to)
+	fmt.Fprintf(&msg, "Subject: %s\r\n", subject)
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=\"utf-8\"\r\n\r\n")
+	msg.WriteString(body)
+
+	if err := smtp.SendMail(m.addr, m.auth, m.from, []string{to}, []byte(msg.String())); err != nil {
+		return fmt.Errorf("failed to send email: %w", err)
+	}
+	return nil
+}
+
+// Send logs the email.
+func (m *LogMailer) Send(to, subject, body string) error {
+	slog.Info("Email", "to", to, "subject", subject, "body", body)
+	return nil
+}
@@ -0,0 +1,140 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/ananikitina/notes-rest/internal/config"
+	"github.com/ananikitina/notes-rest/internal/domain"
+	"github.com/ananikitina/notes-rest/internal/models"
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+// OIDCService implements the authorization code flow with PKCE for the configured providers.
+type OIDCService struct {
+	mu        sync.Mutex
+	providers map[string]*oidcProvider
+}
+
+type oidcProvider struct {
+	cfg         config.OIDCProviderConfig
+	redirectURL string
+
+	// Set on first use from the provider's discovery document
+	oauth2   *oauth2.Config
+	verifier *oidc.IDTokenVerifier
+}
+
+// NewOIDCService creates an OIDC service for the providers from the configuration.
+func NewOIDCService(cfg *config.Config) domain.OIDCService {
+	providers := make(map[string]*oidcProvider)
+	for _, p := range cfg.OIDCProviders {
+		providers[p.Name] = &oidcProvider{
+			cfg:         p,
+			redirectURL: fmt.Sprintf("%s/auth/oidc/%s/callback", strings.TrimSuffix(cfg.AppBaseURL, "/"), p.Name),
+		}
+	}
+	return &OIDCService{providers: providers}
+}
+
+// AuthCodeURL returns the provider URL the user is redirected to.
+func (o *OIDCService) AuthCodeURL(ctx context.Context, provider, state, nonce, codeVerifier string) (string, error) {
+	p, err := o.provider(ctx, provider)
+	if err != nil {
+		return "", err
+	}
+	return p.oauth2.AuthCodeURL(state, oidc.Nonce(nonce), oauth2.S256ChallengeOption(codeVerifier)), nil
+}
+
+// Exchange trades the code for tokens and returns the identity from the ID token.
+func (o *OIDCService) Exchange(ctx context.Context, provider, code, codeVerifier, nonce string) (*models.ExternalIdentity, error) {
+	p, err := o.provider(ctx, provider)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := p.oauth2.Exchange(ctx, code, oauth2.VerifierOption(codeVerifier))
+	if err != nil {
+		return nil, fmt.Errorf("failed to exchange code: %w", err)
+	}
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return nil, errors.New("no id_token in token response")
+	}
+	idToken, err := p.verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, fmt.Errorf("failed to verify ID token: %w", err)
+	}
+	if idToken.Nonce != nonce {
+		return nil, errors.New("invalid ID token nonce")
+	}
+
+	var claims map[string]interface{}
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, fmt.Errorf("failed to parse ID token claims: %w", err)
+	}
+
+	identity := &models.ExternalIdentity{
+		Provider: provider,
+		Subject:  idToken.Subject,
+		Groups:   stringList(claims[p.cfg.GroupsClaim]),
+	}
+	identity.Email, _ = claims["email"].(string)
+	// Some providers send email_verified as a string
+	switch verified := claims["email_verified"].(type) {
+	case bool:
+		identity.EmailVerified = verified
+	case string:
+		identity.EmailVerified = verified == "true"
+	}
+	return identity, nil
+}
+
+// provider returns the configured provider, fetching its discovery document on first use.
+func (o *OIDCService) provider(ctx context.Context, name string) (*oidcProvider, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	p, ok := o.providers[name]
+	if !ok {
+		return nil, domain.ErrUnknownProvider
+	}
+	if p.oauth2 != nil {
+		return p, nil
+	}
+
+	discovered, err := oidc.NewProvider(ctx, p.cfg.IssuerURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to discover OIDC provider %q: %w", name, err)
+	}
+	p.oauth2 = &oauth2.Config{
+		ClientID:     p.cfg.ClientID,
+		ClientSecret: p.cfg.ClientSecret,
+		Endpoint:     discovered.Endpoint(),
+		RedirectURL:  p.redirectURL,
+		Scopes:       p.cfg.Scopes,
+	}
+	p.verifier = discovered.Verifier(&oidc.Config{ClientID: p.cfg.ClientID})
+	return p, nil
+}
+
+// stringList converts a claim that is either a string or a list of strings.
+func stringList(claim interface{}) []string {
+	switch v := claim.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		var list []string
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				list = append(list, s)
+			}
+		}
+		return list
+	}
+	return nil
+}
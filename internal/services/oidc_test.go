@@ -0,0 +1,193 @@
+package services
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ananikitina/notes-rest/internal/config"
+	"github.com/ananikitina/notes-rest/internal/domain"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// mockProvider is a local OpenID Connect provider with discovery, JWKS and token endpoints.
+type mockProvider struct {
+	*httptest.Server
+	key *signingKey
+
+	mu sync.Mutex
+	// Code challenges of the issued codes, and the claims of the ID token returned for them
+	challenges map[string]string
+	claims     map[string]jwt.MapClaims
+}
+
+func newMockProvider(t *testing.T) *mockProvider {
+	t.Helper()
+	private := rsaKey(t, 2048)
+	p := &mockProvider{
+		key:        &signingKey{kid: "idp", method: jwt.SigningMethodRS256, private: private, public: &private.PublicKey},
+		challenges: map[string]string{},
+		claims:     map[string]jwt.MapClaims{},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"issuer":                                p.URL,
+			"authorization_endpoint":                p.URL + "/authorize",
+			"token_endpoint":                        p.URL + "/token",
+			"jwks_uri":                              p.URL + "/jwks",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		jwk, _ := p.key.jwk()
+		json.NewEncoder(w).Encode(domain.JSONWebKeySet{Keys: []domain.JSONWebKey{jwk}})
+	})
+	mux.HandleFunc("/token", p.token)
+	p.Server = httptest.NewServer(mux)
+	t.Cleanup(p.Close)
+	return p
+}
+
+// token checks the code and the PKCE verifier and returns the ID token for the code.
+func (p *mockProvider) token(w http.ResponseWriter, r *http.Request) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	code := r.PostFormValue("code")
+	challenge, ok := p.challenges[code]
+	delete(p.challenges, code)
+	verifier := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+	if r.PostFormValue("grant_type") != "authorization_code" || !ok || base64.RawURLEncoding.EncodeToString(verifier[:]) != challenge {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	token := jwt.NewWithClaims(p.key.method, p.claims[code])
+	token.Header["kid"] = p.key.kid
+	idToken, err := token.SignedString(p.key.private)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"access_token": "access", "token_type": "Bearer", "expires_in": 60, "id_token": idToken})
+}
+
+// authorize plays the user logging in at the authorization URL: it issues a code for
+// the PKCE challenge from the URL and returns the nonce from it.
+func (p *mockProvider) authorize(t *testing.T, authURL string, claims jwt.MapClaims) (code, nonce string) {
+	t.Helper()
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := u.Query()
+	if query.Get("client_id") != "notes" || query.Get("response_type") != "code" || query.Get("code_challenge_method") != "S256" {
+		t.Fatalf("unexpected authorization URL %s", authURL)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	code = "code-" + query.Get("state")
+	p.challenges[code] = query.Get("code_challenge")
+	p.claims[code] = claims
+	return code, query.Get("nonce")
+}
+
+func TestOIDCService(t *testing.T) {
+	ctx := context.Background()
+	provider := newMockProvider(t)
+	service := NewOIDCService(&config.Config{
+		AppBaseURL: "https://notes.example.com",
+		OIDCProviders: []config.OIDCProviderConfig{{
+			Name: "corp", IssuerURL: provider.URL, ClientID: "notes", ClientSecret: "secret",
+			Scopes: []string{"openid", "email"}, GroupsClaim: "groups",
+		}},
+	})
+
+	validClaims := func() jwt.MapClaims {
+		return jwt.MapClaims{
+			"iss": provider.URL, "aud": "notes", "sub": "subject-1",
+			"iat": time.Now().Unix(), "exp": time.Now().Add(time.Minute).Unix(),
+			"email": "alice@example.com", "email_verified": true, "groups": []string{"staff", "admins"},
+		}
+	}
+	otherKey := rsaKey(t, 2048)
+
+	tests := []struct {
+		name     string
+		claims   func(jwt.MapClaims)
+		verifier string // overrides the PKCE verifier
+		nonce    string // overrides the nonce passed to Exchange
+		sign     *rsa.PrivateKey
+		wantErr  bool
+	}{
+		{name: "valid"},
+		{name: "email_verified as a string", claims: func(c jwt.MapClaims) { c["email_verified"] = "true" }},
+		{name: "wrong verifier", verifier: "another verifier that is long enough for PKCE", wantErr: true},
+		{name: "wrong nonce", nonce: "another nonce", wantErr: true},
+		{name: "wrong issuer", claims: func(c jwt.MapClaims) { c["iss"] = "https://evil.example.com" }, wantErr: true},
+		{name: "wrong audience", claims: func(c jwt.MapClaims) { c["aud"] = "another-client" }, wantErr: true},
+		{name: "expired", claims: func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Minute).Unix() }, wantErr: true},
+		{name: "unknown signing key", sign: otherKey, wantErr: true},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			state, verifier, nonce := "state"+string(rune('a'+i)), "a PKCE verifier that is long enough for the spec "+tt.name, "nonce-"+tt.name
+			authURL, err := service.AuthCodeURL(ctx, "corp", state, nonce, verifier)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			claims := validClaims()
+			if tt.claims != nil {
+				tt.claims(claims)
+			}
+			code, sentNonce := provider.authorize(t, authURL, claims)
+			claims["nonce"] = sentNonce
+			if tt.sign != nil {
+				saved := provider.key.private
+				provider.key.private = tt.sign
+				t.Cleanup(func() { provider.key.private = saved })
+			}
+			if tt.verifier != "" {
+				verifier = tt.verifier
+			}
+			if tt.nonce != "" {
+				nonce = tt.nonce
+			}
+
+			identity, err := service.Exchange(ctx, "corp", code, verifier, nonce)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("Exchange() = %+v, want an error", identity)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if identity.Provider != "corp" || identity.Subject != "subject-1" || identity.Email != "alice@example.com" ||
+				!identity.EmailVerified || len(identity.Groups) != 2 || identity.Groups[1] != "admins" {
+				t.Errorf("Exchange() = %+v", identity)
+			}
+		})
+	}
+
+	if _, err := service.AuthCodeURL(ctx, "other", "state", "nonce", "verifier"); !errors.Is(err, domain.ErrUnknownProvider) {
+		t.Errorf("AuthCodeURL() of an unknown provider error = %v, want ErrUnknownProvider", err)
+	}
+}
@@ -0,0 +1,111 @@
+package services
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/ananikitina/notes-rest/internal/domain"
+	"github.com/ananikitina/notes-rest/internal/models"
+)
+
+// outboxBatchSize is how many events the relay handles in one transaction.
+const outboxBatchSize = 100
+
+// OutboxRelay passes the events committed to the outbox on to the event sinks.
+type OutboxRelay struct {
+	uow      domain.UnitOfWork
+	sinks    []domain.EventSink
+	interval time.Duration
+}
+
+// NewOutboxRelay creates a relay that checks the outbox for new events at the interval.
+func NewOutboxRelay(uow domain.UnitOfWork, sinks []domain.EventSink, interval time.Duration) *OutboxRelay {
+	return &OutboxRelay{uow: uow, sinks: sinks, interval: interval}
+}
+
+// Run relays events until the context is cancelled.
+func (r *OutboxRelay) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		relayed, err := r.RelayBatch(ctx)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to relay outbox events", "error", err)
+		}
+		// A full batch means more events may be waiting, so they are relayed without waiting
+		if relayed == outboxBatchSize && ctx.Err() == nil {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RelayBatch hands the oldest events to every sink and removes them from the outbox, returning how
+// many were relayed. If a sink fails, the batch stays in the outbox and is relayed again later, so
+// sinks can receive an event more than once.
+func (r *OutboxRelay) RelayBatch(ctx context.Context) (int, error) {
+	relayed := 0
+	err := r.uow.Do(ctx, func(tx domain.Tx) error {
+		events, err := tx.Outbox().FetchPending(ctx, outboxBatchSize)
+		if err != nil || len(events) == 0 {
+			return err
+		}
+
+		ids := make([]int64, len(events))
+		for i := range events {
+			for _, sink := range r.sinks {
+				if err := sink.Handle(ctx, &events[i]); err != nil {
+					return err
+				}
+			}
+			ids[i] = events[i].ID
+		}
+		if err := tx.Outbox().Delete(ctx, ids); err != nil {
+			return err
+		}
+		relayed = len(events)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return relayed, nil
+}
+
+// EventBusSink publishes relayed events to the subscribers of the event bus.
+type EventBusSink struct {
+	bus domain.EventBus
+}
+
+func NewEventBusSink(bus domain.EventBus) *EventBusSink {
+	return &EventBusSink{bus: bus}
+}
+
+// Handle publishes the event to its recipients. Spell checking failures are only sent to webhooks.
+// Streamed events are best effort, clients catch up with sync, so a failure is logged instead of
+// holding back the outbox.
+func (s *EventBusSink) Handle(ctx context.Context, event *models.DomainEvent) error {
+	if event.Event.Type == models.EventSpellcheckFailed {
+		return nil
+	}
+	if err := s.bus.Publish(ctx, event.Event, event.Recipients); err != nil {
+		slog.ErrorContext(ctx, "Failed to publish event", "type", event.Event.Type, "note", event.Event.NoteID, "error", err)
+	}
+	return nil
+}
+
+// LogSink writes relayed events to the application log.
+type LogSink struct{}
+
+// Handle logs the event.
+func (LogSink) Handle(ctx context.Context, event *models.DomainEvent) error {
+	slog.InfoContext(ctx, "Event", "id", event.ID, "type", event.Event.Type, "note", event.Event.NoteID,
+		"actor", event.Event.ActorID, "recipients", event.Recipients)
+	return nil
+}
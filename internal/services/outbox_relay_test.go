@@ -0,0 +1,107 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ananikitina/notes-rest/internal/domain"
+	"github.com/ananikitina/notes-rest/internal/models"
+	"github.com/ananikitina/notes-rest/internal/repository/memory"
+)
+
+// recordingSink records the IDs of the events it handles and fails while err is set.
+type recordingSink struct {
+	handled []int64
+	err     error
+}
+
+func (s *recordingSink) Handle(ctx context.Context, event *models.DomainEvent) error {
+	if s.err != nil {
+		return s.err
+	}
+	s.handled = append(s.handled, event.ID)
+	return nil
+}
+
+func addEvents(t *testing.T, uow domain.UnitOfWork, n int) {
+	t.Helper()
+	err := uow.Do(context.Background(), func(tx domain.Tx) error {
+		for i := 0; i < n; i++ {
+			if err := tx.Outbox().Add(context.Background(), &models.DomainEvent{
+				Event:      models.Event{Type: models.EventNoteCreated, NoteID: i + 1, ActorID: 1, At: time.Now()},
+				Recipients: []int{1},
+			}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOutboxRelay(t *testing.T) {
+	ctx := context.Background()
+	store := memory.NewStore()
+	first, second := &recordingSink{}, &recordingSink{}
+	relay := NewOutboxRelay(store, []domain.EventSink{first, second}, time.Second)
+	addEvents(t, store, outboxBatchSize+1)
+
+	// A failing sink keeps the whole batch in the outbox
+	second.err = errors.New("sink is down")
+	if relayed, err := relay.RelayBatch(ctx); !errors.Is(err, second.err) || relayed != 0 {
+		t.Fatalf("RelayBatch() with a failing sink = %d, %v, want 0, %v", relayed, err, second.err)
+	}
+	second.err = nil
+	first.handled = nil
+
+	for _, want := range []int{outboxBatchSize, 1, 0} {
+		relayed, err := relay.RelayBatch(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if relayed != want {
+			t.Errorf("RelayBatch() = %d, want %d", relayed, want)
+		}
+	}
+	for _, sink := range []*recordingSink{first, second} {
+		if len(sink.handled) != outboxBatchSize+1 {
+			t.Fatalf("sink handled %d events, want %d", len(sink.handled), outboxBatchSize+1)
+		}
+		for i := 1; i < len(sink.handled); i++ {
+			if sink.handled[i] <= sink.handled[i-1] {
+				t.Fatalf("sink handled events out of order: %v", sink.handled)
+			}
+		}
+	}
+}
+
+func TestEventBusSink(t *testing.T) {
+	ctx := context.Background()
+	hub := NewHub()
+	events, cancel := hub.Subscribe(2)
+	defer cancel()
+	sink := NewEventBusSink(hub)
+
+	for _, event := range []models.DomainEvent{
+		{Event: models.Event{Type: models.EventSpellcheckFailed, ActorID: 2}, Recipients: []int{2}},
+		{Event: models.Event{Type: models.EventNoteUpdated, NoteID: 7, ActorID: 1}, Recipients: []int{1, 2}},
+	} {
+		if err := sink.Handle(ctx, &event); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Spell checking failures are only sent to webhooks, so the note update is the first streamed event
+	select {
+	case event := <-events:
+		if event.Type != models.EventNoteUpdated || event.NoteID != 7 {
+			t.Errorf("got event %+v, want the note update", event)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("recipient got no event")
+	}
+}
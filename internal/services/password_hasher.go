@@ -0,0 +1,142 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/ananikitina/notes-rest/internal/config"
+	"github.com/ananikitina/notes-rest/internal/domain"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Supported password hashing algorithms.
+const (
+	HashBcrypt   = "bcrypt"
+	HashArgon2id = "argon2id"
+)
+
+// argon2Params are the Argon2id parameters stored in the PHC string of each hash.
+type argon2Params struct {
+	memory      uint32 // KiB
+	iterations  uint32
+	parallelism uint8
+}
+
+// PasswordHasher hashes new passwords with the configured algorithm and
+// verifies hashes made with any supported algorithm.
+type PasswordHasher struct {
+	algorithm  string
+	bcryptCost int
+	argon2     argon2Params
+}
+
+// NewPasswordHasher creates a password hasher with given configuration.
+func NewPasswordHasher(cfg *config.Config) (domain.PasswordHasher, error) {
+	h := &PasswordHasher{
+		algorithm:  cfg.PasswordHashAlgorithm,
+		bcryptCost: cfg.BcryptCost,
+		argon2: argon2Params{
+			memory:      uint32(cfg.Argon2MemoryKiB),
+			iterations:  uint32(cfg.Argon2Iterations),
+			parallelism: uint8(cfg.Argon2Parallelism),
+		},
+	}
+
+	switch h.algorithm {
+	case HashBcrypt:
+		if h.bcryptCost < bcrypt.MinCost || h.bcryptCost > bcrypt.MaxCost {
+			return nil, fmt.Errorf("bcrypt cost must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+		}
+	case HashArgon2id:
+		if h.argon2.memory < 8*uint32(h.argon2.parallelism) || h.argon2.iterations < 1 || h.argon2.parallelism < 1 {
+			return nil, errors.New("invalid argon2id parameters")
+		}
+	default:
+		return nil, fmt.Errorf("unsupported password hash algorithm %q", h.algorithm)
+	}
+	return h, nil
+}
+
+// Hash hashes the password with the configured algorithm.
+func (h *PasswordHasher) Hash(password string) (string, error) {
+	if h.algorithm == HashBcrypt {
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), h.bcryptCost)
+		return string(hash), err
+	}
+
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, h.argon2.iterations, h.argon2.memory, h.argon2.parallelism, 32)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, h.argon2.memory, h.argon2.iterations, h.argon2.parallelism,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// Verify checks the password against a bcrypt or Argon2id hash.
+func (h *PasswordHasher) Verify(hash, password string) (bool, error) {
+	if strings.HasPrefix(hash, "$argon2id$") {
+		params, salt, key, err := decodeArgon2(hash)
+		if err != nil {
+			return false, err
+		}
+		other := argon2.IDKey([]byte(password), salt, params.iterations, params.memory, params.parallelism, uint32(len(key)))
+		return subtle.ConstantTimeCompare(key, other) == 1, nil
+	}
+
+	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// NeedsRehash reports whether the hash doesn't match the configured algorithm and parameters.
+func (h *PasswordHasher) NeedsRehash(hash string) bool {
+	if strings.HasPrefix(hash, "$argon2id$") {
+		if h.algorithm != HashArgon2id {
+			return true
+		}
+		params, _, _, err := decodeArgon2(hash)
+		return err != nil || params != h.argon2
+	}
+
+	if h.algorithm != HashBcrypt {
+		return true
+	}
+	cost, err := bcrypt.Cost([]byte(hash))
+	return err != nil || cost < h.bcryptCost
+}
+
+// decodeArgon2 parses a "$argon2id$v=19$m=...,t=...,p=...$salt$key" hash.
+func decodeArgon2(hash string) (argon2Params, []byte, []byte, error) {
+	var params argon2Params
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 {
+		return params, nil, nil, errors.New("invalid argon2id hash")
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return params, nil, nil, errors.New("unsupported argon2 version")
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.memory, &params.iterations, &params.parallelism); err != nil {
+		return params, nil, nil, fmt.Errorf("invalid argon2id parameters: %w", err)
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, err
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return params, nil, nil, err
+	}
+	return params, salt, key, nil
+}
@@ -0,0 +1,97 @@
+package services
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ananikitina/notes-rest/internal/config"
+	"github.com/ananikitina/notes-rest/internal/domain"
+)
+
+func newPasswordHasher(t *testing.T, algorithm string, bcryptCost, argon2Memory int) domain.PasswordHasher {
+	t.Helper()
+	hasher, err := NewPasswordHasher(&config.Config{
+		PasswordHashAlgorithm: algorithm,
+		BcryptCost:            bcryptCost,
+		Argon2MemoryKiB:       argon2Memory,
+		Argon2Iterations:      1,
+		Argon2Parallelism:     1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hasher
+}
+
+func TestPasswordHasher(t *testing.T) {
+	bcrypt4 := newPasswordHasher(t, HashBcrypt, 4, 0)
+	bcrypt5 := newPasswordHasher(t, HashBcrypt, 5, 0)
+	argon64 := newPasswordHasher(t, HashArgon2id, 4, 64)
+	argon128 := newPasswordHasher(t, HashArgon2id, 4, 128)
+
+	hash := func(h domain.PasswordHasher) string {
+		t.Helper()
+		hash, err := h.Hash("correct-horse-battery")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return hash
+	}
+	bcryptHash, argonHash := hash(bcrypt4), hash(argon64)
+	if !strings.HasPrefix(argonHash, "$argon2id$v=19$m=64,t=1,p=1$") {
+		t.Errorf("argon2id hash %q", argonHash)
+	}
+	if hash(argon64) == argonHash {
+		t.Error("hashes of the same password are equal, the salt is not random")
+	}
+
+	// Every hasher verifies the hashes of every algorithm, so hashes can be upgraded on login
+	for _, h := range []domain.PasswordHasher{bcrypt4, argon64} {
+		for _, stored := range []string{bcryptHash, argonHash} {
+			if ok, err := h.Verify(stored, "correct-horse-battery"); !ok || err != nil {
+				t.Errorf("Verify(%q) with the right password = %v, %v", stored, ok, err)
+			}
+			if ok, err := h.Verify(stored, "wrong-horse-battery"); ok || err != nil {
+				t.Errorf("Verify(%q) with a wrong password = %v, %v", stored, ok, err)
+			}
+		}
+	}
+	if _, err := argon64.Verify("$argon2id$v=19$broken", "correct-horse-battery"); err == nil {
+		t.Error("Verify() of a malformed hash succeeded")
+	}
+
+	tests := []struct {
+		name   string
+		hasher domain.PasswordHasher
+		hash   string
+		want   bool
+	}{
+		{name: "same bcrypt cost", hasher: bcrypt4, hash: bcryptHash, want: false},
+		{name: "higher bcrypt cost", hasher: bcrypt5, hash: bcryptHash, want: true},
+		{name: "lower bcrypt cost", hasher: bcrypt4, hash: hash(bcrypt5), want: false},
+		{name: "bcrypt to argon2id", hasher: argon64, hash: bcryptHash, want: true},
+		{name: "argon2id to bcrypt", hasher: bcrypt4, hash: argonHash, want: true},
+		{name: "same argon2id parameters", hasher: argon64, hash: argonHash, want: false},
+		{name: "other argon2id parameters", hasher: argon128, hash: argonHash, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.hasher.NeedsRehash(tt.hash); got != tt.want {
+				t.Errorf("NeedsRehash() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewPasswordHasherRejectsInvalidSettings(t *testing.T) {
+	for _, cfg := range []config.Config{
+		{PasswordHashAlgorithm: "md5"},
+		{PasswordHashAlgorithm: HashBcrypt, BcryptCost: 2},
+		{PasswordHashAlgorithm: HashArgon2id, Argon2MemoryKiB: 64, Argon2Iterations: 0, Argon2Parallelism: 1},
+		{PasswordHashAlgorithm: HashArgon2id, Argon2MemoryKiB: 4, Argon2Iterations: 1, Argon2Parallelism: 1},
+	} {
+		if _, err := NewPasswordHasher(&cfg); err == nil {
+			t.Errorf("NewPasswordHasher(%+v) succeeded", cfg)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package services
+
+import (
+	"bufio"
+	_ "embed"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/ananikitina/notes-rest/internal/config"
+	"github.com/ananikitina/notes-rest/internal/domain"
+)
+
+//go:embed common_passwords.txt
+var commonPasswords string
+
+// bcryptMaxBytes is the longest password bcrypt can hash.
+const bcryptMaxBytes = 72
+
+// PasswordPolicy checks the length of new passwords and rejects common ones.
+type PasswordPolicy struct {
+	minLength int
+	maxBytes  int
+	blocklist map[string]bool
+}
+
+// NewPasswordPolicy creates a password policy with given configuration.
+func NewPasswordPolicy(cfg *config.Config) (domain.PasswordPolicy, error) {
+	p := &PasswordPolicy{minLength: cfg.PasswordMinLength, maxBytes: 1024, blocklist: map[string]bool{}}
+	if cfg.PasswordHashAlgorithm == HashBcrypt {
+		p.maxBytes = bcryptMaxBytes
+	}
+
+	p.addToBlocklist(strings.NewReader(commonPasswords))
+	if cfg.PasswordBlocklistFile != "" {
+		f, err := os.Open(cfg.PasswordBlocklistFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open password blocklist: %w", err)
+		}
+		defer f.Close()
+		if err := p.addToBlocklist(f); err != nil {
+			return nil, fmt.Errorf("failed to read password blocklist: %w", err)
+		}
+	}
+	return p, nil
+}
+
+// Validate returns an error describing why the password is not allowed.
+func (p *PasswordPolicy) Validate(password, email string) error {
+	if utf8.RuneCountInString(password) < p.minLength {
+		return fmt.Errorf("password must be at least %d characters long", p.minLength)
+	}
+	if len(password) > p.maxBytes {
+		return fmt.Errorf("password must be at most %d bytes long", p.maxBytes)
+	}
+
+	normalized := strings.ToLower(password)
+	if p.blocklist[normalized] {
+		return fmt.Errorf("password is too common")
+	}
+	if local, _, _ := strings.Cut(strings.ToLower(email), "@"); normalized == strings.ToLower(email) || normalized == local {
+		return fmt.Errorf("password must not match the email")
+	}
+	return nil
+}
+
+func (p *PasswordPolicy) addToBlocklist(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			p.blocklist[strings.ToLower(line)] = true
+		}
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,59 @@
+package services
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ananikitina/notes-rest/internal/config"
+)
+
+func TestPasswordPolicy(t *testing.T) {
+	blocklist := filepath.Join(t.TempDir(), "blocklist.txt")
+	if err := os.WriteFile(blocklist, []byte("company-name-2024\n\n  Notes-Rest-Password  \n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		algorithm string
+		password  string
+		err       string // empty if the password is allowed
+	}{
+		{name: "allowed", password: "correct-horse-battery"},
+		{name: "too short", password: "short", err: "at least 8 characters"},
+		{name: "length in characters", password: "пароль12"},
+		{name: "too long for bcrypt", algorithm: HashBcrypt, password: strings.Repeat("a", 73) + "-9", err: "at most 72 bytes"},
+		{name: "long with argon2id", algorithm: HashArgon2id, password: strings.Repeat("long-password-", 10)},
+		{name: "common", password: "password123", err: "too common"},
+		{name: "common in another case", password: "PASSWORD123", err: "too common"},
+		{name: "from the blocklist file", password: "notes-rest-password", err: "too common"},
+		{name: "email", password: "Alice.Smith@example.com", err: "must not match the email"},
+		{name: "local part of the email", password: "alice.smith", err: "must not match the email"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			algorithm := tt.algorithm
+			if algorithm == "" {
+				algorithm = HashBcrypt
+			}
+			policy, err := NewPasswordPolicy(&config.Config{PasswordMinLength: 8, PasswordHashAlgorithm: algorithm, PasswordBlocklistFile: blocklist})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = policy.Validate(tt.password, "alice.smith@example.com")
+			if tt.err == "" && err != nil {
+				t.Errorf("Validate() error = %v, want nil", err)
+			}
+			if tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+				t.Errorf("Validate() error = %v, want %q", err, tt.err)
+			}
+		})
+	}
+
+	if _, err := NewPasswordPolicy(&config.Config{PasswordHashAlgorithm: HashBcrypt, PasswordBlocklistFile: filepath.Join(t.TempDir(), "missing.txt")}); err == nil {
+		t.Error("NewPasswordPolicy() with a missing blocklist file succeeded")
+	}
+}
@@ -0,0 +1,179 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/ananikitina/notes-rest/internal/models"
+	"github.com/jackc/pgx/v5"
+)
+
+// eventChannel is the PostgreSQL NOTIFY channel for note events.
+const eventChannel = "note_events"
+
+// maxNotifyPayload is the largest payload PostgreSQL accepts in NOTIFY.
+const maxNotifyPayload = 8000
+
+// eventPayloadTTL is how long larger events are kept in event_payloads for the listeners to read.
+const eventPayloadTTL = 10 * time.Minute
+
+// eventEnvelope is the NOTIFY payload. An envelope too large for NOTIFY is stored in event_payloads
+// and only its ID is sent as Ref.
+type eventEnvelope struct {
+	Event      models.Event `json:"event"`
+	Recipients []int        `json:"recipients"`
+	Ref        int64        `json:"ref,omitempty"`
+}
+
+// Backoff of reconnecting the listener after its connection was lost.
+const (
+	listenMinDelay = time.Second
+	listenMaxDelay = time.Minute
+)
+
+// PostgresEventBus publishes events with NOTIFY and delivers the events received with LISTEN
+// to the subscribers of its hub, so every app instance sees the events of all instances.
+type PostgresEventBus struct {
+	db          *sql.DB
+	hub         *Hub
+	postgresURL string
+	stop        context.CancelFunc
+	stopped     chan struct{}
+}
+
+// NewPostgresEventBus starts listening for events on the database on a dedicated connection.
+func NewPostgresEventBus(db *sql.DB, postgresURL string) (*PostgresEventBus, error) {
+	ctx, stop := context.WithCancel(context.Background())
+	conn, err := listen(ctx, postgresURL)
+	if err != nil {
+		stop()
+		return nil, fmt.Errorf("failed to listen for events: %w", err)
+	}
+
+	bus := &PostgresEventBus{db: db, hub: NewHub(), postgresURL: postgresURL, stop: stop, stopped: make(chan struct{})}
+	go bus.run(ctx, conn)
+	return bus, nil
+}
+
+// listen opens a connection that listens on the event channel.
+func listen(ctx context.Context, postgresURL string) (*pgx.Conn, error) {
+	conn, err := pgx.Connect(ctx, postgresURL)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := conn.Exec(ctx, "LISTEN "+eventChannel); err != nil {
+		conn.Close(context.Background())
+		return nil, err
+	}
+	return conn, nil
+}
+
+// Publish sends the event to all app instances.
+func (b *PostgresEventBus) Publish(ctx context.Context, event models.Event, recipients []int) error {
+	payload, err := json.Marshal(eventEnvelope{Event: event, Recipients: recipients})
+	if err != nil {
+		return err
+	}
+	if len(payload) > maxNotifyPayload {
+		if payload, err = b.store(ctx, payload); err != nil {
+			return err
+		}
+	}
+	_, err = b.db.ExecContext(ctx, "SELECT pg_notify($1, $2)", eventChannel, string(payload))
+	return err
+}
+
+// store saves the envelope in event_payloads, removing the expired ones, and returns the
+// payload referencing it.
+func (b *PostgresEventBus) store(ctx context.Context, envelope []byte) ([]byte, error) {
+	if _, err := b.db.ExecContext(ctx, "DELETE FROM event_payloads WHERE created_at < $1", time.Now().Add(-eventPayloadTTL)); err != nil {
+		return nil, err
+	}
+	var ref eventEnvelope
+	if err := b.db.QueryRowContext(ctx, "INSERT INTO event_payloads (payload) VALUES ($1) RETURNING id", string(envelope)).Scan(&ref.Ref); err != nil {
+		return nil, err
+	}
+	return json.Marshal(ref)
+}
+
+// load returns the envelope of the notification payload, reading a referenced envelope from event_payloads.
+func (b *PostgresEventBus) load(ctx context.Context, payload string) (*eventEnvelope, error) {
+	var envelope eventEnvelope
+	if err := json.Unmarshal([]byte(payload), &envelope); err != nil {
+		return nil, err
+	}
+	if envelope.Ref == 0 {
+		return &envelope, nil
+	}
+
+	var stored []byte
+	if err := b.db.QueryRowContext(ctx, "SELECT payload FROM event_payloads WHERE id = $1", envelope.Ref).Scan(&stored); err != nil {
+		return nil, fmt.Errorf("failed to read event %d: %w", envelope.Ref, err)
+	}
+	envelope = eventEnvelope{}
+	if err := json.Unmarshal(stored, &envelope); err != nil {
+		return nil, err
+	}
+	return &envelope, nil
+}
+
+// Subscribe registers a subscriber for the user's events on this instance.
+func (b *PostgresEventBus) Subscribe(userID int) (<-chan models.Event, func()) {
+	return b.hub.Subscribe(userID)
+}
+
+// Close stops listening for events.
+func (b *PostgresEventBus) Close() error {
+	b.stop()
+	<-b.stopped
+	return nil
+}
+
+// run delivers the received notifications to the hub until the bus is closed, reconnecting when the
+// connection is lost. Events sent while reconnecting are lost.
+func (b *PostgresEventBus) run(ctx context.Context, conn *pgx.Conn) {
+	defer close(b.stopped)
+	for {
+		notification, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			conn.Close(context.Background())
+			if ctx.Err() != nil {
+				return
+			}
+			slog.Error("Event listener failed", "error", err)
+			if conn = b.reconnect(ctx); conn == nil {
+				return
+			}
+			continue
+		}
+
+		envelope, err := b.load(ctx, notification.Payload)
+		if err != nil {
+			slog.Error("Invalid event payload", "error", err)
+			continue
+		}
+		b.hub.Publish(ctx, envelope.Event, envelope.Recipients)
+	}
+}
+
+// reconnect listens again with exponential backoff. It returns nil when the bus is closed.
+func (b *PostgresEventBus) reconnect(ctx context.Context) *pgx.Conn {
+	delay := listenMinDelay
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(delay):
+		}
+		conn, err := listen(ctx, b.postgresURL)
+		if err == nil {
+			return conn
+		}
+		slog.Error("Event listener failed", "error", err)
+		delay = min(delay*2, listenMaxDelay)
+	}
+}
@@ -0,0 +1,60 @@
+package services
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/ananikitina/notes-rest/internal/config"
+	"github.com/ananikitina/notes-rest/internal/database"
+	"github.com/ananikitina/notes-rest/internal/models"
+)
+
+// TestPostgresEventBus runs against the database at TEST_POSTGRES_URL, which it wipes, and is skipped without it.
+func TestPostgresEventBus(t *testing.T) {
+	url := os.Getenv("TEST_POSTGRES_URL")
+	if url == "" {
+		t.Skip("TEST_POSTGRES_URL is not set")
+	}
+	db, err := database.OpenPostgres(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if _, err := db.Exec("DROP SCHEMA public CASCADE; CREATE SCHEMA public"); err != nil {
+		t.Fatal(err)
+	}
+	if err := database.Migrate(db, config.StorageDriverPostgres); err != nil {
+		t.Fatal(err)
+	}
+
+	bus, err := NewPostgresEventBus(db, url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { bus.Close() })
+	events, unsubscribe := bus.Subscribe(1)
+	defer unsubscribe()
+
+	ctx := context.Background()
+	// An event for a large workspace doesn't fit into a NOTIFY payload and is sent by reference
+	many := make([]int, 5000)
+	for i := range many {
+		many[i] = i + 1
+	}
+	for _, recipients := range [][]int{{1, 2}, many} {
+		event := models.Event{Type: models.EventNoteUpdated, NoteID: len(recipients), ActorID: 2, At: time.Now()}
+		if err := bus.Publish(ctx, event, recipients); err != nil {
+			t.Fatalf("Publish() to %d recipients error = %v", len(recipients), err)
+		}
+		select {
+		case got := <-events:
+			if got.NoteID != event.NoteID {
+				t.Errorf("got event for note %d, want %d", got.NoteID, event.NoteID)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("event to %d recipients wasn't delivered", len(recipients))
+		}
+	}
+}
@@ -2,13 +2,15 @@ package services
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
-	"os"
-	"time"
+
+	"github.com/ananikitina/notes-rest/internal/config"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 )
 
 // SpellCheckError represents the spelling error info
@@ -24,38 +26,38 @@ type SpellCheckError struct {
 
 // SpellChecker is an interface for spell checking service
 type SpellChecker interface {
-	Check(text string) ([]SpellCheckError, error)
+	Check(ctx context.Context, text, lang string) ([]SpellCheckError, error)
 }
 
 // YandexSpellChecker is an implementation of SpellChecker interface (logic)
 type YandexSpellChecker struct {
 	client *http.Client
+	apiURL string
 }
 
-// NewYandexSpellChecker creates a custom HTTP client with timeout
-func NewYandexSpellChecker() *YandexSpellChecker {
+// NewYandexSpellChecker creates a spell checker calling the configured API with a custom HTTP client with timeout.
+// Every call is traced as a client span whose trace context is sent to the API in the request headers.
+func NewYandexSpellChecker(cfg *config.Config) *YandexSpellChecker {
 	return &YandexSpellChecker{
 		client: &http.Client{
-			Timeout: 10 * time.Second,
+			Timeout: cfg.SpellcheckTimeout,
+			Transport: otelhttp.NewTransport(http.DefaultTransport,
+				otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string { return "spellcheck " + r.Method })),
 		},
+		apiURL: cfg.SpellcheckAPIURL,
 	}
 }
 
-// Check verifies the text for spelling errors using the external API
-func (ysc *YandexSpellChecker) Check(text string) ([]SpellCheckError, error) {
-	apiURL := os.Getenv("SPELLCHECK_API_URL")
-
-	if apiURL == "" {
-		return nil, fmt.Errorf("API URL is not set in the environment variables")
-	}
-
+// Check verifies the text for spelling errors using the external API.
+// lang is a comma separated list of languages, e.g. "ru,en".
+func (ysc *YandexSpellChecker) Check(ctx context.Context, text, lang string) ([]SpellCheckError, error) {
 	// Prepare the request parameters
 	data := url.Values{}
 	data.Set("text", text)
-	data.Set("lang", "ru,en")
+	data.Set("lang", lang)
 
 	// New POST request to the external API
-	req, err := http.NewRequest("POST", apiURL, bytes.NewBufferString(data.Encode()))
+	req, err := http.NewRequestWithContext(ctx, "POST", ysc.apiURL, bytes.NewBufferString(data.Encode()))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %v", err)
 	}
@@ -0,0 +1,119 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/ananikitina/notes-rest/internal/config"
+	"github.com/ananikitina/notes-rest/internal/models"
+)
+
+// Headers of webhook requests.
+const (
+	WebhookEventHeader     = "X-Webhook-Event"
+	WebhookDeliveryHeader  = "X-Webhook-Delivery"
+	WebhookTimestampHeader = "X-Webhook-Timestamp"
+	WebhookSignatureHeader = "X-Webhook-Signature"
+)
+
+var errPrivateAddress = errors.New("webhook URL resolves to a private address")
+
+// HTTPWebhookSender posts webhook deliveries as signed JSON requests.
+type HTTPWebhookSender struct {
+	client *http.Client
+}
+
+// NewWebhookSender creates a webhook sender. Unless private networks are allowed, connections to
+// loopback, private and link-local addresses are refused after DNS resolution.
+func NewWebhookSender(cfg *config.Config) *HTTPWebhookSender {
+	dialer := &net.Dialer{Timeout: cfg.WebhookTimeout}
+	if !cfg.WebhookAllowPrivateNetworks {
+		dialer.Control = func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || isPrivateIP(ip) {
+				return errPrivateAddress
+			}
+			return nil
+		}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &HTTPWebhookSender{
+		client: &http.Client{
+			Timeout:   cfg.WebhookTimeout,
+			Transport: transport,
+			// Redirects could lead to addresses the webhook owner couldn't register
+			CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+		},
+	}
+}
+
+// Send posts the delivery to its webhook. Responses other than 2xx are returned as errors along
+// with their status code.
+func (s *HTTPWebhookSender) Send(ctx context.Context, delivery *models.WebhookDelivery) (int, error) {
+	body, err := json.Marshal(map[string]interface{}{
+		"id":        delivery.ID,
+		"type":      delivery.EventType,
+		"createdAt": delivery.CreatedAt,
+		"data":      delivery.Payload,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.Webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "notes-rest-webhooks")
+	req.Header.Set(WebhookEventHeader, delivery.EventType)
+	req.Header.Set(WebhookDeliveryHeader, strconv.FormatInt(delivery.ID, 10))
+	req.Header.Set(WebhookTimestampHeader, timestamp)
+	req.Header.Set(WebhookSignatureHeader, SignWebhook(delivery.Webhook.Secret, timestamp, body))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("webhook responded with status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// SignWebhook returns the signature header value of a webhook request: the hex HMAC-SHA256 of
+// the timestamp, a dot and the body, keyed with the webhook secret.
+func SignWebhook(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func isPrivateIP(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsUnspecified() || ip.IsMulticast()
+}
@@ -0,0 +1,58 @@
+// Package tracing sets up the OpenTelemetry traces of the service.
+package tracing
+
+import (
+	"context"
+
+	"github.com/ananikitina/notes-rest/internal/config"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ServiceName identifies the service in the exported spans.
+const ServiceName = "notes-rest"
+
+// Setup installs the global tracer provider with the configured exporter and the W3C trace context
+// propagator, so traces continue across the services that call us and that we call. With the "none"
+// exporter spans are not recorded. The returned function exports the remaining spans and stops the exporter.
+func Setup(ctx context.Context, cfg *config.Config) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch cfg.TracingExporter {
+	case config.TracingExporterStdout:
+		exporter, err = stdouttrace.New()
+	case config.TracingExporterOTLP:
+		exporter, err = otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(cfg.TracingOTLPEndpoint))
+	default:
+		return func(context.Context) error { return nil }, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(ServiceName)))
+	if err != nil {
+		return nil, err
+	}
+	provider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter), sdktrace.WithResource(res))
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// End records the error, if any, on the span and ends it.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,110 @@
+package usecases
+
+import (
+	"context"
+	"time"
+
+	"github.com/ananikitina/notes-rest/internal/config"
+	"github.com/ananikitina/notes-rest/internal/domain"
+	"github.com/ananikitina/notes-rest/internal/models"
+)
+
+// LoginThrottledError is returned when login attempts are temporarily blocked.
+type LoginThrottledError struct {
+	RetryAfter time.Duration
+	Locked     bool // the account is locked, not just delayed
+}
+
+func (e *LoginThrottledError) Error() string {
+	if e.Locked {
+		return "account is temporarily locked due to too many failed login attempts"
+	}
+	return "too many login attempts, try again later"
+}
+
+// loginThrottle delays and then blocks repeated failed logins for an email or from an IP.
+// Wrong passwords and wrong two-factor codes count alike, and so do wrong public link passwords.
+type loginThrottle struct {
+	attemptRepo domain.LoginAttemptRepository
+	cfg         *config.Config
+}
+
+func newLoginThrottle(attemptRepo domain.LoginAttemptRepository, cfg *config.Config) *loginThrottle {
+	return &loginThrottle{attemptRepo: attemptRepo, cfg: cfg}
+}
+
+// check returns a LoginThrottledError if the next attempt for the email or IP is not allowed yet.
+func (l *loginThrottle) check(ctx context.Context, email, ip string) error {
+	now := time.Now()
+	if err := l.checkIP(ctx, ip, now); err != nil {
+		return err
+	}
+	failures, lastFailure, err := l.attemptRepo.CountFailuresByEmail(ctx, email, now.Add(-l.cfg.LoginAttemptWindow))
+	if err != nil {
+		return err
+	}
+	return l.delay(failures, lastFailure, now)
+}
+
+// checkToken returns a LoginThrottledError if the next password attempt with the token, such as
+// a public link token, or from the IP is not allowed yet. Failures of a token are delayed and
+// locked out like failures of an email.
+func (l *loginThrottle) checkToken(ctx context.Context, tokenHash, ip string) error {
+	now := time.Now()
+	if err := l.checkIP(ctx, ip, now); err != nil {
+		return err
+	}
+	failures, lastFailure, err := l.attemptRepo.CountFailuresByToken(ctx, tokenHash, now.Add(-l.cfg.LoginAttemptWindow))
+	if err != nil {
+		return err
+	}
+	return l.delay(failures, lastFailure, now)
+}
+
+// checkIP returns a LoginThrottledError if the IP has too many failures within the window.
+func (l *loginThrottle) checkIP(ctx context.Context, ip string, now time.Time) error {
+	ipFailures, firstIPFailure, err := l.attemptRepo.CountFailuresByIP(ctx, ip, now.Add(-l.cfg.LoginAttemptWindow))
+	if err != nil {
+		return err
+	}
+	if ipFailures >= l.cfg.LoginMaxAttemptsPerIP {
+		return &LoginThrottledError{RetryAfter: firstIPFailure.Add(l.cfg.LoginAttemptWindow).Sub(now)}
+	}
+	return nil
+}
+
+// delay returns a LoginThrottledError if the next attempt after the failures is not allowed yet.
+func (l *loginThrottle) delay(failures int, lastFailure, now time.Time) error {
+	if failures >= l.cfg.LoginMaxAttempts {
+		if lockedUntil := lastFailure.Add(l.cfg.LoginLockoutDuration); now.Before(lockedUntil) {
+			return &LoginThrottledError{RetryAfter: lockedUntil.Sub(now), Locked: true}
+		}
+		return nil
+	}
+	if failures > 0 {
+		// Progressive delay: 1s, 2s, 4s... after each consecutive failure, up to the lockout duration.
+		// The shift is capped too, 2^30s is longer than any lockout and doesn't overflow.
+		delay := min(time.Second<<min(failures-1, 30), l.cfg.LoginLockoutDuration)
+		if nextAttempt := lastFailure.Add(delay); now.Before(nextAttempt) {
+			return &LoginThrottledError{RetryAfter: nextAttempt.Sub(now)}
+		}
+	}
+	return nil
+}
+
+// recordFailure stores the failed attempt and returns the failure for the caller.
+func (l *loginThrottle) recordFailure(ctx context.Context, attempt models.LoginAttempt, failure error) error {
+	attempt.Success, attempt.CreatedAt = false, time.Now()
+	if err := l.attemptRepo.Add(ctx, attempt); err != nil {
+		return err
+	}
+	return failure
+}
+
+// recordSuccess stores the successful login and clears the failures of the email.
+func (l *loginThrottle) recordSuccess(ctx context.Context, email, ip string) error {
+	if err := l.attemptRepo.Add(ctx, models.LoginAttempt{Email: email, IP: ip, Success: true, CreatedAt: time.Now()}); err != nil {
+		return err
+	}
+	return l.attemptRepo.ClearFailures(ctx, email)
+}
@@ -0,0 +1,141 @@
+package usecases
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ananikitina/notes-rest/internal/config"
+	"github.com/ananikitina/notes-rest/internal/models"
+	"github.com/ananikitina/notes-rest/internal/repository/memory"
+)
+
+func TestLoginThrottle(t *testing.T) {
+	cfg := &config.Config{
+		LoginMaxAttempts:      5,
+		LoginMaxAttemptsPerIP: 20,
+		LoginAttemptWindow:    time.Hour,
+		LoginLockoutDuration:  15 * time.Minute,
+	}
+	tests := []struct {
+		name        string
+		maxAttempts int
+		failures    int
+		ip          string
+		ago         time.Duration // since the failures
+		retryAfter  time.Duration // zero if the attempt is allowed
+		locked      bool
+	}{
+		{name: "no failures", failures: 0, ago: 0},
+		{name: "first failure delays", failures: 1, ago: 0, retryAfter: time.Second},
+		{name: "delay doubles", failures: 3, ago: time.Second, retryAfter: 3 * time.Second},
+		{name: "delay passed", failures: 3, ago: 5 * time.Second},
+		{name: "lockout", failures: 5, ago: time.Minute, retryAfter: 14 * time.Minute, locked: true},
+		{name: "lockout passed", failures: 5, ago: 16 * time.Minute},
+		{name: "delay is capped by the lockout", maxAttempts: 100, failures: 80, ago: 10 * time.Minute, retryAfter: 5 * time.Minute},
+		{name: "ip limit", failures: 20, ip: "10.0.0.9", ago: 30 * time.Minute, retryAfter: 30 * time.Minute},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			cfg := *cfg
+			if tt.maxAttempts != 0 {
+				cfg.LoginMaxAttempts = tt.maxAttempts
+			}
+			repo := memory.NewStore().LoginAttempts()
+			at := time.Now().Add(-tt.ago)
+			for i := 0; i < tt.failures; i++ {
+				// Failures from the IP are for other emails, so only the IP limit applies to them
+				email := "alice@example.com"
+				if tt.ip != "" {
+					email = "other@example.com"
+				}
+				ip := tt.ip
+				if ip == "" {
+					ip = "10.0.0.1"
+				}
+				if err := repo.Add(ctx, models.LoginAttempt{Email: email, IP: ip, CreatedAt: at}); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			ip := tt.ip
+			if ip == "" {
+				ip = "10.0.0.2"
+			}
+			err := newLoginThrottle(repo, &cfg).check(ctx, "alice@example.com", ip)
+			var throttled *LoginThrottledError
+			if tt.retryAfter == 0 {
+				if err != nil {
+					t.Fatalf("check() error = %v, want nil", err)
+				}
+				return
+			}
+			if !errors.As(err, &throttled) {
+				t.Fatalf("check() error = %v, want LoginThrottledError", err)
+			}
+			if diff := throttled.RetryAfter - tt.retryAfter; diff > time.Second || diff < -time.Second || throttled.Locked != tt.locked {
+				t.Errorf("check() = %+v, want retry after %v, locked %v", throttled, tt.retryAfter, tt.locked)
+			}
+		})
+	}
+}
+
+func TestLoginThrottleToken(t *testing.T) {
+	ctx := context.Background()
+	cfg := &config.Config{
+		LoginMaxAttempts:      3,
+		LoginMaxAttemptsPerIP: 5,
+		LoginAttemptWindow:    time.Hour,
+		LoginLockoutDuration:  15 * time.Minute,
+	}
+	repo := memory.NewStore().LoginAttempts()
+	throttle := newLoginThrottle(repo, cfg)
+
+	throttled := func(tokenHash, ip string) bool {
+		t.Helper()
+		err := throttle.checkToken(ctx, tokenHash, ip)
+		var throttledErr *LoginThrottledError
+		if err != nil && !errors.As(err, &throttledErr) {
+			t.Fatal(err)
+		}
+		return err != nil
+	}
+	fail := func(tokenHash, ip string, ago time.Duration) {
+		t.Helper()
+		if err := repo.Add(ctx, models.LoginAttempt{IP: ip, TokenHash: tokenHash, CreatedAt: time.Now().Add(-ago)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	fail("link", "10.0.0.1", 0)
+	if !throttled("link", "10.0.0.2") {
+		t.Error("token is not delayed after a failure from another IP")
+	}
+	if throttled("other-link", "10.0.0.2") {
+		t.Error("failures of one token delay another token")
+	}
+
+	fail("link", "10.0.0.1", time.Minute)
+	fail("link", "10.0.0.1", time.Minute)
+	if !throttled("link", "10.0.0.3") {
+		t.Error("token is not locked after LoginMaxAttempts failures")
+	}
+
+	for i := 0; i < 2; i++ {
+		fail("link-"+string(rune('a'+i)), "10.0.0.1", time.Minute)
+	}
+	if !throttled("another-link", "10.0.0.1") {
+		t.Error("IP is not blocked after LoginMaxAttemptsPerIP failures with different tokens")
+	}
+}
+
+func TestNormalizeEmail(t *testing.T) {
+	for _, email := range []string{"alice@example.com", "Alice@Example.COM", " alice@example.com\n"} {
+		if got := normalizeEmail(email); got != "alice@example.com" {
+			t.Errorf("normalizeEmail(%q) = %q, want alice@example.com", email, got)
+		}
+	}
+}
@@ -1,28 +1,469 @@
 package usecases
 
 import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
 	"github.com/ananikitina/notes-rest/internal/domain"
 	"github.com/ananikitina/notes-rest/internal/models"
+	"github.com/ananikitina/notes-rest/internal/tracing"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var (
+	ErrWorkspaceReadOnly = errors.New("viewers can't change notes of the workspace")
+	ErrNoteNotFound      = errors.New("note not found")
+	ErrNoteReadOnly      = errors.New("you don't have permission to edit this note")
+	ErrNotNoteOwner      = errors.New("only the owner can manage the note")
+	ErrShareNotFound     = errors.New("share not found")
+	ErrNoteConflict      = errors.New("note was changed since")
+)
+
+// Access levels of a user to a note, in increasing order.
+const (
+	accessNone = iota
+	accessViewer
+	accessEditor
+	accessOwner
 )
 
+// noteTracer traces the note operations, so their time can be told apart from the spell checker's.
+var noteTracer = otel.Tracer("github.com/ananikitina/notes-rest/internal/usecases")
+
 // NoteUseCase represents the business logic for notes.
 type NoteUseCase struct {
-	noteRepo domain.NoteRepository
+	noteRepo      domain.NoteRepository
+	workspaceRepo domain.WorkspaceRepository
+	userRepo      domain.UserRepository
+	uow           domain.UnitOfWork
 }
 
 // NewNoteUseCase creates a new instance of NoteUseCase.
-func NewNoteUseCase(noteRepo domain.NoteRepository) *NoteUseCase {
-	return &NoteUseCase{noteRepo: noteRepo}
+// Changes of notes are saved together with their events through the unit of work.
+func NewNoteUseCase(noteRepo domain.NoteRepository, workspaceRepo domain.WorkspaceRepository, userRepo domain.UserRepository,
+	uow domain.UnitOfWork) *NoteUseCase {
+	return &NoteUseCase{noteRepo: noteRepo, workspaceRepo: workspaceRepo, userRepo: userRepo, uow: uow}
+}
+
+// AddNote adds a private note, or a note of the workspace if member is not nil.
+func (n *NoteUseCase) AddNote(ctx context.Context, note *models.Note, member *models.WorkspaceMember) (err error) {
+	ctx, span := noteTracer.Start(ctx, "NoteUseCase.AddNote", trace.WithAttributes(attribute.Int("user.id", note.UserID)))
+	defer func() { tracing.End(span, err) }()
+
+	note.WorkspaceID = nil
+	if member != nil {
+		if !member.CanEdit() {
+			return ErrWorkspaceReadOnly
+		}
+		note.WorkspaceID = &member.WorkspaceID
+	}
+	// Recipients are resolved before the transaction, which must not wait for another connection
+	recipients, err := n.recipients(ctx, note)
+	if err != nil {
+		return err
+	}
+	return n.uow.Do(ctx, func(tx domain.Tx) error {
+		if err := tx.Notes().Add(ctx, note); err != nil {
+			return err
+		}
+		return n.recordNoteEvent(ctx, tx, models.EventNoteCreated, note, note.UserID, recipients)
+	})
+}
+
+// GetNotes returns the user's private notes, or the notes of the workspace if member is not nil.
+// A non-empty query limits the notes to those matching the full-text search.
+func (n *NoteUseCase) GetNotes(ctx context.Context, userID int, member *models.WorkspaceMember, query string) (notes []models.Note, err error) {
+	ctx, span := noteTracer.Start(ctx, "NoteUseCase.GetNotes", trace.WithAttributes(attribute.Int("user.id", userID)))
+	defer func() { tracing.End(span, err) }()
+
+	if member != nil {
+		return n.noteRepo.GetByWorkspaceID(ctx, member.WorkspaceID, query)
+	}
+	return n.noteRepo.GetByUserID(ctx, userID, query)
+}
+
+// GetNote returns a note the user can read.
+func (n *NoteUseCase) GetNote(ctx context.Context, noteID, userID int) (note *models.Note, err error) {
+	ctx, span := noteTracer.Start(ctx, "NoteUseCase.GetNote", trace.WithAttributes(
+		attribute.Int("note.id", noteID),
+		attribute.Int("user.id", userID),
+	))
+	defer func() { tracing.End(span, err) }()
+
+	note, _, err = n.getNote(ctx, noteID, userID)
+	return note, err
+}
+
+// UpdateNote changes the content of a note the user can edit. With a non-zero baseSeq the note is only
+// changed if its change sequence still equals baseSeq, otherwise ErrNoteConflict is returned.
+func (n *NoteUseCase) UpdateNote(ctx context.Context, noteID, userID int, content string, baseSeq int64) (_ *models.Note, err error) {
+	ctx, span := noteTracer.Start(ctx, "NoteUseCase.UpdateNote", trace.WithAttributes(
+		attribute.Int("note.id", noteID),
+		attribute.Int("user.id", userID),
+	))
+	defer func() { tracing.End(span, err) }()
+
+	note, access, err := n.getNote(ctx, noteID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if access < accessEditor {
+		return nil, ErrNoteReadOnly
+	}
+
+	recipients, err := n.recipients(ctx, note)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	note.Content = content
+	note.UpdatedAt = &now
+	err = n.uow.Do(ctx, func(tx domain.Tx) error {
+		updated, err := tx.Notes().Update(ctx, note, baseSeq)
+		if err != nil {
+			return err
+		}
+		if !updated {
+			return ErrNoteConflict
+		}
+		return n.recordNoteEvent(ctx, tx, models.EventNoteUpdated, note, userID, recipients)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return note, nil
+}
+
+// DeleteNote deletes the owner's note. With a non-zero baseSeq the note is only deleted if its
+// change sequence still equals baseSeq, otherwise ErrNoteConflict is returned.
+func (n *NoteUseCase) DeleteNote(ctx context.Context, noteID, ownerID int, baseSeq int64) (err error) {
+	ctx, span := noteTracer.Start(ctx, "NoteUseCase.DeleteNote", trace.WithAttributes(
+		attribute.Int("note.id", noteID),
+		attribute.Int("user.id", ownerID),
+	))
+	defer func() { tracing.End(span, err) }()
+
+	note, access, err := n.getNote(ctx, noteID, ownerID)
+	if err != nil {
+		return err
+	}
+	if access != accessOwner {
+		return ErrNotNoteOwner
+	}
+
+	// Recipients are resolved before the shares are deleted with the note
+	recipients, err := n.recipients(ctx, note)
+	if err != nil {
+		return err
+	}
+	err = n.uow.Do(ctx, func(tx domain.Tx) error {
+		deleted, err := tx.Notes().Delete(ctx, noteID, baseSeq)
+		if err != nil {
+			return err
+		}
+		if !deleted {
+			return ErrNoteConflict
+		}
+		return n.recordNoteEvent(ctx, tx, models.EventNoteDeleted, note, ownerID, recipients)
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNoteNotFound
+	}
+	return err
+}
+
+// DeleteUser deletes the user with their notes and the notes of the workspaces they own. The events
+// about the deleted notes are saved in the same transaction.
+func (n *NoteUseCase) DeleteUser(ctx context.Context, userID int) (err error) {
+	ctx, span := noteTracer.Start(ctx, "NoteUseCase.DeleteUser", trace.WithAttributes(attribute.Int("user.id", userID)))
+	defer func() { tracing.End(span, err) }()
+
+	notes, err := n.noteRepo.GetOwnedByUser(ctx, userID)
+	if err != nil {
+		return err
+	}
+	// Recipients are resolved before the shares and memberships are deleted with the user
+	recipients := make([][]int, len(notes))
+	for i := range notes {
+		if recipients[i], err = n.recipients(ctx, &notes[i]); err != nil {
+			return err
+		}
+	}
+	return n.uow.Do(ctx, func(tx domain.Tx) error {
+		for i := range notes {
+			if err := n.recordNoteEvent(ctx, tx, models.EventNoteDeleted, &notes[i], userID, recipients[i]); err != nil {
+				return err
+			}
+		}
+		return tx.Users().DeleteUser(ctx, userID)
+	})
+}
+
+// GetAllNotes returns all notes (admin access).
+func (n *NoteUseCase) GetAllNotes(ctx context.Context) (notes []models.Note, err error) {
+	ctx, span := noteTracer.Start(ctx, "NoteUseCase.GetAllNotes")
+	defer func() { tracing.End(span, err) }()
+
+	return n.noteRepo.GetAllNotes(ctx)
+}
+
+// ShareNote shares the owner's note with the user with the email, or changes the permission of an existing share.
+// An email without an account is accepted and nothing is shared, so the result doesn't reveal which emails have
+// accounts; the returned share has no user ID then.
+func (n *NoteUseCase) ShareNote(ctx context.Context, noteID, ownerID int, email, permission string) (_ *models.NoteShare, err error) {
+	ctx, span := noteTracer.Start(ctx, "NoteUseCase.ShareNote", trace.WithAttributes(
+		attribute.Int("note.id", noteID),
+		attribute.Int("user.id", ownerID),
+	))
+	defer func() { tracing.End(span, err) }()
+
+	note, access, err := n.getNote(ctx, noteID, ownerID)
+	if err != nil {
+		return nil, err
+	}
+	if access != accessOwner {
+		return nil, ErrNotNoteOwner
+	}
+	if permission != models.NotePermissionViewer && permission != models.NotePermissionEditor {
+		return nil, fmt.Errorf("%w: permission must be %q or %q", ErrInvalidInput, models.NotePermissionViewer, models.NotePermissionEditor)
+	}
+
+	email = normalizeEmail(email)
+	user, err := n.userRepo.GetUserByEmail(ctx, email)
+	if errors.Is(err, sql.ErrNoRows) {
+		return &models.NoteShare{NoteID: noteID, Email: email, Permission: permission, CreatedAt: time.Now()}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if user.ID == ownerID {
+		return nil, fmt.Errorf("%w: can't share a note with yourself", ErrInvalidInput)
+	}
+
+	share := &models.NoteShare{
+		NoteID:     noteID,
+		UserID:     user.ID,
+		Email:      user.Email,
+		Permission: permission,
+		CreatedAt:  time.Now(),
+	}
+	err = n.uow.Do(ctx, func(tx domain.Tx) error {
+		if err := tx.Notes().SaveShare(ctx, share); err != nil {
+			return err
+		}
+		return recordShareEvent(ctx, tx, models.EventShareCreated, note, ownerID, share.UserID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return share, nil
+}
+
+// ListShares returns the shares of the owner's note.
+func (n *NoteUseCase) ListShares(ctx context.Context, noteID, ownerID int) (shares []models.NoteShare, err error) {
+	ctx, span := noteTracer.Start(ctx, "NoteUseCase.ListShares", trace.WithAttributes(
+		attribute.Int("note.id", noteID),
+		attribute.Int("user.id", ownerID),
+	))
+	defer func() { tracing.End(span, err) }()
+
+	if err := n.requireOwner(ctx, noteID, ownerID); err != nil {
+		return nil, err
+	}
+	return n.noteRepo.ListShares(ctx, noteID)
+}
+
+// RevokeShare revokes the share of the note with the target user. The owner can revoke any share,
+// other users can only remove notes shared with them.
+func (n *NoteUseCase) RevokeShare(ctx context.Context, noteID, userID, targetUserID int) (err error) {
+	ctx, span := noteTracer.Start(ctx, "NoteUseCase.RevokeShare", trace.WithAttributes(
+		attribute.Int("note.id", noteID),
+		attribute.Int("user.id", userID),
+	))
+	defer func() { tracing.End(span, err) }()
+
+	if userID != targetUserID {
+		if err := n.requireOwner(ctx, noteID, userID); err != nil {
+			return err
+		}
+	}
+	return n.uow.Do(ctx, func(tx domain.Tx) error {
+		note, err := tx.Notes().GetByID(ctx, noteID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrShareNotFound
+		}
+		if err != nil {
+			return err
+		}
+		removed, err := tx.Notes().RemoveShare(ctx, noteID, targetUserID)
+		if err != nil {
+			return err
+		}
+		if !removed {
+			return ErrShareNotFound
+		}
+		return recordShareEvent(ctx, tx, models.EventShareRevoked, note, userID, targetUserID)
+	})
+}
+
+// GetSharedWithMe returns the notes other users shared with the user.
+func (n *NoteUseCase) GetSharedWithMe(ctx context.Context, userID int) (notes []models.SharedNote, err error) {
+	ctx, span := noteTracer.Start(ctx, "NoteUseCase.GetSharedWithMe", trace.WithAttributes(attribute.Int("user.id", userID)))
+	defer func() { tracing.End(span, err) }()
+
+	return n.noteRepo.GetSharedWith(ctx, userID)
+}
+
+// ReportSpellingErrors notifies the user's webhooks that the content of a note was rejected
+// because of spelling errors.
+func (n *NoteUseCase) ReportSpellingErrors(ctx context.Context, userID int, content string, spellErrors interface{}) (err error) {
+	ctx, span := noteTracer.Start(ctx, "NoteUseCase.ReportSpellingErrors", trace.WithAttributes(attribute.Int("user.id", userID)))
+	defer func() { tracing.End(span, err) }()
+
+	data, err := json.Marshal(map[string]interface{}{
+		"userId":  userID,
+		"content": content,
+		"errors":  spellErrors,
+	})
+	if err != nil {
+		return err
+	}
+	return n.uow.Do(ctx, func(tx domain.Tx) error {
+		return tx.Outbox().Add(ctx, &models.DomainEvent{
+			Event:      models.Event{Type: models.EventSpellcheckFailed, ActorID: userID, At: time.Now()},
+			Recipients: []int{userID},
+			Data:       data,
+		})
+	})
+}
+
+// requireOwner returns an error unless the user is the author of the note.
+func (n *NoteUseCase) requireOwner(ctx context.Context, noteID, userID int) error {
+	_, access, err := n.getNote(ctx, noteID, userID)
+	if err != nil {
+		return err
+	}
+	if access != accessOwner {
+		return ErrNotNoteOwner
+	}
+	return nil
+}
+
+// getNote returns the note with the user's access level to it. Notes the user can't read are reported
+// as not found, so their existence isn't revealed. Authors of workspace notes only own them while
+// they are members of the workspace.
+func (n *NoteUseCase) getNote(ctx context.Context, noteID, userID int) (*models.Note, int, error) {
+	note, err := n.noteRepo.GetByID(ctx, noteID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, accessNone, ErrNoteNotFound
+	}
+	if err != nil {
+		return nil, accessNone, err
+	}
+	if note.WorkspaceID == nil && note.UserID == userID {
+		return note, accessOwner, nil
+	}
+
+	access := accessNone
+	if note.WorkspaceID != nil {
+		member, err := n.workspaceRepo.GetMember(ctx, *note.WorkspaceID, userID)
+		switch {
+		case err == nil && note.UserID == userID:
+			return note, accessOwner, nil
+		case err == nil && member.CanEdit():
+			access = accessEditor
+		case err == nil:
+			access = accessViewer
+		case !errors.Is(err, sql.ErrNoRows):
+			return nil, accessNone, err
+		}
+	}
+
+	share, err := n.noteRepo.GetShare(ctx, noteID, userID)
+	switch {
+	case err == nil && share.Permission == models.NotePermissionEditor:
+		access = accessEditor
+	case err == nil && access < accessViewer:
+		access = accessViewer
+	case err != nil && !errors.Is(err, sql.ErrNoRows):
+		return nil, accessNone, err
+	}
+
+	if access == accessNone {
+		return nil, accessNone, ErrNoteNotFound
+	}
+	return note, access, nil
+}
+
+// recipients returns the users who can see the note: the author of a private note, the members of
+// its workspace and the users it is shared with.
+func (n *NoteUseCase) recipients(ctx context.Context, note *models.Note) ([]int, error) {
+	seen := map[int]bool{}
+	recipients := []int{}
+	add := func(userID int) {
+		if !seen[userID] {
+			seen[userID] = true
+			recipients = append(recipients, userID)
+		}
+	}
+
+	if note.WorkspaceID == nil {
+		add(note.UserID)
+	} else {
+		members, err := n.workspaceRepo.ListMembers(ctx, *note.WorkspaceID)
+		if err != nil {
+			return nil, err
+		}
+		for _, member := range members {
+			add(member.UserID)
+		}
+	}
+
+	shares, err := n.noteRepo.ListShares(ctx, note.ID)
+	if err != nil {
+		return nil, err
+	}
+	for _, share := range shares {
+		add(share.UserID)
+	}
+	return recipients, nil
 }
 
-func (n *NoteUseCase) AddNote(note models.Note) error {
-	return n.noteRepo.Add(note)
+// recordNoteEvent adds the event about the note to the outbox of the transaction, with the note as the
+// webhook payload. The recipients are resolved by the caller before the transaction, since the
+// repositories outside it would need a second connection while the transaction holds one.
+func (n *NoteUseCase) recordNoteEvent(ctx context.Context, tx domain.Tx, eventType string, note *models.Note, actorID int, recipients []int) error {
+	data, err := json.Marshal(note)
+	if err != nil {
+		return err
+	}
+	return tx.Outbox().Add(ctx, &models.DomainEvent{
+		Event:      newNoteEvent(eventType, note, actorID),
+		Recipients: recipients,
+		Data:       data,
+	})
 }
 
-func (n *NoteUseCase) GetNotesByUserID(userID int) ([]models.Note, error) {
-	return n.noteRepo.GetByUserID(userID)
+// recordShareEvent adds the event about a share to the outbox of the transaction, addressed to the owner
+// of the note and the user of the share.
+func recordShareEvent(ctx context.Context, tx domain.Tx, eventType string, note *models.Note, actorID, userID int) error {
+	event := newNoteEvent(eventType, note, actorID)
+	event.UserID = userID
+	return tx.Outbox().Add(ctx, &models.DomainEvent{Event: event, Recipients: []int{note.UserID, userID}})
 }
 
-func (n *NoteUseCase) GetAllNotes() ([]models.Note, error) {
-	return n.noteRepo.GetAllNotes()
+func newNoteEvent(eventType string, note *models.Note, actorID int) models.Event {
+	return models.Event{
+		Type:        eventType,
+		NoteID:      note.ID,
+		WorkspaceID: note.WorkspaceID,
+		ActorID:     actorID,
+		At:          time.Now(),
+	}
 }
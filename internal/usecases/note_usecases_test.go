@@ -0,0 +1,164 @@
+package usecases
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ananikitina/notes-rest/internal/config"
+	"github.com/ananikitina/notes-rest/internal/database"
+	"github.com/ananikitina/notes-rest/internal/domain"
+	"github.com/ananikitina/notes-rest/internal/models"
+	"github.com/ananikitina/notes-rest/internal/repository/memory"
+	"github.com/ananikitina/notes-rest/internal/repository/sqlite"
+)
+
+// failingOutbox is a unit of work whose outbox can't store events.
+type failingOutbox struct {
+	*memory.Store
+}
+
+var errOutboxDown = errors.New("outbox is down")
+
+func (u failingOutbox) Do(ctx context.Context, fn func(tx domain.Tx) error) error {
+	return u.Store.Do(ctx, func(tx domain.Tx) error {
+		return fn(failingOutboxTx{tx})
+	})
+}
+
+type failingOutboxTx struct {
+	domain.Tx
+}
+
+func (t failingOutboxTx) Outbox() domain.OutboxRepository {
+	return failingOutboxRepository{t.Tx.Outbox()}
+}
+
+type failingOutboxRepository struct {
+	domain.OutboxRepository
+}
+
+func (failingOutboxRepository) Add(ctx context.Context, event *models.DomainEvent) error {
+	return errOutboxDown
+}
+
+// pendingEvents returns the events in the outbox of the store.
+func pendingEvents(t *testing.T, store *memory.Store) []models.DomainEvent {
+	t.Helper()
+	var events []models.DomainEvent
+	err := store.Do(context.Background(), func(tx domain.Tx) error {
+		var err error
+		events, err = tx.Outbox().FetchPending(context.Background(), 100)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return events
+}
+
+func TestNoteEventsAreWrittenWithTheChange(t *testing.T) {
+	ctx := context.Background()
+	store := memory.NewStore()
+	user := &models.User{Email: "alice@example.com", Password: "hash", Role: "user"}
+	if err := store.Users().CreateUser(ctx, user); err != nil {
+		t.Fatal(err)
+	}
+	useCase := NewNoteUseCase(store.Notes(), store.Workspaces(), store.Users(), store)
+
+	note := &models.Note{Content: "first", UserID: user.ID}
+	if err := useCase.AddNote(ctx, note, nil); err != nil {
+		t.Fatal(err)
+	}
+	updated, err := useCase.UpdateNote(ctx, note.ID, user.ID, "second", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A conflicting update changes nothing and records no event
+	if _, err := useCase.UpdateNote(ctx, note.ID, user.ID, "third", updated.ChangeSeq-1); !errors.Is(err, ErrNoteConflict) {
+		t.Fatalf("UpdateNote() with a stale base error = %v, want ErrNoteConflict", err)
+	}
+	if err := useCase.DeleteNote(ctx, note.ID, user.ID, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	events := pendingEvents(t, store)
+	want := []struct{ eventType, content string }{
+		{models.EventNoteCreated, "first"},
+		{models.EventNoteUpdated, "second"},
+		{models.EventNoteDeleted, "second"},
+	}
+	if len(events) != len(want) {
+		t.Fatalf("outbox has %d events, want %d: %+v", len(events), len(want), events)
+	}
+	for i, event := range events {
+		var data models.Note
+		if err := json.Unmarshal(event.Data, &data); err != nil {
+			t.Fatal(err)
+		}
+		if event.Event.Type != want[i].eventType || event.Event.NoteID != note.ID || data.Content != want[i].content ||
+			len(event.Recipients) != 1 || event.Recipients[0] != user.ID {
+			t.Errorf("event %d = %+v with note %+v, want %s of %q", i, event, data, want[i].eventType, want[i].content)
+		}
+	}
+
+	// A change whose event can't be stored is rolled back
+	failing := NewNoteUseCase(store.Notes(), store.Workspaces(), store.Users(), failingOutbox{store})
+	if err := failing.AddNote(ctx, &models.Note{Content: "lost", UserID: user.ID}, nil); !errors.Is(err, errOutboxDown) {
+		t.Fatalf("AddNote() error = %v, want %v", err, errOutboxDown)
+	}
+	notes, err := store.Notes().GetByUserID(ctx, user.ID, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(notes) != 0 {
+		t.Errorf("notes after a failed AddNote() = %+v, want none", notes)
+	}
+	if got := pendingEvents(t, store); len(got) != len(want) {
+		t.Errorf("outbox has %d events after a failed AddNote(), want %d", len(got), len(want))
+	}
+}
+
+func TestNoteWritesNeedOneConnection(t *testing.T) {
+	db, err := database.OpenSQLite(filepath.Join(t.TempDir(), "notes.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := database.Migrate(db, config.StorageDriverSQLite); err != nil {
+		t.Fatal(err)
+	}
+	// A write that reads outside its transaction waits for a second connection until the timeout
+	db.SetMaxOpenConns(1)
+	timeout := 2 * time.Second
+	users, notes, workspaces := sqlite.NewUserRepository(db, timeout), sqlite.NewNoteRepository(db, timeout), sqlite.NewWorkspaceRepository(db, timeout)
+	useCase := NewNoteUseCase(notes, workspaces, users, sqlite.NewUnitOfWork(db, timeout))
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	user := &models.User{Email: "alice@example.com", Password: "hash", Role: "user"}
+	if err := users.CreateUser(ctx, user); err != nil {
+		t.Fatal(err)
+	}
+	workspace := &models.Workspace{Name: "Team", OwnerID: user.ID}
+	if err := workspaces.Create(ctx, workspace); err != nil {
+		t.Fatal(err)
+	}
+	member := &models.WorkspaceMember{WorkspaceID: workspace.ID, UserID: user.ID, Role: models.WorkspaceOwner}
+
+	for _, m := range []*models.WorkspaceMember{nil, member} {
+		note := &models.Note{Content: "first", UserID: user.ID}
+		if err := useCase.AddNote(ctx, note, m); err != nil {
+			t.Fatalf("AddNote() error = %v", err)
+		}
+		if _, err := useCase.UpdateNote(ctx, note.ID, user.ID, "second", 0); err != nil {
+			t.Fatalf("UpdateNote() error = %v", err)
+		}
+		if err := useCase.DeleteNote(ctx, note.ID, user.ID, 0); err != nil {
+			t.Fatalf("DeleteNote() error = %v", err)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package usecases
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+
+	"github.com/ananikitina/notes-rest/internal/config"
+	"github.com/ananikitina/notes-rest/internal/domain"
+	"github.com/ananikitina/notes-rest/internal/models"
+	"golang.org/x/oauth2"
+)
+
+var (
+	ErrUnverifiedIdentity = errors.New("identity provider did not return a verified email")
+	ErrUnverifiedAccount  = errors.New("an account with this email exists but its email is not verified, verify it before logging in with the identity provider")
+)
+
+// OIDCLoginRequest holds the values of a started login that must be checked in the callback.
+type OIDCLoginRequest struct {
+	URL          string
+	State        string
+	Nonce        string
+	CodeVerifier string
+}
+
+// OIDCUseCase represents the business logic for logging in with external identity providers.
+type OIDCUseCase struct {
+	userRepo    domain.UserRepository
+	oidcService domain.OIDCService
+	hasher      domain.PasswordHasher
+	providers   map[string]config.OIDCProviderConfig
+}
+
+// NewOIDCUseCase creates a new instance of OIDCUseCase.
+func NewOIDCUseCase(userRepo domain.UserRepository, oidcService domain.OIDCService, hasher domain.PasswordHasher, cfg *config.Config) *OIDCUseCase {
+	providers := make(map[string]config.OIDCProviderConfig)
+	for _, p := range cfg.OIDCProviders {
+		providers[p.Name] = p
+	}
+	return &OIDCUseCase{userRepo: userRepo, oidcService: oidcService, hasher: hasher, providers: providers}
+}
+
+// StartLogin generates the state, nonce and PKCE verifier and returns the provider URL.
+func (o *OIDCUseCase) StartLogin(ctx context.Context, provider string) (*OIDCLoginRequest, error) {
+	if _, ok := o.providers[provider]; !ok {
+		return nil, domain.ErrUnknownProvider
+	}
+
+	state, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+	req := &OIDCLoginRequest{State: state, Nonce: nonce, CodeVerifier: oauth2.GenerateVerifier()}
+
+	req.URL, err = o.oidcService.AuthCodeURL(ctx, provider, req.State, req.Nonce, req.CodeVerifier)
+	if err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+// CompleteLogin exchanges the code and returns the linked user. Unknown identities are
+// linked to the existing user with the same verified email, or a new user is provisioned.
+// Users with two-factor authentication enabled still have to complete it before getting a session.
+func (o *OIDCUseCase) CompleteLogin(ctx context.Context, provider, code, codeVerifier, nonce string) (*models.User, error) {
+	providerCfg, ok := o.providers[provider]
+	if !ok {
+		return nil, domain.ErrUnknownProvider
+	}
+
+	identity, err := o.oidcService.Exchange(ctx, provider, code, codeVerifier, nonce)
+	if err != nil {
+		return nil, err
+	}
+	role, roleMapped := mapRole(providerCfg, identity.Groups)
+
+	user, err := o.userRepo.GetUserByIdentity(ctx, identity.Provider, identity.Subject)
+	if errors.Is(err, sql.ErrNoRows) {
+		user, err = o.linkOrProvision(ctx, identity, role)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Group membership is the source of truth for the role when mapping is configured
+	if roleMapped && user.Role != role {
+		if err := o.userRepo.UpdateRole(ctx, user.ID, role); err != nil {
+			return nil, err
+		}
+		user.Role = role
+	}
+	return user, nil
+}
+
+func (o *OIDCUseCase) linkOrProvision(ctx context.Context, identity *models.ExternalIdentity, role string) (*models.User, error) {
+	// Only a verified email proves that the identity owns the local account
+	if !identity.EmailVerified || identity.Email == "" {
+		return nil, ErrUnverifiedIdentity
+	}
+
+	email := normalizeEmail(identity.Email)
+	user, err := o.userRepo.GetUserByEmail(ctx, email)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		password, err := randomToken()
+		if err != nil {
+			return nil, err
+		}
+		// The random password is never shown, the user logs in through the provider
+		hash, err := o.hasher.Hash(password)
+		if err != nil {
+			return nil, err
+		}
+		user = &models.User{Email: email, Password: hash, Role: role, EmailVerified: true}
+		if err := o.userRepo.CreateUser(ctx, user); err != nil {
+			return nil, err
+		}
+	case err != nil:
+		return nil, err
+	case !user.EmailVerified:
+		// Anyone can register an unverified account with someone else's email and keep its password,
+		// so it's never taken over by the identity
+		return nil, ErrUnverifiedAccount
+	}
+
+	if err := o.userRepo.LinkIdentity(ctx, user.ID, identity.Provider, identity.Subject); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// mapRole returns the role for the user's groups and whether the provider has role mapping configured.
+func mapRole(provider config.OIDCProviderConfig, groups []string) (string, bool) {
+	if len(provider.AdminGroups) == 0 {
+		return provider.DefaultRole, false
+	}
+	for _, group := range groups {
+		for _, adminGroup := range provider.AdminGroups {
+			if group == adminGroup {
+				return "admin", true
+			}
+		}
+	}
+	return provider.DefaultRole, true
+}
+
+// randomToken returns a random URL-safe string.
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,140 @@
+package usecases
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ananikitina/notes-rest/internal/config"
+	"github.com/ananikitina/notes-rest/internal/domain"
+	"github.com/ananikitina/notes-rest/internal/models"
+)
+
+var (
+	ErrLinkNotFound         = errors.New("link not found or expired")
+	ErrLinkPasswordRequired = errors.New("password is required")
+	ErrLinkWrongPassword    = errors.New("invalid password")
+)
+
+// PublicLinkUseCase represents the business logic for public read-only links to notes.
+type PublicLinkUseCase struct {
+	linkRepo    domain.PublicLinkRepository
+	noteRepo    domain.NoteRepository
+	noteUseCase *NoteUseCase
+	throttle    *loginThrottle
+	hasher      domain.PasswordHasher
+	cfg         *config.Config
+}
+
+// NewPublicLinkUseCase creates a new instance of PublicLinkUseCase.
+func NewPublicLinkUseCase(linkRepo domain.PublicLinkRepository, noteRepo domain.NoteRepository, attemptRepo domain.LoginAttemptRepository,
+	noteUseCase *NoteUseCase, hasher domain.PasswordHasher, cfg *config.Config) *PublicLinkUseCase {
+	return &PublicLinkUseCase{
+		linkRepo:    linkRepo,
+		noteRepo:    noteRepo,
+		noteUseCase: noteUseCase,
+		throttle:    newLoginThrottle(attemptRepo, cfg),
+		hasher:      hasher,
+		cfg:         cfg,
+	}
+}
+
+// CreateLink creates a public link to the owner's note. The token is only returned here.
+func (p *PublicLinkUseCase) CreateLink(ctx context.Context, noteID, ownerID int, expiresAt *time.Time, password string) (*models.PublicLink, error) {
+	if err := p.noteUseCase.requireOwner(ctx, noteID, ownerID); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	if expiresAt != nil && !expiresAt.After(now) {
+		return nil, fmt.Errorf("%w: expiresAt must be in the future", ErrInvalidInput)
+	}
+
+	token, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+	link := &models.PublicLink{
+		NoteID:    noteID,
+		Token:     token,
+		URL:       p.cfg.AppBaseURL + "/p/" + token,
+		ExpiresAt: expiresAt,
+		CreatedAt: now,
+	}
+	if password != "" {
+		if link.PasswordHash, err = p.hasher.Hash(password); err != nil {
+			return nil, err
+		}
+		link.HasPassword = true
+	}
+
+	if err := p.linkRepo.Create(ctx, link, hashToken(token)); err != nil {
+		return nil, err
+	}
+	return link, nil
+}
+
+// ListLinks returns the public links of the owner's note.
+func (p *PublicLinkUseCase) ListLinks(ctx context.Context, noteID, ownerID int) ([]models.PublicLink, error) {
+	if err := p.noteUseCase.requireOwner(ctx, noteID, ownerID); err != nil {
+		return nil, err
+	}
+	return p.linkRepo.ListByNote(ctx, noteID)
+}
+
+// RevokeLink deletes a public link of the owner's note.
+func (p *PublicLinkUseCase) RevokeLink(ctx context.Context, noteID, ownerID, linkID int) error {
+	if err := p.noteUseCase.requireOwner(ctx, noteID, ownerID); err != nil {
+		return err
+	}
+	deleted, err := p.linkRepo.Delete(ctx, linkID, noteID)
+	if err != nil {
+		return err
+	}
+	if !deleted {
+		return ErrLinkNotFound
+	}
+	return nil
+}
+
+// ViewNote returns the note of the link and counts the view. Wrong passwords are throttled
+// per link and per IP like failed logins.
+func (p *PublicLinkUseCase) ViewNote(ctx context.Context, token, password, ip string) (*models.Note, error) {
+	tokenHash := hashToken(token)
+	link, err := p.linkRepo.GetActiveByToken(ctx, tokenHash)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrLinkNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if link.HasPassword {
+		if password == "" {
+			return nil, ErrLinkPasswordRequired
+		}
+		if err := p.throttle.checkToken(ctx, tokenHash, ip); err != nil {
+			return nil, err
+		}
+		ok, err := p.hasher.Verify(link.PasswordHash, password)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, p.throttle.recordFailure(ctx, models.LoginAttempt{IP: ip, TokenHash: tokenHash}, ErrLinkWrongPassword)
+		}
+	}
+
+	note, err := p.noteRepo.GetByID(ctx, link.NoteID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrLinkNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := p.linkRepo.RecordView(ctx, link.ID, time.Now()); err != nil {
+		return nil, err
+	}
+	return note, nil
+}
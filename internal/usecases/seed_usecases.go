@@ -0,0 +1,154 @@
+package usecases
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/mail"
+
+	"github.com/ananikitina/notes-rest/internal/domain"
+	"github.com/ananikitina/notes-rest/internal/models"
+)
+
+// Fixture describes users with sample notes to seed the database with.
+type Fixture struct {
+	Users []FixtureUser `json:"users" yaml:"users"`
+}
+
+// FixtureUser is a user of a fixture with a plain text password and the contents of their notes.
+type FixtureUser struct {
+	Email         string   `json:"email" yaml:"email"`
+	Password      string   `json:"password" yaml:"password"`
+	Role          string   `json:"role" yaml:"role"`
+	EmailVerified bool     `json:"emailVerified" yaml:"emailVerified"`
+	Notes         []string `json:"notes" yaml:"notes"`
+}
+
+// SeedResult counts what seeding created.
+type SeedResult struct {
+	UsersCreated int
+	UsersSkipped int // already existed and were left unchanged
+	NotesCreated int
+}
+
+// SeedUseCase fills the database with fixtures and creates admins from the command line.
+type SeedUseCase struct {
+	userRepo domain.UserRepository
+	noteRepo domain.NoteRepository
+	hasher   domain.PasswordHasher
+	policy   domain.PasswordPolicy
+}
+
+// NewSeedUseCase creates a new instance of SeedUseCase.
+func NewSeedUseCase(userRepo domain.UserRepository, noteRepo domain.NoteRepository, hasher domain.PasswordHasher,
+	policy domain.PasswordPolicy) *SeedUseCase {
+	return &SeedUseCase{userRepo: userRepo, noteRepo: noteRepo, hasher: hasher, policy: policy}
+}
+
+// Seed creates the users of the fixture that don't exist yet and adds the notes they don't have yet, so
+// it can be run repeatedly. Existing users, including their passwords and roles, are never changed.
+// Fixture passwords are not checked against the password policy.
+func (s *SeedUseCase) Seed(ctx context.Context, fixture *Fixture) (SeedResult, error) {
+	var result SeedResult
+	for _, fixtureUser := range fixture.Users {
+		if fixtureUser.Email == "" || fixtureUser.Password == "" {
+			return result, fmt.Errorf("%w: fixture users need an email and a password", ErrInvalidInput)
+		}
+		role := fixtureUser.Role
+		switch role {
+		case "":
+			role = "user"
+		case "user", "admin":
+		default:
+			return result, fmt.Errorf("%w: invalid role %q of %s", ErrInvalidInput, role, fixtureUser.Email)
+		}
+
+		email := normalizeEmail(fixtureUser.Email)
+		user, err := s.userRepo.GetUserByEmail(ctx, email)
+		switch {
+		case err == nil:
+			slog.InfoContext(ctx, "User already exists, leaving it unchanged", "email", email)
+			result.UsersSkipped++
+		case errors.Is(err, sql.ErrNoRows):
+			user = &models.User{Email: email, Password: fixtureUser.Password, Role: role,
+				EmailVerified: fixtureUser.EmailVerified}
+			if err := s.createUser(ctx, user); err != nil {
+				return result, err
+			}
+			result.UsersCreated++
+		default:
+			return result, err
+		}
+
+		created, err := s.addMissingNotes(ctx, user.ID, fixtureUser.Notes)
+		result.NotesCreated += created
+		if err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+// CreateAdmin creates a verified admin. Unlike fixtures, the password must satisfy the password policy.
+func (s *SeedUseCase) CreateAdmin(ctx context.Context, email, password string) (*models.User, error) {
+	email = normalizeEmail(email)
+	if addr, err := mail.ParseAddress(email); err != nil || addr.Address != email {
+		return nil, fmt.Errorf("%w: invalid email address", ErrInvalidInput)
+	}
+	_, err := s.userRepo.GetUserByEmail(ctx, email)
+	if err == nil {
+		return nil, ErrUserExists
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+	if err := s.policy.Validate(password, email); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidInput, err)
+	}
+
+	user := &models.User{Email: email, Password: password, Role: "admin", EmailVerified: true}
+	if err := s.createUser(ctx, user); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// createUser hashes the user's plain text password and stores the user.
+func (s *SeedUseCase) createUser(ctx context.Context, user *models.User) error {
+	hash, err := s.hasher.Hash(user.Password)
+	if err != nil {
+		return err
+	}
+	user.Password = hash
+	return s.userRepo.CreateUser(ctx, user)
+}
+
+// addMissingNotes adds the private notes the user has no note with the same content for.
+func (s *SeedUseCase) addMissingNotes(ctx context.Context, userID int, contents []string) (int, error) {
+	if len(contents) == 0 {
+		return 0, nil
+	}
+	notes, err := s.noteRepo.GetByUserID(ctx, userID, "")
+	if err != nil {
+		return 0, err
+	}
+	existing := map[string]bool{}
+	for _, note := range notes {
+		existing[note.Content] = true
+	}
+
+	created := 0
+	for _, content := range contents {
+		if existing[content] {
+			continue
+		}
+		if err := s.noteRepo.Add(ctx, &models.Note{Content: content, UserID: userID}); err != nil {
+			return created, err
+		}
+		existing[content] = true
+		created++
+	}
+	return created, nil
+}
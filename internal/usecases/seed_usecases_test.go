@@ -0,0 +1,120 @@
+package usecases
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/ananikitina/notes-rest/internal/config"
+	"github.com/ananikitina/notes-rest/internal/domain"
+	"github.com/ananikitina/notes-rest/internal/repository/memory"
+	"github.com/ananikitina/notes-rest/internal/services"
+)
+
+func newSeedUseCase(t *testing.T) (*SeedUseCase, *memory.Store, domain.PasswordHasher) {
+	t.Helper()
+	cfg := &config.Config{PasswordMinLength: 8, PasswordHashAlgorithm: services.HashBcrypt, BcryptCost: 4}
+	hasher, err := services.NewPasswordHasher(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy, err := services.NewPasswordPolicy(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := memory.NewStore()
+	return NewSeedUseCase(store.Users(), store.Notes(), hasher, policy), store, hasher
+}
+
+func TestSeedIsIdempotent(t *testing.T) {
+	ctx := context.Background()
+	useCase, store, hasher := newSeedUseCase(t)
+
+	// An existing user keeps their password, role and notes
+	existing, err := useCase.CreateAdmin(ctx, "admin@ex.com", "own admin password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fixture := &Fixture{Users: []FixtureUser{
+		{Email: "admin@ex.com", Password: "adminpassword", Role: "user", Notes: []string{"admin note"}},
+		{Email: "user@ex.com", Password: "userpassword", EmailVerified: true, Notes: []string{"first", "second", "first"}},
+	}}
+	result, err := useCase.Seed(ctx, fixture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (SeedResult{UsersCreated: 1, UsersSkipped: 1, NotesCreated: 3}); result != want {
+		t.Errorf("Seed() = %+v, want %+v", result, want)
+	}
+
+	admin, err := store.Users().GetUserByEmail(ctx, "admin@ex.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := hasher.Verify(admin.Password, "own admin password"); !ok || admin.Role != "admin" || admin.ID != existing.ID {
+		t.Errorf("seeding changed the existing user: %+v", admin)
+	}
+	user, err := store.Users().GetUserByEmail(ctx, "user@ex.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := hasher.Verify(user.Password, "userpassword"); !ok || user.Role != "user" || !user.EmailVerified {
+		t.Errorf("seeded user = %+v", user)
+	}
+
+	result, err = useCase.Seed(ctx, fixture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (SeedResult{UsersSkipped: 2}); result != want {
+		t.Errorf("Seed() again = %+v, want %+v", result, want)
+	}
+	notes, err := store.Notes().GetByUserID(ctx, user.ID, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(notes) != 2 {
+		t.Errorf("user has %d notes, want 2", len(notes))
+	}
+}
+
+func TestSeedRejectsInvalidUsers(t *testing.T) {
+	useCase, _, _ := newSeedUseCase(t)
+	for _, user := range []FixtureUser{
+		{Email: "user@ex.com"},
+		{Password: "userpassword"},
+		{Email: "user@ex.com", Password: "userpassword", Role: "owner"},
+	} {
+		if _, err := useCase.Seed(context.Background(), &Fixture{Users: []FixtureUser{user}}); !errors.Is(err, ErrInvalidInput) {
+			t.Errorf("Seed(%+v) error = %v, want ErrInvalidInput", user, err)
+		}
+	}
+}
+
+func TestCreateAdmin(t *testing.T) {
+	ctx := context.Background()
+	useCase, _, _ := newSeedUseCase(t)
+
+	admin, err := useCase.CreateAdmin(ctx, "admin@ex.com", "long admin password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if admin.Role != "admin" || !admin.EmailVerified || admin.Password == "long admin password" {
+		t.Errorf("CreateAdmin() = %+v", admin)
+	}
+
+	tests := []struct {
+		name, email, password string
+		want                  error
+	}{
+		{"existing user", "admin@ex.com", "another long password", ErrUserExists},
+		{"invalid email", "Admin <boss@ex.com>", "long admin password", ErrInvalidInput},
+		{"weak password", "boss@ex.com", "short", ErrInvalidInput},
+	}
+	for _, tt := range tests {
+		if _, err := useCase.CreateAdmin(ctx, tt.email, tt.password); !errors.Is(err, tt.want) {
+			t.Errorf("%s: CreateAdmin() error = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package usecases
+
+import (
+	"context"
+	"time"
+
+	"github.com/ananikitina/notes-rest/internal/domain"
+	"github.com/ananikitina/notes-rest/internal/models"
+)
+
+// lastSeenInterval limits how often the last seen time of a session is written.
+const lastSeenInterval = time.Minute
+
+// SessionUseCase represents the business logic for login sessions.
+type SessionUseCase struct {
+	sessionRepo domain.SessionRepository
+	jwtService  domain.JWTServiceInterface
+}
+
+// NewSessionUseCase creates a new instance of SessionUseCase.
+func NewSessionUseCase(sessionRepo domain.SessionRepository, jwtService domain.JWTServiceInterface) *SessionUseCase {
+	return &SessionUseCase{sessionRepo: sessionRepo, jwtService: jwtService}
+}
+
+// StartSession records a new session for the user and returns its access token.
+func (s *SessionUseCase) StartSession(ctx context.Context, user *models.User, deviceLabel, userAgent, ip string) (string, error) {
+	now := time.Now()
+	session := &models.Session{
+		UserID:      user.ID,
+		DeviceLabel: deviceLabel,
+		UserAgent:   userAgent,
+		IP:          ip,
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(domain.AccessTokenTTL),
+	}
+	if err := s.sessionRepo.Create(ctx, session); err != nil {
+		return "", err
+	}
+	return s.jwtService.GenerateToken(user.ID, user.Role, session.ID)
+}
+
+// ValidateSession checks that the session belongs to the user and is still active.
+func (s *SessionUseCase) ValidateSession(ctx context.Context, sessionID, userID int) error {
+	session, err := s.sessionRepo.GetActive(ctx, sessionID)
+	if err != nil || session.UserID != userID {
+		return ErrInvalidToken
+	}
+
+	if now := time.Now(); now.Sub(session.LastSeenAt) > lastSeenInterval {
+		return s.sessionRepo.Touch(ctx, session.ID, now)
+	}
+	return nil
+}
+
+// ListSessions returns the user's active sessions, marking the current one.
+func (s *SessionUseCase) ListSessions(ctx context.Context, userID, currentSessionID int) ([]models.Session, error) {
+	sessions, err := s.sessionRepo.ListActiveByUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range sessions {
+		sessions[i].Current = sessions[i].ID == currentSessionID
+	}
+	return sessions, nil
+}
+
+// RevokeSession revokes one of the user's sessions.
+func (s *SessionUseCase) RevokeSession(ctx context.Context, userID, sessionID int) error {
+	found, err := s.sessionRepo.Revoke(ctx, sessionID, userID)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return ErrSessionNotFound
+	}
+	return nil
+}
+
+// RevokeAllSessions revokes all sessions of the user.
+func (s *SessionUseCase) RevokeAllSessions(ctx context.Context, userID int) error {
+	return s.sessionRepo.RevokeAllByUser(ctx, userID)
+}
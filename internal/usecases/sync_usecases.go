@@ -0,0 +1,170 @@
+package usecases
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+
+	"github.com/ananikitina/notes-rest/internal/domain"
+	"github.com/ananikitina/notes-rest/internal/models"
+)
+
+// Limits of sync requests.
+const (
+	DefaultSyncPageSize = 500
+	MaxSyncPageSize     = 1000
+	MaxSyncBatchSize    = 500
+)
+
+// ContentCheck checks the content of a created or updated note before it's saved. It returns
+// a *SpellingError if the content can't be saved.
+type ContentCheck func(ctx context.Context, content string) error
+
+// SpellingError rejects content with spelling errors.
+type SpellingError struct {
+	Errors interface{}
+}
+
+func (e *SpellingError) Error() string {
+	return "spelling errors found"
+}
+
+// SyncUseCase represents the business logic for syncing notes with offline clients.
+type SyncUseCase struct {
+	noteRepo      domain.NoteRepository
+	workspaceRepo domain.WorkspaceRepository
+	noteUseCase   *NoteUseCase
+}
+
+// NewSyncUseCase creates a new instance of SyncUseCase.
+func NewSyncUseCase(noteRepo domain.NoteRepository, workspaceRepo domain.WorkspaceRepository, noteUseCase *NoteUseCase) *SyncUseCase {
+	return &SyncUseCase{noteRepo: noteRepo, workspaceRepo: workspaceRepo, noteUseCase: noteUseCase}
+}
+
+// Pull returns up to limit changes of the notes the user can see since the token. An empty token
+// returns everything. The returned token is passed to the next call.
+func (s *SyncUseCase) Pull(ctx context.Context, userID int, token string, limit int) (*models.SyncPage, error) {
+	var since int64
+	if token != "" {
+		var err error
+		if since, err = strconv.ParseInt(token, 10, 64); err != nil || since < 0 {
+			return nil, fmt.Errorf("%w: invalid sync token", ErrInvalidInput)
+		}
+	}
+	if limit <= 0 || limit > MaxSyncPageSize {
+		limit = DefaultSyncPageSize
+	}
+
+	// One more than requested tells whether there are more changes
+	changes, err := s.noteRepo.GetChanges(ctx, userID, since, limit+1)
+	if err != nil {
+		return nil, err
+	}
+	page := &models.SyncPage{Changes: changes}
+	if len(changes) > limit {
+		page.Changes = changes[:limit]
+		page.HasMore = true
+	}
+	if len(page.Changes) > 0 {
+		since = page.Changes[len(page.Changes)-1].Seq
+	}
+	page.Token = strconv.FormatInt(since, 10)
+	return page, nil
+}
+
+// Push applies the client's operations in order and returns a result for each of them. The content
+// of each created or updated note is checked once the user is known to be allowed to save it.
+func (s *SyncUseCase) Push(ctx context.Context, userID int, operations []models.SyncOperation, check ContentCheck) ([]models.SyncResult, error) {
+	if len(operations) > MaxSyncBatchSize {
+		return nil, fmt.Errorf("%w: at most %d operations per request", ErrInvalidInput, MaxSyncBatchSize)
+	}
+
+	results := make([]models.SyncResult, 0, len(operations))
+	for _, op := range operations {
+		result := s.apply(ctx, userID, op, check)
+		result.ClientID = op.ClientID
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// apply applies one operation.
+func (s *SyncUseCase) apply(ctx context.Context, userID int, op models.SyncOperation, check ContentCheck) models.SyncResult {
+	if (op.Op == models.SyncCreate || op.Op == models.SyncUpdate) && op.Content == "" {
+		return models.SyncResult{Status: models.SyncRejected, Error: "content is required"}
+	}
+
+	var note *models.Note
+	var err error
+	switch op.Op {
+	case models.SyncCreate:
+		note, err = s.create(ctx, userID, op, check)
+	case models.SyncUpdate:
+		note, err = s.update(ctx, userID, op, check)
+	case models.SyncDelete:
+		err = s.noteUseCase.DeleteNote(ctx, op.NoteID, userID, op.BaseSeq)
+	default:
+		return models.SyncResult{Status: models.SyncRejected, Error: fmt.Sprintf("unknown operation %q", op.Op)}
+	}
+
+	var spelling *SpellingError
+	switch {
+	case err == nil:
+		return models.SyncResult{Status: models.SyncApplied, Note: note}
+	case errors.As(err, &spelling):
+		return models.SyncResult{Status: models.SyncRejected, Error: spelling.Error(), SpellingErrors: spelling.Errors}
+	case errors.Is(err, ErrNoteConflict):
+		// Send the current note so the client can resolve the conflict
+		current, err := s.noteUseCase.GetNote(ctx, op.NoteID, userID)
+		if err != nil {
+			return models.SyncResult{Status: models.SyncNotFound, Error: ErrNoteNotFound.Error()}
+		}
+		return models.SyncResult{Status: models.SyncConflict, Note: current}
+	case errors.Is(err, ErrNoteNotFound), errors.Is(err, ErrWorkspaceNotFound):
+		return models.SyncResult{Status: models.SyncNotFound, Error: err.Error()}
+	case errors.Is(err, ErrNoteReadOnly), errors.Is(err, ErrNotNoteOwner), errors.Is(err, ErrWorkspaceReadOnly):
+		return models.SyncResult{Status: models.SyncRejected, Error: err.Error()}
+	default:
+		slog.ErrorContext(ctx, "Failed to apply sync operation", "client_id", op.ClientID, "error", err)
+		return models.SyncResult{Status: models.SyncFailed, Error: "internal error"}
+	}
+}
+
+// create adds a note, in the workspace of the operation if it has one.
+func (s *SyncUseCase) create(ctx context.Context, userID int, op models.SyncOperation, check ContentCheck) (*models.Note, error) {
+	var member *models.WorkspaceMember
+	if op.WorkspaceID != nil {
+		var err error
+		member, err = s.workspaceRepo.GetMember(ctx, *op.WorkspaceID, userID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrWorkspaceNotFound
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := check(ctx, op.Content); err != nil {
+		return nil, err
+	}
+
+	note := &models.Note{Content: op.Content, UserID: userID}
+	if err := s.noteUseCase.AddNote(ctx, note, member); err != nil {
+		return nil, err
+	}
+	return note, nil
+}
+
+// update changes the note, checking the content once the user is known to have access to it.
+func (s *SyncUseCase) update(ctx context.Context, userID int, op models.SyncOperation, check ContentCheck) (*models.Note, error) {
+	if _, err := s.noteUseCase.GetNote(ctx, op.NoteID, userID); err != nil {
+		return nil, err
+	}
+	if err := check(ctx, op.Content); err != nil {
+		return nil, err
+	}
+	return s.noteUseCase.UpdateNote(ctx, op.NoteID, userID, op.Content, op.BaseSeq)
+}
@@ -0,0 +1,194 @@
+package usecases
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/ananikitina/notes-rest/internal/config"
+	"github.com/ananikitina/notes-rest/internal/domain"
+	"github.com/ananikitina/notes-rest/internal/models"
+)
+
+const (
+	// mfaTokenTTL is how long the user has to enter the code after the password.
+	mfaTokenTTL = 5 * time.Minute
+	// mfaTokenMaxAttempts is how many wrong codes invalidate the pending login.
+	mfaTokenMaxAttempts = 3
+
+	recoveryCodeCount = 10
+)
+
+var (
+	ErrTwoFactorAlreadyEnabled = errors.New("two-factor authentication is already enabled")
+	ErrTwoFactorNotSetUp       = errors.New("two-factor authentication is not set up")
+	ErrInvalidCode             = errors.New("invalid code")
+)
+
+// TwoFactorSetup contains the data needed to add the account to an authenticator app.
+type TwoFactorSetup struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+// TwoFactorUseCase represents the business logic for TOTP two-factor authentication.
+type TwoFactorUseCase struct {
+	userRepo    domain.UserRepository
+	attemptRepo domain.LoginAttemptRepository
+	throttle    *loginThrottle
+	totp        domain.TOTPService
+	jwtService  domain.JWTServiceInterface
+}
+
+// NewTwoFactorUseCase creates a new instance of TwoFactorUseCase.
+func NewTwoFactorUseCase(userRepo domain.UserRepository, attemptRepo domain.LoginAttemptRepository, totp domain.TOTPService,
+	jwtService domain.JWTServiceInterface, cfg *config.Config) *TwoFactorUseCase {
+	return &TwoFactorUseCase{
+		userRepo:    userRepo,
+		attemptRepo: attemptRepo,
+		throttle:    newLoginThrottle(attemptRepo, cfg),
+		totp:        totp,
+		jwtService:  jwtService,
+	}
+}
+
+// Setup generates a new secret for the user. It has to be confirmed with Enable.
+func (t *TwoFactorUseCase) Setup(ctx context.Context, userID int) (*TwoFactorSetup, error) {
+	user, err := t.userRepo.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.TOTPEnabled {
+		return nil, ErrTwoFactorAlreadyEnabled
+	}
+
+	secret, err := t.totp.GenerateSecret()
+	if err != nil {
+		return nil, err
+	}
+	if err := t.userRepo.SetTOTPSecret(ctx, userID, secret); err != nil {
+		return nil, err
+	}
+	return &TwoFactorSetup{Secret: secret, URI: t.totp.URI(user.Email, secret)}, nil
+}
+
+// Enable confirms the secret with a code from the authenticator app and returns recovery codes.
+func (t *TwoFactorUseCase) Enable(ctx context.Context, userID int, code string) ([]string, error) {
+	user, err := t.userRepo.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.TOTPEnabled {
+		return nil, ErrTwoFactorAlreadyEnabled
+	}
+	if user.TOTPSecret == "" {
+		return nil, ErrTwoFactorNotSetUp
+	}
+
+	step, ok := t.totp.Validate(user.TOTPSecret, code, time.Now(), user.TOTPLastStep)
+	if !ok {
+		return nil, ErrInvalidCode
+	}
+
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		if codes[i], err = generateRecoveryCode(); err != nil {
+			return nil, err
+		}
+		hashes[i] = hashRecoveryCode(codes[i])
+	}
+
+	if err := t.userRepo.EnableTOTP(ctx, userID, step, hashes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// IsEnabled reports whether the user has two-factor authentication enabled.
+func (t *TwoFactorUseCase) IsEnabled(ctx context.Context, userID int) (bool, error) {
+	user, err := t.userRepo.GetUserByID(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+	return user.TOTPEnabled, nil
+}
+
+// GenerateMFAToken issues the short-lived token returned by login when a second factor is required.
+func (t *TwoFactorUseCase) GenerateMFAToken(user *models.User) (string, error) {
+	return t.jwtService.GenerateScopedToken(user.ID, domain.ScopeMFAPending, user.Email, mfaTokenTTL)
+}
+
+// CompleteLogin checks the TOTP or recovery code for the pending login and returns the user.
+// Wrong codes count as failed logins of the account, and a few of them invalidate the token.
+func (t *TwoFactorUseCase) CompleteLogin(ctx context.Context, mfaToken, code, ip string) (*models.User, error) {
+	claims, err := t.jwtService.ValidateScopedToken(mfaToken, domain.ScopeMFAPending)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	tokenHash := hashToken(mfaToken)
+	failures, _, err := t.attemptRepo.CountFailuresByToken(ctx, tokenHash, time.Now().Add(-mfaTokenTTL))
+	if err != nil {
+		return nil, err
+	}
+	if failures >= mfaTokenMaxAttempts {
+		return nil, ErrInvalidToken
+	}
+
+	user, err := t.userRepo.GetUserByID(ctx, claims.UserID)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	if !user.TOTPEnabled {
+		return nil, ErrTwoFactorNotSetUp
+	}
+	email := normalizeEmail(user.Email)
+	if err := t.throttle.check(ctx, email, ip); err != nil {
+		return nil, err
+	}
+
+	ok, err := t.checkCode(ctx, user, code)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, t.throttle.recordFailure(ctx, models.LoginAttempt{Email: email, IP: ip, TokenHash: tokenHash}, ErrInvalidCode)
+	}
+	if err := t.throttle.recordSuccess(ctx, email, ip); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// checkCode reports whether the code is a TOTP code of a step that wasn't used yet or an unused
+// recovery code, and marks it used.
+func (t *TwoFactorUseCase) checkCode(ctx context.Context, user *models.User, code string) (bool, error) {
+	if step, ok := t.totp.Validate(user.TOTPSecret, code, time.Now(), user.TOTPLastStep); ok {
+		// A concurrent request may have used the step since the user was read
+		return t.userRepo.SetTOTPLastStep(ctx, user.ID, step)
+	}
+	return t.userRepo.UseRecoveryCode(ctx, user.ID, hashRecoveryCode(code))
+}
+
+// generateRecoveryCode returns a random code formatted as "xxxxx-xxxxx".
+func generateRecoveryCode() (string, error) {
+	b := make([]byte, 7)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	code := strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b))[:10]
+	return code[:5] + "-" + code[5:], nil
+}
+
+// hashRecoveryCode hashes a recovery code for storage. The codes are random,
+// so a fast hash is enough and allows looking them up directly.
+func hashRecoveryCode(code string) string {
+	normalized := strings.ToLower(strings.TrimSpace(code))
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,167 @@
+package usecases
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ananikitina/notes-rest/internal/config"
+	"github.com/ananikitina/notes-rest/internal/domain"
+	"github.com/ananikitina/notes-rest/internal/models"
+	"github.com/ananikitina/notes-rest/internal/repository/memory"
+	"github.com/ananikitina/notes-rest/internal/services"
+)
+
+// stepTOTP accepts the code "123456" for the current time step.
+type stepTOTP struct{}
+
+func (stepTOTP) GenerateSecret() (string, error) { return "SECRET", nil }
+
+func (stepTOTP) URI(account, secret string) string { return "otpauth://totp/" + account }
+
+func (stepTOTP) Validate(secret, code string, at time.Time, lastStep int64) (int64, bool) {
+	step := at.Unix() / 30
+	return step, code == "123456" && step > lastStep
+}
+
+const testRecoveryCode = "abcde-fghij"
+
+// newTwoFactorTest returns the use case and a user with two-factor authentication enabled.
+func newTwoFactorTest(t *testing.T) (*TwoFactorUseCase, *memory.Store, *models.User) {
+	t.Helper()
+	ctx := context.Background()
+	cfg := &config.Config{
+		JWTSecret:             "a secret that is long enough for HS256",
+		JWTIssuer:             "notes-rest",
+		JWTAudience:           "notes-rest",
+		LoginMaxAttempts:      5,
+		LoginMaxAttemptsPerIP: 100,
+		LoginAttemptWindow:    15 * time.Minute,
+		LoginLockoutDuration:  15 * time.Minute,
+	}
+	jwtService, err := services.NewJWTService(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := memory.NewStore()
+	user := &models.User{Email: "Alice@example.com", Password: "hash", Role: "user", EmailVerified: true}
+	if err := store.Users().CreateUser(ctx, user); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Users().EnableTOTP(ctx, user.ID, 0, []string{hashRecoveryCode(testRecoveryCode)}); err != nil {
+		t.Fatal(err)
+	}
+	return NewTwoFactorUseCase(store.Users(), store.LoginAttempts(), stepTOTP{}, jwtService, cfg), store, user
+}
+
+func mfaToken(t *testing.T, useCase *TwoFactorUseCase, user *models.User) string {
+	t.Helper()
+	token, err := useCase.GenerateMFAToken(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+// addFailures records failed attempts of the user that are old enough not to delay the next one.
+func addFailures(t *testing.T, repo domain.LoginAttemptRepository, n int, tokenHash string, at time.Time) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		attempt := models.LoginAttempt{Email: "alice@example.com", IP: "10.0.0.1", TokenHash: tokenHash, CreatedAt: at}
+		if err := repo.Add(context.Background(), attempt); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestCompleteLogin(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("codes", func(t *testing.T) {
+		useCase, _, user := newTwoFactorTest(t)
+		for _, code := range []string{"123456", testRecoveryCode} {
+			if got, err := useCase.CompleteLogin(ctx, mfaToken(t, useCase, user), code, "10.0.0.1"); err != nil || got.ID != user.ID {
+				t.Errorf("CompleteLogin() with %q = %v, %v, want the user", code, got, err)
+			}
+		}
+		if _, err := useCase.CompleteLogin(ctx, mfaToken(t, useCase, user), testRecoveryCode, "10.0.0.1"); !errors.Is(err, ErrInvalidCode) {
+			t.Errorf("CompleteLogin() with a used recovery code error = %v, want ErrInvalidCode", err)
+		}
+	})
+
+	t.Run("wrong code delays the next attempt", func(t *testing.T) {
+		useCase, _, user := newTwoFactorTest(t)
+		token := mfaToken(t, useCase, user)
+		if _, err := useCase.CompleteLogin(ctx, token, "000000", "10.0.0.1"); !errors.Is(err, ErrInvalidCode) {
+			t.Fatalf("CompleteLogin() with a wrong code error = %v, want ErrInvalidCode", err)
+		}
+		var throttled *LoginThrottledError
+		if _, err := useCase.CompleteLogin(ctx, token, "123456", "10.0.0.1"); !errors.As(err, &throttled) {
+			t.Errorf("CompleteLogin() right after a wrong code error = %v, want LoginThrottledError", err)
+		}
+	})
+
+	t.Run("wrong codes invalidate the token", func(t *testing.T) {
+		useCase, store, user := newTwoFactorTest(t)
+		token := mfaToken(t, useCase, user)
+		addFailures(t, store.LoginAttempts(), mfaTokenMaxAttempts, hashToken(token), time.Now().Add(-time.Minute))
+
+		if _, err := useCase.CompleteLogin(ctx, token, "123456", "10.0.0.1"); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("CompleteLogin() with an exhausted token error = %v, want ErrInvalidToken", err)
+		}
+		if _, err := useCase.CompleteLogin(ctx, mfaToken(t, useCase, user), "123456", "10.0.0.1"); err != nil {
+			t.Errorf("CompleteLogin() with a new token error = %v", err)
+		}
+	})
+
+	t.Run("wrong codes lock the account", func(t *testing.T) {
+		useCase, store, user := newTwoFactorTest(t)
+		addFailures(t, store.LoginAttempts(), 5, "", time.Now().Add(-time.Minute))
+
+		var throttled *LoginThrottledError
+		_, err := useCase.CompleteLogin(ctx, mfaToken(t, useCase, user), "123456", "10.0.0.1")
+		if !errors.As(err, &throttled) || !throttled.Locked {
+			t.Errorf("CompleteLogin() of a locked account error = %v, want a lock", err)
+		}
+	})
+
+	t.Run("success clears failures", func(t *testing.T) {
+		useCase, store, user := newTwoFactorTest(t)
+		addFailures(t, store.LoginAttempts(), 2, "", time.Now().Add(-time.Minute))
+
+		if _, err := useCase.CompleteLogin(ctx, mfaToken(t, useCase, user), "123456", "10.0.0.1"); err != nil {
+			t.Fatal(err)
+		}
+		failures, _, err := store.LoginAttempts().CountFailuresByEmail(ctx, "alice@example.com", time.Now().Add(-time.Hour))
+		if err != nil || failures != 0 {
+			t.Errorf("failures after logging in = %d, %v, want 0", failures, err)
+		}
+	})
+
+	t.Run("concurrent requests can't use the same code", func(t *testing.T) {
+		useCase, _, user := newTwoFactorTest(t)
+		tokens := []string{mfaToken(t, useCase, user), mfaToken(t, useCase, user)}
+		errs := make([]error, len(tokens))
+		var wg sync.WaitGroup
+		for i, token := range tokens {
+			wg.Add(1)
+			go func(i int, token string) {
+				defer wg.Done()
+				_, errs[i] = useCase.CompleteLogin(ctx, token, "123456", "10.0.0.1")
+			}(i, token)
+		}
+		wg.Wait()
+
+		succeeded := 0
+		for _, err := range errs {
+			if err == nil {
+				succeeded++
+			}
+		}
+		if succeeded != 1 {
+			t.Errorf("%d logins succeeded with the same code, want 1: %v", succeeded, errs)
+		}
+	})
+}
@@ -1,41 +1,394 @@
 package usecases
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
 
+	"github.com/ananikitina/notes-rest/internal/config"
+	"github.com/ananikitina/notes-rest/internal/domain"
 	"github.com/ananikitina/notes-rest/internal/models"
-	"github.com/ananikitina/notes-rest/internal/repository"
-	"golang.org/x/crypto/bcrypt"
 )
 
+// verificationTokenTTL is how long an email verification link stays valid.
+const verificationTokenTTL = 24 * time.Hour
+
+var (
+	ErrUserExists            = errors.New("user already exists")
+	ErrInvalidCredentials    = errors.New("invalid email or password")
+	ErrUserNotFound          = errors.New("user not found")
+	ErrSessionNotFound       = errors.New("session not found")
+	ErrEmailNotVerified      = errors.New("email address is not verified")
+	ErrInvalidToken          = errors.New("invalid or expired token")
+	ErrVerificationThrottled = errors.New("verification email was sent recently, try again later")
+	ErrWrongPassword         = errors.New("current password is incorrect")
+	ErrEmailTaken            = errors.New("email address is already in use")
+	ErrInvalidInput          = errors.New("invalid input")
+)
+
+// localePattern matches BCP 47 language tags like "en" or "ru-RU".
+var localePattern = regexp.MustCompile(`^[a-zA-Z]{2,3}(-[a-zA-Z0-9]{2,8})*$`)
+
+// spellcheckLanguages are the languages supported by the spell checker.
+var spellcheckLanguages = map[string]bool{"ru": true, "en": true, "uk": true}
+
+// normalizeEmail returns the form emails are stored, looked up and throttled by.
+// Every use case normalizes the emails it gets before using them.
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
 // UserUseCase represents the business logic for users.
 type UserUseCase struct {
-	userRepo *repository.UserRepository
+	userRepo    domain.UserRepository
+	attemptRepo domain.LoginAttemptRepository
+	throttle    *loginThrottle
+	sessionRepo domain.SessionRepository
+	noteUseCase *NoteUseCase
+	hasher      domain.PasswordHasher
+	policy      domain.PasswordPolicy
+	jwtService  domain.JWTServiceInterface
+	mailer      domain.Mailer
+	cfg         *config.Config
+
+	// dummyHash is checked when the email is unknown, so that the response takes
+	// as long as for a wrong password and doesn't reveal whether the account exists.
+	dummyHash string
 }
 
 // NewUserUseCase creates a new instance of UserUseCase.
-func NewUserUseCase(userRepo *repository.UserRepository) *UserUseCase {
-	return &UserUseCase{userRepo: userRepo}
+// Accounts are deleted through noteUseCase, which records the events about the deleted notes.
+func NewUserUseCase(userRepo domain.UserRepository, attemptRepo domain.LoginAttemptRepository, sessionRepo domain.SessionRepository, noteUseCase *NoteUseCase,
+	hasher domain.PasswordHasher, policy domain.PasswordPolicy, jwtService domain.JWTServiceInterface, mailer domain.Mailer, cfg *config.Config) (*UserUseCase, error) {
+	dummyHash, err := hasher.Hash("dummy password")
+	if err != nil {
+		return nil, err
+	}
+	return &UserUseCase{
+		userRepo:    userRepo,
+		attemptRepo: attemptRepo,
+		throttle:    newLoginThrottle(attemptRepo, cfg),
+		sessionRepo: sessionRepo,
+		noteUseCase: noteUseCase,
+		hasher:      hasher,
+		policy:      policy,
+		jwtService:  jwtService,
+		mailer:      mailer,
+		cfg:         cfg,
+		dummyHash:   dummyHash,
+	}, nil
 }
 
-func (u *UserUseCase) Register(user *models.User) error {
+func (u *UserUseCase) Register(ctx context.Context, user *models.User) error {
+	user.Email = normalizeEmail(user.Email)
+
 	// Check if user already exists
-	existingUser, err := u.userRepo.GetUserByEmail(user.Email)
+	existingUser, err := u.userRepo.GetUserByEmail(ctx, user.Email)
 	if err == nil && existingUser != nil {
-		return errors.New("user already exists")
+		return ErrUserExists
+	}
+
+	if err := u.policy.Validate(user.Password, user.Email); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidInput, err)
+	}
+	if user.Password, err = u.hasher.Hash(user.Password); err != nil {
+		return err
+	}
+
+	user.EmailVerified = false
+	if err := u.userRepo.CreateUser(ctx, user); err != nil {
+		return err
+	}
+
+	// The account is created even if the email can't be sent, the user can request it again
+	if err := u.sendVerificationEmail(ctx, user); err != nil {
+		slog.ErrorContext(ctx, "Failed to send verification email", "user", user.ID, "error", err)
 	}
-	return u.userRepo.CreateUser(user)
+	return nil
 }
 
-func (u *UserUseCase) Authenticate(email, password string) (*models.User, error) {
-	user, err := u.userRepo.GetUserByEmail(email)
+// Authenticate checks the credentials and records the attempt. Repeated failures
+// for the same email or from the same IP are delayed and then blocked.
+func (u *UserUseCase) Authenticate(ctx context.Context, email, password, ip string) (*models.User, error) {
+	// Attempts are tracked for any email, so lockouts don't reveal which accounts exist
+	email = normalizeEmail(email)
+	if err := u.throttle.check(ctx, email, ip); err != nil {
+		return nil, err
+	}
+
+	user, err := u.userRepo.GetUserByEmail(ctx, email)
 	if err != nil {
-		return nil, errors.New("invalid email or password")
+		u.hasher.Verify(u.dummyHash, password)
+		return nil, u.throttle.recordFailure(ctx, models.LoginAttempt{Email: email, IP: ip}, ErrInvalidCredentials)
 	}
 
 	// Compare the hashed password
-	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)); err != nil {
-		return nil, errors.New("invalid email or password")
+	if ok, _ := u.hasher.Verify(user.Password, password); !ok {
+		return nil, u.throttle.recordFailure(ctx, models.LoginAttempt{Email: email, IP: ip}, ErrInvalidCredentials)
+	}
+
+	// Upgrade hashes made with an older algorithm or weaker parameters
+	if u.hasher.NeedsRehash(user.Password) {
+		if err := u.updatePassword(ctx, user.ID, password); err != nil {
+			slog.ErrorContext(ctx, "Failed to rehash password", "user", user.ID, "error", err)
+		}
+	}
+
+	// With two-factor authentication the failures are cleared once the code is checked too,
+	// so logging in again with the password doesn't reset the count of wrong codes
+	if !user.TOTPEnabled {
+		if err := u.throttle.recordSuccess(ctx, email, ip); err != nil {
+			return nil, err
+		}
+	}
+
+	if u.cfg.EmailVerificationPolicy == config.VerificationPolicyLogin && !user.EmailVerified {
+		return nil, ErrEmailNotVerified
 	}
 	return user, nil
 }
+
+// UnlockUser clears the failed login attempts that locked the user's account.
+func (u *UserUseCase) UnlockUser(ctx context.Context, userID int) error {
+	user, err := u.userRepo.GetUserByID(ctx, userID)
+	if err != nil {
+		return ErrUserNotFound
+	}
+	return u.attemptRepo.ClearFailures(ctx, normalizeEmail(user.Email))
+}
+
+// VerifyEmail marks the email from the verification token as verified,
+// or confirms an email change.
+func (u *UserUseCase) VerifyEmail(ctx context.Context, token string) error {
+	if claims, err := u.jwtService.ValidateScopedToken(token, domain.ScopeEmailChange); err == nil {
+		return u.confirmEmailChange(ctx, claims)
+	}
+
+	claims, err := u.jwtService.ValidateScopedToken(token, domain.ScopeEmailVerification)
+	if err != nil {
+		return ErrInvalidToken
+	}
+
+	user, err := u.userRepo.GetUserByID(ctx, claims.UserID)
+	if err != nil {
+		return ErrInvalidToken
+	}
+	// The link is only valid for the address it was sent to
+	if user.Email != claims.Email {
+		return ErrInvalidToken
+	}
+	if user.EmailVerified {
+		return nil
+	}
+	return u.userRepo.SetEmailVerified(ctx, user.ID)
+}
+
+// ResendVerification sends a new verification email unless one was sent recently.
+// Unknown and already verified addresses are silently ignored.
+func (u *UserUseCase) ResendVerification(ctx context.Context, email string) error {
+	user, err := u.userRepo.GetUserByEmail(ctx, normalizeEmail(email))
+	if err != nil || user.EmailVerified {
+		return nil
+	}
+
+	if user.VerificationSentAt != nil && time.Since(*user.VerificationSentAt) < u.cfg.VerificationResendInterval {
+		return ErrVerificationThrottled
+	}
+	return u.sendVerificationEmail(ctx, user)
+}
+
+// IsEmailVerified reports whether the user has verified their email.
+func (u *UserUseCase) IsEmailVerified(ctx context.Context, userID int) (bool, error) {
+	user, err := u.userRepo.GetUserByID(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+	return user.EmailVerified, nil
+}
+
+// GetProfile returns the user without the password hash.
+func (u *UserUseCase) GetProfile(ctx context.Context, userID int) (*models.User, error) {
+	user, err := u.userRepo.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, ErrUserNotFound
+	}
+	user.Password = ""
+	return user, nil
+}
+
+// UpdateProfile validates and saves the changed profile fields.
+func (u *UserUseCase) UpdateProfile(ctx context.Context, userID int, update models.ProfileUpdate) (*models.User, error) {
+	user, err := u.GetProfile(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if update.DisplayName != nil {
+		name := strings.TrimSpace(*update.DisplayName)
+		if len([]rune(name)) > 100 {
+			return nil, fmt.Errorf("%w: display name is too long", ErrInvalidInput)
+		}
+		user.DisplayName = name
+	}
+	if update.Locale != nil {
+		if !localePattern.MatchString(*update.Locale) {
+			return nil, fmt.Errorf("%w: invalid locale", ErrInvalidInput)
+		}
+		user.Locale = *update.Locale
+	}
+	if update.Timezone != nil {
+		if _, err := time.LoadLocation(*update.Timezone); err != nil || *update.Timezone == "" {
+			return nil, fmt.Errorf("%w: invalid timezone", ErrInvalidInput)
+		}
+		user.Timezone = *update.Timezone
+	}
+	if update.SpellcheckEnabled != nil {
+		user.SpellcheckEnabled = *update.SpellcheckEnabled
+	}
+	if update.SpellcheckLanguages != nil {
+		langs := strings.Split(*update.SpellcheckLanguages, ",")
+		for _, lang := range langs {
+			if !spellcheckLanguages[lang] {
+				return nil, fmt.Errorf("%w: unsupported spellcheck language %q", ErrInvalidInput, lang)
+			}
+		}
+		user.SpellcheckLanguages = *update.SpellcheckLanguages
+	}
+
+	if err := u.userRepo.UpdateProfile(ctx, user); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// ChangePassword sets a new password and revokes all sessions except the current one.
+func (u *UserUseCase) ChangePassword(ctx context.Context, userID, sessionID int, currentPassword, newPassword, ip string) error {
+	user, err := u.checkPassword(ctx, userID, currentPassword, ip)
+	if err != nil {
+		return err
+	}
+	if err := u.policy.Validate(newPassword, user.Email); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidInput, err)
+	}
+
+	if err := u.updatePassword(ctx, userID, newPassword); err != nil {
+		return err
+	}
+	return u.sessionRepo.RevokeOthersByUser(ctx, userID, sessionID)
+}
+
+// RequestEmailChange sends a confirmation link to the new email. The email is
+// changed only after the link is opened. If the new email belongs to another account, its owner is
+// told so instead, and the caller gets the same result, so it doesn't reveal which emails have accounts.
+func (u *UserUseCase) RequestEmailChange(ctx context.Context, userID int, password, newEmail, ip string) error {
+	user, err := u.checkPassword(ctx, userID, password, ip)
+	if err != nil {
+		return err
+	}
+	newEmail = normalizeEmail(newEmail)
+	if newEmail == user.Email {
+		return fmt.Errorf("%w: new email is the same as the current one", ErrInvalidInput)
+	}
+	if _, err := u.userRepo.GetUserByEmail(ctx, newEmail); err == nil {
+		body := "Someone asked to move another account to this email address, but the address already belongs to your account, " +
+			"so nothing was changed. If you wanted to merge the accounts, delete one of them first."
+		if err := u.mailer.Send(newEmail, "Email change requested", body); err != nil {
+			return err
+		}
+		u.sendEmailChangeNotice(ctx, user, newEmail)
+		return nil
+	}
+
+	if err := u.userRepo.SetPendingEmail(ctx, user.ID, newEmail); err != nil {
+		return err
+	}
+
+	token, err := u.jwtService.GenerateScopedToken(user.ID, domain.ScopeEmailChange, newEmail, verificationTokenTTL)
+	if err != nil {
+		return err
+	}
+	link := fmt.Sprintf("%s/verify-email?token=%s", u.cfg.AppBaseURL, url.QueryEscape(token))
+	body := fmt.Sprintf("Please confirm your new email address by opening the link below:\n\n%s\n\nThe link expires in 24 hours.", link)
+	if err := u.mailer.Send(newEmail, "Confirm your new email address", body); err != nil {
+		return err
+	}
+
+	u.sendEmailChangeNotice(ctx, user, newEmail)
+	return nil
+}
+
+// sendEmailChangeNotice lets the owner of the current address know in case the request wasn't theirs.
+func (u *UserUseCase) sendEmailChangeNotice(ctx context.Context, user *models.User, newEmail string) {
+	notice := fmt.Sprintf("A change of your account email to %s was requested. If it wasn't you, change your password.", newEmail)
+	if err := u.mailer.Send(user.Email, "Email change requested", notice); err != nil {
+		slog.ErrorContext(ctx, "Failed to send email change notice", "user", user.ID, "error", err)
+	}
+}
+
+// DeleteAccount deletes the user and all their notes.
+func (u *UserUseCase) DeleteAccount(ctx context.Context, userID int, password, ip string) error {
+	if _, err := u.checkPassword(ctx, userID, password, ip); err != nil {
+		return err
+	}
+	return u.noteUseCase.DeleteUser(ctx, userID)
+}
+
+// checkPassword returns the user if the password matches. Wrong passwords count as failed logins
+// of the account, so a stolen access token can't be used to guess the password past the lockout.
+func (u *UserUseCase) checkPassword(ctx context.Context, userID int, password, ip string) (*models.User, error) {
+	user, err := u.userRepo.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, ErrUserNotFound
+	}
+	email := normalizeEmail(user.Email)
+	if err := u.throttle.check(ctx, email, ip); err != nil {
+		return nil, err
+	}
+	if ok, _ := u.hasher.Verify(user.Password, password); !ok {
+		return nil, u.throttle.recordFailure(ctx, models.LoginAttempt{Email: email, IP: ip}, ErrWrongPassword)
+	}
+	return user, nil
+}
+
+// updatePassword hashes and saves the password.
+func (u *UserUseCase) updatePassword(ctx context.Context, userID int, password string) error {
+	hash, err := u.hasher.Hash(password)
+	if err != nil {
+		return err
+	}
+	return u.userRepo.UpdatePassword(ctx, userID, hash)
+}
+
+func (u *UserUseCase) confirmEmailChange(ctx context.Context, claims *domain.Claims) error {
+	user, err := u.userRepo.GetUserByID(ctx, claims.UserID)
+	if err != nil {
+		return ErrInvalidToken
+	}
+	// A newer request replaces the pending email and invalidates older links
+	if user.PendingEmail == "" || user.PendingEmail != claims.Email {
+		return ErrInvalidToken
+	}
+	if _, err := u.userRepo.GetUserByEmail(ctx, user.PendingEmail); err == nil {
+		return ErrEmailTaken
+	}
+	return u.userRepo.ConfirmPendingEmail(ctx, user.ID)
+}
+
+func (u *UserUseCase) sendVerificationEmail(ctx context.Context, user *models.User) error {
+	token, err := u.jwtService.GenerateScopedToken(user.ID, domain.ScopeEmailVerification, user.Email, verificationTokenTTL)
+	if err != nil {
+		return err
+	}
+
+	link := fmt.Sprintf("%s/verify-email?token=%s", u.cfg.AppBaseURL, url.QueryEscape(token))
+	body := fmt.Sprintf("Please confirm your email address by opening the link below:\n\n%s\n\nThe link expires in 24 hours.", link)
+	if err := u.mailer.Send(user.Email, "Confirm your email address", body); err != nil {
+		return err
+	}
+	return u.userRepo.SetVerificationSentAt(ctx, user.ID, time.Now())
+}
@@ -0,0 +1,236 @@
+package usecases
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"time"
+
+	"github.com/ananikitina/notes-rest/internal/config"
+	"github.com/ananikitina/notes-rest/internal/domain"
+	"github.com/ananikitina/notes-rest/internal/models"
+)
+
+const (
+	// webhookPollInterval is how often the worker looks for due deliveries.
+	webhookPollInterval = 5 * time.Second
+	// webhookBatchSize is how many deliveries the worker claims at once.
+	webhookBatchSize = 20
+	// Retries wait webhookRetryBase, doubled after every failed attempt up to webhookRetryMax.
+	webhookRetryBase = 30 * time.Second
+	webhookRetryMax  = time.Hour
+	// webhookDeliveryLog is how many of the latest deliveries are listed.
+	webhookDeliveryLog = 100
+)
+
+// WebhookEvents are the events webhooks can subscribe to.
+var WebhookEvents = []string{
+	models.EventNoteCreated,
+	models.EventNoteUpdated,
+	models.EventNoteDeleted,
+	models.EventSpellcheckFailed,
+}
+
+var (
+	ErrWebhookNotFound  = errors.New("webhook not found")
+	ErrDeliveryNotFound = errors.New("webhook delivery not found")
+	ErrGlobalWebhook    = errors.New("only admins can create global webhooks")
+)
+
+// WebhookUseCase represents the business logic for webhooks and the delivery of their events.
+type WebhookUseCase struct {
+	webhookRepo domain.WebhookRepository
+	sender      domain.WebhookSender
+	cfg         *config.Config
+}
+
+// NewWebhookUseCase creates a new instance of WebhookUseCase.
+func NewWebhookUseCase(webhookRepo domain.WebhookRepository, sender domain.WebhookSender, cfg *config.Config) *WebhookUseCase {
+	return &WebhookUseCase{webhookRepo: webhookRepo, sender: sender, cfg: cfg}
+}
+
+// CreateWebhook registers a webhook of the user for the events, or for all events if none are given.
+// Only admins can create global webhooks, which receive the events of all notes. The returned webhook
+// includes its signing secret.
+func (wh *WebhookUseCase) CreateWebhook(ctx context.Context, userID int, isAdmin bool, rawURL string, events []string, global bool) (*models.Webhook, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("%w: url must be an absolute http or https URL", ErrInvalidInput)
+	}
+	if global && !isAdmin {
+		return nil, ErrGlobalWebhook
+	}
+	if len(events) == 0 {
+		events = WebhookEvents
+	}
+	for _, event := range events {
+		if !isWebhookEvent(event) {
+			return nil, fmt.Errorf("%w: unknown event %q", ErrInvalidInput, event)
+		}
+	}
+
+	secret, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+	webhook := &models.Webhook{
+		UserID:    userID,
+		URL:       u.String(),
+		Secret:    secret,
+		Events:    events,
+		Global:    global,
+		CreatedAt: time.Now(),
+	}
+	if err := wh.webhookRepo.Create(ctx, webhook); err != nil {
+		return nil, err
+	}
+	return webhook, nil
+}
+
+// ListWebhooks returns the user's webhooks.
+func (wh *WebhookUseCase) ListWebhooks(ctx context.Context, userID int) ([]models.Webhook, error) {
+	return wh.webhookRepo.ListByUser(ctx, userID)
+}
+
+// DeleteWebhook deletes the user's webhook with its delivery log.
+func (wh *WebhookUseCase) DeleteWebhook(ctx context.Context, id, userID int) error {
+	deleted, err := wh.webhookRepo.Delete(ctx, id, userID)
+	if err != nil {
+		return err
+	}
+	if !deleted {
+		return ErrWebhookNotFound
+	}
+	return nil
+}
+
+// ListDeliveries returns the latest deliveries of the user's webhook.
+func (wh *WebhookUseCase) ListDeliveries(ctx context.Context, id, userID int) ([]models.WebhookDelivery, error) {
+	if err := wh.requireWebhook(ctx, id, userID); err != nil {
+		return nil, err
+	}
+	return wh.webhookRepo.ListDeliveries(ctx, id, webhookDeliveryLog)
+}
+
+// Redeliver queues the payload of a delivery of the user's webhook again, as a new delivery.
+func (wh *WebhookUseCase) Redeliver(ctx context.Context, id, userID int, deliveryID int64) (*models.WebhookDelivery, error) {
+	if err := wh.requireWebhook(ctx, id, userID); err != nil {
+		return nil, err
+	}
+	var delivery models.WebhookDelivery
+	err := wh.webhookRepo.Redeliver(ctx, deliveryID, id, &delivery)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrDeliveryNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &delivery, nil
+}
+
+// Handle queues deliveries of a relayed event to the webhooks of its recipients subscribed to it and
+// to the global webhooks. Events webhooks can't subscribe to are ignored.
+func (wh *WebhookUseCase) Handle(ctx context.Context, event *models.DomainEvent) error {
+	if !isWebhookEvent(event.Event.Type) {
+		return nil
+	}
+	return wh.webhookRepo.Enqueue(ctx, event.Event.Type, event.Data, event.Recipients)
+}
+
+// RunWorker sends due deliveries until the context is cancelled.
+func (wh *WebhookUseCase) RunWorker(ctx context.Context) {
+	ticker := time.NewTicker(webhookPollInterval)
+	defer ticker.Stop()
+
+	for {
+		// A full batch means more deliveries may be due, so they are sent without waiting
+		if wh.ProcessDue(ctx) == webhookBatchSize && ctx.Err() == nil {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ProcessDue sends a batch of due deliveries and returns how many were claimed.
+func (wh *WebhookUseCase) ProcessDue(ctx context.Context) int {
+	// The lease outlasts a send, so a delivery isn't claimed twice while it is in flight
+	deliveries, err := wh.webhookRepo.ClaimDue(ctx, webhookBatchSize, 2*wh.cfg.WebhookTimeout+time.Minute)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to claim webhook deliveries", "error", err)
+		return 0
+	}
+	for i := range deliveries {
+		wh.attempt(ctx, &deliveries[i])
+	}
+	return len(deliveries)
+}
+
+// attempt sends the delivery and records the outcome, scheduling a retry after a failure until
+// the attempts run out.
+func (wh *WebhookUseCase) attempt(ctx context.Context, delivery *models.WebhookDelivery) {
+	code, err := wh.sender.Send(ctx, delivery)
+	now := time.Now()
+
+	delivery.Attempts++
+	delivery.LastAttemptAt = &now
+	delivery.ResponseCode = nil
+	if code != 0 {
+		delivery.ResponseCode = &code
+	}
+	delivery.Error = ""
+	switch {
+	case err == nil:
+		delivery.Status = models.DeliverySucceeded
+	case delivery.Attempts >= wh.cfg.WebhookMaxAttempts:
+		delivery.Status = models.DeliveryFailed
+		delivery.Error = err.Error()
+	default:
+		delivery.Status = models.DeliveryPending
+		delivery.Error = err.Error()
+		delivery.NextAttemptAt = now.Add(retryDelay(delivery.Attempts))
+	}
+
+	if err := wh.webhookRepo.RecordAttempt(ctx, delivery); err != nil {
+		slog.ErrorContext(ctx, "Failed to record webhook delivery", "delivery", delivery.ID, "error", err)
+	}
+}
+
+// requireWebhook returns an error unless the user has the webhook.
+func (wh *WebhookUseCase) requireWebhook(ctx context.Context, id, userID int) error {
+	exists, err := wh.webhookRepo.Exists(ctx, id, userID)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrWebhookNotFound
+	}
+	return nil
+}
+
+// retryDelay returns how long to wait after the given number of failed attempts.
+func retryDelay(attempts int) time.Duration {
+	delay := webhookRetryBase
+	for i := 1; i < attempts && delay < webhookRetryMax; i++ {
+		delay *= 2
+	}
+	if delay > webhookRetryMax {
+		delay = webhookRetryMax
+	}
+	return delay
+}
+
+func isWebhookEvent(event string) bool {
+	for _, known := range WebhookEvents {
+		if event == known {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,87 @@
+package usecases
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ananikitina/notes-rest/internal/config"
+	"github.com/ananikitina/notes-rest/internal/models"
+	"github.com/ananikitina/notes-rest/internal/repository/memory"
+	"github.com/ananikitina/notes-rest/internal/services"
+)
+
+func TestWebhookDelivery(t *testing.T) {
+	tests := []struct {
+		name        string
+		status      int
+		maxAttempts int
+		want        string
+	}{
+		{name: "success", status: http.StatusNoContent, maxAttempts: 3, want: models.DeliverySucceeded},
+		{name: "failure is retried", status: http.StatusInternalServerError, maxAttempts: 3, want: models.DeliveryPending},
+		{name: "last failure", status: http.StatusInternalServerError, maxAttempts: 1, want: models.DeliveryFailed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var secret string
+			var received int
+			receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				received++
+				body, _ := io.ReadAll(r.Body)
+				timestamp := r.Header.Get(services.WebhookTimestampHeader)
+				if got := r.Header.Get(services.WebhookSignatureHeader); got != services.SignWebhook(secret, timestamp, body) {
+					t.Errorf("invalid signature %q", got)
+				}
+				if got := r.Header.Get(services.WebhookEventHeader); got != models.EventNoteCreated {
+					t.Errorf("got event header %q, want %q", got, models.EventNoteCreated)
+				}
+				w.WriteHeader(tt.status)
+			}))
+			defer receiver.Close()
+
+			cfg := &config.Config{WebhookMaxAttempts: tt.maxAttempts, WebhookTimeout: 5 * time.Second,
+				WebhookAllowPrivateNetworks: true}
+			webhookRepo := memory.NewStore().Webhooks()
+			useCase := NewWebhookUseCase(webhookRepo, services.NewWebhookSender(cfg), cfg)
+
+			webhook, err := useCase.CreateWebhook(context.Background(), 1, false, receiver.URL, nil, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			secret = webhook.Secret
+			event := &models.DomainEvent{Event: models.Event{Type: models.EventNoteCreated}, Recipients: []int{1, 2},
+				Data: []byte(`{"id":1}`)}
+			if err := useCase.Handle(context.Background(), event); err != nil {
+				t.Fatal(err)
+			}
+
+			if claimed := useCase.ProcessDue(context.Background()); claimed != 1 {
+				t.Fatalf("claimed %d deliveries, want 1", claimed)
+			}
+			if received != 1 {
+				t.Fatalf("receiver got %d requests, want 1", received)
+			}
+			deliveries, err := useCase.ListDeliveries(context.Background(), webhook.ID, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			delivery := deliveries[0]
+			if delivery.Status != tt.want || delivery.Attempts != 1 || delivery.ResponseCode == nil || *delivery.ResponseCode != tt.status {
+				t.Errorf("got delivery %+v, want status %s after one attempt", delivery, tt.want)
+			}
+			if tt.want == models.DeliveryPending && !delivery.NextAttemptAt.After(time.Now()) {
+				t.Errorf("retry isn't postponed: %v", delivery.NextAttemptAt)
+			}
+
+			// A pending retry isn't due yet
+			if claimed := useCase.ProcessDue(context.Background()); claimed != 0 {
+				t.Errorf("claimed %d deliveries again", claimed)
+			}
+		})
+	}
+}
@@ -0,0 +1,193 @@
+package usecases
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/ananikitina/notes-rest/internal/config"
+	"github.com/ananikitina/notes-rest/internal/domain"
+	"github.com/ananikitina/notes-rest/internal/models"
+)
+
+// invitationTTL is how long a workspace invitation stays valid.
+const invitationTTL = 7 * 24 * time.Hour
+
+var (
+	ErrWorkspaceNotFound       = errors.New("workspace not found")
+	ErrNotWorkspaceOwner       = errors.New("only the owner can manage the workspace")
+	ErrMemberNotFound          = errors.New("workspace member not found")
+	ErrInvitationEmailMismatch = errors.New("invitation was sent to another email address")
+)
+
+// WorkspaceUseCase represents the business logic for workspaces and their members.
+type WorkspaceUseCase struct {
+	workspaceRepo domain.WorkspaceRepository
+	userRepo      domain.UserRepository
+	mailer        domain.Mailer
+	cfg           *config.Config
+}
+
+// NewWorkspaceUseCase creates a new instance of WorkspaceUseCase.
+func NewWorkspaceUseCase(workspaceRepo domain.WorkspaceRepository, userRepo domain.UserRepository, mailer domain.Mailer, cfg *config.Config) *WorkspaceUseCase {
+	return &WorkspaceUseCase{workspaceRepo: workspaceRepo, userRepo: userRepo, mailer: mailer, cfg: cfg}
+}
+
+// CreateWorkspace creates a workspace owned by the user.
+func (w *WorkspaceUseCase) CreateWorkspace(ctx context.Context, userID int, name string) (*models.Workspace, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || len(name) > 100 {
+		return nil, fmt.Errorf("%w: name must be 1 to 100 characters", ErrInvalidInput)
+	}
+
+	workspace := &models.Workspace{
+		Name:      name,
+		OwnerID:   userID,
+		Role:      models.WorkspaceOwner,
+		CreatedAt: time.Now(),
+	}
+	if err := w.workspaceRepo.Create(ctx, workspace); err != nil {
+		return nil, err
+	}
+	return workspace, nil
+}
+
+// ListWorkspaces returns the workspaces the user is a member of.
+func (w *WorkspaceUseCase) ListWorkspaces(ctx context.Context, userID int) ([]models.Workspace, error) {
+	return w.workspaceRepo.ListByUser(ctx, userID)
+}
+
+// GetMembership returns the user's membership in the workspace.
+func (w *WorkspaceUseCase) GetMembership(ctx context.Context, workspaceID, userID int) (*models.WorkspaceMember, error) {
+	member, err := w.workspaceRepo.GetMember(ctx, workspaceID, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrWorkspaceNotFound
+	}
+	return member, err
+}
+
+// ListMembers returns the members of the workspace to one of its members.
+func (w *WorkspaceUseCase) ListMembers(ctx context.Context, workspaceID, userID int) ([]models.WorkspaceMember, error) {
+	if _, err := w.GetMembership(ctx, workspaceID, userID); err != nil {
+		return nil, err
+	}
+	return w.workspaceRepo.ListMembers(ctx, workspaceID)
+}
+
+// Invite emails an invitation to join the workspace. Only the owner can invite.
+func (w *WorkspaceUseCase) Invite(ctx context.Context, workspaceID, inviterID int, email, role string) (*models.WorkspaceInvitation, error) {
+	if err := w.requireOwner(ctx, workspaceID, inviterID); err != nil {
+		return nil, err
+	}
+	if role != models.WorkspaceEditor && role != models.WorkspaceViewer {
+		return nil, fmt.Errorf("%w: role must be %q or %q", ErrInvalidInput, models.WorkspaceEditor, models.WorkspaceViewer)
+	}
+
+	token, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	invitation := &models.WorkspaceInvitation{
+		WorkspaceID: workspaceID,
+		Email:       normalizeEmail(email),
+		Role:        role,
+		InvitedBy:   inviterID,
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(invitationTTL),
+	}
+	if err := w.workspaceRepo.CreateInvitation(ctx, invitation, hashToken(token)); err != nil {
+		return nil, err
+	}
+
+	link := fmt.Sprintf("%s/workspaces/invitations/accept?token=%s", w.cfg.AppBaseURL, url.QueryEscape(token))
+	body := fmt.Sprintf("You have been invited to a workspace as %s.\n\nOpen the link below to see the invitation, then log in as %s "+
+		"in your notes app to accept it:\n\n%s\n\nThe link expires in 7 days.", role, email, link)
+	if err := w.mailer.Send(email, "Workspace invitation", body); err != nil {
+		return nil, err
+	}
+	return invitation, nil
+}
+
+// GetInvitation returns the pending invitation of the token.
+func (w *WorkspaceUseCase) GetInvitation(ctx context.Context, token string) (*models.WorkspaceInvitation, error) {
+	invitation, err := w.workspaceRepo.GetPendingInvitation(ctx, hashToken(token))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrInvalidToken
+	}
+	return invitation, err
+}
+
+// AcceptInvitation adds the user to the workspace of the invitation.
+// The invitation must be addressed to the user's verified email.
+func (w *WorkspaceUseCase) AcceptInvitation(ctx context.Context, userID int, token string) (*models.WorkspaceMember, error) {
+	invitation, err := w.workspaceRepo.GetPendingInvitation(ctx, hashToken(token))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrInvalidToken
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := w.userRepo.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, ErrUserNotFound
+	}
+	if !strings.EqualFold(user.Email, invitation.Email) {
+		return nil, ErrInvitationEmailMismatch
+	}
+	if !user.EmailVerified {
+		return nil, ErrEmailNotVerified
+	}
+
+	err = w.workspaceRepo.AcceptInvitation(ctx, invitation, userID, time.Now())
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrInvalidToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	return w.GetMembership(ctx, invitation.WorkspaceID, userID)
+}
+
+// RemoveMember removes a member from the workspace. The owner can remove anyone else,
+// other members can only leave the workspace themselves.
+func (w *WorkspaceUseCase) RemoveMember(ctx context.Context, workspaceID, userID, memberID int) error {
+	if userID != memberID {
+		if err := w.requireOwner(ctx, workspaceID, userID); err != nil {
+			return err
+		}
+	}
+	removed, err := w.workspaceRepo.RemoveMember(ctx, workspaceID, memberID)
+	if err != nil {
+		return err
+	}
+	if !removed {
+		return ErrMemberNotFound
+	}
+	return nil
+}
+
+// requireOwner returns an error unless the user owns the workspace.
+func (w *WorkspaceUseCase) requireOwner(ctx context.Context, workspaceID, userID int) error {
+	member, err := w.GetMembership(ctx, workspaceID, userID)
+	if err != nil {
+		return err
+	}
+	if member.Role != models.WorkspaceOwner {
+		return ErrNotWorkspaceOwner
+	}
+	return nil
+}
+
+// hashToken hashes a random token for storage, so a leaked table doesn't leak usable tokens.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
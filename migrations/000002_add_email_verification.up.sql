@@ -0,0 +1,5 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS email_verified BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS verification_sent_at TIMESTAMP;
+
+-- Accounts created before verification was introduced are trusted
+UPDATE users SET email_verified = TRUE;
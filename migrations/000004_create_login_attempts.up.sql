@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS login_attempts (
+    id SERIAL PRIMARY KEY,
+    email VARCHAR(255) NOT NULL,
+    ip VARCHAR(64) NOT NULL,
+    success BOOLEAN NOT NULL,
+    cleared BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS login_attempts_email_idx ON login_attempts (email, created_at);
+CREATE INDEX IF NOT EXISTS login_attempts_ip_idx ON login_attempts (ip, created_at);
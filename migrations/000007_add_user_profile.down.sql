@@ -0,0 +1,6 @@
+ALTER TABLE users DROP COLUMN IF EXISTS pending_email;
+ALTER TABLE users DROP COLUMN IF EXISTS spellcheck_languages;
+ALTER TABLE users DROP COLUMN IF EXISTS spellcheck_enabled;
+ALTER TABLE users DROP COLUMN IF EXISTS timezone;
+ALTER TABLE users DROP COLUMN IF EXISTS locale;
+ALTER TABLE users DROP COLUMN IF EXISTS display_name;
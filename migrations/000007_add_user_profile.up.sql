@@ -0,0 +1,6 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS display_name VARCHAR(100) NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN IF NOT EXISTS locale VARCHAR(35) NOT NULL DEFAULT 'en';
+ALTER TABLE users ADD COLUMN IF NOT EXISTS timezone VARCHAR(64) NOT NULL DEFAULT 'UTC';
+ALTER TABLE users ADD COLUMN IF NOT EXISTS spellcheck_enabled BOOLEAN NOT NULL DEFAULT TRUE;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS spellcheck_languages VARCHAR(50) NOT NULL DEFAULT 'ru,en';
+ALTER TABLE users ADD COLUMN IF NOT EXISTS pending_email VARCHAR(255) NOT NULL DEFAULT '';
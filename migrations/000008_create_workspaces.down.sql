@@ -0,0 +1,9 @@
+DROP INDEX IF EXISTS notes_content_search_idx;
+DROP INDEX IF EXISTS notes_workspace_id_idx;
+DROP INDEX IF EXISTS notes_user_id_idx;
+
+ALTER TABLE notes DROP COLUMN IF EXISTS workspace_id;
+
+DROP TABLE IF EXISTS workspace_invitations;
+DROP TABLE IF EXISTS workspace_members;
+DROP TABLE IF EXISTS workspaces;
@@ -0,0 +1,33 @@
+CREATE TABLE IF NOT EXISTS workspaces (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(100) NOT NULL,
+    owner_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS workspace_members (
+    workspace_id INT NOT NULL REFERENCES workspaces(id) ON DELETE CASCADE,
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    role VARCHAR(20) NOT NULL CHECK (role IN ('owner', 'editor', 'viewer')),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (workspace_id, user_id)
+);
+
+CREATE TABLE IF NOT EXISTS workspace_invitations (
+    id SERIAL PRIMARY KEY,
+    workspace_id INT NOT NULL REFERENCES workspaces(id) ON DELETE CASCADE,
+    email VARCHAR(255) NOT NULL,
+    role VARCHAR(20) NOT NULL CHECK (role IN ('editor', 'viewer')),
+    token_hash VARCHAR(64) NOT NULL UNIQUE,
+    invited_by INT REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMPTZ NOT NULL,
+    accepted_at TIMESTAMPTZ
+);
+
+-- Notes without a workspace are private to their author
+ALTER TABLE notes ADD COLUMN IF NOT EXISTS workspace_id INT REFERENCES workspaces(id) ON DELETE CASCADE;
+
+CREATE INDEX IF NOT EXISTS notes_user_id_idx ON notes (user_id);
+CREATE INDEX IF NOT EXISTS notes_workspace_id_idx ON notes (workspace_id);
+CREATE INDEX IF NOT EXISTS notes_content_search_idx ON notes USING GIN (to_tsvector('simple', content));
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS note_shares;
+
+ALTER TABLE notes DROP COLUMN IF EXISTS updated_at;
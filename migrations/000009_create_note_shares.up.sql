@@ -0,0 +1,11 @@
+ALTER TABLE notes ADD COLUMN IF NOT EXISTS updated_at TIMESTAMPTZ;
+
+CREATE TABLE IF NOT EXISTS note_shares (
+    note_id INT NOT NULL REFERENCES notes(id) ON DELETE CASCADE,
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    permission VARCHAR(20) NOT NULL CHECK (permission IN ('viewer', 'editor')),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (note_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS note_shares_user_id_idx ON note_shares (user_id);
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS public_links (
+    id SERIAL PRIMARY KEY,
+    note_id INT NOT NULL REFERENCES notes(id) ON DELETE CASCADE,
+    token_hash VARCHAR(64) NOT NULL UNIQUE,
+    password_hash VARCHAR(255),
+    expires_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    view_count INT NOT NULL DEFAULT 0,
+    last_viewed_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS public_links_note_id_idx ON public_links (note_id);
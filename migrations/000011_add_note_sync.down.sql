@@ -0,0 +1,6 @@
+DROP TABLE IF EXISTS note_tombstones;
+
+DROP INDEX IF EXISTS notes_change_seq_idx;
+ALTER TABLE notes DROP COLUMN IF EXISTS change_seq;
+
+DROP SEQUENCE IF EXISTS note_change_seq;
@@ -0,0 +1,17 @@
+-- Every change of a note takes the next value, clients sync from the last value they saw
+CREATE SEQUENCE IF NOT EXISTS note_change_seq;
+
+ALTER TABLE notes ADD COLUMN IF NOT EXISTS change_seq BIGINT NOT NULL DEFAULT nextval('note_change_seq');
+
+CREATE INDEX IF NOT EXISTS notes_change_seq_idx ON notes (change_seq);
+
+-- One tombstone per user who could see a note that was deleted or became invisible to them
+CREATE TABLE IF NOT EXISTS note_tombstones (
+    note_id INT NOT NULL,
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    change_seq BIGINT NOT NULL DEFAULT nextval('note_change_seq'),
+    deleted_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (note_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS note_tombstones_user_id_change_seq_idx ON note_tombstones (user_id, change_seq);
@@ -0,0 +1,9 @@
+-- Domain events written in the same transaction as the change that caused them,
+-- relayed to the event sinks and removed once the change is committed
+CREATE TABLE IF NOT EXISTS outbox (
+    id BIGSERIAL PRIMARY KEY,
+    event JSONB NOT NULL,
+    recipients INT[] NOT NULL,
+    data JSONB,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
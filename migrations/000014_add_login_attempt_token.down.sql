@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS login_attempts_token_idx;
+ALTER TABLE login_attempts DROP COLUMN IF EXISTS token_hash;
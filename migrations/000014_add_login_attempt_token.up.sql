@@ -0,0 +1,5 @@
+-- Hash of the token an attempt was made with, e.g. the pending two-factor login,
+-- so failures can be counted per token as well as per account
+ALTER TABLE login_attempts ADD COLUMN IF NOT EXISTS token_hash VARCHAR(64) NOT NULL DEFAULT '';
+
+CREATE INDEX IF NOT EXISTS login_attempts_token_idx ON login_attempts (token_hash, created_at) WHERE token_hash <> '';
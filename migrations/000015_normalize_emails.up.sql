@@ -0,0 +1,8 @@
+-- Emails are stored in lower case. Accounts whose emails only differ in case are left
+-- as they are, they have to be merged or renamed by hand.
+UPDATE users SET email = LOWER(email)
+WHERE email <> LOWER(email)
+  AND NOT EXISTS (SELECT 1 FROM users other WHERE LOWER(other.email) = LOWER(users.email) AND other.id <> users.id);
+
+UPDATE users SET pending_email = LOWER(pending_email) WHERE pending_email <> LOWER(pending_email);
+UPDATE workspace_invitations SET email = LOWER(email) WHERE email <> LOWER(email);
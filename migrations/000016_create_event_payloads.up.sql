@@ -0,0 +1,9 @@
+-- Events too large for a NOTIFY payload; the notification only carries the ID and listeners
+-- read the event from here. Rows are removed once every listener had time to read them
+CREATE TABLE IF NOT EXISTS event_payloads (
+    id BIGSERIAL PRIMARY KEY,
+    payload JSONB NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS event_payloads_created_at_idx ON event_payloads (created_at);
@@ -0,0 +1,9 @@
+// Package migrations embeds the SQL migrations, so the binary runs them without the files next to it.
+package migrations
+
+import "embed"
+
+// FS holds the PostgreSQL migrations in its root and the SQLite migrations in sqlite/.
+//
+//go:embed *.sql sqlite/*.sql
+var FS embed.FS
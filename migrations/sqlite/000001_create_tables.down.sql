@@ -0,0 +1,20 @@
+DROP TABLE IF EXISTS outbox;
+DROP TABLE IF EXISTS webhook_deliveries;
+DROP TABLE IF EXISTS webhooks;
+DROP TABLE IF EXISTS public_links;
+DROP TABLE IF EXISTS note_tombstones;
+DROP TABLE IF EXISTS note_shares;
+DROP TRIGGER IF EXISTS notes_fts_update;
+DROP TRIGGER IF EXISTS notes_fts_delete;
+DROP TRIGGER IF EXISTS notes_fts_insert;
+DROP TABLE IF EXISTS notes_fts;
+DROP TABLE IF EXISTS notes;
+DROP TABLE IF EXISTS note_change_seq;
+DROP TABLE IF EXISTS workspace_invitations;
+DROP TABLE IF EXISTS workspace_members;
+DROP TABLE IF EXISTS workspaces;
+DROP TABLE IF EXISTS sessions;
+DROP TABLE IF EXISTS user_identities;
+DROP TABLE IF EXISTS login_attempts;
+DROP TABLE IF EXISTS recovery_codes;
+DROP TABLE IF EXISTS users;
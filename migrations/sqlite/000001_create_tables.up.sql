@@ -0,0 +1,204 @@
+-- SQLite schema of the storage backend for local development and single-user installs.
+-- Times are stored as UTC text written by the application, so they compare in time order.
+CREATE TABLE IF NOT EXISTS users (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    email TEXT NOT NULL UNIQUE,
+    password TEXT NOT NULL,
+    role TEXT NOT NULL DEFAULT 'user',
+    email_verified BOOLEAN NOT NULL DEFAULT FALSE,
+    pending_email TEXT NOT NULL DEFAULT '',
+    verification_sent_at TIMESTAMP,
+    totp_secret TEXT NOT NULL DEFAULT '',
+    totp_enabled BOOLEAN NOT NULL DEFAULT FALSE,
+    totp_last_step INTEGER NOT NULL DEFAULT 0,
+    display_name TEXT NOT NULL DEFAULT '',
+    locale TEXT NOT NULL DEFAULT 'en',
+    timezone TEXT NOT NULL DEFAULT 'UTC',
+    spellcheck_enabled BOOLEAN NOT NULL DEFAULT TRUE,
+    spellcheck_languages TEXT NOT NULL DEFAULT 'ru,en'
+);
+
+CREATE TABLE IF NOT EXISTS recovery_codes (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    code_hash TEXT NOT NULL,
+    used_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS recovery_codes_user_id_idx ON recovery_codes (user_id);
+
+CREATE TABLE IF NOT EXISTS login_attempts (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    email TEXT NOT NULL,
+    ip TEXT NOT NULL,
+    success BOOLEAN NOT NULL,
+    cleared BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS login_attempts_email_idx ON login_attempts (email, created_at);
+CREATE INDEX IF NOT EXISTS login_attempts_ip_idx ON login_attempts (ip, created_at);
+
+CREATE TABLE IF NOT EXISTS user_identities (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    provider TEXT NOT NULL,
+    subject TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    UNIQUE (provider, subject)
+);
+
+CREATE TABLE IF NOT EXISTS sessions (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    device_label TEXT NOT NULL DEFAULT '',
+    user_agent TEXT NOT NULL DEFAULT '',
+    ip TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL,
+    last_seen_at TIMESTAMP NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    revoked_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS sessions_user_id_idx ON sessions (user_id);
+
+CREATE TABLE IF NOT EXISTS workspaces (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT NOT NULL,
+    owner_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS workspace_members (
+    workspace_id INTEGER NOT NULL REFERENCES workspaces(id) ON DELETE CASCADE,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    role TEXT NOT NULL CHECK (role IN ('owner', 'editor', 'viewer')),
+    created_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (workspace_id, user_id)
+);
+
+CREATE TABLE IF NOT EXISTS workspace_invitations (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    workspace_id INTEGER NOT NULL REFERENCES workspaces(id) ON DELETE CASCADE,
+    email TEXT NOT NULL,
+    role TEXT NOT NULL CHECK (role IN ('editor', 'viewer')),
+    token_hash TEXT NOT NULL UNIQUE,
+    invited_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    accepted_at TIMESTAMP
+);
+
+-- Every change of a note takes the next value, clients sync from the last value they saw
+CREATE TABLE IF NOT EXISTS note_change_seq (
+    value INTEGER NOT NULL
+);
+
+INSERT INTO note_change_seq (value) VALUES (0);
+
+-- Notes without a workspace are private to their author
+CREATE TABLE IF NOT EXISTS notes (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    content TEXT NOT NULL,
+    user_id INTEGER REFERENCES users(id),
+    workspace_id INTEGER REFERENCES workspaces(id) ON DELETE CASCADE,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP,
+    change_seq INTEGER NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS notes_user_id_idx ON notes (user_id);
+CREATE INDEX IF NOT EXISTS notes_workspace_id_idx ON notes (workspace_id);
+CREATE INDEX IF NOT EXISTS notes_change_seq_idx ON notes (change_seq);
+
+-- Full-text search index of the notes, kept in sync by triggers
+CREATE VIRTUAL TABLE IF NOT EXISTS notes_fts USING fts5(content, content='notes', content_rowid='id');
+
+CREATE TRIGGER IF NOT EXISTS notes_fts_insert AFTER INSERT ON notes BEGIN
+    INSERT INTO notes_fts (rowid, content) VALUES (new.id, new.content);
+END;
+
+CREATE TRIGGER IF NOT EXISTS notes_fts_delete AFTER DELETE ON notes BEGIN
+    INSERT INTO notes_fts (notes_fts, rowid, content) VALUES ('delete', old.id, old.content);
+END;
+
+CREATE TRIGGER IF NOT EXISTS notes_fts_update AFTER UPDATE OF content ON notes BEGIN
+    INSERT INTO notes_fts (notes_fts, rowid, content) VALUES ('delete', old.id, old.content);
+    INSERT INTO notes_fts (rowid, content) VALUES (new.id, new.content);
+END;
+
+CREATE TABLE IF NOT EXISTS note_shares (
+    note_id INTEGER NOT NULL REFERENCES notes(id) ON DELETE CASCADE,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    permission TEXT NOT NULL CHECK (permission IN ('viewer', 'editor')),
+    created_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (note_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS note_shares_user_id_idx ON note_shares (user_id);
+
+-- One tombstone per user who could see a note that was deleted or became invisible to them
+CREATE TABLE IF NOT EXISTS note_tombstones (
+    note_id INTEGER NOT NULL,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    change_seq INTEGER NOT NULL,
+    deleted_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (note_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS note_tombstones_user_id_change_seq_idx ON note_tombstones (user_id, change_seq);
+
+CREATE TABLE IF NOT EXISTS public_links (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    note_id INTEGER NOT NULL REFERENCES notes(id) ON DELETE CASCADE,
+    token_hash TEXT NOT NULL UNIQUE,
+    password_hash TEXT,
+    expires_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL,
+    view_count INTEGER NOT NULL DEFAULT 0,
+    last_viewed_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS public_links_note_id_idx ON public_links (note_id);
+
+CREATE TABLE IF NOT EXISTS webhooks (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    url TEXT NOT NULL,
+    secret TEXT NOT NULL,
+    -- JSON array of the event types
+    events TEXT NOT NULL,
+    -- Global webhooks (admins only) receive the events of all notes
+    global BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS webhooks_user_id_idx ON webhooks (user_id);
+
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    webhook_id INTEGER NOT NULL REFERENCES webhooks(id) ON DELETE CASCADE,
+    event_type TEXT NOT NULL,
+    payload TEXT NOT NULL,
+    status TEXT NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'succeeded', 'failed')),
+    attempts INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMP NOT NULL,
+    last_attempt_at TIMESTAMP,
+    response_code INTEGER,
+    error TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS webhook_deliveries_due_idx ON webhook_deliveries (next_attempt_at) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS webhook_deliveries_webhook_id_idx ON webhook_deliveries (webhook_id, created_at);
+
+-- Domain events written in the same transaction as the change that caused them,
+-- relayed to the event sinks and removed once the change is committed
+CREATE TABLE IF NOT EXISTS outbox (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    event TEXT NOT NULL,
+    -- JSON array of the user IDs
+    recipients TEXT NOT NULL,
+    data TEXT,
+    created_at TIMESTAMP NOT NULL
+);